@@ -1,5 +1,6 @@
-package observer
+/*
+Assembles the nodes of the buildkit out of the core services.
+The observer node is assembled by builder/observer.
+*/
 
-func NewWithConfig() {
-
-}
+package builder
@@ -0,0 +1,91 @@
+package observer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	detectorModule "github.com/0xBow-io/asp-go-buildkit/builder/observer/detector"
+	"github.com/0xBow-io/asp-go-buildkit/core/store"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	pkgerrors "github.com/pkg/errors"
+	"go.uber.org/fx"
+)
+
+// DefaultAPIAddr is the default address of the node API
+const DefaultAPIAddr = "127.0.0.1:8645"
+
+// recordsRPC is the records module over
+// json-rpc, the hashes are hex encoded
+type recordsRPC struct{ m RecordsModule }
+
+func (r *recordsRPC) Query(q *store.Query) (*RecordPage, error) { return r.m.Query(q) }
+
+func (r *recordsRPC) Record(hash hexutil.Bytes) (*RecordDetails, error) { return r.m.Record(hash) }
+
+/*
+apiServer serves the modules of the node over json-rpc on http,
+as the records_* and detector_* methods.
+It is not served if the api address of the config is empty.
+*/
+type apiServer struct {
+	rpc      *rpc.Server
+	http     *http.Server
+	listener net.Listener
+}
+
+func apiModule() fx.Option {
+	return fx.Module("api",
+		fx.Provide(newAPIServer),
+		fx.Invoke(func(*apiServer) {}),
+	)
+}
+
+func newAPIServer(lc fx.Lifecycle, cfg *Config, records RecordsModule, detector detectorModule.Module) (*apiServer, error) {
+	s := &apiServer{rpc: rpc.NewServer()}
+	if err := s.rpc.RegisterName("records", &recordsRPC{records}); err != nil {
+		return nil, pkgerrors.Wrap(err, "failed to register the records api")
+	}
+	if err := s.rpc.RegisterName("detector", detector); err != nil {
+		return nil, pkgerrors.Wrap(err, "failed to register the detector api")
+	}
+	if cfg.APIAddr == "" {
+		return s, nil
+	}
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error { return s.start(cfg.APIAddr) },
+		OnStop:  s.stop,
+	})
+	return s, nil
+}
+
+func (s *apiServer) start(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return pkgerrors.Wrapf(err, "failed to listen on the api address %s", addr)
+	}
+	s.listener = l
+	s.http = &http.Server{Handler: s.rpc}
+	go func() {
+		if err := s.http.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorw("api: server failure", "err", err)
+		}
+	}()
+	log.Infow("api: serving", "addr", l.Addr().String())
+	return nil
+}
+
+func (s *apiServer) stop(ctx context.Context) error {
+	defer s.rpc.Stop()
+	return s.http.Shutdown(ctx)
+}
+
+// addr returns the address the api is served on, empty if not served
+func (s *apiServer) addr() string {
+	if s.listener == nil {
+		return ""
+	}
+	return s.listener.Addr().String()
+}
@@ -1 +1,278 @@
 package observer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/0xBow-io/asp-go-buildkit/core"
+	"github.com/0xBow-io/asp-go-buildkit/core/detector"
+	"github.com/0xBow-io/asp-go-buildkit/internal"
+	"github.com/BurntSushi/toml"
+	"github.com/ilyakaznacheev/cleanenv"
+	pkgerrors "github.com/pkg/errors"
+)
+
+// ConfigFile is the config file of the node home
+const ConfigFile = "config.toml"
+
+var (
+	ErrInvalidDataDir    = errors.New("invalid data dir")
+	ErrInvalidWindowSize = errors.New("invalid window size")
+	ErrUnknownNetwork    = errors.New("unknown network")
+	ErrLegacyConfig      = errors.New("legacy config")
+)
+
+/*
+Config is the config of the observer node.
+It is read from the config file of the node home, values
+of the file are overridden by the environment variables,
+the defaults apply to the values set by neither.
+The chains and the observed instances are the [[chains]]
+and [[instances]] tables of the file.
+*/
+type Config struct {
+	core.Config
+	// network the config was generated for
+	Network string `toml:"network" env:"NETWORK"`
+	// directory of the store, buffer log
+	// and quarantine of the node
+	DataDir string `toml:"data_dir" env:"DATA_DIR" env-default:"data"`
+	// directory of the attestation keys
+	KeystoreDir string `toml:"keystore_dir" env:"KEYSTORE_DIR" env-default:"keystore"`
+	// directory of the node logs
+	LogDir   string `toml:"log_dir" env:"LOG_DIR" env-default:"logs"`
+	LogLevel string `toml:"log_level" env:"LOG_LEVEL" env-default:"info"`
+	// address of the json-rpc api, not served if empty
+	APIAddr string `toml:"api_addr" env:"API_ADDR" env-default:"127.0.0.1:8645"`
+	// maximum number of blocks watched at once,
+	// unless set by the chain
+	WindowSize uint64 `toml:"window_size" env:"WINDOW_SIZE" env-default:"10000"`
+	// wait between windows once caught up,
+	// unless set by the chain
+	WaitTime time.Duration `toml:"wait_time" env:"WAIT_TIME" env-default:"5s"`
+	// when the buffer log is fsynced
+	WALSync string `toml:"wal_sync" env:"WAL_SYNC" env-default:"interval"`
+	// how to carry on after a quarantined state transition
+	QuarantinePolicy string `toml:"quarantine_policy" env:"QUARANTINE_POLICY" env-default:"continue"`
+}
+
+// Network is the defaults of the node config per network
+type Network struct {
+	ChainID    uint64
+	WindowSize uint64
+	// about the block time
+	WaitTime time.Duration
+}
+
+// Networks are the networks of which
+// the config defaults are known
+var Networks = map[string]Network{
+	"sepolia": {ChainID: 11155111, WindowSize: 10000, WaitTime: 12 * time.Second},
+	"gnosis":  {ChainID: 100, WindowSize: 10000, WaitTime: 5 * time.Second},
+}
+
+// DefaultNetwork is the network of the default config
+const DefaultNetwork = "sepolia"
+
+// DefaultConfig returns the config of the defaults
+func DefaultConfig() *Config {
+	cfg, _ := NetworkConfig(DefaultNetwork)
+	return cfg
+}
+
+// NetworkConfig returns the default config of the network
+func NetworkConfig(name string) (*Config, error) {
+	n, ok := Networks[name]
+	if !ok {
+		return nil, pkgerrors.Wrapf(ErrUnknownNetwork, "network %s", name)
+	}
+	return &Config{
+		Config:           core.Config{Chains: []core.Chain{{Name: name, ID: n.ChainID}}},
+		Network:          name,
+		DataDir:          "data",
+		KeystoreDir:      "keystore",
+		LogDir:           "logs",
+		LogLevel:         "info",
+		APIAddr:          DefaultAPIAddr,
+		WindowSize:       n.WindowSize,
+		WaitTime:         n.WaitTime,
+		WALSync:          string(internal.SyncInterval),
+		QuarantinePolicy: "continue",
+	}, nil
+}
+
+// Validate checks the config, every problem is reported
+func (cfg *Config) Validate() error {
+	errs := []error{cfg.Config.Validate()}
+	if cfg.DataDir == "" {
+		errs = append(errs, ErrInvalidDataDir)
+	}
+	if cfg.WindowSize == 0 {
+		errs = append(errs, ErrInvalidWindowSize)
+	}
+	if _, err := internal.ParseSyncPolicy(cfg.WALSync); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := detector.ParseQuarantinePolicy(cfg.QuarantinePolicy); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// ConfigPath returns the path of the config file of the home
+func ConfigPath(home string) string { return filepath.Join(home, ConfigFile) }
+
+// Resolve makes the relative directories
+// of the config relative to the home
+func (cfg *Config) Resolve(home string) {
+	for _, dir := range []*string{&cfg.DataDir, &cfg.KeystoreDir, &cfg.LogDir} {
+		if *dir != "" && !filepath.IsAbs(*dir) {
+			*dir = filepath.Join(home, *dir)
+		}
+	}
+}
+
+// Save writes the config to the path
+func (cfg *Config) Save(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return pkgerrors.Wrap(err, "failed to create the config file")
+	}
+	if err := toml.NewEncoder(f).Encode(cfg); err != nil {
+		f.Close()
+		return pkgerrors.Wrap(err, "failed to write the config file")
+	}
+	return f.Close()
+}
+
+// LoadConfig reads the config file of the home over the
+// defaults of its network, overridden by the environment variables.
+// The chains and instances are those of the file
+func LoadConfig(home string) (*Config, error) {
+	var file struct {
+		Network string `toml:"network"`
+		legacyConfig
+	}
+	md, err := toml.DecodeFile(ConfigPath(home), &file)
+	if err != nil {
+		return nil, pkgerrors.Wrapf(err, "failed to read the config of %s", home)
+	}
+	cfg := &Config{}
+	if file.Network != "" {
+		if cfg, err = NetworkConfig(file.Network); err != nil {
+			return nil, err
+		}
+		cfg.Config = core.Config{}
+	}
+	if err := cleanenv.ReadConfig(ConfigPath(home), cfg); err != nil {
+		return nil, pkgerrors.Wrapf(err, "failed to read the config of %s", home)
+	}
+	if err := file.legacyConfig.migrate(cfg, md); err != nil {
+		return nil, pkgerrors.Wrapf(err, "config of %s", home)
+	}
+	cfg.Config.ReadEnv()
+	cfg.Resolve(home)
+	return cfg, nil
+}
+
+// legacyConfig is the single chain and instance of the config
+// files preceding the [[chains]] and [[instances]] tables
+type legacyConfig struct {
+	ChainID    uint64 `toml:"chain_id"`
+	ErpcWss    string `toml:"erpc_wss"`
+	ErpcHttps  string `toml:"erpc_https"`
+	ProtocolID string `toml:"protocol_id"`
+	InstanceID string `toml:"instance_id"`
+	StartBlock uint64 `toml:"start_block"`
+}
+
+/*
+migrate moves the legacy keys defined by the file into the chain
+and the instance of the config. The keys are rejected if the file
+has chains or instances of its own, which they would be ambiguous with.
+*/
+func (l legacyConfig) migrate(cfg *Config, md toml.MetaData) error {
+	defined := func(keys ...string) []string {
+		var out []string
+		for _, key := range keys {
+			if md.IsDefined(key) {
+				out = append(out, key)
+			}
+		}
+		return out
+	}
+	chainKeys := defined("chain_id", "erpc_wss", "erpc_https")
+	instanceKeys := defined("protocol_id", "instance_id")
+	startKeys := defined("start_block")
+	if len(chainKeys)+len(instanceKeys)+len(startKeys) == 0 {
+		return nil
+	}
+	if len(chainKeys) > 0 {
+		if len(cfg.Chains) > 0 {
+			return pkgerrors.Wrapf(ErrLegacyConfig, "%v are replaced by the [[chains]] tables", chainKeys)
+		}
+		cfg.Chains = []core.Chain{{Name: legacyChainName(cfg.Network, l.ChainID), ID: l.ChainID, ErpcWss: l.ErpcWss, ErpcHttps: l.ErpcHttps}}
+	}
+	if len(instanceKeys) > 0 {
+		if len(cfg.Instances) > 0 {
+			return pkgerrors.Wrapf(ErrLegacyConfig, "%v are replaced by the [[instances]] tables", instanceKeys)
+		}
+		if len(cfg.Chains) != 1 {
+			return pkgerrors.Wrapf(ErrLegacyConfig, "%v are of a single chain, set the chain of the [[instances]] tables instead", instanceKeys)
+		}
+		cfg.Instances = []core.Instance{{ProtocolID: l.ProtocolID, InstanceID: l.InstanceID, Chain: cfg.Chains[0].Name}}
+	}
+	if len(startKeys) > 0 {
+		if len(cfg.Instances) != 1 || cfg.Instances[0].StartBlock != 0 {
+			return pkgerrors.Wrap(ErrLegacyConfig, "start_block is replaced by the start_block of the [[instances]] tables")
+		}
+		cfg.Instances[0].StartBlock = l.StartBlock
+	}
+	log.Warnw("observer: migrated the legacy config keys, move them to the [[chains]] and [[instances]] tables",
+		"keys", append(append(chainKeys, instanceKeys...), startKeys...))
+	return nil
+}
+
+// legacyChainName returns the name of the network
+// of the chain id, else a name of the chain id
+func legacyChainName(network string, id uint64) string {
+	if n, ok := Networks[network]; ok && n.ChainID == id {
+		return network
+	}
+	for name, n := range Networks {
+		if n.ChainID == id {
+			return name
+		}
+	}
+	return fmt.Sprintf("chain-%d", id)
+}
+
+type configKey struct{}
+
+// WithConfig sets the node config in the context
+func WithConfig(ctx context.Context, cfg *Config) context.Context {
+	return context.WithValue(ctx, configKey{}, *cfg)
+}
+
+// ObserverConfig returns the node config of the context, else the
+// defaults overridden by the environment, of the single chain and
+// instance of the environment
+func ObserverConfig(ctx context.Context) Config {
+	if cfg, ok := ctx.Value(configKey{}).(Config); ok {
+		return cfg
+	}
+	cfg := DefaultConfig()
+	if err := cleanenv.ReadEnv(cfg); err != nil {
+		log.Warnw("observer/ObserverConfig: failed to read the environment", "err", err)
+	}
+	c, err := core.NewConfig()
+	if err != nil {
+		log.Warnw("observer/ObserverConfig: failed to read the environment", "err", err)
+	}
+	cfg.Config = c
+	return *cfg
+}
@@ -0,0 +1,114 @@
+package observer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0xBow-io/asp-go-buildkit/core"
+	"github.com/test-go/testify/require"
+)
+
+func Test_LoadConfig(t *testing.T) {
+	home := t.TempDir()
+
+	_, err := NetworkConfig("mainnet")
+	require.Error(t, err)
+
+	cfg, err := NetworkConfig("gnosis")
+	require.NoError(t, err)
+	cfg.Chains[0].ErpcHttps = "https://file"
+	cfg.Chains[0].ErpcWss = "wss://file"
+	cfg.Chains[0].WaitTime = 6 * time.Second
+	cfg.Instances = []core.Instance{{ProtocolID: "privacy-pool", InstanceID: "GNOSIS_XDAI_POOL_1", Chain: "gnosis", StartBlock: 42}}
+	require.NoError(t, cfg.Save(ConfigPath(home)))
+
+	// the environment overrides the file
+	t.Setenv("ERPC_HTTPS", "https://env")
+	t.Setenv("ERPC_WSS_GNOSIS", "wss://env")
+	loaded, err := LoadConfig(home)
+	require.NoError(t, err)
+	require.Equal(t, []core.Chain{{Name: "gnosis", ID: 100, ErpcHttps: "https://env", ErpcWss: "wss://env", WaitTime: 6 * time.Second}}, loaded.Chains)
+	require.Equal(t, cfg.Instances, loaded.Instances)
+	require.NoError(t, loaded.Validate())
+	require.Equal(t, 5*time.Second, loaded.WaitTime)
+	require.Equal(t, "gnosis", loaded.Network)
+	// relative directories are of the home
+	require.Equal(t, filepath.Join(home, "data"), loaded.DataDir)
+
+	_, err = LoadConfig(t.TempDir())
+	require.Error(t, err)
+}
+
+func Test_LoadConfig_Network(t *testing.T) {
+	home := t.TempDir()
+	require.NoError(t, os.WriteFile(ConfigPath(home), []byte(`network = "sepolia"
+window_size = 500
+
+[[chains]]
+name = "sepolia"
+id = 11155111
+`), 0o600))
+
+	// the values missing from the file are the network defaults
+	loaded, err := LoadConfig(home)
+	require.NoError(t, err)
+	require.Equal(t, uint64(500), loaded.WindowSize)
+	require.Equal(t, Networks["sepolia"].WaitTime, loaded.WaitTime)
+	require.Equal(t, []core.Chain{{Name: "sepolia", ID: 11155111}}, loaded.Chains)
+
+	require.NoError(t, os.WriteFile(ConfigPath(home), []byte(`network = "mainnet"`), 0o600))
+	_, err = LoadConfig(home)
+	require.True(t, errors.Is(err, ErrUnknownNetwork))
+}
+
+func Test_LoadConfig_Legacy(t *testing.T) {
+	home := t.TempDir()
+	require.NoError(t, os.WriteFile(ConfigPath(home), []byte(`network = "gnosis"
+chain_id = 100
+erpc_wss = "wss://file"
+erpc_https = "https://file"
+protocol_id = "privacy-pool"
+instance_id = "GNOSIS_XDAI_POOL_1"
+start_block = 42
+`), 0o600))
+
+	// the single chain and instance keys are migrated
+	loaded, err := LoadConfig(home)
+	require.NoError(t, err)
+	require.Equal(t, []core.Chain{{Name: "gnosis", ID: 100, ErpcWss: "wss://file", ErpcHttps: "https://file"}}, loaded.Chains)
+	require.Equal(t, []core.Instance{{ProtocolID: "privacy-pool", InstanceID: "GNOSIS_XDAI_POOL_1", Chain: "gnosis", StartBlock: 42}}, loaded.Instances)
+	require.NoError(t, loaded.Validate())
+
+	// and rejected along with the tables
+	require.NoError(t, os.WriteFile(ConfigPath(home), []byte(`network = "gnosis"
+erpc_https = "https://file"
+
+[[chains]]
+name = "gnosis"
+id = 100
+`), 0o600))
+	_, err = LoadConfig(home)
+	require.True(t, errors.Is(err, ErrLegacyConfig))
+
+	require.NoError(t, os.WriteFile(ConfigPath(home), []byte(`start_block = 42
+
+[[chains]]
+name = "gnosis"
+id = 100
+
+[[instances]]
+protocol_id = "privacy-pool"
+instance_id = "GNOSIS_XDAI_POOL_1"
+chain = "gnosis"
+
+[[instances]]
+protocol_id = "privacy-pool"
+instance_id = "GNOSIS_XDAI_POOL_2"
+chain = "gnosis"
+`), 0o600))
+	_, err = LoadConfig(home)
+	require.True(t, errors.Is(err, ErrLegacyConfig))
+}
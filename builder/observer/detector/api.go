@@ -1 +1,49 @@
+/*
+Verifies the observed states are of valid state transitions before they are stashed into the buffer.
+*/
+
 package detector
+
+import (
+	core "github.com/0xBow-io/asp-go-buildkit/core/detector"
+)
+
+var _ Module = (*API)(nil)
+
+type Module interface {
+	// Quarantined returns the
+	// quarantined state transitions.
+	Quarantined() ([]*core.QuarantineEntry, error)
+	// Inspect returns a quarantined state transition.
+	Inspect(id uint64) (*core.QuarantineEntry, error)
+	// Approve releases a quarantined
+	// state transition into the buffer.
+	Approve(id uint64) error
+	// Reject drops a quarantined state transition.
+	Reject(id uint64) error
+}
+
+type API struct {
+	Internal struct {
+		Quarantined func() ([]*core.QuarantineEntry, error)
+		Inspect     func(uint64) (*core.QuarantineEntry, error)
+		Approve     func(uint64) error
+		Reject      func(uint64) error
+	}
+}
+
+func (a *API) Quarantined() ([]*core.QuarantineEntry, error) {
+	return a.Internal.Quarantined()
+}
+
+func (a *API) Inspect(id uint64) (*core.QuarantineEntry, error) {
+	return a.Internal.Inspect(id)
+}
+
+func (a *API) Approve(id uint64) error {
+	return a.Internal.Approve(id)
+}
+
+func (a *API) Reject(id uint64) error {
+	return a.Internal.Reject(id)
+}
@@ -1 +1,82 @@
 package detector
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/alert"
+	core "github.com/0xBow-io/asp-go-buildkit/core/detector"
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/0xBow-io/asp-go-buildkit/internal"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/pkg/errors"
+	"go.uber.org/fx"
+)
+
+var log = logging.Logger("observer/detector")
+
+// Config is the config of the detector module
+type Config struct {
+	// log of the buffered states
+	WAL internal.WALOptions
+	// directory of the quarantined
+	// state transitions, disabled if empty
+	QuarantineDir string
+	Policy        core.QuarantinePolicy
+}
+
+func ConstructModule(cfg Config) fx.Option {
+	return fx.Module("detector",
+		fx.Supply(cfg),
+		fx.Provide(
+			newBuffer,
+			newService,
+			newAPI,
+		),
+	)
+}
+
+// newBuffer opens the durable buffer, absorbed
+// batches are staged until the detector commits them
+func newBuffer(lc fx.Lifecycle, cfg Config, des watcher.StateDeserializer) (internal.Buffer, error) {
+	durable, err := internal.NewDurableBuffer(big.NewInt(0), cfg.WAL)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open the buffer")
+	}
+	lc.Append(fx.Hook{
+		OnStop: func(context.Context) error { return durable.Close() },
+	})
+	return internal.NewTxBuffer(durable, des).Serialized(), nil
+}
+
+func newService(
+	cfg Config,
+	buff internal.Buffer,
+	rules []core.Rule,
+	des watcher.StateDeserializer,
+	alerts alert.Raiser,
+) (*core.Service, error) {
+	s := core.NewService(buff, rules...)
+	s.SetAlerter(alerts)
+	if cfg.QuarantineDir == "" {
+		return s, nil
+	}
+	q, err := core.NewFileQuarantine(cfg.QuarantineDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open the quarantine")
+	}
+	if err := s.SetQuarantine(q, cfg.Policy, des); err != nil {
+		return nil, err
+	}
+	log.Infow("quarantine enabled", "dir", cfg.QuarantineDir)
+	return s, nil
+}
+
+func newAPI(s *core.Service) Module {
+	api := &API{}
+	api.Internal.Quarantined = s.Quarantined
+	api.Internal.Inspect = s.Inspect
+	api.Internal.Approve = s.Approve
+	api.Internal.Reject = s.Reject
+	return api
+}
@@ -0,0 +1,134 @@
+package observer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/0xBow-io/asp-go-buildkit/core"
+	"github.com/0xBow-io/asp-go-buildkit/core/alert"
+	"github.com/0xBow-io/asp-go-buildkit/core/attest"
+	"github.com/0xBow-io/asp-go-buildkit/core/detector"
+	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+	"github.com/0xBow-io/asp-go-buildkit/core/store"
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	pkgerrors "github.com/pkg/errors"
+	"go.uber.org/fx"
+)
+
+const (
+	storeFile     = "records.db"
+	walDir        = "wal"
+	quarantineDir = "quarantine"
+	checkpointDir = "checkpoints"
+	// the buffer root shared by the instances
+	rootCheckpointFile = "root.json"
+	// the checkpoint of the single instance of the
+	// nodes preceding the checkpoints of the instances
+	legacyCheckpointFile = "checkpoint.json"
+	trackerDir           = "tracker"
+	// operator key of the keystore dir
+	operatorKeyFile = "operator.json"
+)
+
+// PassphraseEnv is the environment variable
+// of the passphrase of the operator key
+const PassphraseEnv = "OPERATOR_KEY_PASSPHRASE"
+
+// instance is an observed protocol
+// instance and the chain it is of
+type instance struct {
+	watcher.Observable
+	Config core.Instance
+	Chain  core.Chain
+}
+
+// protocolModule supplies the protocol components
+// and the observed instances to the other modules
+func protocolModule(p Protocol, instances []instance) fx.Option {
+	return fx.Module("protocol",
+		fx.Provide(
+			func() Protocol { return p },
+			func() []instance { return instances },
+			func() watcher.StateDeserializer { return p.Deserializer() },
+			func() []detector.Rule { return p.Rules() },
+		),
+	)
+}
+
+func recorderModule() fx.Option {
+	return fx.Module("recorder",
+		fx.Provide(
+			newAlerts,
+			newStore,
+			newRecorder,
+			newRecords,
+		),
+	)
+}
+
+// newAlerts returns the alert manager
+// configured by the environment
+func newAlerts(lc fx.Lifecycle) (alert.Raiser, error) {
+	cfg, err := alert.NewConfig()
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "failed to read the alert config")
+	}
+	m, err := alert.NewFromConfig(cfg)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "failed to create the alert manager")
+	}
+	lc.Append(fx.Hook{
+		OnStop: func(context.Context) error { return m.Close() },
+	})
+	return m, nil
+}
+
+func newStore(lc fx.Lifecycle, cfg *Config, p Protocol) (store.RecordStore, error) {
+	if err := os.MkdirAll(cfg.DataDir, 0o755); err != nil {
+		return nil, pkgerrors.Wrap(err, "failed to create the data dir")
+	}
+	s, err := store.NewBoltStore(filepath.Join(cfg.DataDir, storeFile), p.TransitionDecoder())
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "failed to open the record store")
+	}
+	lc.Append(fx.Hook{
+		OnStop: func(context.Context) error { return s.Close() },
+	})
+	return s, nil
+}
+
+// newRecorder returns the recorder attesting the
+// records with the operator key of the keystore, if any
+func newRecorder(cfg *Config, alerts alert.Raiser) (*recorder.Service, error) {
+	r := recorder.NewService()
+	r.SetAlerter(alerts)
+	path := filepath.Join(cfg.KeystoreDir, operatorKeyFile)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		log.Infow("no operator key, the records are not attested", "keystore", cfg.KeystoreDir)
+		return r, nil
+	}
+	passphrase, ok := os.LookupEnv(PassphraseEnv)
+	if !ok {
+		return nil, pkgerrors.Errorf("%s is not set, required by the operator key %s", PassphraseEnv, path)
+	}
+	key, err := attest.Load(path, passphrase)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "failed to load the operator key")
+	}
+	r.SetSigner(key)
+	return r, nil
+}
+
+func newRecords(s store.RecordStore, p Protocol) RecordsModule {
+	var (
+		decoder *watcher.EventDecoder
+		detail  TransitionDetailer
+	)
+	if d, ok := p.(Detailer); ok {
+		decoder, _ = d.EventDecoder()
+		detail = d.TransitionDetails()
+	}
+	return NewRecordsAPI(s, decoder, detail)
+}
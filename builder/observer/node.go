@@ -0,0 +1,131 @@
+package observer
+
+import (
+	"context"
+	"path/filepath"
+
+	detectorModule "github.com/0xBow-io/asp-go-buildkit/builder/observer/detector"
+	watcherModule "github.com/0xBow-io/asp-go-buildkit/builder/observer/watcher"
+	"github.com/0xBow-io/asp-go-buildkit/core"
+	"github.com/0xBow-io/asp-go-buildkit/core/detector"
+	"github.com/0xBow-io/asp-go-buildkit/internal"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/pkg/errors"
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxevent"
+)
+
+var log = logging.Logger("observer")
+
+// Node is an observer node of the instances of a protocol
+type Node struct {
+	Config   *Config
+	Protocol Protocol
+
+	Detector detectorModule.Module
+	Records  RecordsModule
+
+	api     *apiServer
+	app     *fx.App
+	started bool
+	stopped bool
+}
+
+// NewWithConfig assembles the node of the config,
+// the options are applied on top of the node modules
+func NewWithConfig(cfg *Config, options ...fx.Option) (*Node, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid config")
+	}
+	p, instances, err := resolve(&cfg.Config)
+	if err != nil {
+		return nil, err
+	}
+	sync, _ := internal.ParseSyncPolicy(cfg.WALSync)
+	policy, _ := detector.ParseQuarantinePolicy(cfg.QuarantinePolicy)
+
+	node := &Node{Config: cfg, Protocol: p}
+	node.app = fx.New(
+		fx.WithLogger(func() fxevent.Logger {
+			return &fxevent.ZapLogger{Logger: log.Desugar()}
+		}),
+		fx.Supply(cfg),
+		protocolModule(p, instances),
+		watcherModule.ConstructModule(&cfg.Config),
+		detectorModule.ConstructModule(detectorModule.Config{
+			WAL:           internal.WALOptions{Dir: filepath.Join(cfg.DataDir, walDir), Sync: sync},
+			QuarantineDir: filepath.Join(cfg.DataDir, quarantineDir),
+			Policy:        policy,
+		}),
+		recorderModule(),
+		pipelineModule(),
+		apiModule(),
+		fx.Options(options...),
+		fx.Populate(&node.Detector, &node.Records, &node.api),
+	)
+	if err := node.app.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to assemble the node")
+	}
+	return node, nil
+}
+
+// resolve looks up the observables of the instances of the config,
+// the instances must be of a single protocol and of their chain
+func resolve(cfg *core.Config) (Protocol, []instance, error) {
+	var (
+		p         Protocol
+		instances []instance
+	)
+	for _, inst := range cfg.Instances {
+		ip, err := Lookup(inst.ProtocolID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if p == nil {
+			p = ip
+		} else if ip.ID() != p.ID() {
+			return nil, nil, errors.Errorf("instances of protocols %s and %s, a node observes a single protocol", p.ID(), ip.ID())
+		}
+		obs, err := p.Observable(inst.InstanceID)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "instance %s of protocol %s", inst.InstanceID, inst.ProtocolID)
+		}
+		chain, _ := cfg.Chain(inst.Chain)
+		if uint64(obs.ChainID()) != chain.ID {
+			return nil, nil, errors.Errorf("instance %s is of chain %d, expected %d of chain %s",
+				obs.ID(), obs.ChainID(), chain.ID, chain.Name)
+		}
+		instances = append(instances, instance{Observable: obs, Config: inst, Chain: chain})
+	}
+	return p, instances, nil
+}
+
+// Start starts the node components in order
+func (n *Node) Start(ctx context.Context) error {
+	if err := n.app.Start(ctx); err != nil {
+		return errors.Wrap(err, "failed to start the node")
+	}
+	n.started = true
+	return nil
+}
+
+// APIAddr returns the address the node
+// api is served on, empty if it is not served
+func (n *Node) APIAddr() string { return n.api.addr() }
+
+// Stop stops the node components in reverse order
+func (n *Node) Stop(ctx context.Context) error {
+	if !n.started || n.stopped {
+		return nil
+	}
+	n.stopped = true
+	return n.app.Stop(ctx)
+}
+
+// Close releases the node, stopping
+// it if it was started and not stopped
+func (n *Node) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), n.app.StopTimeout())
+	defer cancel()
+	return n.Stop(ctx)
+}
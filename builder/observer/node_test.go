@@ -0,0 +1,153 @@
+package observer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	watcherModule "github.com/0xBow-io/asp-go-buildkit/builder/observer/watcher"
+	"github.com/0xBow-io/asp-go-buildkit/core"
+	"github.com/0xBow-io/asp-go-buildkit/core/store"
+	privacypool "github.com/0xBow-io/asp-go-buildkit/integrations/protocols/privacy-pool"
+	"github.com/0xBow-io/asp-go-buildkit/internal"
+	"github.com/0xBow-io/asp-go-buildkit/internal/erpc"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/test-go/testify/require"
+	"go.uber.org/fx"
+)
+
+// testBackend is a chain of empty blocks up to latest
+type testBackend struct {
+	erpc.Backend
+	latest   uint64
+	filtered atomic.Int64
+}
+
+func (b *testBackend) BlockNumber(context.Context) (uint64, error) { return b.latest, nil }
+
+func (b *testBackend) FilterLogs(context.Context, ethereum.FilterQuery) ([]types.Log, error) {
+	b.filtered.Add(1)
+	return nil, nil
+}
+
+func Test_NewWithConfig(t *testing.T) {
+	if _, err := Lookup(privacypool.ProtocolID); err != nil {
+		Register(privacypool.Protocol{})
+	}
+	require.Contains(t, Protocols(), privacypool.ProtocolID)
+
+	cfg := DefaultConfig()
+	cfg.Config = core.Config{
+		Chains: []core.Chain{
+			{Name: "sepolia", ID: 11155111, ErpcWss: "ws://127.0.0.1:8546", ErpcHttps: "http://127.0.0.1:8545"},
+			{Name: "gnosis", ID: 100, ErpcWss: "ws://127.0.0.1:9546", ErpcHttps: "http://127.0.0.1:9545"},
+		},
+		Instances: []core.Instance{
+			{ProtocolID: privacypool.ProtocolID, InstanceID: "SEPOLIA_ETH_POOL_1", Chain: "sepolia"},
+			{ProtocolID: privacypool.ProtocolID, InstanceID: "GNOSIS_XDAI_POOL_1", Chain: "gnosis"},
+		},
+	}
+	cfg.DataDir = t.TempDir()
+
+	node, err := NewWithConfig(cfg)
+	require.NoError(t, err)
+	require.NotNil(t, node.Records)
+	require.NotNil(t, node.Detector)
+
+	entries, err := node.Detector.Quarantined()
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	// closing a node which never started is a no-op
+	require.NoError(t, node.Close())
+
+	// the instance must be of the configured chain
+	cfg.Instances[1].Chain = "sepolia"
+	_, err = NewWithConfig(cfg)
+	require.Error(t, err)
+
+	cfg.Instances[1].ProtocolID = "unknown"
+	_, err = NewWithConfig(cfg)
+	require.True(t, errors.Is(err, ErrUnknownProtocol))
+}
+
+func Test_Node_Lifecycle(t *testing.T) {
+	if _, err := Lookup(privacypool.ProtocolID); err != nil {
+		Register(privacypool.Protocol{})
+	}
+	cfg := DefaultConfig()
+	cfg.Config = core.Config{
+		Chains: []core.Chain{
+			// the window and wait of the chain prevail
+			{Name: "sepolia", ID: 11155111, ErpcWss: "ws://127.0.0.1:8546", ErpcHttps: "http://127.0.0.1:8545",
+				WindowSize: 10, WaitTime: 10 * time.Millisecond},
+		},
+		Instances: []core.Instance{
+			{ProtocolID: privacypool.ProtocolID, InstanceID: "SEPOLIA_ETH_POOL_1", Chain: "sepolia", StartBlock: 1000},
+		},
+	}
+	cfg.DataDir = t.TempDir()
+	cfg.APIAddr = "127.0.0.1:0"
+
+	backend := &testBackend{latest: 1025}
+	node, err := NewWithConfig(cfg, fx.Replace(watcherModule.Backends{"sepolia": backend}))
+	require.NoError(t, err)
+	require.NoError(t, node.Start(context.Background()))
+
+	// the records are served by the api
+	client, err := rpc.DialHTTP("http://" + node.APIAddr())
+	require.NoError(t, err)
+	defer client.Close()
+	var page RecordPage
+	require.NoError(t, client.Call(&page, "records_query", &store.Query{Filter: store.Filter{FromBlock: 1000}}))
+	require.Empty(t, page.Records)
+	var details *RecordDetails
+	require.Error(t, client.Call(&details, "records_record", hexutil.Bytes{0x1}))
+
+	// the 3 windows up to the latest block are watched
+	for backend.filtered.Load() < 3 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, node.Stop(ctx))
+	require.Equal(t, int64(3), backend.filtered.Load())
+
+	cp, err := internal.LoadCheckpoint(filepath.Join(cfg.DataDir, checkpointDir, "SEPOLIA_ETH_POOL_1.json"))
+	require.NoError(t, err)
+	require.NotNil(t, cp)
+	require.Equal(t, uint64(1026), cp.Next)
+	// the buffer root is checkpointed once for the instances
+	require.Empty(t, cp.Root)
+	rcp, err := internal.LoadCheckpoint(filepath.Join(cfg.DataDir, rootCheckpointFile))
+	require.NoError(t, err)
+	require.Equal(t, "0", rcp.Root)
+	// stopping twice is a no-op
+	require.NoError(t, node.Close())
+
+	// the checkpoint of a node preceding the
+	// instance checkpoints is migrated to its instance
+	legacy := filepath.Join(cfg.DataDir, legacyCheckpointFile)
+	require.NoError(t, os.Rename(filepath.Join(cfg.DataDir, checkpointDir, "SEPOLIA_ETH_POOL_1.json"), legacy))
+
+	// the node resumes from the checkpoint
+	node, err = NewWithConfig(cfg, fx.Replace(watcherModule.Backends{"sepolia": backend}))
+	require.NoError(t, err)
+	backend.latest = 1030
+	require.NoError(t, node.Start(context.Background()))
+	for backend.filtered.Load() < 4 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	require.NoError(t, node.Close())
+	cp, err = internal.LoadCheckpoint(filepath.Join(cfg.DataDir, checkpointDir, "SEPOLIA_ETH_POOL_1.json"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1031), cp.Next)
+	_, err = os.Stat(legacy)
+	require.True(t, os.IsNotExist(err))
+}
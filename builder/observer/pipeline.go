@@ -0,0 +1,144 @@
+package observer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	watcherModule "github.com/0xBow-io/asp-go-buildkit/builder/observer/watcher"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/accumulator"
+	"github.com/0xBow-io/asp-go-buildkit/core/alert"
+	"github.com/0xBow-io/asp-go-buildkit/core/detector"
+	"github.com/0xBow-io/asp-go-buildkit/core/pipeline"
+	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+	"github.com/0xBow-io/asp-go-buildkit/core/store"
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/0xBow-io/asp-go-buildkit/internal"
+	pkgerrors "github.com/pkg/errors"
+	"go.uber.org/fx"
+)
+
+type pipelineParams struct {
+	fx.In
+
+	Config      *Config
+	Protocol    Protocol
+	Instances   []instance
+	Backends    watcherModule.Backends
+	Watchers    watcherModule.Watchers
+	Detector    *detector.Service
+	Recorder    *recorder.Service
+	Store       store.RecordStore
+	Accumulator *accumulator.Accumulator
+	Buffer      internal.Buffer
+	Des         watcher.StateDeserializer
+	Alerts      alert.Raiser
+}
+
+func pipelineModule() fx.Option {
+	return fx.Module("observer",
+		fx.Provide(
+			newAccumulator,
+			newPipeline,
+		),
+		fx.Invoke(registerPipeline),
+	)
+}
+
+func newAccumulator() (*accumulator.Accumulator, error) {
+	return accumulator.New(accumulator.DefaultDepth)
+}
+
+// newPipeline assembles the pipeline of the instances,
+// with the specifics of the protocol if it has any
+func newPipeline(params pipelineParams) (*pipeline.Pipeline, error) {
+	var (
+		cfg       = params.Config
+		instances = make([]pipeline.Instance, len(params.Instances))
+		hooks     pipeline.Hooks
+	)
+	for i, inst := range params.Instances {
+		instances[i] = pipeline.Instance{
+			Observable: inst.Observable,
+			Chain:      inst.Chain.Name,
+			Backend:    params.Backends[inst.Chain.Name],
+			Watcher:    params.Watchers[inst.Chain.Name],
+			Start:      inst.Config.StartBlock,
+			WindowSize: inst.Chain.WindowSize,
+			WaitTime:   inst.Chain.WaitTime,
+		}
+	}
+	checkpoint := func(id string) string {
+		return filepath.Join(cfg.DataDir, checkpointDir, id+".json")
+	}
+	if err := migrateCheckpoint(cfg.DataDir, instances, checkpoint); err != nil {
+		return nil, err
+	}
+	if h, ok := params.Protocol.(Hooker); ok {
+		hooks = h.Hooks()
+	}
+
+	var tracker *internal.Tracker
+	trackerDir := filepath.Join(cfg.DataDir, trackerDir)
+	if hooks.Indices != nil {
+		t, err := internal.LoadTracker(trackerDir)
+		if err != nil {
+			return nil, pkgerrors.Wrap(err, "failed to load the state index tracker")
+		}
+		tracker = t
+	}
+	return pipeline.New(pipeline.Params{
+		Config: pipeline.Config{
+			WindowSize:     cfg.WindowSize,
+			WaitTime:       cfg.WaitTime,
+			Checkpoint:     checkpoint,
+			RootCheckpoint: filepath.Join(cfg.DataDir, rootCheckpointFile),
+		},
+		Instances:   instances,
+		Detector:    params.Detector,
+		Recorder:    params.Recorder,
+		Buffer:      params.Buffer,
+		Des:         params.Des,
+		Alerts:      params.Alerts,
+		Hooks:       hooks,
+		Store:       params.Store,
+		Accumulator: params.Accumulator,
+		Tracker:     tracker,
+		TrackerDir:  trackerDir,
+	})
+}
+
+func registerPipeline(lc fx.Lifecycle, p *pipeline.Pipeline) {
+	lc.Append(fx.Hook{
+		OnStart: p.Start,
+		OnStop:  p.Stop,
+	})
+}
+
+// migrateCheckpoint moves the checkpoint of a node preceding the
+// checkpoints of the instances to the checkpoint of its instance,
+// the instance resumes from it along with the buffer root it holds
+func migrateCheckpoint(dataDir string, instances []pipeline.Instance, checkpoint func(id string) string) error {
+	legacy := filepath.Join(dataDir, legacyCheckpointFile)
+	if _, err := os.Stat(legacy); errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return pkgerrors.Wrap(err, "failed to read the legacy checkpoint")
+	}
+	if len(instances) != 1 {
+		return pkgerrors.Errorf("the checkpoint %s is of a single instance, start the node once with the --instance it is of", legacy)
+	}
+	path := checkpoint(instances[0].ID())
+	if _, err := os.Stat(path); err == nil {
+		return pkgerrors.Errorf("the checkpoint %s is superseded by %s, remove it", legacy, path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return pkgerrors.Wrap(err, "failed to create the checkpoint dir")
+	}
+	if err := os.Rename(legacy, path); err != nil {
+		return pkgerrors.Wrap(err, "failed to migrate the legacy checkpoint")
+	}
+	log.Infow("observer: migrated the legacy checkpoint", "instance", instances[0].ID(), "checkpoint", path)
+	return nil
+}
@@ -0,0 +1,88 @@
+package observer
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/detector"
+	"github.com/0xBow-io/asp-go-buildkit/core/pipeline"
+	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/pkg/errors"
+)
+
+var ErrUnknownProtocol = errors.New("unknown protocol")
+
+// Protocol is a protocol the node can observe
+type Protocol interface {
+	// ID is the protocol id of the node config
+	ID() string
+	// Observable returns the observable
+	// of the protocol instance
+	Observable(instanceID string) (watcher.Observable, error)
+	Deserializer() watcher.StateDeserializer
+	TransitionDecoder() recorder.TransitionDecoder
+	// Rules returns the detector rules,
+	// the default rules are used if empty
+	Rules() []detector.Rule
+}
+
+// Detailer is implemented by protocols which
+// decode their events and state transitions
+type Detailer interface {
+	EventDecoder() (*watcher.EventDecoder, error)
+	TransitionDetails() func(transition []byte) interface{}
+}
+
+// Hooker is implemented by protocols which cross-validate,
+// bootstrap or track the states of their instances
+type Hooker interface {
+	Hooks() pipeline.Hooks
+}
+
+// Defaulter is implemented by protocols
+// which have a default instance per chain
+type Defaulter interface {
+	// DefaultInstance returns the id of the default
+	// instance of the chain, empty if there is none
+	DefaultInstance(chainID uint64) string
+}
+
+var (
+	protocols = make(map[string]Protocol)
+	protoLock = &sync.RWMutex{}
+)
+
+// Register makes the protocol available to the node
+// it panics if the protocol id is already registered
+func Register(p Protocol) {
+	defer protoLock.Unlock()
+	protoLock.Lock()
+	if _, ok := protocols[p.ID()]; ok {
+		panic("observer: protocol " + p.ID() + " registered twice")
+	}
+	protocols[p.ID()] = p
+}
+
+// Lookup returns the registered protocol of the id
+func Lookup(id string) (Protocol, error) {
+	defer protoLock.RUnlock()
+	protoLock.RLock()
+	p, ok := protocols[id]
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownProtocol, "protocol %s", id)
+	}
+	return p, nil
+}
+
+// Protocols returns the ids of the registered protocols
+func Protocols() []string {
+	defer protoLock.RUnlock()
+	protoLock.RLock()
+	ids := make([]string, 0, len(protocols))
+	for id := range protocols {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -1 +1,110 @@
 package observer
+
+import (
+	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+	"github.com/0xBow-io/asp-go-buildkit/core/store"
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+var _ RecordsModule = (*RecordsAPI)(nil)
+
+// TransitionDetailer deserializes the
+// state transition details of a protocol
+type TransitionDetailer func(transition []byte) interface{}
+
+// RecordDetails is a record with its
+// deserialized event and state transition
+type RecordDetails struct {
+	Hash        hexutil.Bytes         `json:"hash"`
+	Scope       hexutil.Bytes         `json:"scope"`
+	Previous    hexutil.Bytes         `json:"previous,omitempty"`
+	PreState    hexutil.Bytes         `json:"preState"`
+	PostState   hexutil.Bytes         `json:"postState"`
+	Time        int64                 `json:"time"`
+	Event       *watcher.Event        `json:"event"`
+	Decoded     *watcher.DecodedEvent `json:"decoded,omitempty"`
+	Transition  interface{}           `json:"transition,omitempty"`
+	Attestation *recorder.Attestation `json:"attestation,omitempty"`
+}
+
+// RecordPage is a page of query results
+type RecordPage struct {
+	Records []*RecordDetails `json:"records"`
+	Next    string           `json:"next,omitempty"`
+}
+
+type RecordsModule interface {
+	// Query returns a page of the
+	// records matching the filter.
+	Query(q *store.Query) (*RecordPage, error)
+	// Record returns the record of the hash.
+	Record(hash []byte) (*RecordDetails, error)
+}
+
+type RecordsAPI struct {
+	Internal struct {
+		Query func(
+			*store.Query,
+		) (*RecordPage, error)
+		Record func(
+			[]byte,
+		) (*RecordDetails, error)
+	}
+}
+
+func (a *RecordsAPI) Query(q *store.Query) (*RecordPage, error) {
+	return a.Internal.Query(q)
+}
+
+func (a *RecordsAPI) Record(hash []byte) (*RecordDetails, error) {
+	return a.Internal.Record(hash)
+}
+
+// NewRecordsAPI returns the records API over the store.
+// The decoder and detailer are optional
+func NewRecordsAPI(s store.RecordStore, decoder *watcher.EventDecoder, detail TransitionDetailer) *RecordsAPI {
+	details := func(rec recorder.Record) *RecordDetails {
+		d := &RecordDetails{
+			Hash:        rec.Hash(),
+			Scope:       rec.Scope(),
+			Previous:    rec.Previous(),
+			PreState:    rec.PreState(),
+			PostState:   rec.PostState(),
+			Time:        rec.Time(),
+			Event:       rec.Event(),
+			Attestation: rec.Attestation(),
+		}
+		if decoder != nil && d.Event != nil {
+			d.Decoded, _ = decoder.Decode(d.Event)
+		}
+		if detail != nil {
+			d.Transition = detail(rec.Transition())
+		}
+		return d
+	}
+
+	api := &RecordsAPI{}
+	api.Internal.Query = func(q *store.Query) (*RecordPage, error) {
+		page, err := s.Query(q)
+		if err != nil {
+			return nil, err
+		}
+		out := &RecordPage{
+			Records: make([]*RecordDetails, len(page.Records)),
+			Next:    page.Next,
+		}
+		for i, rec := range page.Records {
+			out.Records[i] = details(rec)
+		}
+		return out, nil
+	}
+	api.Internal.Record = func(hash []byte) (*RecordDetails, error) {
+		rec, err := s.Get(hash)
+		if err != nil {
+			return nil, err
+		}
+		return details(rec), nil
+	}
+	return api
+}
@@ -1,19 +1,84 @@
 package watcher
 
 import (
+	"context"
+
 	core "github.com/0xBow-io/asp-go-buildkit/core"
+	"github.com/0xBow-io/asp-go-buildkit/core/alert"
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/0xBow-io/asp-go-buildkit/internal/erpc"
 	logging "github.com/ipfs/go-log/v2"
+	"github.com/pkg/errors"
 	"go.uber.org/fx"
 )
 
 var log = logging.Logger("observer/watcher")
 
+// Backends are the erpc backends of the chains by name
+type Backends map[string]erpc.Backend
+
+// Watchers are the watchers of the chains by name
+type Watchers map[string]*watcher.Service
+
 func ConstructModule(cfg *core.Config) fx.Option {
 	cfgErr := cfg.Validate()
 
 	return fx.Module("watcher",
 		fx.Supply(cfg),
 		fx.Error(cfgErr),
-		fx.Provide(),
+		fx.Provide(
+			newBackends,
+			newWatchers,
+		),
 	)
 }
+
+// newBackends dials the erpc backends of the chains of the config
+// the chain id of each backend is checked on start
+func newBackends(lc fx.Lifecycle, cfg *core.Config) (Backends, error) {
+	var (
+		backends = make(Backends, len(cfg.Chains))
+		dialed   []*erpc.ERPC
+	)
+	for _, chain := range cfg.Chains {
+		backend, err := erpc.NewERPC(chain.ErpcHttps)
+		if err != nil {
+			for _, b := range dialed {
+				b.Close()
+			}
+			return nil, errors.Wrapf(err, "failed to dial the erpc backend of chain %s", chain.Name)
+		}
+		backends[chain.Name] = backend
+		dialed = append(dialed, backend)
+
+		chain := chain
+		lc.Append(fx.Hook{
+			OnStart: func(ctx context.Context) error {
+				id, err := backend.ChainID(ctx)
+				if err != nil {
+					return errors.Wrapf(err, "failed to get the chain id of the erpc backend of chain %s", chain.Name)
+				}
+				if id.Uint64() != chain.ID {
+					return errors.Errorf("erpc backend of chain %s is of chain %d, expected %d", chain.Name, id, chain.ID)
+				}
+				log.Infow("connected to the erpc backend", "chain", chain.Name, "id", chain.ID)
+				return nil
+			},
+			OnStop: func(context.Context) error {
+				backend.Close()
+				return nil
+			},
+		})
+	}
+	return backends, nil
+}
+
+func newWatchers(backends Backends, alerts alert.Raiser) Watchers {
+	watchers := make(Watchers, len(backends))
+	for name, backend := range backends {
+		s := watcher.NewService(backend)
+		s.SetAlerter(alerts)
+		watchers[name] = s
+	}
+	return watchers
+}
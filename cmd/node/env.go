@@ -3,39 +3,27 @@ package main
 import (
 	"context"
 
+	observerBuilder "github.com/0xBow-io/asp-go-buildkit/builder/observer"
 	logging "github.com/ipfs/go-log/v2"
 	"go.uber.org/fx"
 )
 
 var log = logging.Logger("cmd")
 
-// Network reads the node type from the context.
-func Network(ctx context.Context) p2p.Network {
-	return ctx.Value(networkKey{}).(p2p.Network)
-}
-
-// StorePath reads the store path from the context.
-func StorePath(ctx context.Context) string {
-	return ctx.Value(storePathKey{}).(string)
+// NodeHome reads the node home from the context.
+func NodeHome(ctx context.Context) string {
+	home, _ := ctx.Value(nodeHomeKey{}).(string)
+	return home
 }
 
 // NodeConfig reads the node config from the context.
-func NodeConfig(ctx context.Context) Config {
-	cfg, ok := ctx.Value(configKey{}).(Config)
-	if !ok {
-		cfg = *DefaultConfig()
-	}
-	return cfg
-}
-
-// WithNetwork sets the network in the given context.
-func WithNetwork(ctx context.Context, network p2p.Network) context.Context {
-	return context.WithValue(ctx, networkKey{}, network)
+func NodeConfig(ctx context.Context) observerBuilder.Config {
+	return observerBuilder.ObserverConfig(ctx)
 }
 
-// WithStorePath sets Store Path in the given context.
-func WithStorePath(ctx context.Context, storePath string) context.Context {
-	return context.WithValue(ctx, storePathKey{}, storePath)
+// WithNodeHome sets the node home in the given context.
+func WithNodeHome(ctx context.Context, home string) context.Context {
+	return context.WithValue(ctx, nodeHomeKey{}, home)
 }
 
 // NodeOptions returns config options parsed from Environment(Flags, ENV vars, etc)
@@ -54,14 +42,11 @@ func WithNodeOptions(ctx context.Context, opts ...fx.Option) context.Context {
 }
 
 // WithNodeConfig sets the node config in the Env.
-func WithNodeConfig(ctx context.Context, config *nodebuilder.Config) context.Context {
-	return context.WithValue(ctx, configKey{}, *config)
+func WithNodeConfig(ctx context.Context, config *observerBuilder.Config) context.Context {
+	return observerBuilder.WithConfig(ctx, config)
 }
 
 type (
-	optionsKey   struct{}
-	configKey    struct{}
-	storePathKey struct{}
-	nodeTypeKey  struct{}
-	networkKey   struct{}
+	optionsKey  struct{}
+	nodeHomeKey struct{}
 )
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	observerBuilder "github.com/0xBow-io/asp-go-buildkit/builder/observer"
+	"github.com/0xBow-io/asp-go-buildkit/core"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+const (
+	homeFlag    = "home"
+	homeEnv     = "OBSERVER_HOME"
+	defaultHome = ".observer"
+	logFile     = "observer.log"
+)
+
+// homeDir returns the node home of the environment
+// else the default home of the user
+func homeDir() string {
+	if home := os.Getenv(homeEnv); home != "" {
+		return home
+	}
+	if user, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(user, defaultHome)
+	}
+	return defaultHome
+}
+
+// WithHomeFlag adds the node home flag
+// and sets the node home in the context
+func WithHomeFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().String(homeFlag, homeDir(),
+		"node home of the config file, data, keystore and logs (env "+homeEnv+")")
+	prev := cmd.PersistentPreRunE
+	cmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		home, err := cmd.Flags().GetString(homeFlag)
+		if err != nil {
+			return err
+		}
+		cmd.SetContext(WithNodeHome(cmd.Context(), home))
+		if prev != nil {
+			return prev(cmd, args)
+		}
+		return nil
+	}
+}
+
+/*
+WithConfigFlags adds the flags overriding the node config
+and reads the config of the node home before running.
+The config is the defaults, overridden by the config file,
+overridden by the environment, overridden by the flags.
+*/
+func WithConfigFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringToString("erpc-https", nil, "https endpoints of the erpc backends by chain (name=endpoint)")
+	flags.StringToString("erpc-wss", nil, "wss endpoints of the erpc backends by chain (name=endpoint)")
+	flags.StringSlice("instance", nil, "instances of the config observed by the node, defaults to all")
+	flags.StringToString("start-block", nil, "first block watched of the instances if nothing was recorded (instance=block)")
+	flags.String("log-level", "", "level of the node logs")
+
+	cmd.PreRunE = func(cmd *cobra.Command, _ []string) error {
+		ctx := cmd.Context()
+		cfg, err := observerBuilder.LoadConfig(NodeHome(ctx))
+		if err != nil {
+			return errors.Wrap(err, "run `observer init` to create the node home")
+		}
+		if err := endpointFlags(cmd, cfg); err != nil {
+			return err
+		}
+		if flags.Changed("instance") {
+			ids, _ := flags.GetStringSlice("instance")
+			if err := selectInstances(cfg, ids); err != nil {
+				return err
+			}
+		}
+		if flags.Changed("log-level") {
+			cfg.LogLevel, _ = flags.GetString("log-level")
+		}
+		if err := startBlockFlags(cmd, cfg); err != nil {
+			return err
+		}
+		if err := setupLogging(cfg); err != nil {
+			return err
+		}
+		cmd.SetContext(WithNodeConfig(ctx, cfg))
+		return nil
+	}
+}
+
+// endpointFlags overrides the endpoints of the chains of the config
+func endpointFlags(cmd *cobra.Command, cfg *observerBuilder.Config) error {
+	for _, name := range []string{"erpc-https", "erpc-wss"} {
+		endpoints, _ := cmd.Flags().GetStringToString(name)
+		for chain, endpoint := range endpoints {
+			idx := slices.IndexFunc(cfg.Chains, func(c core.Chain) bool { return c.Name == chain })
+			if idx < 0 {
+				return errors.Errorf("--%s: unknown chain %s", name, chain)
+			}
+			if name == "erpc-https" {
+				cfg.Chains[idx].ErpcHttps = endpoint
+			} else {
+				cfg.Chains[idx].ErpcWss = endpoint
+			}
+		}
+	}
+	return nil
+}
+
+// startBlockFlags overrides the start blocks of the instances of the config
+func startBlockFlags(cmd *cobra.Command, cfg *observerBuilder.Config) error {
+	blocks, _ := cmd.Flags().GetStringToString("start-block")
+	for id, block := range blocks {
+		idx := slices.IndexFunc(cfg.Instances, func(inst core.Instance) bool { return inst.InstanceID == id })
+		if idx < 0 {
+			return errors.Errorf("--start-block: unknown instance %s", id)
+		}
+		start, err := strconv.ParseUint(block, 10, 64)
+		if err != nil {
+			return errors.Wrapf(err, "--start-block: invalid block %s of instance %s", block, id)
+		}
+		cfg.Instances[idx].StartBlock = start
+	}
+	return nil
+}
+
+// selectInstances keeps the instances of the ids
+// and the chains they refer to in the config
+func selectInstances(cfg *observerBuilder.Config, ids []string) error {
+	var (
+		instances []core.Instance
+		chains    []core.Chain
+	)
+	for _, id := range ids {
+		idx := slices.IndexFunc(cfg.Instances, func(inst core.Instance) bool { return inst.InstanceID == id })
+		if idx < 0 {
+			return errors.Errorf("--instance: unknown instance %s", id)
+		}
+		instances = append(instances, cfg.Instances[idx])
+	}
+	for _, c := range cfg.Chains {
+		if slices.ContainsFunc(instances, func(inst core.Instance) bool { return inst.Chain == c.Name }) {
+			chains = append(chains, c)
+		}
+	}
+	cfg.Chains, cfg.Instances = chains, instances
+	return nil
+}
+
+// setupLogging logs to stderr and the log file of the config
+func setupLogging(cfg *observerBuilder.Config) error {
+	level, err := logging.LevelFromString(cfg.LogLevel)
+	if err != nil {
+		return errors.Wrapf(err, "invalid log level %s", cfg.LogLevel)
+	}
+	logCfg := logging.GetConfig()
+	logCfg.Level, logCfg.Stderr = level, true
+	if cfg.LogDir != "" {
+		if err := os.MkdirAll(cfg.LogDir, 0o755); err != nil {
+			return errors.Wrap(err, "failed to create the log dir")
+		}
+		logCfg.File = filepath.Join(cfg.LogDir, logFile)
+	}
+	logging.SetupLogging(logCfg)
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	observerBuilder "github.com/0xBow-io/asp-go-buildkit/builder/observer"
+	"github.com/0xBow-io/asp-go-buildkit/core"
+	"github.com/spf13/cobra"
+	"github.com/test-go/testify/require"
+)
+
+// testHome returns a node home of the gnosis defaults
+func testHome(t *testing.T) string {
+	home := t.TempDir()
+	cfg, err := observerBuilder.NetworkConfig("gnosis")
+	require.NoError(t, err)
+	cfg.Chains[0].ErpcHttps = "https://file"
+	cfg.Instances = []core.Instance{{ProtocolID: "privacy-pool", InstanceID: "GNOSIS_XDAI_POOL_1", Chain: "gnosis", StartBlock: 5}}
+	cfg.LogLevel = "info"
+	require.NoError(t, cfg.Save(observerBuilder.ConfigPath(home)))
+	return home
+}
+
+// runConfig runs a command of the config flags
+// with the args and returns the config it ran with
+func runConfig(t *testing.T, args ...string) (observerBuilder.Config, error) {
+	var cfg observerBuilder.Config
+	root := &cobra.Command{Use: "observer"}
+	WithHomeFlag(root)
+	cmd := &cobra.Command{
+		Use: "start",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg = NodeConfig(cmd.Context())
+			return nil
+		},
+	}
+	WithConfigFlags(cmd)
+	root.AddCommand(cmd)
+	root.SetArgs(append([]string{"start"}, args...))
+	root.SilenceUsage, root.SilenceErrors = true, true
+	return cfg, root.ExecuteContext(context.Background())
+}
+
+func Test_WithConfigFlags(t *testing.T) {
+	home := testHome(t)
+	t.Setenv("LOG_LEVEL", "error")
+	t.Setenv("ERPC_HTTPS_GNOSIS", "https://env")
+
+	// the environment overrides the file
+	cfg, err := runConfig(t, "--home", home)
+	require.NoError(t, err)
+	require.Equal(t, "error", cfg.LogLevel)
+	require.Equal(t, "https://env", cfg.Chains[0].ErpcHttps)
+	require.Equal(t, uint64(5), cfg.Instances[0].StartBlock)
+
+	// the flags override the environment
+	cfg, err = runConfig(t, "--home", home,
+		"--log-level", "debug",
+		"--erpc-https", "gnosis=https://flag",
+		"--start-block", "GNOSIS_XDAI_POOL_1=9",
+	)
+	require.NoError(t, err)
+	require.Equal(t, "debug", cfg.LogLevel)
+	require.Equal(t, "https://flag", cfg.Chains[0].ErpcHttps)
+	require.Equal(t, uint64(9), cfg.Instances[0].StartBlock)
+
+	_, err = runConfig(t, "--home", home, "--erpc-https", "sepolia=https://flag")
+	require.Error(t, err)
+	_, err = runConfig(t, "--home", home, "--start-block", "GNOSIS_XDAI_POOL_2=9")
+	require.Error(t, err)
+	_, err = runConfig(t, "--home", home, "--start-block", "GNOSIS_XDAI_POOL_1=latest")
+	require.Error(t, err)
+	_, err = runConfig(t, "--home", t.TempDir())
+	require.Error(t, err)
+}
+
+func Test_defaultInstance(t *testing.T) {
+	id, err := defaultInstance("privacy-pool", 11155111)
+	require.NoError(t, err)
+	require.NotEmpty(t, id)
+
+	_, err = defaultInstance("privacy-pool", 1)
+	require.Error(t, err)
+	_, err = defaultInstance("unknown", 11155111)
+	require.Error(t, err)
+}
+
+// testConfig returns a config of two chains
+// with two instances on the first one
+func testConfig() *observerBuilder.Config {
+	return &observerBuilder.Config{Config: core.Config{
+		Chains: []core.Chain{
+			{Name: "sepolia", ID: 11155111, ErpcHttps: "https://sepolia", ErpcWss: "wss://sepolia"},
+			{Name: "gnosis", ID: 100, ErpcHttps: "https://gnosis", ErpcWss: "wss://gnosis"},
+		},
+		Instances: []core.Instance{
+			{ProtocolID: "privacy-pool", InstanceID: "SEPOLIA_ETH_POOL_1", Chain: "sepolia"},
+			{ProtocolID: "privacy-pool", InstanceID: "SEPOLIA_ETH_POOL_2", Chain: "sepolia"},
+			{ProtocolID: "privacy-pool", InstanceID: "GNOSIS_XDAI_POOL_1", Chain: "gnosis"},
+		},
+	}}
+}
+
+func Test_endpointFlags(t *testing.T) {
+	var (
+		cmd = &cobra.Command{}
+		cfg = testConfig()
+	)
+	WithConfigFlags(cmd)
+	require.NoError(t, cmd.ParseFlags([]string{"--erpc-https", "gnosis=https://flag", "--erpc-wss", "sepolia=wss://flag"}))
+	require.NoError(t, endpointFlags(cmd, cfg))
+	require.Equal(t, "https://sepolia", cfg.Chains[0].ErpcHttps)
+	require.Equal(t, "wss://flag", cfg.Chains[0].ErpcWss)
+	require.Equal(t, "https://flag", cfg.Chains[1].ErpcHttps)
+	require.Equal(t, "wss://gnosis", cfg.Chains[1].ErpcWss)
+
+	cmd = &cobra.Command{}
+	WithConfigFlags(cmd)
+	require.NoError(t, cmd.ParseFlags([]string{"--erpc-wss", "mainnet=wss://flag"}))
+	require.Error(t, endpointFlags(cmd, testConfig()))
+}
+
+func Test_selectInstances(t *testing.T) {
+	cfg := testConfig()
+	require.NoError(t, selectInstances(cfg, []string{"SEPOLIA_ETH_POOL_2"}))
+	require.Equal(t, []core.Instance{{ProtocolID: "privacy-pool", InstanceID: "SEPOLIA_ETH_POOL_2", Chain: "sepolia"}}, cfg.Instances)
+	// the chains of no selected instance are left out
+	require.Len(t, cfg.Chains, 1)
+	require.Equal(t, "sepolia", cfg.Chains[0].Name)
+
+	cfg = testConfig()
+	require.NoError(t, selectInstances(cfg, []string{"GNOSIS_XDAI_POOL_1", "SEPOLIA_ETH_POOL_1"}))
+	require.Len(t, cfg.Instances, 2)
+	require.Len(t, cfg.Chains, 2)
+	require.NoError(t, cfg.Config.Validate())
+
+	require.Error(t, selectInstances(testConfig(), []string{"MAINNET_POOL_1"}))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	observerBuilder "github.com/0xBow-io/asp-go-buildkit/builder/observer"
+	"github.com/0xBow-io/asp-go-buildkit/core"
+	privacypool "github.com/0xBow-io/asp-go-buildkit/integrations/protocols/privacy-pool"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// Init constructs a CLI command to initialise the node home:
+// the config file with the defaults of the network and the
+// instances observed on it, the data, keystore and log directories.
+// Other chains and instances are added to the config file.
+func Init(options ...func(*cobra.Command)) *cobra.Command {
+	var (
+		network   string
+		protocol  string
+		instances []string
+		erpcHttps string
+		erpcWss   string
+		force     bool
+	)
+	cmd := &cobra.Command{
+		Use:          "init",
+		Short:        "Initialises the node home with the config file of the network defaults.",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			home := NodeHome(cmd.Context())
+			path := observerBuilder.ConfigPath(home)
+			if _, err := os.Stat(path); err == nil && !force {
+				return errors.Errorf("%s already exists, use --force to overwrite it", path)
+			}
+
+			cfg, err := observerBuilder.NetworkConfig(network)
+			if err != nil {
+				return err
+			}
+			chain := &cfg.Chains[0]
+			chain.ErpcHttps, chain.ErpcWss = erpcHttps, erpcWss
+			if len(instances) == 0 {
+				id, err := defaultInstance(protocol, chain.ID)
+				if err != nil {
+					return err
+				}
+				instances = []string{id}
+			}
+			for _, id := range instances {
+				cfg.Instances = append(cfg.Instances, core.Instance{
+					ProtocolID: protocol,
+					InstanceID: id,
+					Chain:      chain.Name,
+				})
+			}
+
+			// the directories are relative to the home
+			dirs := *cfg
+			dirs.Resolve(home)
+			for dir, perm := range map[string]os.FileMode{
+				home:             0o755,
+				dirs.DataDir:     0o755,
+				dirs.KeystoreDir: 0o700,
+				dirs.LogDir:      0o755,
+			} {
+				if err := os.MkdirAll(dir, perm); err != nil {
+					return errors.Wrapf(err, "failed to create %s", dir)
+				}
+			}
+			if err := cfg.Save(path); err != nil {
+				return err
+			}
+			fmt.Printf("Initialised the %s node home at %s\n", network, home)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&network, "network", observerBuilder.DefaultNetwork,
+		"network of the config defaults (sepolia|gnosis)")
+	cmd.Flags().StringVar(&protocol, "protocol", privacypool.ProtocolID, "protocol observed by the node")
+	cmd.Flags().StringSliceVar(&instances, "instance", nil,
+		"protocol instances of the network observed by the node, defaults to the first instance of the network")
+	cmd.Flags().StringVar(&erpcHttps, "erpc-https", "", "https endpoint of the erpc backend of the network")
+	cmd.Flags().StringVar(&erpcWss, "erpc-wss", "", "wss endpoint of the erpc backend of the network")
+	cmd.Flags().BoolVar(&force, "force", false, "overwrite an existing config file")
+
+	for _, option := range options {
+		option(cmd)
+	}
+	return cmd
+}
+
+// defaultInstance returns the default
+// instance of the protocol on the chain
+func defaultInstance(protocol string, chainID uint64) (string, error) {
+	p, err := observerBuilder.Lookup(protocol)
+	if err != nil {
+		return "", err
+	}
+	if d, ok := p.(observerBuilder.Defaulter); ok {
+		if id := d.DefaultInstance(chainID); id != "" {
+			return id, nil
+		}
+	}
+	return "", errors.Errorf("protocol %s has no default instance of chain %d, set --instance", protocol, chainID)
+}
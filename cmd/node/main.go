@@ -4,9 +4,22 @@ import (
 	"context"
 	"os"
 
+	observerBuilder "github.com/0xBow-io/asp-go-buildkit/builder/observer"
+	privacypool "github.com/0xBow-io/asp-go-buildkit/integrations/protocols/privacy-pool"
 	"github.com/spf13/cobra"
 )
 
+var _ observerBuilder.Detailer = privacypool.Protocol{}
+
+func init() {
+	observerBuilder.Register(privacypool.Protocol{})
+	WithHomeFlag(rootCmd)
+	rootCmd.AddCommand(
+		Init(),
+		Start(WithConfigFlags),
+	)
+}
+
 var rootCmd = &cobra.Command{
 	Use: "observer [protocol] [subcommand]",
 	Short: `
@@ -23,13 +23,8 @@ func Start(options ...func(*cobra.Command)) *cobra.Command {
 		RunE: func(cmd *cobra.Command, _ []string) (err error) {
 			ctx := cmd.Context()
 
-			// TODO: Apply configuration for the Observer Service
-			cfg := observerBuilder.ObserverConfig(ctx)
-
-			// observerBuilder is a package that constructs the observer service
-			// NodeType(ctx), Protocol(ctx), store, &cfg, NodeOptions(ctx)...
-			// TODO: Create the observerBuilder pkg
-			obs, err := observerBuilder.NewWithConfig(&cfg)
+			cfg := NodeConfig(ctx)
+			obs, err := observerBuilder.NewWithConfig(&cfg, NodeOptions(ctx)...)
 			if err != nil {
 				return err
 			}
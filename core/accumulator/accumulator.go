@@ -0,0 +1,299 @@
+/*
+Accumulates the record hashes into an append-only Poseidon Merkle tree
+to prove the inclusion of a record in the published history.
+*/
+
+package accumulator
+
+import (
+	"encoding/hex"
+	"math/big"
+	"sync"
+
+	posiedon "github.com/iden3/go-iden3-crypto/poseidon"
+	"github.com/iden3/go-iden3-crypto/utils"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/pkg/errors"
+)
+
+var (
+	log = logging.Logger("accumulator")
+)
+
+const (
+	DefaultDepth = 32
+	MaxDepth     = 64
+)
+
+var (
+	ErrInvalidDepth  = errors.New("invalid tree depth")
+	ErrTreeFull      = errors.New("tree is full")
+	ErrDuplicateLeaf = errors.New("leaf already accumulated")
+	ErrLeafNotFound  = errors.New("leaf not found")
+	ErrInvalidLeaf   = errors.New("invalid leaf")
+)
+
+// AppendHandler is called with the
+// published root after every append or truncation
+type AppendHandler func(root *big.Int, size uint64)
+
+// Accumulator is an incremental Poseidon Merkle tree of fixed depth.
+// Empty leaves are zero and empty subtrees hash to
+//
+//	zeros[i] = H(zeros[i-1], zeros[i-1])
+//
+// Only the non-empty nodes of every level are kept
+type Accumulator struct {
+	depth int
+	zeros []*big.Int
+	// nodes[0] are the leaves
+	// nodes[depth] holds the root
+	nodes    [][]*big.Int
+	index    map[string]uint64
+	onAppend []AppendHandler
+	lock     *sync.RWMutex
+}
+
+func New(depth int) (*Accumulator, error) {
+	if depth <= 0 || depth > MaxDepth {
+		return nil, errors.Wrapf(ErrInvalidDepth, "%d", depth)
+	}
+	zeros := make([]*big.Int, depth+1)
+	zeros[0] = big.NewInt(0)
+	for i := 1; i <= depth; i++ {
+		z, err := hash(zeros[i-1], zeros[i-1])
+		if err != nil {
+			return nil, err
+		}
+		zeros[i] = z
+	}
+	return &Accumulator{
+		depth: depth,
+		zeros: zeros,
+		nodes: make([][]*big.Int, depth+1),
+		index: make(map[string]uint64),
+		lock:  &sync.RWMutex{},
+	}, nil
+}
+
+func hash(left, right *big.Int) (*big.Int, error) {
+	return posiedon.Hash([]*big.Int{left, right})
+}
+
+// OnAppend registers a handler
+// of the published roots
+func (a *Accumulator) OnAppend(fn AppendHandler) {
+	defer a.lock.Unlock()
+	a.lock.Lock()
+	a.onAppend = append(a.onAppend, fn)
+}
+
+func (a *Accumulator) Depth() int { return a.depth }
+
+// Size returns the number of accumulated leaves
+func (a *Accumulator) Size() uint64 {
+	defer a.lock.RUnlock()
+	a.lock.RLock()
+	return uint64(len(a.nodes[0]))
+}
+
+// Root returns the current root of the tree
+func (a *Accumulator) Root() *big.Int {
+	defer a.lock.RUnlock()
+	a.lock.RLock()
+	return new(big.Int).Set(a.root())
+}
+
+func (a *Accumulator) root() *big.Int {
+	if len(a.nodes[a.depth]) == 0 {
+		return a.zeros[a.depth]
+	}
+	return a.nodes[a.depth][0]
+}
+
+// node returns the node at the level and position
+func (a *Accumulator) node(level int, pos uint64) *big.Int {
+	if pos < uint64(len(a.nodes[level])) {
+		return a.nodes[level][pos]
+	}
+	return a.zeros[level]
+}
+
+// Append accumulates the record hash as the next leaf
+// and publishes the new root
+func (a *Accumulator) Append(recordHash []byte) (*big.Int, error) {
+	a.lock.Lock()
+	leaf := new(big.Int).SetBytes(recordHash)
+	if len(recordHash) == 0 || !utils.CheckBigIntInField(leaf) {
+		a.lock.Unlock()
+		return nil, ErrInvalidLeaf
+	}
+	key := hex.EncodeToString(leaf.Bytes())
+	if _, ok := a.index[key]; ok {
+		a.lock.Unlock()
+		return nil, errors.Wrap(ErrDuplicateLeaf, key)
+	}
+	pos := uint64(len(a.nodes[0]))
+	if a.depth < 64 && pos >= uint64(1)<<a.depth {
+		a.lock.Unlock()
+		return nil, ErrTreeFull
+	}
+
+	// hash the path from the leaf up to the root
+	// before updating the tree
+	path := make([]*big.Int, a.depth+1)
+	path[0] = leaf
+	for level, p := 0, pos; level < a.depth; level, p = level+1, p/2 {
+		var (
+			parent *big.Int
+			err    error
+		)
+		if p%2 == 0 {
+			parent, err = hash(path[level], a.zeros[level])
+		} else {
+			parent, err = hash(a.node(level, p-1), path[level])
+		}
+		if err != nil {
+			a.lock.Unlock()
+			return nil, errors.Wrap(err, "failed to hash node")
+		}
+		path[level+1] = parent
+	}
+	for level, p := 0, pos; level <= a.depth; level, p = level+1, p/2 {
+		if p == uint64(len(a.nodes[level])) {
+			a.nodes[level] = append(a.nodes[level], path[level])
+		} else {
+			a.nodes[level][p] = path[level]
+		}
+	}
+	a.index[key] = pos
+
+	var (
+		root     = new(big.Int).Set(a.root())
+		size     = pos + 1
+		handlers = append([]AppendHandler(nil), a.onAppend...)
+	)
+	a.lock.Unlock()
+
+	log.Debugw("accumulator/Append: leaf appended", "index", pos, "root", root)
+	for _, fn := range handlers {
+		fn(new(big.Int).Set(root), size)
+	}
+	return root, nil
+}
+
+// Index returns the leaf index of the record hash
+func (a *Accumulator) Index(recordHash []byte) (uint64, bool) {
+	defer a.lock.RUnlock()
+	a.lock.RLock()
+	pos, ok := a.index[hex.EncodeToString(new(big.Int).SetBytes(recordHash).Bytes())]
+	return pos, ok
+}
+
+// Truncate drops the leaves from the index on
+// once their records have been rolled back by a reorg
+// and publishes the restored root
+func (a *Accumulator) Truncate(size uint64) (*big.Int, error) {
+	a.lock.Lock()
+	if size >= uint64(len(a.nodes[0])) {
+		root := new(big.Int).Set(a.root())
+		a.lock.Unlock()
+		return root, nil
+	}
+	for key, pos := range a.index {
+		if pos >= size {
+			delete(a.index, key)
+		}
+	}
+	a.nodes[0] = a.nodes[0][:size]
+	for level := 1; level <= a.depth; level++ {
+		// number of non-empty nodes of the level
+		n := (uint64(len(a.nodes[level-1])) + 1) / 2
+		a.nodes[level] = a.nodes[level][:n]
+		if n == 0 {
+			continue
+		}
+		// the last node covers dropped leaves
+		p := n - 1
+		parent, err := hash(a.node(level-1, 2*p), a.node(level-1, 2*p+1))
+		if err != nil {
+			a.lock.Unlock()
+			return nil, errors.Wrap(err, "failed to hash node")
+		}
+		a.nodes[level][p] = parent
+	}
+
+	var (
+		root     = new(big.Int).Set(a.root())
+		handlers = append([]AppendHandler(nil), a.onAppend...)
+	)
+	a.lock.Unlock()
+
+	log.Warnw("accumulator/Truncate: leaves dropped", "size", size, "root", root)
+	for _, fn := range handlers {
+		fn(new(big.Int).Set(root), size)
+	}
+	return root, nil
+}
+
+// Proof is the inclusion proof of a leaf
+type Proof struct {
+	Leaf  *big.Int `json:"leaf"`
+	Index uint64   `json:"index"`
+	// siblings from the leaf level up
+	Siblings []*big.Int `json:"siblings"`
+	Root     *big.Int   `json:"root"`
+}
+
+// Prove returns the inclusion proof of the
+// record hash against the current root
+func (a *Accumulator) Prove(recordHash []byte) (*Proof, error) {
+	defer a.lock.RUnlock()
+	a.lock.RLock()
+	leaf := new(big.Int).SetBytes(recordHash)
+	pos, ok := a.index[hex.EncodeToString(leaf.Bytes())]
+	if !ok {
+		return nil, ErrLeafNotFound
+	}
+	proof := &Proof{
+		Leaf:     leaf,
+		Index:    pos,
+		Siblings: make([]*big.Int, a.depth),
+		Root:     new(big.Int).Set(a.root()),
+	}
+	for level, p := 0, pos; level < a.depth; level, p = level+1, p/2 {
+		proof.Siblings[level] = new(big.Int).Set(a.node(level, p^1))
+	}
+	return proof, nil
+}
+
+// Verify returns true if the proof
+// is of the record hash
+func (p *Proof) Verify(recordHash []byte) bool {
+	if p.Leaf == nil || p.Leaf.Cmp(new(big.Int).SetBytes(recordHash)) != 0 {
+		return false
+	}
+	root, err := p.Compute()
+	return err == nil && root.Cmp(p.Root) == 0
+}
+
+// Compute returns the root hashed
+// from the leaf along the siblings
+func (p *Proof) Compute() (*big.Int, error) {
+	if p.Leaf == nil || p.Root == nil || len(p.Siblings) > MaxDepth {
+		return nil, errors.New("malformed proof")
+	}
+	node := p.Leaf
+	for level, pos := 0, p.Index; level < len(p.Siblings); level, pos = level+1, pos/2 {
+		var err error
+		if pos%2 == 0 {
+			node, err = hash(node, p.Siblings[level])
+		} else {
+			node, err = hash(p.Siblings[level], node)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to hash node")
+		}
+	}
+	return node, nil
+}
@@ -0,0 +1,97 @@
+package accumulator
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/test-go/testify/require"
+)
+
+// naiveRoot hashes the full tree of the leaves
+func naiveRoot(t *testing.T, depth int, leaves []*big.Int) *big.Int {
+	level := make([]*big.Int, 1<<depth)
+	for i := range level {
+		level[i] = big.NewInt(0)
+		if i < len(leaves) {
+			level[i] = leaves[i]
+		}
+	}
+	for len(level) > 1 {
+		next := make([]*big.Int, len(level)/2)
+		for i := range next {
+			h, err := hash(level[2*i], level[2*i+1])
+			require.NoError(t, err)
+			next[i] = h
+		}
+		level = next
+	}
+	return level[0]
+}
+
+func Test_Accumulator(t *testing.T) {
+	const depth = 3
+	acc, err := New(depth)
+	require.NoError(t, err)
+	require.Equal(t, naiveRoot(t, depth, nil), acc.Root())
+
+	var (
+		leaves    []*big.Int
+		published []*big.Int
+	)
+	acc.OnAppend(func(root *big.Int, size uint64) {
+		published = append(published, root)
+	})
+
+	for i := int64(1); i <= 1<<depth; i++ {
+		leaf := big.NewInt(i * 1000)
+		root, err := acc.Append(leaf.Bytes())
+		require.NoError(t, err)
+		leaves = append(leaves, leaf)
+		require.Equal(t, naiveRoot(t, depth, leaves), root)
+	}
+	require.Len(t, published, 1<<depth)
+	require.Equal(t, acc.Root(), published[len(published)-1])
+
+	_, err = acc.Append(big.NewInt(1).Bytes())
+	require.True(t, errors.Is(err, ErrTreeFull))
+
+	for _, leaf := range leaves {
+		proof, err := acc.Prove(leaf.Bytes())
+		require.NoError(t, err)
+		require.Equal(t, acc.Root(), proof.Root)
+		require.True(t, proof.Verify(leaf.Bytes()))
+		require.False(t, proof.Verify(big.NewInt(7).Bytes()))
+	}
+
+	// a proof of a tampered sibling does not verify
+	proof, err := acc.Prove(leaves[2].Bytes())
+	require.NoError(t, err)
+	proof.Siblings[1] = big.NewInt(1)
+	require.False(t, proof.Verify(leaves[2].Bytes()))
+
+	_, err = acc.Prove(big.NewInt(7).Bytes())
+	require.True(t, errors.Is(err, ErrLeafNotFound))
+
+	// truncation restores the root of the remaining leaves
+	root, err := acc.Truncate(3)
+	require.NoError(t, err)
+	require.Equal(t, naiveRoot(t, depth, leaves[:3]), root)
+	_, ok := acc.Index(leaves[3].Bytes())
+	require.False(t, ok)
+	root, err = acc.Append(leaves[4].Bytes())
+	require.NoError(t, err)
+	require.Equal(t, naiveRoot(t, depth, append(leaves[:3:3], leaves[4])), root)
+}
+
+func Test_Accumulator_Duplicate(t *testing.T) {
+	acc, err := New(DefaultDepth)
+	require.NoError(t, err)
+	_, err = acc.Append([]byte{1})
+	require.NoError(t, err)
+	_, err = acc.Append([]byte{1})
+	require.True(t, errors.Is(err, ErrDuplicateLeaf))
+
+	_, err = New(0)
+	require.True(t, errors.Is(err, ErrInvalidDepth))
+}
@@ -0,0 +1,115 @@
+package alert
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var (
+	log = logging.Logger("alert")
+)
+
+type Severity uint8
+
+const (
+	Info Severity = iota
+	Warning
+	Critical
+)
+
+func (s Severity) String() string {
+	names := [...]string{"info", "warning", "critical"}
+	if int(s) >= len(names) {
+		return fmt.Sprintf("severity(%d)", uint8(s))
+	}
+	return names[s]
+}
+
+func (s Severity) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+// ParseSeverity returns the severity of the given name
+func ParseSeverity(name string) (Severity, error) {
+	switch strings.ToLower(name) {
+	case "info":
+		return Info, nil
+	case "warning", "warn":
+		return Warning, nil
+	case "critical":
+		return Critical, nil
+	}
+	return Info, fmt.Errorf("unknown severity %q", name)
+}
+
+// Alert is a failure or suspicious
+// condition raised by a service
+type Alert struct {
+	Severity Severity          `json:"severity"`
+	Source   string            `json:"source"`
+	Title    string            `json:"title"`
+	Message  string            `json:"message"`
+	Fields   map[string]string `json:"fields,omitempty"`
+	Time     time.Time         `json:"time"`
+	// number of duplicates suppressed
+	// since the alert was last sent
+	Suppressed int `json:"suppressed,omitempty"`
+}
+
+// New returns a new alert
+// fields are given as key value pairs
+func New(sev Severity, source string, title string, msg string, fields ...string) *Alert {
+	a := &Alert{
+		Severity: sev,
+		Source:   source,
+		Title:    title,
+		Message:  msg,
+		Fields:   make(map[string]string, len(fields)/2),
+		Time:     time.Now().UTC(),
+	}
+	for i := 0; i+1 < len(fields); i += 2 {
+		a.Fields[fields[i]] = fields[i+1]
+	}
+	return a
+}
+
+// Key returns the key used to deduplicate the alert
+func (a *Alert) Key() string {
+	keys := make([]string, 0, len(a.Fields))
+	for k := range a.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s|%s|%s", a.Severity, a.Source, a.Title)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "|%s=%s", k, a.Fields[k])
+	}
+	return b.String()
+}
+
+func (a *Alert) String() string {
+	return fmt.Sprintf("[%s] %s: %s - %s", a.Severity, a.Source, a.Title, a.Message)
+}
+
+// Sink delivers alerts to a destination
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, a *Alert) error
+}
+
+// Raiser raises alerts
+type Raiser interface {
+	Raise(a *Alert)
+}
+
+type nop struct{}
+
+func (nop) Raise(*Alert) {}
+
+// Nop is a raiser which drops every alert
+var Nop Raiser = nop{}
@@ -0,0 +1,52 @@
+package alert
+
+import (
+	"time"
+
+	"github.com/ilyakaznacheev/cleanenv"
+)
+
+type Config struct {
+	MinSeverity  string        `env:"ALERT_MIN_SEVERITY" env-default:"warning"`
+	DedupWindow  time.Duration `env:"ALERT_DEDUP_WINDOW" env-default:"5m"`
+	RateLimit    int           `env:"ALERT_RATE_LIMIT" env-default:"10"`
+	RateInterval time.Duration `env:"ALERT_RATE_INTERVAL" env-default:"1m"`
+
+	File       string `env:"ALERT_FILE"`
+	WebhookURL string `env:"ALERT_WEBHOOK_URL"`
+
+	SMTPAddr     string   `env:"ALERT_SMTP_ADDR"`
+	SMTPUsername string   `env:"ALERT_SMTP_USERNAME"`
+	SMTPPassword string   `env:"ALERT_SMTP_PASSWORD"`
+	SMTPFrom     string   `env:"ALERT_SMTP_FROM"`
+	SMTPTo       []string `env:"ALERT_SMTP_TO" env-separator:","`
+}
+
+func NewConfig() (Config, error) {
+	conf := Config{}
+	err := cleanenv.ReadEnv(&conf)
+	return conf, err
+}
+
+// NewFromConfig returns a manager with the configured sinks.
+// Alerts are always logged, the file, webhook and smtp
+// sinks are only registered when configured
+func NewFromConfig(cfg Config) (*Manager, error) {
+	min, err := ParseSeverity(cfg.MinSeverity)
+	if err != nil {
+		return nil, err
+	}
+
+	m := NewManager(cfg.DedupWindow, cfg.RateLimit, cfg.RateInterval)
+	m.Register(NewLogSink(), Info)
+	if cfg.File != "" {
+		m.Register(NewFileSink(cfg.File), min)
+	}
+	if cfg.WebhookURL != "" {
+		m.Register(NewWebhookSink(cfg.WebhookURL, nil), min)
+	}
+	if cfg.SMTPAddr != "" && len(cfg.SMTPTo) > 0 {
+		m.Register(NewSMTPSink(cfg.SMTPAddr, cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPFrom, cfg.SMTPTo), min)
+	}
+	return m, nil
+}
@@ -0,0 +1,156 @@
+package alert
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var _ Raiser = (*Manager)(nil)
+
+const (
+	queueSize   = 256
+	sendTimeout = 10 * time.Second
+)
+
+type route struct {
+	sink    Sink
+	min     Severity
+	window  time.Time
+	counter int
+}
+
+type seen struct {
+	last       time.Time
+	suppressed int
+}
+
+// Manager deduplicates and rate limits alerts
+// before dispatching them to the registered sinks
+type Manager struct {
+	routes []*route
+	seen   map[string]*seen
+
+	// duplicates raised within the dedup window are suppressed
+	dedupWindow time.Duration
+	// at most rateLimit alerts are sent
+	// to a sink every rateInterval
+	rateLimit    int
+	rateInterval time.Duration
+
+	queue  chan *Alert
+	done   chan struct{}
+	closed bool
+	lock   *sync.Mutex
+}
+
+func NewManager(dedupWindow time.Duration, rateLimit int, rateInterval time.Duration) *Manager {
+	m := &Manager{
+		seen:         make(map[string]*seen),
+		dedupWindow:  dedupWindow,
+		rateLimit:    rateLimit,
+		rateInterval: rateInterval,
+		queue:        make(chan *Alert, queueSize),
+		done:         make(chan struct{}),
+		lock:         &sync.Mutex{},
+	}
+	go m.dispatch()
+	return m
+}
+
+// Register routes alerts of at least
+// the min severity to the sink
+func (m *Manager) Register(sink Sink, min Severity) {
+	defer m.lock.Unlock()
+	m.lock.Lock()
+	m.routes = append(m.routes, &route{sink: sink, min: min})
+}
+
+// Raise queues the alert for dispatch
+// unless it is a duplicate within the dedup window.
+// Never blocks, alerts are dropped if the queue is full
+func (m *Manager) Raise(a *Alert) {
+	defer m.lock.Unlock()
+	m.lock.Lock()
+	if a == nil || m.closed || !m.admit(a) {
+		return
+	}
+	select {
+	case m.queue <- a:
+	default:
+		log.Errorw("alert/Raise: queue full, dropping alert", "alert", a.String())
+	}
+}
+
+func (m *Manager) admit(a *Alert) bool {
+	key := a.Key()
+	if s, ok := m.seen[key]; ok && a.Time.Sub(s.last) < m.dedupWindow {
+		s.suppressed++
+		return false
+	} else if ok {
+		a.Suppressed = s.suppressed
+	}
+	m.seen[key] = &seen{last: a.Time}
+
+	// forget alerts which are
+	// out of the dedup window
+	for k, s := range m.seen {
+		if a.Time.Sub(s.last) >= m.dedupWindow {
+			delete(m.seen, k)
+		}
+	}
+	return true
+}
+
+// allow returns true if the route
+// is within its rate limit
+func (m *Manager) allow(r *route, now time.Time) bool {
+	defer m.lock.Unlock()
+	m.lock.Lock()
+	if now.Sub(r.window) >= m.rateInterval {
+		r.window = now
+		r.counter = 0
+	}
+	if r.counter >= m.rateLimit {
+		return false
+	}
+	r.counter++
+	return true
+}
+
+func (m *Manager) dispatch() {
+	defer close(m.done)
+	for a := range m.queue {
+		m.lock.Lock()
+		routes := append([]*route(nil), m.routes...)
+		m.lock.Unlock()
+
+		for _, r := range routes {
+			if a.Severity < r.min {
+				continue
+			}
+			if !m.allow(r, time.Now()) {
+				log.Warnw("alert/dispatch: rate limited", "sink", r.sink.Name(), "alert", a.String())
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+			if err := r.sink.Send(ctx, a); err != nil {
+				log.Errorw("alert/dispatch: failed to send alert", "sink", r.sink.Name(), "error", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// Close stops accepting alerts and
+// waits for the queued alerts to be dispatched
+func (m *Manager) Close() error {
+	m.lock.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.queue)
+	}
+	m.lock.Unlock()
+	<-m.done
+	return nil
+}
@@ -0,0 +1,59 @@
+package alert
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/require"
+)
+
+type testSink struct {
+	lock *sync.Mutex
+	sent []*Alert
+}
+
+func (s *testSink) Name() string { return "test" }
+func (s *testSink) Send(_ context.Context, a *Alert) error {
+	defer s.lock.Unlock()
+	s.lock.Lock()
+	s.sent = append(s.sent, a)
+	return nil
+}
+
+func Test_Manager(t *testing.T) {
+	var (
+		m        = NewManager(time.Minute, 3, time.Hour)
+		sink     = &testSink{lock: &sync.Mutex{}}
+		critical = &testSink{lock: &sync.Mutex{}}
+	)
+	m.Register(sink, Info)
+	m.Register(critical, Critical)
+
+	// duplicates within the dedup window are suppressed
+	first := New(Critical, "detector", "invalid state transition", "rejected", "scope", "0x01")
+	m.Raise(first)
+	m.Raise(New(Critical, "detector", "invalid state transition", "rejected", "scope", "0x01"))
+
+	// alerts outside of the dedup window pass
+	late := New(Critical, "detector", "invalid state transition", "rejected", "scope", "0x01")
+	late.Time = first.Time.Add(2 * time.Minute)
+	m.Raise(late)
+
+	// the sink is rate limited to 3 alerts
+	m.Raise(New(Warning, "watcher", "a", ""))
+	m.Raise(New(Warning, "watcher", "b", ""))
+	require.NoError(t, m.Close())
+
+	require.Len(t, sink.sent, 3)
+	require.Equal(t, 0, sink.sent[0].Suppressed)
+	require.Equal(t, 1, sink.sent[1].Suppressed)
+	require.Equal(t, "a", sink.sent[2].Title)
+
+	// warnings are not routed to the critical sink
+	require.Len(t, critical.sent, 2)
+
+	// raising on a closed manager is a no-op
+	m.Raise(New(Critical, "recorder", "c", ""))
+}
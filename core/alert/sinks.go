@@ -0,0 +1,231 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultSMTPTimeout bounds the mailing of an
+// alert if the context of Send has no deadline
+const DefaultSMTPTimeout = 30 * time.Second
+
+var (
+	_ Sink = (*LogSink)(nil)
+	_ Sink = (*FileSink)(nil)
+	_ Sink = (*WebhookSink)(nil)
+	_ Sink = (*SMTPSink)(nil)
+)
+
+// LogSink writes alerts as structured log entries
+type LogSink struct{}
+
+func NewLogSink() *LogSink { return &LogSink{} }
+
+func (*LogSink) Name() string { return "log" }
+
+func (*LogSink) Send(_ context.Context, a *Alert) error {
+	kv := []interface{}{"source", a.Source, "title", a.Title, "suppressed", a.Suppressed}
+	for k, v := range a.Fields {
+		kv = append(kv, k, v)
+	}
+	switch a.Severity {
+	case Critical:
+		log.Errorw(a.Message, kv...)
+	case Warning:
+		log.Warnw(a.Message, kv...)
+	default:
+		log.Infow(a.Message, kv...)
+	}
+	return nil
+}
+
+// FileSink appends alerts as json lines to a local file
+type FileSink struct {
+	path string
+	lock *sync.Mutex
+}
+
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path, lock: &sync.Mutex{}}
+}
+
+func (s *FileSink) Name() string { return "file" }
+
+func (s *FileSink) Send(_ context.Context, a *Alert) error {
+	line, err := json.Marshal(a)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode alert")
+	}
+
+	defer s.lock.Unlock()
+	s.lock.Lock()
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return errors.Wrap(err, "failed to open alert file")
+	}
+	defer f.Close()
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "failed to write alert")
+	}
+	return nil
+}
+
+// WebhookSink posts alerts as json to a http endpoint
+type WebhookSink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func NewWebhookSink(url string, headers map[string]string) *WebhookSink {
+	return &WebhookSink{url: url, headers: headers, client: &http.Client{}}
+}
+
+func (s *WebhookSink) Name() string { return "webhook" }
+
+func (s *WebhookSink) Send(ctx context.Context, a *Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode alert")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to create webhook request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to post alert")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SMTPSink mails alerts to a list of recipients
+type SMTPSink struct {
+	addr string
+	auth smtp.Auth
+	from string
+	to   []string
+}
+
+// NewSMTPSink returns a smtp sink
+// PLAIN auth is used if a username is given
+func NewSMTPSink(addr string, username string, password string, from string, to []string) *SMTPSink {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, strings.Split(addr, ":")[0])
+	}
+	return &SMTPSink{addr: addr, auth: auth, from: from, to: to}
+}
+
+func (s *SMTPSink) Name() string { return "smtp" }
+
+func (s *SMTPSink) Send(ctx context.Context, a *Alert) error {
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\n", s.from)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&body, "Subject: [%s] %s: %s\r\n", strings.ToUpper(a.Severity.String()), a.Source, a.Title)
+	fmt.Fprintf(&body, "Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	fmt.Fprintf(&body, "%s\r\n\r\n", a.Message)
+	fmt.Fprintf(&body, "time: %s\r\n", a.Time.Format("2006-01-02T15:04:05Z07:00"))
+	if a.Suppressed > 0 {
+		fmt.Fprintf(&body, "suppressed: %d\r\n", a.Suppressed)
+	}
+
+	keys := make([]string, 0, len(a.Fields))
+	for k := range a.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&body, "%s: %s\r\n", k, a.Fields[k])
+	}
+
+	if err := s.mail(ctx, []byte(body.String())); err != nil {
+		return errors.Wrap(err, "failed to mail alert")
+	}
+	return nil
+}
+
+// mail sends the message as smtp.SendMail does,
+// the connection is bound by the deadline of the
+// context and closed once the context is done
+func (s *SMTPSink) mail(ctx context.Context, msg []byte) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultSMTPTimeout)
+		defer cancel()
+	}
+	deadline, _ := ctx.Deadline()
+
+	conn, err := (&net.Dialer{Deadline: deadline}).DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return err
+	}
+
+	host, _, _ := net.SplitHostPort(s.addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp server doesn't support AUTH")
+		}
+		if err := c.Auth(s.auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.from); err != nil {
+		return err
+	}
+	for _, to := range s.to {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,163 @@
+package alert
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/require"
+)
+
+// sentAlert is the json encoding of an alert
+type sentAlert struct {
+	Severity string            `json:"severity"`
+	Source   string            `json:"source"`
+	Title    string            `json:"title"`
+	Fields   map[string]string `json:"fields"`
+	// headers of the webhook request
+	Header http.Header `json:"-"`
+}
+
+func Test_FileSink(t *testing.T) {
+	var (
+		path = filepath.Join(t.TempDir(), "alerts.jsonl")
+		sink = NewFileSink(path)
+	)
+	require.NoError(t, sink.Send(context.Background(), New(Warning, "detector", "state flagged", "gap")))
+	require.NoError(t, sink.Send(context.Background(), New(Critical, "store", "failed to persist record", "io", "record", "0x01")))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+
+	var a sentAlert
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &a))
+	require.Equal(t, "critical", a.Severity)
+	require.Equal(t, "store", a.Source)
+	require.Equal(t, "0x01", a.Fields["record"])
+}
+
+func Test_WebhookSink(t *testing.T) {
+	var (
+		received = make(chan sentAlert, 1)
+		status   = http.StatusOK
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a, code := sentAlert{Header: r.Header}, status
+		json.NewDecoder(r.Body).Decode(&a)
+		received <- a
+		w.WriteHeader(code)
+	}))
+	defer srv.Close()
+
+	sink := NewWebhookSink(srv.URL, map[string]string{"X-Token": "secret"})
+	require.NoError(t, sink.Send(context.Background(), New(Critical, "observer", "buffer root mismatch", "got 1")))
+	a := <-received
+	require.Equal(t, "critical", a.Severity)
+	require.Equal(t, "buffer root mismatch", a.Title)
+	require.Equal(t, "application/json", a.Header.Get("Content-Type"))
+	require.Equal(t, "secret", a.Header.Get("X-Token"))
+
+	// non 2xx responses are failures
+	status = http.StatusInternalServerError
+	require.Error(t, sink.Send(context.Background(), New(Info, "observer", "test", "")))
+	<-received
+}
+
+// serveSMTP answers a single smtp session on the
+// listener and returns the mailed message
+func serveSMTP(l net.Listener) <-chan string {
+	out := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var (
+			r   = bufio.NewReader(conn)
+			msg strings.Builder
+		)
+		io.WriteString(conn, "220 localhost ESMTP\r\n")
+		for data := false; ; {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if data {
+				if line == ".\r\n" {
+					data = false
+					io.WriteString(conn, "250 OK\r\n")
+					continue
+				}
+				msg.WriteString(line)
+				continue
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "EHLO", "HELO":
+				io.WriteString(conn, "250 localhost\r\n")
+			case "DATA":
+				data = true
+				io.WriteString(conn, "354 go ahead\r\n")
+			case "QUIT":
+				io.WriteString(conn, "221 bye\r\n")
+				out <- msg.String()
+				return
+			default:
+				io.WriteString(conn, "250 OK\r\n")
+			}
+		}
+	}()
+	return out
+}
+
+func Test_SMTPSink(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	var (
+		mailed = serveSMTP(l)
+		sink   = NewSMTPSink(l.Addr().String(), "", "", "asp@localhost", []string{"ops@localhost"})
+	)
+	require.NoError(t, sink.Send(context.Background(), New(Critical, "validator", "divergence", "missing root", "kind", "missing-root")))
+	msg := <-mailed
+	require.Contains(t, msg, "Subject: [CRITICAL] validator: divergence")
+	require.Contains(t, msg, "kind: missing-root")
+}
+
+func Test_SMTPSink_Deadline(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	// the server accepts but never greets
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			defer conn.Close()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	sink := NewSMTPSink(l.Addr().String(), "", "", "asp@localhost", []string{"ops@localhost"})
+	require.Error(t, sink.Send(ctx, New(Info, "observer", "test", "")))
+	require.True(t, time.Since(start) < time.Second)
+}
+
+func Test_Severity(t *testing.T) {
+	require.Equal(t, "critical", Critical.String())
+	require.Equal(t, "severity(7)", Severity(7).String())
+}
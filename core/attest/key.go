@@ -0,0 +1,158 @@
+/*
+Operator keys which attest the records produced by the recorder.
+*/
+
+package attest
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/iden3/go-iden3-crypto/babyjub"
+	"github.com/iden3/go-iden3-crypto/utils"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrUnknownScheme    = errors.New("unknown signature scheme")
+	ErrInvalidKey       = errors.New("invalid private key")
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// Key is an operator key
+type Key interface {
+	recorder.Signer
+	// Bytes returns the raw private key
+	Bytes() []byte
+}
+
+var (
+	_ Key = (*babyJubJubKey)(nil)
+	_ Key = (*secp256k1Key)(nil)
+)
+
+// Generate returns a new random key of the scheme
+func Generate(scheme recorder.Scheme) (Key, error) {
+	switch scheme {
+	case recorder.BabyJubJub:
+		k := babyjub.NewRandPrivKey()
+		return &babyJubJubKey{k: k}, nil
+	case recorder.Secp256k1:
+		k, err := crypto.GenerateKey()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to generate key")
+		}
+		return &secp256k1Key{k: k}, nil
+	}
+	return nil, errors.Wrap(ErrUnknownScheme, string(scheme))
+}
+
+// Import returns the key of the scheme
+// from the raw 32 byte private key
+func Import(scheme recorder.Scheme, raw []byte) (Key, error) {
+	if len(raw) != 32 {
+		return nil, errors.Wrapf(ErrInvalidKey, "expected 32 bytes, got %d", len(raw))
+	}
+	switch scheme {
+	case recorder.BabyJubJub:
+		k := babyjub.PrivateKey{}
+		copy(k[:], raw)
+		return &babyJubJubKey{k: k}, nil
+	case recorder.Secp256k1:
+		k, err := crypto.ToECDSA(raw)
+		if err != nil {
+			return nil, errors.Wrap(ErrInvalidKey, err.Error())
+		}
+		return &secp256k1Key{k: k}, nil
+	}
+	return nil, errors.Wrap(ErrUnknownScheme, string(scheme))
+}
+
+// babyJubJubKey signs the record hash
+// as a field element with EdDSA-Poseidon
+type babyJubJubKey struct {
+	k babyjub.PrivateKey
+}
+
+func (k *babyJubJubKey) Scheme() recorder.Scheme { return recorder.BabyJubJub }
+func (k *babyJubJubKey) Bytes() []byte           { return append([]byte(nil), k.k[:]...) }
+
+func (k *babyJubJubKey) PublicKey() []byte {
+	pub := k.k.Public().Compress()
+	return pub[:]
+}
+
+func (k *babyJubJubKey) Sign(hash []byte) ([]byte, error) {
+	msg := new(big.Int).SetBytes(hash)
+	if !utils.CheckBigIntInField(msg) {
+		return nil, errors.New("hash is not a field element")
+	}
+	sig := k.k.SignPoseidon(msg).Compress()
+	return sig[:], nil
+}
+
+// secp256k1Key signs the record hash
+// left padded to 32 bytes with ECDSA
+type secp256k1Key struct {
+	k *ecdsa.PrivateKey
+}
+
+func (k *secp256k1Key) Scheme() recorder.Scheme { return recorder.Secp256k1 }
+func (k *secp256k1Key) Bytes() []byte           { return crypto.FromECDSA(k.k) }
+func (k *secp256k1Key) PublicKey() []byte       { return crypto.CompressPubkey(&k.k.PublicKey) }
+
+func (k *secp256k1Key) Sign(hash []byte) ([]byte, error) {
+	return crypto.Sign(common.LeftPadBytes(hash, 32), k.k)
+}
+
+// Verify verifies the attestation of the hash
+func Verify(hash []byte, a *recorder.Attestation) error {
+	if a == nil {
+		return errors.New("record is not attested")
+	}
+	switch a.Scheme {
+	case recorder.BabyJubJub:
+		var (
+			pubComp babyjub.PublicKeyComp
+			sigComp babyjub.SignatureComp
+		)
+		if len(a.PublicKey) != len(pubComp) || len(a.Signature) != len(sigComp) {
+			return ErrInvalidSignature
+		}
+		copy(pubComp[:], a.PublicKey)
+		copy(sigComp[:], a.Signature)
+		pub, err := pubComp.Decompress()
+		if err != nil {
+			return errors.Wrap(ErrInvalidSignature, err.Error())
+		}
+		sig, err := sigComp.Decompress()
+		if err != nil {
+			return errors.Wrap(ErrInvalidSignature, err.Error())
+		}
+		if !pub.VerifyPoseidon(new(big.Int).SetBytes(hash), sig) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case recorder.Secp256k1:
+		if len(a.Signature) != crypto.SignatureLength {
+			return ErrInvalidSignature
+		}
+		if !crypto.VerifySignature(a.PublicKey, common.LeftPadBytes(hash, 32), a.Signature[:64]) {
+			return ErrInvalidSignature
+		}
+		return nil
+	}
+	return errors.Wrap(ErrUnknownScheme, string(a.Scheme))
+}
+
+// VerifyRecord verifies the record commits to its
+// hash and that the hash is attested
+func VerifyRecord(rec recorder.Record) error {
+	if !rec.Verify() {
+		return errors.New("hash does not commit to the record")
+	}
+	return Verify(rec.Hash(), rec.Attestation())
+}
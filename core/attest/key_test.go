@@ -0,0 +1,64 @@
+package attest
+
+import (
+	"errors"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	posiedon "github.com/iden3/go-iden3-crypto/poseidon"
+	"github.com/test-go/testify/require"
+)
+
+func Test_Attest(t *testing.T) {
+	hash, err := posiedon.HashBytes([]byte("record"))
+	require.NoError(t, err)
+	other := new(big.Int).Add(hash, big.NewInt(1))
+
+	for _, scheme := range []recorder.Scheme{recorder.BabyJubJub, recorder.Secp256k1} {
+		key, err := Generate(scheme)
+		require.NoError(t, err)
+
+		a, err := recorder.Attest(key, hash.Bytes())
+		require.NoError(t, err)
+		require.Equal(t, scheme, a.Scheme)
+		require.NoError(t, Verify(hash.Bytes(), a))
+		require.True(t, errors.Is(Verify(other.Bytes(), a), ErrInvalidSignature))
+
+		// the imported key signs for the same public key
+		imported, err := Import(scheme, key.Bytes())
+		require.NoError(t, err)
+		require.Equal(t, key.PublicKey(), imported.PublicKey())
+
+		// an attestation by another key does not verify
+		forged, err := Generate(scheme)
+		require.NoError(t, err)
+		b, err := recorder.Attest(forged, hash.Bytes())
+		require.NoError(t, err)
+		b.PublicKey = a.PublicKey
+		require.Error(t, Verify(hash.Bytes(), b))
+	}
+
+	_, err = Generate("rsa")
+	require.True(t, errors.Is(err, ErrUnknownScheme))
+}
+
+func Test_Keystore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore", "operator.json")
+	key, err := Generate(recorder.BabyJubJub)
+	require.NoError(t, err)
+	require.NoError(t, save(path, key, "secret", keystore.LightScryptN, keystore.LightScryptP))
+
+	// existing keystore files are not overwritten
+	require.Error(t, save(path, key, "secret", keystore.LightScryptN, keystore.LightScryptP))
+
+	loaded, err := Load(path, "secret")
+	require.NoError(t, err)
+	require.Equal(t, recorder.BabyJubJub, loaded.Scheme())
+	require.Equal(t, key.Bytes(), loaded.Bytes())
+
+	_, err = Load(path, "wrong")
+	require.Error(t, err)
+}
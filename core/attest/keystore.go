@@ -0,0 +1,74 @@
+package attest
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/pkg/errors"
+)
+
+// keyFile is the json encoding of
+// a passphrase encrypted operator key
+type keyFile struct {
+	Scheme    recorder.Scheme     `json:"scheme"`
+	PublicKey string              `json:"publicKey"`
+	Crypto    keystore.CryptoJSON `json:"crypto"`
+	Version   int                 `json:"version"`
+}
+
+// Save encrypts the key with the passphrase
+// and writes it to the keystore file
+func Save(path string, key Key, passphrase string) error {
+	return save(path, key, passphrase, keystore.StandardScryptN, keystore.StandardScryptP)
+}
+
+func save(path string, key Key, passphrase string, scryptN, scryptP int) error {
+	crypto, err := keystore.EncryptDataV3(key.Bytes(), []byte(passphrase), scryptN, scryptP)
+	if err != nil {
+		return errors.Wrap(err, "failed to encrypt key")
+	}
+	out, err := json.MarshalIndent(&keyFile{
+		Scheme:    key.Scheme(),
+		PublicKey: hex.EncodeToString(key.PublicKey()),
+		Crypto:    crypto,
+		Version:   3,
+	}, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode keystore file")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return errors.Wrap(err, "failed to create keystore directory")
+	}
+	if _, err := os.Stat(path); err == nil {
+		return errors.Errorf("keystore file %s already exists", path)
+	}
+	return os.WriteFile(path, out, 0o600)
+}
+
+// Load decrypts the key of the keystore file
+func Load(path string, passphrase string) (Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read keystore file")
+	}
+	kf := &keyFile{}
+	if err := json.Unmarshal(data, kf); err != nil {
+		return nil, errors.Wrap(err, "failed to decode keystore file")
+	}
+	raw, err := keystore.DecryptDataV3(kf.Crypto, passphrase)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt key")
+	}
+	key, err := Import(kf.Scheme, raw)
+	if err != nil {
+		return nil, err
+	}
+	if hex.EncodeToString(key.PublicKey()) != kf.PublicKey {
+		return nil, errors.New("public key does not match the decrypted key")
+	}
+	return key, nil
+}
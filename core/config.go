@@ -2,48 +2,204 @@ package core
 
 import (
 	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/ilyakaznacheev/cleanenv"
 )
 
 var (
 	ErrInvalidChainID    = errors.New("invalid chain id")
+	ErrInvalidChainName  = errors.New("invalid chain name")
 	ErrInvalidERPCWSS    = errors.New("invalid erpc wss")
 	ErrInvalidERPCHTTPS  = errors.New("invalid erpc https")
 	ErrInvalidProtocolID = errors.New("invalid protocol id")
 	ErrInvalidInstanceID = errors.New("invalid instance id")
+	ErrNoChain           = errors.New("no chain configured")
+	ErrNoInstance        = errors.New("no instance configured")
+	ErrDuplicateChain    = errors.New("duplicate chain")
+	ErrDuplicateInstance = errors.New("duplicate instance")
+	ErrUnknownChain      = errors.New("unknown chain")
 )
 
+// Chain is a chain of the config
+// and the endpoints of its erpc backend
+type Chain struct {
+	// name the instances refer to the chain by
+	Name      string `toml:"name"`
+	ID        uint64 `toml:"id"`
+	ErpcWss   string `toml:"erpc_wss"`
+	ErpcHttps string `toml:"erpc_https"`
+	// maximum number of blocks watched at once,
+	// defaults to the window size of the node
+	WindowSize uint64 `toml:"window_size,omitempty"`
+	// wait between windows once caught up,
+	// about the block time, defaults to the wait of the node
+	WaitTime time.Duration `toml:"wait_time,omitempty"`
+}
+
+// Instance is a protocol instance
+// observed on a chain of the config
+type Instance struct {
+	ProtocolID string `toml:"protocol_id"`
+	InstanceID string `toml:"instance_id"`
+	// name of the chain of the instance
+	Chain string `toml:"chain"`
+	// first block watched if nothing was recorded,
+	// defaults to the genesis of the instance
+	StartBlock uint64 `toml:"start_block,omitempty"`
+}
+
+/*
+Config is the chains and the protocol instances observed on them.
+Each instance refers to a chain by its name, several instances
+may refer to the same chain.
+*/
 type Config struct {
+	Chains    []Chain    `toml:"chains"`
+	Instances []Instance `toml:"instances"`
+}
+
+// envConfig is the single chain and
+// instance configured by the environment
+type envConfig struct {
 	ChainId    uint64 `env:"CHAIN_ID" env-default:"11155111"`
+	ChainName  string `env:"CHAIN_NAME" env-default:"default"`
 	ErpcWss    string `env:"ERPC_WSS"`
 	ErpcHttps  string `env:"ERPC_HTTPS"`
 	ProtocolID string `env:"PROTOCOL_ID"`
 	InstanceID string `env:"INSTANCE_ID"`
+	StartBlock uint64 `env:"START_BLOCK"`
 }
 
+// NewConfig returns the config of the single
+// chain and instance of the environment
 func NewConfig() (Config, error) {
-	conf := Config{}
-	err := cleanenv.ReadEnv(&conf)
-	conf.Validate()
-	return conf, err
+	env := envConfig{}
+	err := cleanenv.ReadEnv(&env)
+	return Config{
+		Chains: []Chain{{
+			Name:      env.ChainName,
+			ID:        env.ChainId,
+			ErpcWss:   env.ErpcWss,
+			ErpcHttps: env.ErpcHttps,
+		}},
+		Instances: []Instance{{
+			ProtocolID: env.ProtocolID,
+			InstanceID: env.InstanceID,
+			Chain:      env.ChainName,
+			StartBlock: env.StartBlock,
+		}},
+	}, err
 }
 
-func (cfg *Config) Validate() error {
-	if cfg.ChainId == 0 {
-		return ErrInvalidChainID
+// Chain returns the chain of the name
+func (cfg *Config) Chain(name string) (Chain, bool) {
+	for _, c := range cfg.Chains {
+		if c.Name == name {
+			return c, true
+		}
 	}
-	if cfg.ErpcWss == "" {
-		return ErrInvalidERPCWSS
+	return Chain{}, false
+}
+
+// envName returns the suffix of the
+// environment variables of the chain
+func envName(chain string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, chain)
+}
+
+/*
+ReadEnv overrides the endpoints of the chains by the environment:
+ERPC_HTTPS_<NAME> and ERPC_WSS_<NAME> for the chain of the name
+(upper cased, other than letters and digits replaced by '_'),
+ERPC_HTTPS and ERPC_WSS if the config has a single chain.
+*/
+func (cfg *Config) ReadEnv() {
+	for i := range cfg.Chains {
+		c := &cfg.Chains[i]
+		for env, field := range map[string]*string{
+			"ERPC_HTTPS": &c.ErpcHttps,
+			"ERPC_WSS":   &c.ErpcWss,
+		} {
+			if v, ok := os.LookupEnv(env + "_" + envName(c.Name)); ok {
+				*field = v
+			} else if v, ok := os.LookupEnv(env); ok && len(cfg.Chains) == 1 {
+				*field = v
+			}
+		}
+	}
+}
+
+// Validate checks the chains, the instances and
+// the chains they refer to, every problem is reported
+func (cfg *Config) Validate() error {
+	var (
+		errs  []error
+		names = make(map[string]bool)
+		ids   = make(map[uint64]string)
+	)
+	if len(cfg.Chains) == 0 {
+		errs = append(errs, ErrNoChain)
 	}
-	if cfg.ErpcHttps == "" {
-		return ErrInvalidERPCHTTPS
+	for i, c := range cfg.Chains {
+		if c.Name == "" {
+			errs = append(errs, fmt.Errorf("chains[%d]: %w", i, ErrInvalidChainName))
+			continue
+		}
+		if names[c.Name] {
+			errs = append(errs, fmt.Errorf("chain %s: %w", c.Name, ErrDuplicateChain))
+			continue
+		}
+		names[c.Name] = true
+
+		if c.ID == 0 {
+			errs = append(errs, fmt.Errorf("chain %s: %w", c.Name, ErrInvalidChainID))
+		} else if other, ok := ids[c.ID]; ok {
+			errs = append(errs, fmt.Errorf("chain %s: %w: id %d is of chain %s", c.Name, ErrDuplicateChain, c.ID, other))
+		} else {
+			ids[c.ID] = c.Name
+		}
+		if c.ErpcWss == "" {
+			errs = append(errs, fmt.Errorf("chain %s: %w", c.Name, ErrInvalidERPCWSS))
+		}
+		if c.ErpcHttps == "" {
+			errs = append(errs, fmt.Errorf("chain %s: %w", c.Name, ErrInvalidERPCHTTPS))
+		}
 	}
-	if cfg.ProtocolID == "" {
-		return ErrInvalidProtocolID
+
+	if len(cfg.Instances) == 0 {
+		errs = append(errs, ErrNoInstance)
 	}
-	if cfg.InstanceID == "" {
-		return ErrInvalidInstanceID
+	seen := make(map[Instance]bool)
+	for i, inst := range cfg.Instances {
+		name := fmt.Sprintf("instances[%d]", i)
+		if inst.InstanceID != "" {
+			name = "instance " + inst.InstanceID
+		}
+		if inst.ProtocolID == "" {
+			errs = append(errs, fmt.Errorf("%s: %w", name, ErrInvalidProtocolID))
+		}
+		if inst.InstanceID == "" {
+			errs = append(errs, fmt.Errorf("%s: %w", name, ErrInvalidInstanceID))
+		}
+		if !names[inst.Chain] {
+			errs = append(errs, fmt.Errorf("%s: %w %q", name, ErrUnknownChain, inst.Chain))
+		}
+		// the chain is left out, an instance is observed once
+		key := Instance{ProtocolID: inst.ProtocolID, InstanceID: inst.InstanceID}
+		if inst.InstanceID != "" && seen[key] {
+			errs = append(errs, fmt.Errorf("%s: %w", name, ErrDuplicateInstance))
+		}
+		seen[key] = true
 	}
-	return nil
+	return errors.Join(errs...)
 }
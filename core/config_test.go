@@ -0,0 +1,63 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/test-go/testify/require"
+)
+
+func Test_Config_Validate(t *testing.T) {
+	cfg := Config{
+		Chains: []Chain{
+			{Name: "sepolia", ID: 11155111, ErpcWss: "wss://sepolia", ErpcHttps: "https://sepolia"},
+			{Name: "gnosis", ID: 100, ErpcWss: "wss://gnosis", ErpcHttps: "https://gnosis"},
+		},
+		Instances: []Instance{
+			{ProtocolID: "privacy-pool", InstanceID: "SEPOLIA_ETH_POOL_1", Chain: "sepolia"},
+			{ProtocolID: "privacy-pool", InstanceID: "SEPOLIA_ETH_POOL_2", Chain: "sepolia"},
+			{ProtocolID: "privacy-pool", InstanceID: "GNOSIS_XDAI_POOL_1", Chain: "gnosis"},
+		},
+	}
+	require.NoError(t, cfg.Validate())
+
+	chain, ok := cfg.Chain("gnosis")
+	require.True(t, ok)
+	require.Equal(t, uint64(100), chain.ID)
+
+	// every problem is reported
+	cfg.Chains = append(cfg.Chains, Chain{Name: "gnosis-2", ID: 100, ErpcWss: "wss://gnosis"})
+	cfg.Instances = append(cfg.Instances,
+		Instance{ProtocolID: "privacy-pool", InstanceID: "GNOSIS_XDAI_POOL_1", Chain: "gnosis"},
+		Instance{InstanceID: "MAINNET_POOL_1", Chain: "mainnet"},
+	)
+	err := cfg.Validate()
+	for _, target := range []error{
+		ErrDuplicateChain,
+		ErrInvalidERPCHTTPS,
+		ErrDuplicateInstance,
+		ErrInvalidProtocolID,
+		ErrUnknownChain,
+	} {
+		require.True(t, errors.Is(err, target), target.Error())
+	}
+	require.False(t, errors.Is(err, ErrInvalidERPCWSS))
+
+	require.True(t, errors.Is((&Config{}).Validate(), ErrNoChain))
+	require.True(t, errors.Is((&Config{}).Validate(), ErrNoInstance))
+}
+
+func Test_Config_ReadEnv(t *testing.T) {
+	cfg := Config{Chains: []Chain{{Name: "sepolia"}, {Name: "gnosis-chiado"}}}
+
+	t.Setenv("ERPC_HTTPS", "https://all")
+	t.Setenv("ERPC_HTTPS_GNOSIS_CHIADO", "https://chiado")
+	cfg.ReadEnv()
+	// the unsuffixed variables are of a single chain
+	require.Empty(t, cfg.Chains[0].ErpcHttps)
+	require.Equal(t, "https://chiado", cfg.Chains[1].ErpcHttps)
+
+	cfg.Chains = cfg.Chains[:1]
+	cfg.ReadEnv()
+	require.Equal(t, "https://all", cfg.Chains[0].ErpcHttps)
+}
@@ -0,0 +1,214 @@
+package detector
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/alert"
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/pkg/errors"
+)
+
+// DefaultHistoryLimit is the default number of
+// absorbed states kept per scope for rewinding
+const DefaultHistoryLimit = 1024
+
+var ErrRewindTooDeep = errors.New("reorged block is beyond the kept history")
+
+// Rewinder is implemented by state buffers
+// whose root can be rewound
+type Rewinder interface {
+	Root() *big.Int
+	Rewind(root *big.Int) error
+}
+
+// RollbackHandler is called with the restored last known state
+// and the rolled back states (in order) of a scope after a rewind
+type RollbackHandler func(restored watcher.State, rolledBack []watcher.State)
+
+// entry is an absorbed state with the last known
+// state and roots prior to its absorption
+type entry struct {
+	// order in which the state was stashed
+	seq           uint64
+	state         watcher.State
+	prevState     watcher.State
+	prevRoot      *big.Int
+	prevScopeRoot *big.Int
+}
+
+type history struct {
+	entries []entry
+	limit   int
+	// set once entries were evicted
+	truncated bool
+}
+
+func (h *history) push(e entry) {
+	h.entries = append(h.entries, e)
+	if over := len(h.entries) - h.limit; over > 0 {
+		h.entries = append(h.entries[:0], h.entries[over:]...)
+		h.truncated = true
+	}
+}
+
+// SetHistoryLimit sets the number of absorbed
+// states kept per scope for rewinding
+func (s *Service) SetHistoryLimit(limit int) {
+	defer s.lock.Unlock()
+	s.lock.Lock()
+	s.historyLimit = limit
+	for _, sc := range s.scopes {
+		sc.history.limit = limit
+	}
+}
+
+// OnRollback sets the handler for rolled back states
+func (s *Service) OnRollback(h RollbackHandler) { s.onRollback = h }
+
+// RewindTo rolls back every absorbed state of the scopes emitted
+// at or after the block of the (reorged) event, the scopes are
+// those of the chain the event is of.
+// The buffer root is shared by all scopes, hence states of
+// other scopes stashed after the first reorged state are
+// rolled back as well, whatever their block.
+// The last known state and roots of every affected scope are
+// restored to their values just before its first rolled back state.
+// Returns the rolled back states in the order they were stashed,
+// the rollback handler is called once the rewind is over.
+// Nothing is rolled back if an error is returned
+func (s *Service) RewindTo(event *watcher.Event, scopes [][]byte) ([]watcher.State, error) {
+	s.absorbing.Lock()
+	s.lock.Lock()
+	rolled, err := s.rewindTo(event, scopes)
+	s.lock.Unlock()
+	notices := s.notices
+	s.notices = nil
+	s.absorbing.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	for _, notice := range notices {
+		notice()
+	}
+	return rolled, nil
+}
+
+// rewindTo rolls back the states of the scopes emitted at or after
+// the block of the event and the states stashed after them, the
+// rollback of every scope is checked before any scope is changed
+func (s *Service) rewindTo(event *watcher.Event, scopes [][]byte) ([]watcher.State, error) {
+	// the blocks of the other scopes
+	// are those of another chain
+	reorged := make(map[*scope]bool, len(scopes))
+	for _, id := range scopes {
+		if sc, ok := s.scopes[scopeKey(id)]; ok {
+			reorged[sc] = true
+		}
+	}
+
+	// find the first stashed state
+	// at or after the reorged block
+	var from uint64
+	for sc := range reorged {
+		for i, e := range sc.history.entries {
+			if ev := e.state.Event(); ev != nil && ev.BlockNumber >= event.BlockNumber {
+				if i == 0 && sc.history.truncated {
+					return nil, ErrRewindTooDeep
+				}
+				if from == 0 || e.seq < from {
+					from = e.seq
+				}
+				break
+			}
+		}
+	}
+
+	var (
+		// index of the first rolled back entry of
+		// the scopes, -1 if only the last known state
+		cuts   = make(map[*scope]int)
+		rolled []entry
+	)
+	for _, sc := range s.scopes {
+		// the first state of a scope is never
+		// stashed and therefore has no history entry
+		if len(sc.history.entries) == 0 {
+			if reorged[sc] && sc.lastKnown != nil {
+				if ev := sc.lastKnown.Event(); ev != nil && ev.BlockNumber >= event.BlockNumber {
+					cuts[sc] = -1
+				}
+			}
+			continue
+		}
+		if from == 0 {
+			continue
+		}
+
+		idx := sort.Search(len(sc.history.entries), func(i int) bool {
+			return sc.history.entries[i].seq >= from
+		})
+		if idx == len(sc.history.entries) {
+			continue
+		}
+		if idx == 0 && sc.history.truncated {
+			return nil, ErrRewindTooDeep
+		}
+		cuts[sc] = idx
+		rolled = append(rolled, sc.history.entries[idx:]...)
+	}
+
+	sort.Slice(rolled, func(i, j int) bool { return rolled[i].seq < rolled[j].seq })
+	if rw, ok := s.StateBuffer.(Rewinder); ok && len(rolled) > 0 && rolled[0].prevRoot != nil {
+		if err := rw.Rewind(rolled[0].prevRoot); err != nil {
+			return nil, errors.Wrap(err, "failed to rewind the buffer root")
+		}
+	}
+
+	for sc, idx := range cuts {
+		if idx < 0 {
+			s.rolledBack(sc, nil, []watcher.State{sc.lastKnown})
+			sc.lastKnown = nil
+			continue
+		}
+		target := sc.history.entries[idx]
+		states := make([]watcher.State, 0, len(sc.history.entries)-idx)
+		for _, e := range sc.history.entries[idx:] {
+			states = append(states, e.state)
+		}
+
+		sc.history.entries = sc.history.entries[:idx]
+		sc.lastKnown = target.prevState
+		sc.root = target.prevScopeRoot
+		sc.stats.Absorbed -= uint64(len(states))
+		sc.stats.RolledBack += uint64(len(states))
+		sc.stats.LastBlock = 0
+		if sc.lastKnown != nil {
+			if ev := sc.lastKnown.Event(); ev != nil {
+				sc.stats.LastBlock = ev.BlockNumber
+			}
+		}
+		s.rolledBack(sc, target.prevState, states)
+	}
+	if len(rolled) == 0 {
+		return nil, nil
+	}
+
+	out := make([]watcher.State, len(rolled))
+	for i, e := range rolled {
+		out[i] = e.state
+	}
+	return out, nil
+}
+
+func (s *Service) rolledBack(sc *scope, restored watcher.State, rolled []watcher.State) {
+	log.Warnw("detector/RewindTo: rolled back states", "scope", scopeKey(sc.id), "count", len(rolled))
+	s.alerts.Raise(alert.New(alert.Warning, "detector", "states rolled back",
+		fmt.Sprintf("%d states rolled back", len(rolled)),
+		"scope", scopeKey(sc.id)))
+	if onRollback := s.onRollback; onRollback != nil {
+		s.notify(func() { onRollback(restored, rolled) })
+	}
+}
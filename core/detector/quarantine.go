@@ -0,0 +1,513 @@
+package detector
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/pkg/errors"
+)
+
+var ErrQuarantineEntryNotFound = errors.New("quarantine entry not found")
+
+type QuarantineStatus uint8
+
+const (
+	// QuarantinePending entries await an operator decision
+	QuarantinePending QuarantineStatus = iota
+	// QuarantineHeld entries are states of a paused scope
+	// which are replayed once the scope is resumed
+	QuarantineHeld
+	// QuarantineApproved entries are released into the buffer
+	QuarantineApproved
+	// QuarantineRejected entries are dropped
+	QuarantineRejected
+)
+
+func (s QuarantineStatus) String() string {
+	names := [...]string{"pending", "held", "approved", "rejected"}
+	if int(s) >= len(names) {
+		return fmt.Sprintf("quarantine-status(%d)", uint8(s))
+	}
+	return names[s]
+}
+
+// QuarantinePolicy decides how the detector carries on
+// after a state transition has been quarantined
+type QuarantinePolicy uint8
+
+const (
+	// ContinuePolicy carries on with the following states of the scope
+	ContinuePolicy QuarantinePolicy = iota
+	// PausePolicy holds the following states of the scope
+	// until the quarantined state has been approved or rejected
+	PausePolicy
+)
+
+// ParseQuarantinePolicy returns the policy of the given name
+func ParseQuarantinePolicy(name string) (QuarantinePolicy, error) {
+	switch strings.ToLower(name) {
+	case "continue":
+		return ContinuePolicy, nil
+	case "pause":
+		return PausePolicy, nil
+	}
+	return ContinuePolicy, fmt.Errorf("unknown quarantine policy %q", name)
+}
+
+// QuarantineEntry is a suspicious state transition
+// awaiting the decision of an operator
+type QuarantineEntry struct {
+	ID      uint64           `cbor:"id"`
+	Scope   []byte           `cbor:"scope"`
+	State   []byte           `cbor:"state"`
+	Verdict Verdict          `cbor:"verdict"`
+	Status  QuarantineStatus `cbor:"status"`
+	Time    int64            `cbor:"time"`
+}
+
+// Quarantine is a persistent queue of quarantined states
+type Quarantine interface {
+	// Put assigns an id to the entry and persists it
+	Put(e *QuarantineEntry) (uint64, error)
+	Get(id uint64) (*QuarantineEntry, error)
+	// List returns every entry in order of their ids
+	List() ([]*QuarantineEntry, error)
+	SetStatus(id uint64, status QuarantineStatus) error
+	Remove(id uint64) error
+}
+
+var _ Quarantine = (*FileQuarantine)(nil)
+
+/*
+FileQuarantine persists every entry as a cbor file within a directory.
+The entries are indexed in memory, the index is reloaded once the
+directory was modified by another process (e.g. an operator resolving
+entries from the command line).
+*/
+type FileQuarantine struct {
+	dir  string
+	next uint64
+	// index of the entries and the modification
+	// time of the directory it was loaded at
+	entries map[uint64]*QuarantineEntry
+	stamp   time.Time
+	lock    *sync.Mutex
+}
+
+func NewFileQuarantine(dir string) (*FileQuarantine, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, errors.Wrap(err, "failed to create quarantine directory")
+	}
+	q := &FileQuarantine{dir: dir, next: 1, lock: &sync.Mutex{}}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	for id := range q.entries {
+		if id >= q.next {
+			q.next = id + 1
+		}
+	}
+	return q, nil
+}
+
+func (q *FileQuarantine) path(id uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d.cbor", id))
+}
+
+func (q *FileQuarantine) ids() ([]uint64, error) {
+	files, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read quarantine directory")
+	}
+	ids := make([]uint64, 0, len(files))
+	for _, f := range files {
+		name, ok := strings.CutSuffix(f.Name(), ".cbor")
+		if !ok {
+			continue
+		}
+		if id, err := strconv.ParseUint(name, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// modified returns the modification time of the directory
+func (q *FileQuarantine) modified() (time.Time, error) {
+	info, err := os.Stat(q.dir)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "failed to stat quarantine directory")
+	}
+	return info.ModTime(), nil
+}
+
+// load reads the entries into the index
+// if the directory changed since the last load
+func (q *FileQuarantine) load() error {
+	stamp, err := q.modified()
+	if err != nil {
+		return err
+	}
+	if q.entries != nil && stamp.Equal(q.stamp) {
+		return nil
+	}
+	ids, err := q.ids()
+	if err != nil {
+		return err
+	}
+	entries := make(map[uint64]*QuarantineEntry, len(ids))
+	for _, id := range ids {
+		e, err := q.read(id)
+		if errors.Is(err, ErrQuarantineEntryNotFound) {
+			// removed while listing
+			continue
+		} else if err != nil {
+			return err
+		}
+		entries[id] = e
+	}
+	q.entries, q.stamp = entries, stamp
+	return nil
+}
+
+// restamp records the modification time of the
+// directory after a write of the index itself
+func (q *FileQuarantine) restamp() {
+	if stamp, err := q.modified(); err == nil {
+		q.stamp = stamp
+	}
+}
+
+// write persists the entry by renaming
+// a temporary file over the entry file
+func (q *FileQuarantine) write(e *QuarantineEntry) error {
+	out, err := cbor.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "failed to serialize quarantine entry")
+	}
+	tmp := q.path(e.ID) + ".tmp"
+	if err := os.WriteFile(tmp, out, 0o600); err != nil {
+		return errors.Wrap(err, "failed to write quarantine entry")
+	}
+	if err := os.Rename(tmp, q.path(e.ID)); err != nil {
+		return errors.Wrap(err, "failed to write quarantine entry")
+	}
+	q.entries[e.ID] = e
+	q.restamp()
+	return nil
+}
+
+func (q *FileQuarantine) read(id uint64) (*QuarantineEntry, error) {
+	data, err := os.ReadFile(q.path(id))
+	if os.IsNotExist(err) {
+		return nil, ErrQuarantineEntryNotFound
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to read quarantine entry")
+	}
+	e := &QuarantineEntry{}
+	if err := cbor.Unmarshal(data, e); err != nil {
+		return nil, errors.Wrap(err, "failed to deserialize quarantine entry")
+	}
+	return e, nil
+}
+
+// get returns a copy of the indexed entry
+func (q *FileQuarantine) get(id uint64) (*QuarantineEntry, error) {
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	e, ok := q.entries[id]
+	if !ok {
+		return nil, ErrQuarantineEntryNotFound
+	}
+	c := *e
+	return &c, nil
+}
+
+func (q *FileQuarantine) Put(e *QuarantineEntry) (uint64, error) {
+	defer q.lock.Unlock()
+	q.lock.Lock()
+	if err := q.load(); err != nil {
+		return 0, err
+	}
+	c := *e
+	c.ID = q.next
+	if err := q.write(&c); err != nil {
+		return 0, err
+	}
+	q.next++
+	e.ID = c.ID
+	return e.ID, nil
+}
+
+func (q *FileQuarantine) Get(id uint64) (*QuarantineEntry, error) {
+	defer q.lock.Unlock()
+	q.lock.Lock()
+	return q.get(id)
+}
+
+func (q *FileQuarantine) List() ([]*QuarantineEntry, error) {
+	defer q.lock.Unlock()
+	q.lock.Lock()
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	out := make([]*QuarantineEntry, 0, len(q.entries))
+	for _, e := range q.entries {
+		c := *e
+		out = append(out, &c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+func (q *FileQuarantine) SetStatus(id uint64, status QuarantineStatus) error {
+	defer q.lock.Unlock()
+	q.lock.Lock()
+	e, err := q.get(id)
+	if err != nil {
+		return err
+	}
+	e.Status = status
+	return q.write(e)
+}
+
+func (q *FileQuarantine) Remove(id uint64) error {
+	defer q.lock.Unlock()
+	q.lock.Lock()
+	if err := q.load(); err != nil {
+		return err
+	}
+	if err := os.Remove(q.path(id)); os.IsNotExist(err) {
+		return ErrQuarantineEntryNotFound
+	} else if err != nil {
+		return errors.Wrap(err, "failed to remove quarantine entry")
+	}
+	delete(q.entries, id)
+	q.restamp()
+	return nil
+}
+
+// SetQuarantine moves rejected state transitions into the quarantine
+// instead of failing the absorption.
+// Scopes with pending entries are paused under the pause policy
+func (s *Service) SetQuarantine(q Quarantine, policy QuarantinePolicy, des watcher.StateDeserializer) error {
+	defer s.lock.Unlock()
+	s.lock.Lock()
+	s.quarantine, s.policy, s.des = q, policy, des
+
+	entries, err := q.List()
+	if err != nil {
+		return errors.Wrap(err, "failed to list quarantined states")
+	}
+	for _, e := range entries {
+		if e.Status == QuarantinePending && policy == PausePolicy {
+			s.scope(e.Scope).paused = true
+		}
+	}
+	return nil
+}
+
+// Quarantined returns the quarantined states in order
+func (s *Service) Quarantined() ([]*QuarantineEntry, error) {
+	if s.quarantine == nil {
+		return nil, errors.New("quarantine is not set")
+	}
+	return s.quarantine.List()
+}
+
+// Inspect returns the quarantined state of the id
+func (s *Service) Inspect(id uint64) (*QuarantineEntry, error) {
+	if s.quarantine == nil {
+		return nil, errors.New("quarantine is not set")
+	}
+	return s.quarantine.Get(id)
+}
+
+// Approve approves the pending quarantined state
+// it is released into the buffer by Release
+func (s *Service) Approve(id uint64) error { return s.resolve(id, QuarantineApproved) }
+
+// Reject rejects the pending quarantined state
+// it is dropped by Release
+func (s *Service) Reject(id uint64) error { return s.resolve(id, QuarantineRejected) }
+
+// Resolved returns true if quarantined states
+// were resolved and wait to be released
+func (s *Service) Resolved() bool {
+	if s.quarantine == nil {
+		return false
+	}
+	entries, err := s.quarantine.List()
+	if err != nil {
+		log.Errorw("detector/Resolved: failed to list quarantined states", "err", err)
+		return false
+	}
+	for _, e := range entries {
+		if e.Status == QuarantineApproved || e.Status == QuarantineRejected {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) resolve(id uint64, status QuarantineStatus) error {
+	if s.quarantine == nil {
+		return errors.New("quarantine is not set")
+	}
+	e, err := s.quarantine.Get(id)
+	if err != nil {
+		return err
+	}
+	if e.Status != QuarantinePending {
+		return errors.Errorf("quarantine entry %d is %s", id, e.Status)
+	}
+	return s.quarantine.SetStatus(id, status)
+}
+
+// quarantined puts the state into the quarantine
+func (s *Service) quarantined(sc *scope, state watcher.State, verdict Verdict, status QuarantineStatus) error {
+	id, err := s.quarantine.Put(&QuarantineEntry{
+		Scope:   sc.id,
+		State:   state.Serialize(),
+		Verdict: verdict,
+		Status:  status,
+		Time:    time.Now().Unix(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to quarantine state")
+	}
+	sc.stats.Quarantined++
+	log.Warnw("detector/Absorb: state quarantined", "id", id, "scope", scopeKey(sc.id), "status", status)
+	return nil
+}
+
+/*
+Release processes the resolved quarantined states in order, each
+in its own transaction. Rejected states are dropped. Approved states
+are stashed into the buffer in order: the states of a scope which
+carried on without the approved state are rolled back from its block
+and absorbed again after it, the rollback handler is called before
+the released states are committed.
+Paused scopes are resumed and their held states replayed.
+Every absorbed state is expected to be sinked before the release.
+Returns the buffer root after the last stash.
+*/
+func (s *Service) Release() (*big.Int, error) {
+	defer s.absorbing.Unlock()
+	s.absorbing.Lock()
+	if s.quarantine == nil {
+		return s.root(), nil
+	}
+	entries, err := s.quarantine.List()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list quarantined states")
+	}
+	root := s.root()
+	for _, e := range entries {
+		if e.Status != QuarantineApproved && e.Status != QuarantineRejected {
+			continue
+		}
+		if root, err = s.transact(func() (*big.Int, error) { return s.release(e, entries) }); err != nil {
+			return nil, errors.Wrapf(err, "failed to release quarantined state %d", e.ID)
+		}
+	}
+	return root, nil
+}
+
+// release processes the resolved entry,
+// entries are the quarantined states in order
+func (s *Service) release(e *QuarantineEntry, entries []*QuarantineEntry) (root *big.Int, err error) {
+	sc := s.scope(e.Scope)
+	if e.Status == QuarantineApproved {
+		state := s.des(e.State)
+		if state == nil {
+			return nil, errors.New("failed to deserialize quarantined state")
+		}
+		if sc.paused {
+			// the scope waited for the state
+			root, err = s.stash(sc, state, true)
+		} else {
+			root, err = s.reinsert(sc, state)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := s.quarantine.Remove(e.ID); err != nil {
+		return nil, err
+	}
+	if !sc.paused {
+		return root, nil
+	}
+
+	// replay the held states of the resumed scope
+	sc.paused = false
+	for _, held := range entries {
+		if held.Status != QuarantineHeld || scopeKey(held.Scope) != scopeKey(sc.id) {
+			continue
+		}
+		state := s.des(held.State)
+		if state == nil {
+			return nil, errors.Errorf("failed to deserialize held state %d", held.ID)
+		}
+		if err := s.quarantine.Remove(held.ID); err != nil {
+			return nil, err
+		}
+		if r, err := s.absorb(sc, state, true); err != nil {
+			return nil, err
+		} else if r != nil {
+			root = r
+		}
+	}
+	return root, nil
+}
+
+// reinsert stashes the approved state of a scope which
+// carried on without it. The states stashed from its block
+// on are rolled back and absorbed again around it in order
+func (s *Service) reinsert(sc *scope, state watcher.State) (root *big.Int, err error) {
+	event := state.Event()
+	if event == nil {
+		return nil, errors.New("approved state has no event")
+	}
+	rolled, err := s.rewindTo(event, [][]byte{sc.id})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to roll back the following states")
+	}
+	inserted := false
+	insert := func() (*big.Int, error) {
+		inserted = true
+		return s.stash(sc, state, true)
+	}
+	for _, next := range rolled {
+		var r *big.Int
+		if !inserted && scopeKey(next.Scope()) == scopeKey(sc.id) && event.Cmp(next.Event()) == 1 {
+			if r, err = insert(); err != nil {
+				return nil, err
+			}
+			root = r
+		}
+		if r, err = s.absorb(s.scope(next.Scope()), next, false); err != nil {
+			return nil, err
+		} else if r != nil {
+			root = r
+		}
+	}
+	if !inserted {
+		if root, err = insert(); err != nil {
+			return nil, err
+		}
+	}
+	return root, nil
+}
@@ -0,0 +1,168 @@
+package detector
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/test-go/testify/require"
+)
+
+func Test_Quarantine_Pause(t *testing.T) {
+	q, err := NewFileQuarantine(t.TempDir())
+	require.NoError(t, err)
+
+	buff := &testBuffer{}
+	svc := NewService(buff)
+	des := (&testState{}).Deserialize
+	require.NoError(t, svc.SetQuarantine(q, PausePolicy, des))
+
+	// the out of order state is quarantined
+	// and the following state of the scope is held
+	_, err = svc.Absorb([]watcher.State{
+		newTestState(1, 1, 10),
+		newTestState(1, 2, 11),
+		newTestState(1, 3, 9),
+		newTestState(1, 4, 12),
+		newTestState(2, 1, 10),
+		newTestState(2, 2, 11),
+	})
+	require.NoError(t, err)
+	require.Len(t, buff.stashed, 2)
+
+	entries, err := svc.Quarantined()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, QuarantinePending, entries[0].Status)
+	require.Equal(t, "event-order", entries[0].Verdict.Rule)
+	require.Equal(t, QuarantineHeld, entries[1].Status)
+	require.True(t, svc.Stats()[scopeKey(entries[0].Scope)].Paused)
+
+	// only pending entries can be resolved
+	require.Error(t, svc.Approve(entries[1].ID))
+	require.NoError(t, svc.Reject(entries[0].ID))
+
+	// the rejected state is dropped and the held state replayed
+	require.True(t, svc.Resolved())
+	_, err = svc.Release()
+	require.NoError(t, err)
+	require.False(t, svc.Resolved())
+	_, err = svc.Absorb([]watcher.State{newTestState(1, 5, 13)})
+	require.NoError(t, err)
+	require.Len(t, buff.stashed, 4)
+
+	entries, err = svc.Quarantined()
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	require.Equal(t, uint64(2), svc.Stats()[scopeKey(newTestState(1, 0, 0).Scope())].Quarantined)
+
+	// the quarantine survives a restart
+	_, err = svc.Absorb([]watcher.State{newTestState(1, 6, 8)})
+	require.NoError(t, err)
+	q, err = NewFileQuarantine(q.dir)
+	require.NoError(t, err)
+	svc = NewService(buff)
+	require.NoError(t, svc.SetQuarantine(q, PausePolicy, des))
+	entries, err = svc.Quarantined()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.True(t, svc.Stats()[scopeKey(entries[0].Scope)].Paused)
+
+	require.NoError(t, svc.Approve(entries[0].ID))
+	_, err = svc.Release()
+	require.NoError(t, err)
+	require.Len(t, buff.stashed, 5)
+}
+
+func Test_Quarantine_Continue(t *testing.T) {
+	q, err := NewFileQuarantine(t.TempDir())
+	require.NoError(t, err)
+
+	var (
+		buff   = &rewindBuffer{root: big.NewInt(100)}
+		svc    = NewService(buff)
+		des    = (&testState{}).Deserialize
+		scope  = newTestState(1, 0, 0).Scope()
+		rolled []watcher.State
+	)
+	require.NoError(t, svc.SetQuarantine(q, ContinuePolicy, des))
+	svc.OnRollback(func(_ watcher.State, states []watcher.State) { rolled = append(rolled, states...) })
+
+	// the state at block 11 is quarantined and the scope carries on
+	svc.Register(NewRule("veto", func(_ watcher.State, next watcher.State) Verdict {
+		if bytes.Equal(next.Hash(), newTestState(1, 2, 11).Hash()) {
+			return Rejected("vetoed")
+		}
+		return Accepted()
+	}))
+	_, err = svc.Absorb([]watcher.State{
+		newTestState(1, 1, 10),
+		newTestState(1, 2, 11),
+		newTestState(1, 3, 12),
+		newTestState(2, 5, 10),
+		newTestState(2, 6, 13),
+		newTestState(1, 4, 14),
+	})
+	require.NoError(t, err)
+	require.Len(t, buff.stashed, 3)
+
+	entries, err := svc.Quarantined()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.NoError(t, svc.Approve(entries[0].ID))
+
+	// the approved state is stashed before the states which
+	// followed it, those are rolled back and absorbed again
+	root, err := svc.Release()
+	require.NoError(t, err)
+	require.Len(t, rolled, 3)
+	require.Equal(t, int64(104), root.Int64())
+	require.Len(t, buff.stashed, 7)
+	var order [][]byte
+	for _, ss := range buff.stashed[3:] {
+		order = append(order, des(ss).Hash())
+	}
+	require.Equal(t, [][]byte{
+		newTestState(1, 2, 11).Hash(),
+		newTestState(1, 3, 12).Hash(),
+		newTestState(2, 6, 13).Hash(),
+		newTestState(1, 4, 14).Hash(),
+	}, order)
+	require.Equal(t, newTestState(1, 4, 14).Hash(), svc.LastKnown(scope).Hash())
+	require.Equal(t, uint64(3), svc.Stats()[scopeKey(scope)].Absorbed)
+}
+
+func Test_FileQuarantine_Index(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewFileQuarantine(dir)
+	require.NoError(t, err)
+	id, err := q.Put(&QuarantineEntry{Scope: []byte{1}, Status: QuarantinePending})
+	require.NoError(t, err)
+
+	// the entry is resolved by another process
+	other, err := NewFileQuarantine(dir)
+	require.NoError(t, err)
+	require.NoError(t, other.SetStatus(id, QuarantineApproved))
+	// the directory modification time may be coarse
+	os.Chtimes(dir, time.Now(), time.Now().Add(time.Second))
+
+	e, err := q.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, QuarantineApproved, e.Status)
+
+	require.NoError(t, q.Remove(id))
+	entries, err := q.List()
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	require.True(t, errors.Is(q.Remove(id), ErrQuarantineEntryNotFound))
+}
+
+func Test_QuarantineStatus(t *testing.T) {
+	require.Equal(t, "rejected", QuarantineRejected.String())
+	// the status of a corrupt or newer entry
+	require.Equal(t, "quarantine-status(9)", QuarantineStatus(9).String())
+}
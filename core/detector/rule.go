@@ -0,0 +1,147 @@
+package detector
+
+import (
+	"fmt"
+
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+)
+
+// Decision is the outcome of a rule
+type Decision uint8
+
+const (
+	// Accept lets the state transition through
+	Accept Decision = iota
+	// Reject marks the state transition as invalid
+	Reject
+	// Flag lets the state transition through
+	// but reports it as suspicious
+	Flag
+)
+
+func (d Decision) String() string {
+	switch d {
+	case Accept:
+		return "accept"
+	case Reject:
+		return "reject"
+	case Flag:
+		return "flag"
+	default:
+		return fmt.Sprintf("decision(%d)", uint8(d))
+	}
+}
+
+// Verdict is the structured result of a rule
+// examining a candidate state transition
+type Verdict struct {
+	Decision Decision
+	Rule     string
+	Reason   string
+	// Gap is set when the rule found
+	// state transitions to be missing
+	Gap *Gap
+}
+
+// Gap describes the state transitions missed
+// between the last known state and the candidate state
+type Gap struct {
+	Scope []byte
+	// Range of the missing state indices (inclusive)
+	From uint64
+	To   uint64
+	// Range of blocks the missing
+	// transitions were emitted within
+	Blocks [2]uint64
+}
+
+func (g *Gap) String() string {
+	return fmt.Sprintf("missed transition: state indices [%d, %d] within blocks [%d, %d]",
+		g.From, g.To, g.Blocks[0], g.Blocks[1])
+}
+
+func Accepted() Verdict              { return Verdict{Decision: Accept} }
+func Rejected(reason string) Verdict { return Verdict{Decision: Reject, Reason: reason} }
+func Flagged(reason string) Verdict  { return Verdict{Decision: Flag, Reason: reason} }
+
+// Missed flags the candidate state for missing the
+// state transitions described by the gap
+func Missed(gap *Gap) Verdict {
+	return Verdict{Decision: Flag, Reason: gap.String(), Gap: gap}
+}
+
+func (v Verdict) String() string {
+	return fmt.Sprintf("%s: %s (%s)", v.Rule, v.Decision, v.Reason)
+}
+
+// Rule examines a candidate state transition (next)
+// given the last known state (last)
+type Rule interface {
+	Name() string
+	Examine(last watcher.State, next watcher.State) Verdict
+}
+
+// RejectedError is returned when a rule
+// rejects a state transition
+type RejectedError struct {
+	Verdict Verdict
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("invalid state transition detected by %s: %s", e.Verdict.Rule, e.Verdict.Reason)
+}
+
+type rule struct {
+	name    string
+	examine func(last watcher.State, next watcher.State) Verdict
+}
+
+// NewRule returns a named rule from the examine function
+func NewRule(name string, examine func(last watcher.State, next watcher.State) Verdict) Rule {
+	return &rule{name: name, examine: examine}
+}
+
+func (r *rule) Name() string { return r.name }
+func (r *rule) Examine(last watcher.State, next watcher.State) Verdict {
+	return r.examine(last, next)
+}
+
+// DefaultRules returns the rules every
+// protocol is expected to satisfy
+func DefaultRules() []Rule {
+	return []Rule{StateTransitionRule, EventOrderRule}
+}
+
+// StateTransitionRule rejects states which
+// are not a transition of the last known state
+var StateTransitionRule = NewRule("state-transition",
+	func(last watcher.State, next watcher.State) Verdict {
+		if cmp := next.Cmp(last); cmp != 1 {
+			return Rejected(fmt.Sprintf("invalid state transition detected %d", cmp))
+		}
+		return Accepted()
+	})
+
+// EventOrderRule rejects states which
+// were emitted before the last known state
+var EventOrderRule = NewRule("event-order",
+	func(last watcher.State, next watcher.State) Verdict {
+		// Get the last cached state transition event
+		lastEvent := last.Event()
+		if lastEvent == nil {
+			return Rejected("last known state has no event")
+		}
+
+		// Get the current state transition event
+		currEvent := next.Event()
+		if currEvent == nil {
+			return Rejected("current state has no event")
+		}
+
+		// Check if the current state transition event is greater
+		//  than the last known state transition event
+		if lastEvent.Cmp(currEvent) != 1 {
+			return Rejected("state events are out of order")
+		}
+		return Accepted()
+	})
@@ -0,0 +1,106 @@
+package detector
+
+import (
+	"encoding/hex"
+	"math/big"
+
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+)
+
+// ScopeStats are the detector statistics of a single scope
+type ScopeStats struct {
+	Scope       []byte
+	Absorbed    uint64
+	Rejected    uint64
+	Flagged     uint64
+	Filled      uint64
+	RolledBack  uint64
+	Quarantined uint64
+	Paused      bool
+	LastBlock   uint64
+	Root        *big.Int
+}
+
+// scope holds the detector state of a single scope
+// every scope is tracked independently of the others
+type scope struct {
+	id        []byte
+	lastKnown watcher.State
+	// rolling root of the states absorbed for the scope
+	root    *big.Int
+	history *history
+	stats   ScopeStats
+	// paused scopes hold their states
+	// until the quarantine is resolved
+	paused bool
+}
+
+func newScope(id []byte, limit int) *scope {
+	return &scope{
+		id:      append([]byte(nil), id...),
+		root:    big.NewInt(0),
+		history: &history{limit: limit},
+	}
+}
+
+func scopeKey(id []byte) string { return hex.EncodeToString(id) }
+
+// scope returns the tracked scope of the id
+// a new scope is tracked if it is unknown
+func (s *Service) scope(id []byte) *scope {
+	key := scopeKey(id)
+	sc, ok := s.scopes[key]
+	if !ok {
+		sc = newScope(id, s.historyLimit)
+		s.scopes[key] = sc
+	}
+	return sc
+}
+
+// Scopes returns the ids of the tracked scopes
+func (s *Service) Scopes() [][]byte {
+	defer s.lock.RUnlock()
+	s.lock.RLock()
+	out := make([][]byte, 0, len(s.scopes))
+	for _, sc := range s.scopes {
+		out = append(out, sc.id)
+	}
+	return out
+}
+
+// LastKnown returns the last known state of the scope
+func (s *Service) LastKnown(id []byte) watcher.State {
+	defer s.lock.RUnlock()
+	s.lock.RLock()
+	if sc, ok := s.scopes[scopeKey(id)]; ok && sc.lastKnown != nil {
+		return sc.lastKnown.Clone()
+	}
+	return nil
+}
+
+// Root returns the rolling root of
+// the states absorbed for the scope
+func (s *Service) Root(id []byte) *big.Int {
+	defer s.lock.RUnlock()
+	s.lock.RLock()
+	if sc, ok := s.scopes[scopeKey(id)]; ok {
+		return new(big.Int).Set(sc.root)
+	}
+	return nil
+}
+
+// Stats returns the statistics of every
+// tracked scope keyed by the hex encoded scope
+func (s *Service) Stats() map[string]ScopeStats {
+	defer s.lock.RUnlock()
+	s.lock.RLock()
+	out := make(map[string]ScopeStats, len(s.scopes))
+	for key, sc := range s.scopes {
+		stats := sc.stats
+		stats.Scope = sc.id
+		stats.Root = new(big.Int).Set(sc.root)
+		stats.Paused = sc.paused
+		out[key] = stats
+	}
+	return out
+}
@@ -1,10 +1,14 @@
 package detector
 
 import (
-	"fmt"
+	"bytes"
+	"encoding/hex"
 	"math/big"
+	"sync"
 
+	"github.com/0xBow-io/asp-go-buildkit/core/alert"
 	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/0xBow-io/asp-go-buildkit/internal"
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/pkg/errors"
 )
@@ -17,57 +21,429 @@ type StateBuffer interface {
 	Stash(_ []byte) (*big.Int, error)
 }
 
+// Transaction is implemented by state buffers which
+// stage the stashed states until they are committed
+type Transaction interface {
+	Commit() error
+}
+
+// FlagHandler is called for every verdict
+// which flagged an absorbed state
+type FlagHandler func(watcher.State, Verdict)
+
+// Refetcher returns the states of the
+// scope observed within the block range
+type Refetcher func(scope []byte, blocks [2]uint64) ([]watcher.State, error)
+
+// StashHandler is called with the states stashed
+// by an absorption in order, once they are committed
+type StashHandler func([]watcher.State)
+
 type Service struct {
-	stashed   <-chan watcher.State
-	lastKnown watcher.State
+	stashed <-chan watcher.State
+	// states are tracked per scope
+	scopes map[string]*scope
+	rules  []Rule
+
+	onFlag  FlagHandler
+	onStash StashHandler
+	refetch Refetcher
+	alerts  alert.Raiser
+
+	// states stashed by the current absorption
+	batch []watcher.State
+	// handler calls queued until the locks are released
+	notices []func()
+
+	// sequence number of the last stashed state
+	seq          uint64
+	historyLimit int
+	onRollback   RollbackHandler
+
+	quarantine Quarantine
+	policy     QuarantinePolicy
+	des        watcher.StateDeserializer
+
+	// hashes of the incoming batch
+	// computed ahead by the hasher
+	hasher *internal.Hasher
+	hashes map[string]*big.Int
+
+	// serializes the absorptions and rewinds,
+	// lock guards the scopes and is released
+	// while refetching
+	absorbing *sync.Mutex
+	lock      *sync.RWMutex
 	StateBuffer
 }
 
-func NewService(sb StateBuffer) *Service {
+// NewService returns a detector which examines
+// state transitions with the given rules in order.
+// The default rules are used if none are given
+func NewService(sb StateBuffer, rules ...Rule) *Service {
+	if len(rules) == 0 {
+		rules = DefaultRules()
+	}
 	return &Service{
-		lastKnown:   nil,
-		StateBuffer: sb,
+		scopes:       make(map[string]*scope),
+		rules:        rules,
+		alerts:       alert.Nop,
+		historyLimit: DefaultHistoryLimit,
+		hasher:       internal.NewHasher(0),
+		absorbing:    &sync.Mutex{},
+		lock:         &sync.RWMutex{},
+		StateBuffer:  sb,
+	}
+}
+
+// Register appends the rules to the rule pipeline
+func (s *Service) Register(rules ...Rule) {
+	s.rules = append(s.rules, rules...)
+}
+
+// Rules returns the registered rules in order
+func (s *Service) Rules() []Rule { return s.rules }
+
+// OnFlag sets the handler for flagged states
+func (s *Service) OnFlag(h FlagHandler) { s.onFlag = h }
+
+// OnStash sets the handler for stashed states,
+// refetched and released states included
+func (s *Service) OnStash(h StashHandler) { s.onStash = h }
+
+// SetAlerter sets the raiser of the detector alerts
+func (s *Service) SetAlerter(r alert.Raiser) { s.alerts = r }
+
+// SetRefetcher sets the refetcher used to
+// fill in missed state transitions
+func (s *Service) SetRefetcher(r Refetcher) { s.refetch = r }
+
+// SetHasher sets the hasher of the incoming batches,
+// states are hashed one at a time if nil
+func (s *Service) SetHasher(h *internal.Hasher) { s.hasher = h }
+
+// examine runs the candidate state through the rules
+// against the last known state of its scope
+// Stops at the first rejection
+func (s *Service) examine(sc *scope, next watcher.State) (flags []Verdict, err error) {
+	for _, rule := range s.rules {
+		verdict := rule.Examine(sc.lastKnown, next)
+		if verdict.Rule == "" {
+			verdict.Rule = rule.Name()
+		}
+		switch verdict.Decision {
+		case Reject:
+			return nil, &RejectedError{Verdict: verdict}
+		case Flag:
+			flags = append(flags, verdict)
+		}
 	}
+	return flags, nil
 }
 
+// Absorb examines the states and stashes the valid
+// state transitions into the buffer.
+// States may be of different scopes, each scope
+// is checked against its own last known state.
+// If the buffer is a Transaction the batch is committed
+// once absorbed, on failure the states stashed before the
+// failing state are committed and the error is returned.
+// Returns the buffer root after the last stash, or the
+// current buffer root if no state was stashed.
+// Quarantined states are released by Release
 func (s *Service) Absorb(in []watcher.State) (*big.Int, error) {
-	var (
-		root  = big.NewInt(0)
-		err   error
-		index int = 0
-	)
-	if s.lastKnown == nil {
-		s.lastKnown = in[0]
-		index++
+	defer s.absorbing.Unlock()
+	s.absorbing.Lock()
+	return s.transact(func() (*big.Int, error) { return s.absorbAll(in) })
+}
+
+// notify queues the handler call until
+// the scopes are unlocked
+func (s *Service) notify(notice func()) {
+	s.notices = append(s.notices, notice)
+}
+
+// flush calls the queued handlers in order
+func (s *Service) flush() {
+	notices := s.notices
+	s.notices = nil
+	for _, notice := range notices {
+		notice()
+	}
+}
+
+/*
+transact runs absorb over the locked scopes and commits the
+stashed states if the buffer is a Transaction, on failure the
+states stashed before it are committed.
+The scopes are unlocked once absorbed, the queued handlers are
+called before the commit and the stash handler after it, handlers
+may read the detector.
+*/
+func (s *Service) transact(absorb func() (*big.Int, error)) (*big.Int, error) {
+	s.lock.Lock()
+	s.batch = nil
+	root, err := absorb()
+	batch := s.batch
+	s.batch = nil
+	s.lock.Unlock()
+
+	s.flush()
+	if tx, ok := s.StateBuffer.(Transaction); ok {
+		if cerr := tx.Commit(); cerr != nil {
+			log.Errorw("detector/Absorb: failed to commit the absorbed states", "error", cerr)
+			if err == nil {
+				err = errors.Wrap(cerr, "failed to commit the absorbed states")
+			}
+			batch = nil
+		}
 	}
-	for index < len(in) {
-		if cmp := in[index].Cmp(s.lastKnown); cmp != 1 {
-			return nil, errors.New(fmt.Sprintf("invalid state transition detected %d", cmp))
+	if len(batch) > 0 && s.onStash != nil {
+		s.onStash(batch)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		root = s.root()
+	}
+	return root, nil
+}
+
+// prehash hashes the incoming states on the
+// hasher ahead of their stash in order
+func (s *Service) prehash(in []watcher.State) error {
+	if s.hasher == nil || len(in) < 2 {
+		return nil
+	}
+	batch := make([][]byte, 0, len(in))
+	for _, state := range in {
+		if ss := state.Serialize(); ss != nil {
+			batch = append(batch, ss)
 		}
+	}
+	hashes, err := s.hasher.Hash(batch)
+	if err != nil {
+		return err
+	}
+	s.hashes = make(map[string]*big.Int, len(batch))
+	for i, ss := range batch {
+		s.hashes[string(ss)] = hashes[i]
+	}
+	return nil
+}
+
+// hash returns the hash of the serialized state
+// computed ahead if it is part of the batch
+func (s *Service) hash(ss []byte) (*big.Int, error) {
+	if hash, ok := s.hashes[string(ss)]; ok {
+		return hash, nil
+	}
+	return internal.HashBytes(ss)
+}
+
+func (s *Service) absorbAll(in []watcher.State) (root *big.Int, err error) {
+	var r *big.Int
+	if err = s.prehash(in); err != nil {
+		return nil, errors.Wrap(err, "failed to hash incoming states")
+	}
+	defer func() { s.hashes = nil }()
 
-		// Get the last cached state transition event
-		lastEvent := s.lastKnown.Event()
-		if lastEvent == nil {
-			return nil, errors.New("last known state has no event")
+	for _, state := range in {
+		sc := s.scope(state.Scope())
+		if sc.paused {
+			if err := s.hold(sc, state); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// the first state of a scope
+		// is the reference for the next
+		if sc.lastKnown == nil {
+			sc.lastKnown = state.Clone()
+			if ev := state.Event(); ev != nil {
+				sc.stats.LastBlock = ev.BlockNumber
+			}
+			continue
+		}
+		if r, err = s.absorb(sc, state, true); err != nil {
+			return nil, err
+		} else if r != nil {
+			root = r
 		}
+	}
+	return root, nil
+}
+
+// root returns the current buffer root
+// or zero if the buffer has no root
+func (s *Service) root() *big.Int {
+	if rw, ok := s.StateBuffer.(Rewinder); ok {
+		return new(big.Int).Set(rw.Root())
+	}
+	return big.NewInt(0)
+}
 
-		// Get the current state transition event
-		currEvent := in[index].Event()
-		if currEvent == nil {
-			return nil, errors.New("current state has no event")
+// hold quarantines the state of a paused scope
+func (s *Service) hold(sc *scope, state watcher.State) error {
+	return s.quarantined(sc, state, Verdict{
+		Decision: Reject,
+		Rule:     "quarantine",
+		Reason:   "scope is paused",
+	}, QuarantineHeld)
+}
+
+// absorb examines the state and stashes it into the buffer
+// if refill is set, missed state transitions
+// are refetched and absorbed before the state.
+// Rejected states are quarantined if a quarantine is set,
+// in which case a nil root is returned
+func (s *Service) absorb(sc *scope, next watcher.State, refill bool) (*big.Int, error) {
+	if sc.paused {
+		return nil, s.hold(sc, next)
+	}
+
+	flags, err := s.examine(sc, next)
+	if err != nil {
+		sc.stats.Rejected++
+		s.alerts.Raise(alert.New(alert.Critical, "detector", "invalid state transition", err.Error(),
+			"scope", hex.EncodeToString(next.Scope()),
+			"state", hex.EncodeToString(next.Hash())))
+
+		var rejected *RejectedError
+		if s.quarantine == nil || !errors.As(err, &rejected) {
+			return nil, err
+		}
+		if err := s.quarantined(sc, next, rejected.Verdict, QuarantinePending); err != nil {
+			return nil, err
 		}
+		sc.paused = s.policy == PausePolicy
+		return nil, nil
+	}
 
-		// Check if the current state transition event is greater
-		//  than the last known state transition event
-		if lastEvent.Cmp(currEvent) != 1 {
-			return nil, errors.New("state events are out of order")
+	var gap *Gap
+	for _, verdict := range flags {
+		sc.stats.Flagged++
+		log.Warnw("detector/Absorb: state flagged", "rule", verdict.Rule, "reason", verdict.Reason)
+		s.alerts.Raise(alert.New(alert.Warning, "detector", "state flagged", verdict.String(),
+			"scope", hex.EncodeToString(next.Scope()),
+			"state", hex.EncodeToString(next.Hash())))
+		if onFlag, verdict := s.onFlag, verdict; onFlag != nil {
+			s.notify(func() { onFlag(next, verdict) })
+		}
+		if verdict.Gap != nil {
+			gap = verdict.Gap
 		}
+	}
 
-		if root, err = s.StateBuffer.Stash(in[index].Serialize()); err != nil {
-			return nil, errors.Wrap(err, "failed to push the state into the buffer")
+	if gap != nil && refill && s.refetch != nil {
+		if err := s.fill(sc, gap, next); err != nil {
+			log.Errorw("detector/Absorb: failed to fill gap", "gap", gap.String(), "error", err)
+			s.alerts.Raise(alert.New(alert.Warning, "detector", "failed to fill gap", err.Error(),
+				"scope", hex.EncodeToString(gap.Scope),
+				"gap", gap.String()))
+		} else {
+			// re-examine the state against
+			// the newly absorbed states
+			return s.absorb(sc, next, false)
 		}
-		s.lastKnown = in[index].Clone()
-		index++
+	}
+
+	return s.stash(sc, next, true)
+}
+
+// stash pushes the state into the buffer
+// if advance is set, the state becomes
+// the last known state of its scope
+func (s *Service) stash(sc *scope, next watcher.State, advance bool) (*big.Int, error) {
+	var (
+		serialized = next.Serialize()
+		prevRoot   *big.Int
+	)
+	if rw, ok := s.StateBuffer.(Rewinder); ok {
+		prevRoot = new(big.Int).Set(rw.Root())
+	}
+
+	// the state is hashed once for both roots
+	hash, err := s.hash(serialized)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to hash the state")
+	}
+	scopeRoot, err := internal.FoldHash(sc.root, hash)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fold the state into the scope root")
+	}
+
+	var root *big.Int
+	if hs, ok := s.StateBuffer.(internal.HashedStasher); ok {
+		root, err = hs.StashHashed(serialized, hash)
+	} else {
+		root, err = s.StateBuffer.Stash(serialized)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to push the state into the buffer")
+	}
+
+	s.seq++
+	sc.history.push(entry{
+		seq:           s.seq,
+		state:         next.Clone(),
+		prevState:     sc.lastKnown,
+		prevRoot:      prevRoot,
+		prevScopeRoot: sc.root,
+	})
+	sc.root = scopeRoot
+	sc.stats.Absorbed++
+	s.batch = append(s.batch, next)
+	if !advance {
+		return root, nil
+	}
+	sc.lastKnown = next.Clone()
+	if ev := next.Event(); ev != nil {
+		sc.stats.LastBlock = ev.BlockNumber
 	}
 	return root, nil
 }
+
+// fill refetches the block range of the gap and absorbs
+// the states emitted between the last known state and next
+func (s *Service) fill(sc *scope, gap *Gap, next watcher.State) error {
+	// the scopes are readable while refetching,
+	// absorptions are held off by absorbing
+	s.lock.Unlock()
+	states, err := s.refetch(next.Scope(), gap.Blocks)
+	s.lock.Lock()
+	if err != nil {
+		return errors.Wrap(err, "failed to refetch states")
+	}
+
+	nextEvent := next.Event()
+	if nextEvent == nil {
+		return errors.New("current state has no event")
+	}
+
+	filled := 0
+	for _, state := range states {
+		if state == nil || !bytes.Equal(state.Scope(), next.Scope()) {
+			continue
+		}
+		event := state.Event()
+		if event == nil {
+			continue
+		}
+		// only absorb states within the gap
+		if sc.lastKnown.Event().Cmp(event) != 1 || event.Cmp(nextEvent) != 1 {
+			continue
+		}
+		if _, err := s.absorb(sc, state, false); err != nil {
+			return errors.Wrap(err, "failed to absorb refetched state")
+		}
+		filled++
+	}
+	if filled == 0 {
+		return errors.New("no missing states found")
+	}
+	sc.stats.Filled += uint64(filled)
+	log.Infow("detector/Absorb: filled gap", "gap", gap.String(), "states", filled)
+	return nil
+}
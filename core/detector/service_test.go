@@ -0,0 +1,322 @@
+package detector
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/test-go/testify/require"
+)
+
+type testState struct {
+	Sc []byte `cbor:"scope"`
+	H  []byte `cbor:"hash"`
+	E  []byte `cbor:"e"`
+}
+
+func newTestState(scope int64, hash int64, block uint64) *testState {
+	return &testState{
+		Sc: common.BigToHash(big.NewInt(scope)).Bytes(),
+		H:  common.BigToHash(big.NewInt(hash)).Bytes(),
+		E: (&watcher.Event{
+			BlockNumber: block,
+			BlockHash:   common.BigToHash(new(big.Int).SetUint64(block)).Bytes(),
+			TxHash:      common.BigToHash(big.NewInt(hash)).Bytes(),
+			TxIndex:     uint(hash),
+		}).Serialize(),
+	}
+}
+
+func (s *testState) Event() *watcher.Event { return new(watcher.Event).Deserialize(s.E) }
+func (s *testState) Scope() []byte         { return s.Sc }
+func (s *testState) Inner() []byte         { return nil }
+func (s *testState) Hash() []byte          { return s.H }
+func (s *testState) Clone() watcher.State  { c := *s; return &c }
+func (s *testState) Serialize() []byte     { out, _ := cbor.Marshal(s); return out }
+func (s *testState) Deserialize(b []byte) watcher.State {
+	x := &testState{}
+	if err := cbor.Unmarshal(b, x); err != nil {
+		return nil
+	}
+	return x
+}
+func (s *testState) Cmp(x watcher.State) int {
+	if !bytes.Equal(s.Scope(), x.Scope()) {
+		return -1
+	}
+	if !bytes.Equal(s.Hash(), x.Hash()) {
+		return 1
+	}
+	return 0
+}
+
+type testBuffer struct{ stashed [][]byte }
+
+func (b *testBuffer) Stash(in []byte) (*big.Int, error) {
+	b.stashed = append(b.stashed, in)
+	return big.NewInt(int64(len(b.stashed))), nil
+}
+
+func Test_Absorb_DefaultRules(t *testing.T) {
+	buff := &testBuffer{}
+	svc := NewService(buff)
+
+	root, err := svc.Absorb([]watcher.State{
+		newTestState(1, 1, 10),
+		newTestState(1, 2, 11),
+		newTestState(1, 3, 12),
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), root.Int64())
+
+	// out of order event is rejected
+	_, err = svc.Absorb([]watcher.State{newTestState(1, 4, 9)})
+	var rejected *RejectedError
+	require.True(t, errors.As(err, &rejected))
+	require.Equal(t, "event-order", rejected.Verdict.Rule)
+
+	// replaying the last known state is rejected
+	_, err = svc.Absorb([]watcher.State{newTestState(1, 3, 12)})
+	require.True(t, errors.As(err, &rejected))
+	require.Equal(t, "state-transition", rejected.Verdict.Rule)
+}
+
+func Test_Absorb_MixedScopes(t *testing.T) {
+	buff := &testBuffer{}
+	svc := NewService(buff)
+
+	// states of different scopes are
+	// checked against their own last known state
+	_, err := svc.Absorb([]watcher.State{
+		newTestState(1, 1, 10),
+		newTestState(2, 1, 5),
+		newTestState(1, 2, 11),
+		newTestState(2, 2, 6),
+		newTestState(2, 3, 7),
+	})
+	require.NoError(t, err)
+	require.Len(t, buff.stashed, 3)
+
+	scope1 := newTestState(1, 0, 0).Scope()
+	scope2 := newTestState(2, 0, 0).Scope()
+	require.Equal(t, newTestState(1, 2, 11).Hash(), svc.LastKnown(scope1).Hash())
+	require.Equal(t, newTestState(2, 3, 7).Hash(), svc.LastKnown(scope2).Hash())
+	require.NotEqual(t, svc.Root(scope1), svc.Root(scope2))
+
+	// a rejection is accounted to its scope
+	_, err = svc.Absorb([]watcher.State{newTestState(2, 4, 6)})
+	require.Error(t, err)
+
+	stats := svc.Stats()
+	require.Len(t, stats, 2)
+	require.Equal(t, uint64(1), stats[scopeKey(scope1)].Absorbed)
+	require.Equal(t, uint64(11), stats[scopeKey(scope1)].LastBlock)
+	require.Equal(t, uint64(2), stats[scopeKey(scope2)].Absorbed)
+	require.Equal(t, uint64(1), stats[scopeKey(scope2)].Rejected)
+}
+
+func Test_Absorb_CustomRules(t *testing.T) {
+	var (
+		buff    = &testBuffer{}
+		flagged []Verdict
+		svc     = NewService(buff, DefaultRules()...)
+	)
+	svc.Register(NewRule("even-hash", func(_ watcher.State, next watcher.State) Verdict {
+		if new(big.Int).SetBytes(next.Hash()).Bit(0) == 1 {
+			return Flagged("odd hash")
+		}
+		return Accepted()
+	}))
+	svc.OnFlag(func(_ watcher.State, v Verdict) { flagged = append(flagged, v) })
+
+	_, err := svc.Absorb([]watcher.State{
+		newTestState(1, 2, 10),
+		newTestState(1, 3, 11),
+		newTestState(1, 4, 12),
+	})
+	require.NoError(t, err)
+	require.Len(t, buff.stashed, 2)
+	require.Len(t, flagged, 1)
+	require.Equal(t, "even-hash", flagged[0].Rule)
+	require.Equal(t, Flag, flagged[0].Decision)
+}
+
+type rewindBuffer struct {
+	testBuffer
+	root *big.Int
+}
+
+func (b *rewindBuffer) Stash(in []byte) (*big.Int, error) {
+	b.testBuffer.Stash(in)
+	b.root = new(big.Int).Add(b.root, big.NewInt(1))
+	return b.root, nil
+}
+func (b *rewindBuffer) Root() *big.Int { return b.root }
+func (b *rewindBuffer) Rewind(root *big.Int) error {
+	b.root = new(big.Int).Set(root)
+	return nil
+}
+
+func Test_RewindTo(t *testing.T) {
+	var (
+		buff     = &rewindBuffer{root: big.NewInt(100)}
+		svc      = NewService(buff)
+		scopes   = [][]byte{newTestState(1, 0, 0).Scope()}
+		restored watcher.State
+		rolled   []watcher.State
+	)
+	svc.OnRollback(func(r watcher.State, states []watcher.State) { restored, rolled = r, states })
+
+	_, err := svc.Absorb([]watcher.State{
+		newTestState(1, 1, 10),
+		newTestState(1, 2, 11),
+		newTestState(1, 3, 12),
+		newTestState(1, 4, 12),
+		newTestState(1, 5, 13),
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(104), buff.Root().Int64())
+
+	// block 12 is reorged
+	out, err := svc.RewindTo(&watcher.Event{BlockNumber: 12}, scopes)
+	require.NoError(t, err)
+	require.Len(t, out, 3)
+	require.Equal(t, out, rolled)
+	require.Equal(t, newTestState(1, 2, 11).Hash(), restored.Hash())
+	require.Equal(t, int64(101), buff.Root().Int64())
+
+	// the replayed chain is absorbed on top of the restored state
+	_, err = svc.Absorb([]watcher.State{newTestState(1, 6, 12)})
+	require.NoError(t, err)
+	require.Equal(t, int64(102), buff.Root().Int64())
+
+	// nothing to rewind
+	out, err = svc.RewindTo(&watcher.Event{BlockNumber: 20}, scopes)
+	require.NoError(t, err)
+	require.Len(t, out, 0)
+
+	// rewinding past the kept history fails
+	svc.SetHistoryLimit(1)
+	_, err = svc.Absorb([]watcher.State{newTestState(1, 7, 14)})
+	require.NoError(t, err)
+	_, err = svc.RewindTo(&watcher.Event{BlockNumber: 11}, scopes)
+	require.True(t, errors.Is(err, ErrRewindTooDeep))
+}
+
+func Test_RewindTo_TooDeep(t *testing.T) {
+	var (
+		buff    = &rewindBuffer{root: big.NewInt(100)}
+		svc     = NewService(buff)
+		scope   = newTestState(1, 0, 0).Scope()
+		noticed bool
+	)
+	svc.OnRollback(func(watcher.State, []watcher.State) { noticed = true })
+	svc.SetHistoryLimit(1)
+
+	_, err := svc.Absorb([]watcher.State{
+		newTestState(1, 1, 10),
+		newTestState(1, 2, 12),
+		newTestState(2, 1, 10),
+		newTestState(2, 2, 11),
+		newTestState(2, 3, 11),
+	})
+	require.NoError(t, err)
+
+	// the 2nd scope lost the history of its states
+	// stashed after the reorged state of the 1st one
+	_, err = svc.RewindTo(&watcher.Event{BlockNumber: 12}, [][]byte{scope})
+	require.True(t, errors.Is(err, ErrRewindTooDeep))
+	// no scope is rolled back
+	require.False(t, noticed)
+	require.Equal(t, newTestState(1, 2, 12).Hash(), svc.LastKnown(scope).Hash())
+	require.Equal(t, uint64(1), svc.Stats()[scopeKey(scope)].Absorbed)
+	require.Equal(t, int64(103), buff.Root().Int64())
+}
+
+// testTxBuffer stages the stashed states until committed
+type testTxBuffer struct {
+	testBuffer
+	staged [][]byte
+}
+
+func (b *testTxBuffer) Stash(in []byte) (*big.Int, error) {
+	b.staged = append(b.staged, in)
+	return big.NewInt(int64(len(b.stashed) + len(b.staged))), nil
+}
+
+func (b *testTxBuffer) Commit() error {
+	b.stashed, b.staged = append(b.stashed, b.staged...), nil
+	return nil
+}
+
+func Test_Absorb_Transaction(t *testing.T) {
+	buff := &testTxBuffer{}
+	svc := NewService(buff)
+	scope := newTestState(1, 0, 0).Scope()
+
+	_, err := svc.Absorb([]watcher.State{
+		newTestState(1, 1, 10),
+		newTestState(1, 2, 11),
+	})
+	require.NoError(t, err)
+	require.Len(t, buff.stashed, 1)
+
+	// the states before the rejected state are committed
+	_, err = svc.Absorb([]watcher.State{
+		newTestState(1, 3, 12),
+		newTestState(1, 4, 13),
+		newTestState(2, 1, 10),
+		newTestState(1, 5, 9),
+		newTestState(1, 6, 14),
+	})
+	require.Error(t, err)
+	require.Len(t, buff.stashed, 3)
+	require.Empty(t, buff.staged)
+	require.Equal(t, newTestState(1, 4, 13).Hash(), svc.LastKnown(scope).Hash())
+	require.NotNil(t, svc.LastKnown(newTestState(2, 0, 0).Scope()))
+
+	// absorption resumes from the last committed state
+	root, err := svc.Absorb([]watcher.State{newTestState(1, 5, 14)})
+	require.NoError(t, err)
+	require.Equal(t, int64(4), root.Int64())
+	require.Len(t, buff.stashed, 4)
+	require.Equal(t, uint64(4), svc.Stats()[scopeKey(scope)].Absorbed)
+}
+
+func Test_Absorb_Handlers(t *testing.T) {
+	var (
+		buff  = &rewindBuffer{root: big.NewInt(100)}
+		svc   = NewService(buff)
+		scope = newTestState(1, 0, 0).Scope()
+		stats map[string]ScopeStats
+	)
+	// a batch of first states stashes
+	// nothing and returns the buffer root
+	root, err := svc.Absorb([]watcher.State{newTestState(1, 1, 10), newTestState(2, 1, 10)})
+	require.NoError(t, err)
+	require.Equal(t, int64(100), root.Int64())
+
+	// the handlers may call into the detector
+	svc.Register(NewRule("flag-all", func(watcher.State, watcher.State) Verdict {
+		return Missed(&Gap{Scope: scope, From: 1, To: 1, Blocks: [2]uint64{10, 12}})
+	}))
+	svc.OnFlag(func(watcher.State, Verdict) { stats = svc.Stats() })
+	svc.OnRollback(func(watcher.State, []watcher.State) { svc.Absorb(nil) })
+	svc.SetRefetcher(func([]byte, [2]uint64) ([]watcher.State, error) {
+		// the scopes are readable while refetching
+		require.NotNil(t, svc.LastKnown(scope))
+		return nil, nil
+	})
+
+	root, err = svc.Absorb([]watcher.State{newTestState(1, 2, 12)})
+	require.NoError(t, err)
+	require.Equal(t, int64(101), root.Int64())
+	require.Len(t, stats, 2)
+
+	_, err = svc.RewindTo(&watcher.Event{BlockNumber: 12}, [][]byte{scope})
+	require.NoError(t, err)
+}
@@ -0,0 +1,164 @@
+/*
+Exports the observed records and states to JSONL, CSV and Parquet.
+*/
+
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/pkg/errors"
+)
+
+var (
+	log = logging.Logger("export")
+)
+
+var (
+	ErrUnknownFormat      = errors.New("unknown export format")
+	ErrUnknownKind        = errors.New("unknown export kind")
+	ErrCheckpointMismatch = errors.New("checkpoint is of a different export")
+)
+
+type Format string
+
+const (
+	JSONL   Format = "jsonl"
+	CSV     Format = "csv"
+	Parquet Format = "parquet"
+)
+
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(strings.ToLower(name)); f {
+	case JSONL, CSV, Parquet:
+		return f, nil
+	}
+	return "", errors.Wrap(ErrUnknownFormat, name)
+}
+
+// Kind is what is exported
+type Kind string
+
+const (
+	Records Kind = "records"
+	States  Kind = "states"
+)
+
+func ParseKind(name string) (Kind, error) {
+	switch k := Kind(strings.ToLower(name)); k {
+	case Records, States:
+		return k, nil
+	}
+	return "", errors.Wrap(ErrUnknownKind, name)
+}
+
+// Spec describes an export, an export
+// is only resumed with the same spec
+type Spec struct {
+	Kind      Kind   `json:"kind"`
+	Format    Format `json:"format"`
+	Scope     string `json:"scope"`
+	FromBlock uint64 `json:"fromBlock"`
+	ToBlock   uint64 `json:"toBlock"`
+}
+
+// Checkpoint is the progress of an export
+// persisted after every exported batch
+type Checkpoint struct {
+	Spec
+	// source position of the next batch
+	Cursor string `json:"cursor"`
+	Rows   uint64 `json:"rows"`
+	// length of the jsonl and csv output,
+	// or of the rows spooled by a parquet export
+	Offset int64 `json:"offset"`
+	Done   bool  `json:"done"`
+}
+
+// CheckpointPath returns the path of
+// the checkpoint file of the output
+func CheckpointPath(out string) string { return out + ".checkpoint" }
+
+func loadCheckpoint(path string, spec Spec) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Checkpoint{Spec: spec}, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to read checkpoint")
+	}
+	cp := &Checkpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, errors.Wrap(err, "failed to decode checkpoint")
+	}
+	if cp.Spec != spec {
+		return nil, ErrCheckpointMismatch
+	}
+	return cp, nil
+}
+
+func (cp *Checkpoint) save(path string) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return errors.Wrap(err, "failed to write checkpoint")
+	}
+	return os.Rename(tmp, path)
+}
+
+// Export streams the rows of the source into the output.
+// Progress is checkpointed after every batch and an
+// interrupted export is resumed from its checkpoint
+func Export(ctx context.Context, src Source, spec Spec, out string) (*Checkpoint, error) {
+	cpPath := CheckpointPath(out)
+	cp, err := loadCheckpoint(cpPath, spec)
+	if err != nil {
+		return nil, err
+	}
+	if cp.Done {
+		return cp, nil
+	}
+	if cp.Rows > 0 {
+		log.Infow("export/Export: resuming export", "out", out, "rows", cp.Rows, "cursor", cp.Cursor)
+	}
+
+	w, err := openWriter(spec.Format, out, cp)
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+
+	for !cp.Done {
+		if err := ctx.Err(); err != nil {
+			return cp, err
+		}
+		rows, next, done, err := src.Next(ctx, cp.Cursor)
+		if err != nil {
+			return cp, err
+		}
+		if err := w.Write(rows); err != nil {
+			return cp, errors.Wrap(err, "failed to write rows")
+		}
+		if err := w.Checkpoint(cp); err != nil {
+			return cp, errors.Wrap(err, "failed to flush rows")
+		}
+		if done {
+			if err := w.Finish(); err != nil {
+				return cp, errors.Wrap(err, "failed to finish export")
+			}
+		}
+		cp.Cursor, cp.Done = next, done
+		cp.Rows += uint64(len(rows))
+		if err := cp.save(cpPath); err != nil {
+			return cp, err
+		}
+	}
+	log.Infow("export/Export: export done", "out", out, "rows", cp.Rows)
+	return cp, nil
+}
@@ -0,0 +1,106 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/test-go/testify/require"
+)
+
+// testSource yields n rows in batches
+// and fails once after the given batch
+type testSource struct {
+	n, batch, failAfter int
+	calls               int
+}
+
+func (s *testSource) Next(ctx context.Context, cursor string) ([]*Row, string, bool, error) {
+	s.calls++
+	if s.failAfter > 0 && s.calls > s.failAfter {
+		s.failAfter = 0
+		return nil, "", false, errors.New("interrupted")
+	}
+	from := 0
+	if cursor != "" {
+		from, _ = strconv.Atoi(cursor)
+	}
+	var rows []*Row
+	for i := from; i < from+s.batch && i < s.n; i++ {
+		rows = append(rows, &Row{Kind: string(Records), Hash: fmt.Sprintf("%04x", i), BlockNumber: uint64(i)})
+	}
+	next := from + len(rows)
+	return rows, strconv.Itoa(next), next >= s.n, nil
+}
+
+func Test_Export_Resume(t *testing.T) {
+	for _, format := range []Format{JSONL, CSV, Parquet} {
+		t.Run(string(format), func(t *testing.T) {
+			var (
+				out  = filepath.Join(t.TempDir(), "export."+string(format))
+				spec = Spec{Kind: Records, Format: format, FromBlock: 1, ToBlock: 100}
+				src  = &testSource{n: 23, batch: 5, failAfter: 2}
+			)
+
+			cp, err := Export(context.Background(), src, spec, out)
+			require.Error(t, err)
+			require.Equal(t, uint64(10), cp.Rows)
+
+			// a different export does not resume the checkpoint
+			_, err = Export(context.Background(), src, Spec{Kind: States, Format: format}, out)
+			require.True(t, errors.Is(err, ErrCheckpointMismatch))
+
+			cp, err = Export(context.Background(), src, spec, out)
+			require.NoError(t, err)
+			require.True(t, cp.Done)
+			require.Equal(t, uint64(23), cp.Rows)
+
+			var hashes []string
+			switch format {
+			case JSONL:
+				f, err := os.Open(out)
+				require.NoError(t, err)
+				defer f.Close()
+				for sc := bufio.NewScanner(f); sc.Scan(); {
+					hashes = append(hashes, sc.Text())
+				}
+			case CSV:
+				f, err := os.Open(out)
+				require.NoError(t, err)
+				defer f.Close()
+				lines, err := csv.NewReader(f).ReadAll()
+				require.NoError(t, err)
+				require.Equal(t, header, lines[0])
+				for _, l := range lines[1:] {
+					hashes = append(hashes, l[1])
+				}
+			case Parquet:
+				f, err := os.Open(out)
+				require.NoError(t, err)
+				defer f.Close()
+				info, err := f.Stat()
+				require.NoError(t, err)
+				pf, err := parquet.OpenFile(f, info.Size())
+				require.NoError(t, err)
+				// the batches are written in a single row group
+				require.Len(t, pf.RowGroups(), 1)
+				rows, err := parquet.Read[Row](f, info.Size())
+				require.NoError(t, err)
+				for _, r := range rows {
+					hashes = append(hashes, r.Hash)
+				}
+				// the spooled rows are removed
+				_, err = os.Stat(SpoolPath(out))
+				require.True(t, os.IsNotExist(err))
+			}
+			require.Len(t, hashes, 23)
+		})
+	}
+}
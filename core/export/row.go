@@ -0,0 +1,119 @@
+package export
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Row is the flattened form of an exported
+// record or state with its decoded event
+// and state transition fields
+type Row struct {
+	Kind      string `json:"kind" parquet:"kind"`
+	Hash      string `json:"hash" parquet:"hash"`
+	Scope     string `json:"scope" parquet:"scope"`
+	Previous  string `json:"previous,omitempty" parquet:"previous,optional"`
+	PreState  string `json:"preState,omitempty" parquet:"pre_state,optional"`
+	PostState string `json:"postState,omitempty" parquet:"post_state,optional"`
+	Time      int64  `json:"time,omitempty" parquet:"time,optional"`
+
+	BlockNumber uint64 `json:"blockNumber" parquet:"block_number"`
+	BlockHash   string `json:"blockHash" parquet:"block_hash"`
+	TxHash      string `json:"txHash" parquet:"tx_hash"`
+	TxIndex     uint64 `json:"txIndex" parquet:"tx_index"`
+	LogIndex    uint64 `json:"logIndex" parquet:"log_index"`
+	Contract    string `json:"contract" parquet:"contract"`
+	EventName   string `json:"eventName,omitempty" parquet:"event_name,optional"`
+	// json encoding of the decoded event arguments
+	EventArgs string `json:"eventArgs,omitempty" parquet:"event_args,optional"`
+
+	Src          string `json:"src,omitempty" parquet:"src,optional"`
+	Sink         string `json:"sink,omitempty" parquet:"sink,optional"`
+	FeeCollector string `json:"feeCollector,omitempty" parquet:"fee_collector,optional"`
+	// decimal encoding of the fee
+	Fee string `json:"fee,omitempty" parquet:"fee,optional"`
+}
+
+// header is the csv header in the order of values
+var header = []string{
+	"kind", "hash", "scope", "previous", "preState", "postState", "time",
+	"blockNumber", "blockHash", "txHash", "txIndex", "logIndex", "contract", "eventName", "eventArgs",
+	"src", "sink", "feeCollector", "fee",
+}
+
+func (r *Row) values() []string {
+	return []string{
+		r.Kind, r.Hash, r.Scope, r.Previous, r.PreState, r.PostState, strconv.FormatInt(r.Time, 10),
+		strconv.FormatUint(r.BlockNumber, 10), r.BlockHash, r.TxHash,
+		strconv.FormatUint(r.TxIndex, 10), strconv.FormatUint(r.LogIndex, 10),
+		r.Contract, r.EventName, r.EventArgs,
+		r.Src, r.Sink, r.FeeCollector, r.Fee,
+	}
+}
+
+// Decoders decode the event and the state
+// transition of the exported rows, both are optional
+type Decoders struct {
+	Event      *watcher.EventDecoder
+	Transition recorder.TransitionDecoder
+}
+
+func (d Decoders) fill(r *Row, event *watcher.Event, transition []byte) {
+	if event != nil {
+		r.BlockNumber = event.BlockNumber
+		r.BlockHash = common.BytesToHash(event.BlockHash).Hex()
+		r.TxHash = common.BytesToHash(event.TxHash).Hex()
+		r.TxIndex = uint64(event.TxIndex)
+		r.LogIndex = uint64(event.LogIndex)
+		r.Contract = common.BytesToAddress(event.LogAddress).Hex()
+		if d.Event != nil {
+			if decoded, err := d.Event.Decode(event); err == nil {
+				r.EventName = decoded.Name
+				if args, err := json.Marshal(decoded.Args); err == nil {
+					r.EventArgs = string(args)
+				}
+			}
+		}
+	}
+	if d.Transition != nil {
+		if fields := d.Transition(transition); fields != nil {
+			r.Src = common.BytesToAddress(fields.Src).Hex()
+			r.Sink = common.BytesToAddress(fields.Sink).Hex()
+			r.FeeCollector = common.BytesToAddress(fields.FeeCollector).Hex()
+			if fields.Fee != nil {
+				r.Fee = fields.Fee.String()
+			}
+		}
+	}
+}
+
+// RecordRow returns the row of the record
+func (d Decoders) RecordRow(rec recorder.Record) *Row {
+	r := &Row{
+		Kind:      string(Records),
+		Hash:      hex.EncodeToString(rec.Hash()),
+		Scope:     hex.EncodeToString(rec.Scope()),
+		Previous:  hex.EncodeToString(rec.Previous()),
+		PreState:  hex.EncodeToString(rec.PreState()),
+		PostState: hex.EncodeToString(rec.PostState()),
+		Time:      rec.Time(),
+	}
+	d.fill(r, rec.Event(), rec.Transition())
+	return r
+}
+
+// StateRow returns the row of the state
+func (d Decoders) StateRow(state watcher.State) *Row {
+	r := &Row{
+		Kind:  string(States),
+		Hash:  hex.EncodeToString(state.Hash()),
+		Scope: hex.EncodeToString(state.Scope()),
+	}
+	d.fill(r, state.Event(), state.Inner())
+	return r
+}
@@ -0,0 +1,101 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/store"
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/pkg/errors"
+)
+
+// Source yields the rows of an export in batches.
+// A cursor is the position following the last
+// yielded batch, the empty cursor is the start
+type Source interface {
+	Next(ctx context.Context, cursor string) (rows []*Row, next string, done bool, err error)
+}
+
+var (
+	_ Source = (*RecordSource)(nil)
+	_ Source = (*StateSource)(nil)
+)
+
+// RecordSource yields the stored records
+// of the scope within the block range
+type RecordSource struct {
+	Store    store.Querier
+	Filter   store.Filter
+	Batch    int
+	Decoders Decoders
+}
+
+func (s *RecordSource) Next(ctx context.Context, cursor string) ([]*Row, string, bool, error) {
+	page, err := s.Store.Query(&store.Query{
+		Filter: s.Filter,
+		Limit:  s.Batch,
+		Cursor: cursor,
+	})
+	if err != nil {
+		return nil, "", false, errors.Wrap(err, "failed to query records")
+	}
+	rows := make([]*Row, len(page.Records))
+	for i, rec := range page.Records {
+		rows[i] = s.Decoders.RecordRow(rec)
+	}
+	return rows, page.Next, page.Next == "", nil
+}
+
+// Watch returns the observed states within the block range
+type Watch func(blocks [2]uint64) ([]watcher.State, error)
+
+// StateSource yields the states of the scope by
+// replaying the watcher over the block range window by window.
+// The cursor is the first block of the next window
+type StateSource struct {
+	Watch Watch
+	// nil for every scope
+	Scope     []byte
+	FromBlock uint64
+	ToBlock   uint64
+	Window    uint64
+	Decoders  Decoders
+}
+
+func (s *StateSource) Next(ctx context.Context, cursor string) ([]*Row, string, bool, error) {
+	if s.Window == 0 {
+		return nil, "", false, errors.New("window size is zero")
+	}
+	from := s.FromBlock
+	if cursor != "" {
+		var err error
+		if from, err = strconv.ParseUint(cursor, 10, 64); err != nil {
+			return nil, "", false, errors.Wrap(err, "invalid cursor")
+		}
+	}
+	if from > s.ToBlock {
+		return nil, cursor, true, nil
+	}
+	to := from + s.Window - 1
+	if to > s.ToBlock || to < from {
+		to = s.ToBlock
+	}
+
+	// watch is inclusive of both ends
+	states, err := s.Watch([2]uint64{from, to})
+	if err != nil {
+		return nil, "", false, errors.Wrapf(err, "failed to watch blocks [%d %d]", from, to)
+	}
+	rows := make([]*Row, 0, len(states))
+	for _, state := range states {
+		if s.Scope != nil && !bytes.Equal(state.Scope(), s.Scope) {
+			continue
+		}
+		if ev := state.Event(); ev == nil || ev.BlockNumber < from || ev.BlockNumber > to {
+			continue
+		}
+		rows = append(rows, s.Decoders.StateRow(state))
+	}
+	return rows, strconv.FormatUint(to+1, 10), to == s.ToBlock, nil
+}
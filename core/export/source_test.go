@@ -0,0 +1,186 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+	"github.com/0xBow-io/asp-go-buildkit/core/store"
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/test-go/testify/require"
+)
+
+const testABI = `[{"anonymous":false,"inputs":[{"indexed":true,"name":"from","type":"address"},{"indexed":false,"name":"amount","type":"uint256"}],"name":"Deposit","type":"event"}]`
+
+var (
+	testScope = common.BigToHash(big.NewInt(1)).Bytes()
+	testSrc   = common.HexToAddress("0x01")
+	testSink  = common.HexToAddress("0x02")
+)
+
+type testState struct {
+	S []byte `cbor:"scope"`
+	H []byte `cbor:"hash"`
+	E []byte `cbor:"e"`
+	I []byte `cbor:"inner"`
+}
+
+func (s *testState) Event() *watcher.Event { return new(watcher.Event).Deserialize(s.E) }
+func (s *testState) Scope() []byte         { return s.S }
+func (s *testState) Inner() []byte         { return s.I }
+func (s *testState) Hash() []byte          { return s.H }
+func (s *testState) Clone() watcher.State  { c := *s; return &c }
+func (s *testState) Serialize() []byte     { out, _ := cbor.Marshal(s); return out }
+func (s *testState) Deserialize(b []byte) watcher.State {
+	x := &testState{}
+	if err := cbor.Unmarshal(b, x); err != nil {
+		return nil
+	}
+	return x
+}
+func (s *testState) Cmp(x watcher.State) int {
+	if !bytes.Equal(s.Hash(), x.Hash()) {
+		return 1
+	}
+	return 0
+}
+
+// testTransition is the src, sink and fee of the transition
+func testTransition(fee int64) []byte {
+	return append(append(testSrc.Bytes(), testSink.Bytes()...), big.NewInt(fee).Bytes()...)
+}
+
+func decodeTestTransition(b []byte) *recorder.TransitionFields {
+	if len(b) < 2*common.AddressLength {
+		return nil
+	}
+	return &recorder.TransitionFields{
+		Src:  b[:common.AddressLength],
+		Sink: b[common.AddressLength : 2*common.AddressLength],
+		Fee:  new(big.Int).SetBytes(b[2*common.AddressLength:]),
+	}
+}
+
+// testDeposit returns a deposit state of the scope at the block
+func testDeposit(t *testing.T, scope []byte, block uint64) *testState {
+	parsed, err := abi.JSON(bytes.NewReader([]byte(testABI)))
+	require.NoError(t, err)
+	data, err := parsed.Events["Deposit"].Inputs.NonIndexed().Pack(new(big.Int).SetUint64(block))
+	require.NoError(t, err)
+	ev := &watcher.Event{
+		BlockNumber: block,
+		TxHash:      crypto.Keccak256([]byte{byte(block)}),
+		LogTopics:   append(parsed.Events["Deposit"].ID.Bytes(), common.BytesToHash(testSrc.Bytes()).Bytes()...),
+		LogData:     data,
+		LogAddress:  testSink.Bytes(),
+	}
+	return &testState{
+		S: scope,
+		H: common.BigToHash(new(big.Int).SetUint64(block + 1)).Bytes(),
+		E: ev.Serialize(),
+		I: testTransition(int64(block)),
+	}
+}
+
+func testDecoders(t *testing.T) Decoders {
+	decoder := watcher.NewEventDecoder()
+	require.NoError(t, decoder.RegisterJSON(testABI))
+	return Decoders{Event: decoder, Transition: decodeTestTransition}
+}
+
+// drain returns the rows of every batch of the source
+func drain(t *testing.T, src Source) []*Row {
+	var (
+		rows   []*Row
+		cursor string
+	)
+	for done := false; !done; {
+		batch, next, ok, err := src.Next(context.Background(), cursor)
+		require.NoError(t, err)
+		rows, cursor, done = append(rows, batch...), next, ok
+	}
+	return rows
+}
+
+func Test_RecordSource(t *testing.T) {
+	s, err := store.NewBoltStore(filepath.Join(t.TempDir(), "records.db"), decodeTestTransition)
+	require.NoError(t, err)
+	defer s.Close()
+
+	var prev []byte
+	for block := uint64(1); block <= 3; block++ {
+		rec := recorder.NewRecord(testDeposit(t, testScope, block), common.BigToHash(new(big.Int).SetUint64(block)).Bytes(), prev)
+		require.NoError(t, s.Put(rec))
+		prev = rec.Hash()
+	}
+
+	rows := drain(t, &RecordSource{
+		Store:    s,
+		Filter:   store.Filter{Scope: testScope, FromBlock: 2},
+		Batch:    1,
+		Decoders: testDecoders(t),
+	})
+	require.Len(t, rows, 2)
+	for i, r := range rows {
+		block := uint64(i + 2)
+		require.Equal(t, string(Records), r.Kind)
+		require.NotEmpty(t, r.Previous)
+		require.Equal(t, block, r.BlockNumber)
+		require.Equal(t, common.BytesToHash(crypto.Keccak256([]byte{byte(block)})).Hex(), r.TxHash)
+		require.Equal(t, testSink.Hex(), r.Contract)
+		require.Equal(t, "Deposit", r.EventName)
+		require.Contains(t, r.EventArgs, fmt.Sprintf(`"amount":"%d"`, block))
+		require.Equal(t, testSrc.Hex(), r.Src)
+		require.Equal(t, testSink.Hex(), r.Sink)
+		require.Equal(t, big.NewInt(int64(block)).String(), r.Fee)
+	}
+}
+
+func Test_StateSource(t *testing.T) {
+	var (
+		other  = common.BigToHash(big.NewInt(2)).Bytes()
+		states = []watcher.State{
+			testDeposit(t, testScope, 1),
+			testDeposit(t, other, 2),
+			testDeposit(t, testScope, 4),
+			testDeposit(t, testScope, 6),
+		}
+		windows [][2]uint64
+	)
+	rows := drain(t, &StateSource{
+		Watch: func(blocks [2]uint64) ([]watcher.State, error) {
+			windows = append(windows, blocks)
+			// the watched states may exceed the window
+			return states, nil
+		},
+		Scope:     testScope,
+		FromBlock: 1,
+		ToBlock:   5,
+		Window:    2,
+		Decoders:  testDecoders(t),
+	})
+	require.Equal(t, [][2]uint64{{1, 2}, {3, 4}, {5, 5}}, windows)
+	require.Len(t, rows, 2)
+	require.Equal(t, uint64(1), rows[0].BlockNumber)
+	require.Equal(t, uint64(4), rows[1].BlockNumber)
+	for _, r := range rows {
+		require.Equal(t, string(States), r.Kind)
+		require.Empty(t, r.Previous)
+		require.Equal(t, "Deposit", r.EventName)
+		require.Equal(t, testSrc.Hex(), r.Src)
+	}
+
+	// the transition is left empty if it fails to decode
+	r := testDecoders(t).StateRow(&testState{S: testScope, E: states[0].(*testState).E})
+	require.Equal(t, "Deposit", r.EventName)
+	require.Empty(t, r.Src)
+	require.Empty(t, r.Fee)
+}
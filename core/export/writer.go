@@ -0,0 +1,188 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/pkg/errors"
+)
+
+// writer writes the rows of an export.
+// Checkpoint flushes the written rows to disk,
+// Finish completes the output once every row is written
+type writer interface {
+	Write(rows []*Row) error
+	Checkpoint(cp *Checkpoint) error
+	Finish() error
+	Close() error
+}
+
+// openWriter opens the output at the checkpoint
+// discarding whatever was written after it
+func openWriter(format Format, out string, cp *Checkpoint) (writer, error) {
+	switch format {
+	case JSONL, CSV, Parquet:
+		path := out
+		if format == Parquet {
+			path = SpoolPath(out)
+		}
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open export file")
+		}
+		if err := f.Truncate(cp.Offset); err != nil {
+			f.Close()
+			return nil, errors.Wrap(err, "failed to truncate export file")
+		}
+		if _, err := f.Seek(cp.Offset, 0); err != nil {
+			f.Close()
+			return nil, errors.Wrap(err, "failed to seek export file")
+		}
+		w := &streamWriter{f: f, buf: bufio.NewWriter(f), offset: cp.Offset}
+		if format == CSV {
+			w.csv = csv.NewWriter(w.buf)
+			if cp.Offset == 0 {
+				if err := w.csv.Write(header); err != nil {
+					f.Close()
+					return nil, err
+				}
+			}
+		}
+		if format == Parquet {
+			return &parquetWriter{out: out, spool: w}, nil
+		}
+		return w, nil
+	}
+	return nil, errors.Wrap(ErrUnknownFormat, string(format))
+}
+
+// streamWriter appends jsonl or csv rows to a single file
+type streamWriter struct {
+	f      *os.File
+	buf    *bufio.Writer
+	csv    *csv.Writer
+	offset int64
+}
+
+func (w *streamWriter) Write(rows []*Row) error {
+	for _, r := range rows {
+		if w.csv != nil {
+			if err := w.csv.Write(r.values()); err != nil {
+				return err
+			}
+			continue
+		}
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if _, err := w.buf.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *streamWriter) Checkpoint(cp *Checkpoint) error {
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+	offset, err := w.f.Seek(0, 1)
+	if err != nil {
+		return err
+	}
+	cp.Offset = offset
+	return nil
+}
+
+func (w *streamWriter) Finish() error { return nil }
+
+func (w *streamWriter) Close() error { return w.f.Close() }
+
+// RowGroupSize is the number of rows of
+// the row groups of a parquet export
+const RowGroupSize = 64 * 1024
+
+// SpoolPath returns the path of the rows
+// spooled by a parquet export until it is done
+func SpoolPath(out string) string { return out + ".rows" }
+
+// parquetWriter spools the rows to a jsonl file, which
+// is resumed like any jsonl export, and writes them in
+// row groups into a single parquet file once every row
+// is written
+type parquetWriter struct {
+	out   string
+	spool *streamWriter
+	done  bool
+}
+
+func (w *parquetWriter) Write(rows []*Row) error { return w.spool.Write(rows) }
+
+func (w *parquetWriter) Checkpoint(cp *Checkpoint) error { return w.spool.Checkpoint(cp) }
+
+func (w *parquetWriter) Finish() error {
+	if err := w.spool.buf.Flush(); err != nil {
+		return err
+	}
+	if _, err := w.spool.f.Seek(0, 0); err != nil {
+		return errors.Wrap(err, "failed to rewind the spooled rows")
+	}
+	tmp := w.out + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return errors.Wrap(err, "failed to create export file")
+	}
+	defer f.Close()
+	pw := parquet.NewGenericWriter[Row](f, parquet.MaxRowsPerRowGroup(RowGroupSize))
+	dec := json.NewDecoder(bufio.NewReader(w.spool.f))
+	batch := make([]Row, 0, RowGroupSize)
+	for {
+		var r Row
+		if err := dec.Decode(&r); err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "failed to read the spooled rows")
+		}
+		if batch = append(batch, r); len(batch) == cap(batch) {
+			if _, err := pw.Write(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if _, err := pw.Write(batch); err != nil {
+		return err
+	}
+	if err := pw.Close(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, w.out); err != nil {
+		return err
+	}
+	w.done = true
+	return nil
+}
+
+func (w *parquetWriter) Close() error {
+	if err := w.spool.Close(); err != nil || !w.done {
+		return err
+	}
+	return os.Remove(SpoolPath(w.out))
+}
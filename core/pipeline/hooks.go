@@ -0,0 +1,265 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/accumulator"
+	"github.com/0xBow-io/asp-go-buildkit/core/alert"
+	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+	"github.com/0xBow-io/asp-go-buildkit/core/store"
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/0xBow-io/asp-go-buildkit/internal"
+)
+
+// Divergence is a mismatch between the
+// stashed states and the on-chain state
+type Divergence struct {
+	// block the chain was read at
+	Block  uint64
+	Kind   string
+	Reason string
+}
+
+// Validator cross-validates the stashed
+// states of an instance against the chain
+type Validator interface {
+	// Track keeps track of the stashed states in order
+	Track(states ...watcher.State)
+	// Rollback stops tracking the states
+	// emitted at or after the block
+	Rollback(block uint64)
+	// Diverged returns the divergences of the tracked
+	// states, none if no state is tracked
+	Diverged(ctx context.Context) ([]Divergence, error)
+}
+
+// Hooks are the protocol specifics of the pipeline, each is optional
+type Hooks struct {
+	// Validator returns the cross-validator of the instance
+	Validator func(inst Instance) Validator
+	// Checkpoint returns the on-chain state of the instance
+	// at the block, nil if there is none yet
+	Checkpoint func(ctx context.Context, inst Instance, block uint64) ([]byte, error)
+	// IsCheckpoint returns whether the state is
+	// an on-chain state of the instance at the block,
+	// zero is the latest block
+	IsCheckpoint func(ctx context.Context, inst Instance, state []byte, block uint64) (bool, error)
+	// Indices returns the state indices inserted by the state
+	Indices func(state watcher.State) (internal.Range, bool)
+	// OnRecord is called with every recorded state
+	OnRecord func(state watcher.State, rec recorder.Record)
+}
+
+// validate cross-validates the states of the instance
+// at every interval until the context is done
+func (p *Pipeline) validate(ctx context.Context, inst Instance, v Validator) {
+	ticker := time.NewTicker(p.in.Config.ValidateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		divergences, err := v.Diverged(ctx)
+		if err != nil {
+			log.Errorw("pipeline/validate: cross-validation failed", "instance", inst.ID(), "err", err)
+			p.in.Alerts.Raise(alert.New(alert.Warning, "validator", "cross-validation failed", err.Error(),
+				"instance", inst.ID()))
+			continue
+		}
+		for _, d := range divergences {
+			log.Errorw("pipeline/validate: divergence from on-chain state", "instance", inst.ID(),
+				"block", d.Block, "kind", d.Kind, "reason", d.Reason)
+			p.in.Alerts.Raise(alert.New(alert.Critical, "validator", "divergence from on-chain state", d.Reason,
+				"instance", inst.ID(),
+				"kind", d.Kind,
+				"block", fmt.Sprintf("%d", d.Block)))
+		}
+	}
+}
+
+// stashed tracks the stashed states, refetched and released
+// states included, for the cross-validation of their
+// instance and marks their state indices as observed
+func (p *Pipeline) stashed(states []watcher.State) {
+	for _, state := range states {
+		if i := p.instanceOf(state.Scope()); i >= 0 && p.validators[i] != nil {
+			p.validators[i].Track(state)
+		}
+	}
+	p.track(states)
+}
+
+// rollback rewinds the store, accumulator, validator
+// and recorder to the state restored by the detector
+func (p *Pipeline) rollback(restored watcher.State, rolled []watcher.State) {
+	scope := rolled[0].Scope()
+	if ev := rolled[0].Event(); ev != nil {
+		if p.in.Store != nil {
+			hashes, err := unrecord(p.in.Store, scope, ev.BlockNumber)
+			if err != nil {
+				log.Errorw("pipeline/rollback: failed to delete the rolled back records", "err", err)
+			}
+			if p.in.Accumulator != nil {
+				unaccumulate(p.in.Accumulator, hashes)
+			}
+		}
+		if i := p.instanceOf(scope); i >= 0 && p.validators[i] != nil {
+			p.validators[i].Rollback(ev.BlockNumber)
+		}
+	}
+	p.in.Recorder.Rewind(scope, restored)
+	log.Warnw("pipeline/rollback: rolled back states", "count", len(rolled))
+}
+
+// bootstrap returns the pre-state of a scope from the last stored
+// record preceding the first block watched of its instance,
+// or else from the on-chain checkpoint preceding that block
+func (p *Pipeline) bootstrap(scope []byte) ([]byte, error) {
+	i := p.instanceOf(scope)
+	if i < 0 {
+		if p.in.Store == nil {
+			return nil, nil
+		}
+		rec, err := p.in.Store.Last(scope)
+		if errors.Is(err, store.ErrRecordNotFound) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		return rec.PostState(), nil
+	}
+	var (
+		inst = p.in.Instances[i]
+		from = p.from[i]
+	)
+	if p.in.Store != nil && from > 0 {
+		rec, err := lastBefore(p.in.Store, scope, from)
+		if err != nil {
+			return nil, err
+		}
+		if rec != nil {
+			pre := rec.PostState()
+			p.verify(inst, scope, pre, from-1)
+			return pre, nil
+		}
+	}
+	if from == 0 || p.in.Hooks.Checkpoint == nil {
+		return nil, nil
+	}
+	return p.in.Hooks.Checkpoint(p.work, inst, from-1)
+}
+
+// verify alerts if the stored pre-state of
+// the scope is not an on-chain checkpoint at the block
+func (p *Pipeline) verify(inst Instance, scope, pre []byte, block uint64) {
+	if p.in.Hooks.IsCheckpoint == nil {
+		return
+	}
+	ok, err := p.in.Hooks.IsCheckpoint(p.work, inst, pre, block)
+	if err != nil {
+		log.Errorw("pipeline/bootstrap: failed to verify the stored pre-state", "instance", inst.ID(), "err", err)
+		p.in.Alerts.Raise(alert.New(alert.Warning, "recorder", "failed to verify the stored pre-state", err.Error(),
+			"instance", inst.ID(),
+			"scope", hex.EncodeToString(scope)))
+	} else if !ok {
+		p.in.Alerts.Raise(alert.New(alert.Warning, "recorder", "stored pre-state is not an on-chain checkpoint",
+			hex.EncodeToString(pre),
+			"instance", inst.ID(),
+			"scope", hex.EncodeToString(scope)))
+	}
+}
+
+// lastBefore returns the latest stored record of the scope
+// emitted before the block, nil if there is none
+func lastBefore(s store.RecordStore, scope []byte, block uint64) (recorder.Record, error) {
+	rec, err := s.Last(scope)
+	if errors.Is(err, store.ErrRecordNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if ev := rec.Event(); ev != nil && ev.BlockNumber < block {
+		return rec, nil
+	}
+	rec = nil
+	if err := s.ByBlockRange(0, block-1, func(r recorder.Record) bool {
+		if bytes.Equal(r.Scope(), scope) {
+			rec = r
+		}
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// headLookup returns the hash of
+// the latest stored record of the scope
+func headLookup(s store.RecordStore) recorder.HeadLookup {
+	return func(scope []byte) ([]byte, error) {
+		rec, err := s.Last(scope)
+		if errors.Is(err, store.ErrRecordNotFound) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		return rec.Hash(), nil
+	}
+}
+
+// unrecord deletes the stored records of the scope
+// emitted at or after the block and returns their hashes
+func unrecord(s store.RecordStore, scope []byte, block uint64) ([][]byte, error) {
+	var hashes [][]byte
+	if err := s.ByBlockRange(block, ^uint64(0), func(rec recorder.Record) bool {
+		if bytes.Equal(rec.Scope(), scope) {
+			hashes = append(hashes, rec.Hash())
+		}
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	for i, hash := range hashes {
+		if err := s.Delete(hash); err != nil {
+			return hashes[:i], err
+		}
+	}
+	return hashes, nil
+}
+
+// unaccumulate truncates the accumulator
+// before the earliest of the rolled back records
+func unaccumulate(acc *accumulator.Accumulator, hashes [][]byte) {
+	size := acc.Size()
+	for _, hash := range hashes {
+		if idx, ok := acc.Index(hash); ok && idx < size {
+			size = idx
+		}
+	}
+	if _, err := acc.Truncate(size); err != nil {
+		log.Errorw("pipeline/rollback: failed to truncate the accumulator", "err", err)
+	}
+}
+
+// accumulate appends the stored records to the accumulator in
+// the order they were stored, the order they are accumulated in
+// as they are recorded, skipping the records it holds already
+func accumulate(acc *accumulator.Accumulator, s store.RecordStore) (err error) {
+	if serr := s.ByOrder(func(rec recorder.Record) bool {
+		if _, ok := acc.Index(rec.Hash()); ok {
+			return true
+		}
+		_, err = acc.Append(rec.Hash())
+		return err == nil
+	}); serr != nil {
+		return serr
+	}
+	return err
+}
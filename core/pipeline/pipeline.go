@@ -0,0 +1,691 @@
+/*
+Package pipeline observes protocol instances: each instance is
+watched a window of blocks at a time, the observed states are
+absorbed by the detector into the buffer and the states sinked
+by the buffer are recorded, stored and accumulated.
+*/
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/accumulator"
+	"github.com/0xBow-io/asp-go-buildkit/core/alert"
+	"github.com/0xBow-io/asp-go-buildkit/core/detector"
+	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+	"github.com/0xBow-io/asp-go-buildkit/core/store"
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/0xBow-io/asp-go-buildkit/internal"
+	"github.com/0xBow-io/asp-go-buildkit/internal/erpc"
+	logging "github.com/ipfs/go-log/v2"
+	pkgerrors "github.com/pkg/errors"
+)
+
+var log = logging.Logger("pipeline")
+
+// DefaultValidateInterval is the default
+// interval between cross-validations
+const DefaultValidateInterval = time.Minute
+
+var (
+	ErrNoInstances       = errors.New("no instances to observe")
+	ErrInvalidWindowSize = errors.New("invalid window size")
+)
+
+// genesis is implemented by observables
+// which know their deployment block
+type genesis interface {
+	Genesis() uint64
+}
+
+// acker is implemented by buffers which keep
+// sinked states until they are acknowledged
+type acker interface {
+	Ack() error
+}
+
+// Instance is an observed protocol instance
+type Instance struct {
+	watcher.Observable
+	// name of the chain of the instance
+	Chain   string
+	Backend erpc.Backend
+	Watcher *watcher.Service
+	// first block watched, prevails over the
+	// checkpoint and the records unless 0
+	From uint64
+	// first block watched if nothing was recorded,
+	// defaults to the genesis of the observable
+	Start uint64
+	// window size and wait of the instance,
+	// default to those of the config
+	WindowSize uint64
+	WaitTime   time.Duration
+}
+
+// Config is the config of the pipeline
+type Config struct {
+	// maximum number of blocks watched at once
+	WindowSize uint64
+	// wait between windows once caught up
+	WaitTime time.Duration
+	// interval between cross-validations,
+	// defaults to DefaultValidateInterval
+	ValidateInterval time.Duration
+	// returns the checkpoint path of the
+	// instance, not checkpointed if nil or empty
+	Checkpoint func(id string) string
+	// checkpoint path of the buffer root shared
+	// by the instances, not checkpointed if empty
+	RootCheckpoint string
+}
+
+// Params are the components of the pipeline,
+// the store, accumulator and tracker are optional
+type Params struct {
+	Config    Config
+	Instances []Instance
+	Detector  *detector.Service
+	Recorder  *recorder.Service
+	Buffer    internal.Buffer
+	Des       watcher.StateDeserializer
+	Alerts    alert.Raiser
+	Hooks     Hooks
+	// persists the records
+	Store store.RecordStore
+	// accumulates the records for inclusion
+	// proofs, it is rebuilt from the store
+	Accumulator *accumulator.Accumulator
+	// records the observed state indices
+	// saved to TrackerDir after every window
+	Tracker    *internal.Tracker
+	TrackerDir string
+}
+
+/*
+Pipeline watches each instance a window of blocks at a time,
+absorbs the observed states into the buffer and records the
+states sinked by the buffer.
+Once stopped, the current windows are finished and the buffer
+drained before the checkpoints are persisted, unless aborted.
+*/
+type Pipeline struct {
+	in Params
+	// cross-validators of the instances, if any
+	validators []Validator
+	// first block watched of the instances
+	from []uint64
+	// blocks the instances watch again
+	// from once their states are rewound
+	rewinds   []uint64
+	rewinding sync.Mutex
+
+	stream chan []byte
+	// the windows of the instances are
+	// absorbed one at a time
+	absorbing sync.Mutex
+	// the recording loop closes the requested
+	// channel once every absorbed state is recorded
+	settling chan chan struct{}
+	// calls of the current windows,
+	// only cancelled on abort
+	work context.Context
+	// stops after the current window
+	halt context.CancelFunc
+	// aborts the current window and drain
+	abort context.CancelFunc
+	// closed once the pipeline returned
+	done chan struct{}
+	err  error
+}
+
+// New wires the components of the pipeline,
+// the accumulator is rebuilt from the store
+func New(params Params) (*Pipeline, error) {
+	if len(params.Instances) == 0 {
+		return nil, ErrNoInstances
+	}
+	instances := make([]Instance, len(params.Instances))
+	for i, inst := range params.Instances {
+		if inst.WindowSize == 0 {
+			inst.WindowSize = params.Config.WindowSize
+		}
+		if inst.WaitTime == 0 {
+			inst.WaitTime = params.Config.WaitTime
+		}
+		if inst.WindowSize == 0 {
+			return nil, pkgerrors.Wrapf(ErrInvalidWindowSize, "instance %s", inst.ID())
+		}
+		instances[i] = inst
+	}
+	params.Instances = instances
+	if params.Config.ValidateInterval == 0 {
+		params.Config.ValidateInterval = DefaultValidateInterval
+	}
+	if params.Alerts == nil {
+		params.Alerts = alert.Nop
+	}
+	p := &Pipeline{
+		in:         params,
+		validators: make([]Validator, len(params.Instances)),
+		from:       make([]uint64, len(params.Instances)),
+		rewinds:    make([]uint64, len(params.Instances)),
+		stream:     make(chan []byte),
+		settling:   make(chan chan struct{}),
+		work:       context.Background(),
+		done:       make(chan struct{}),
+	}
+	if params.Hooks.Validator != nil {
+		for i, inst := range params.Instances {
+			p.validators[i] = params.Hooks.Validator(inst)
+		}
+	}
+
+	// report rolled back states downstream
+	params.Detector.OnRollback(p.rollback)
+	// every stashed state is cross-validated
+	// and tracked, the refetched states included
+	params.Detector.OnStash(p.stashed)
+	// fill in missed state transitions
+	// by re-watching the block range of the gap
+	params.Detector.SetRefetcher(p.refetch)
+
+	// record the first observed transition
+	params.Recorder.SetBootstrap(p.bootstrap)
+	if params.Store == nil {
+		return p, nil
+	}
+	// chain the records to the stored records
+	params.Recorder.SetHeadLookup(headLookup(params.Store))
+	if params.Accumulator != nil {
+		if err := accumulate(params.Accumulator, params.Store); err != nil {
+			return nil, pkgerrors.Wrap(err, "failed to rebuild the accumulator")
+		}
+	}
+	return p, nil
+}
+
+// checkpoint returns the checkpoint path of the instance
+func (p *Pipeline) checkpoint(inst Instance) string {
+	if p.in.Config.Checkpoint == nil {
+		return ""
+	}
+	return p.in.Config.Checkpoint(inst.ID())
+}
+
+// resume returns the first block watched of the instance and its
+// checkpoint, if it is resumed from it. An explicit start block
+// other than the checkpoint block prevails, else the latest
+// of the checkpoint and the stored records
+func (p *Pipeline) resume(inst Instance) (uint64, *internal.Checkpoint, error) {
+	var cp *internal.Checkpoint
+	if path := p.checkpoint(inst); path != "" {
+		var err error
+		if cp, err = internal.LoadCheckpoint(path); err != nil {
+			return 0, nil, err
+		}
+	}
+	if inst.From > 0 && (cp == nil || cp.Next != inst.From) {
+		if cp != nil {
+			log.Warnw("pipeline: ignoring the checkpoint of the instance", "instance", inst.ID(),
+				"checkpoint", cp.Next, "from", inst.From)
+		}
+		return inst.From, nil, nil
+	}
+
+	var next uint64
+	if p.in.Store != nil {
+		rec, err := p.in.Store.Last(inst.Scope())
+		if err == nil {
+			if ev := rec.Event(); ev != nil {
+				next = ev.BlockNumber + 1
+			}
+		} else if !errors.Is(err, store.ErrRecordNotFound) {
+			return 0, nil, pkgerrors.Wrap(err, "failed to look up the last record")
+		}
+	}
+	if cp != nil && cp.Next >= next {
+		return cp.Next, cp, nil
+	}
+	if next > 0 {
+		return next, nil, nil
+	}
+	if inst.Start > 0 {
+		return inst.Start, nil, nil
+	}
+	if g, ok := inst.Observable.(genesis); ok && g.Genesis() > 0 {
+		return g.Genesis(), nil, nil
+	}
+	return 0, nil, pkgerrors.Errorf("no start block configured for instance %s", inst.ID())
+}
+
+// root returns the buffer root of the root checkpoint, else
+// the root of the instance checkpoint written before the root
+// was checkpointed on its own, nil if there is none
+func (p *Pipeline) root(cp *internal.Checkpoint) (*big.Int, error) {
+	if path := p.in.Config.RootCheckpoint; path != "" {
+		rcp, err := internal.LoadCheckpoint(path)
+		if err != nil {
+			return nil, err
+		}
+		if rcp != nil {
+			cp = rcp
+		}
+	}
+	if cp.Root == "" {
+		return nil, nil
+	}
+	root, ok := new(big.Int).SetString(cp.Root, 10)
+	if !ok {
+		return nil, pkgerrors.Errorf("invalid checkpoint root %q", cp.Root)
+	}
+	return root, nil
+}
+
+// Start resumes the instances and watches them in the background
+// until the pipeline is stopped, it gives up on resuming once ctx
+// is done. The pre-states are bootstrapped as the scopes are first
+// recorded, with the calls of the windows rather than ctx
+func (p *Pipeline) Start(ctx context.Context) error {
+	var resumed *internal.Checkpoint
+	for i, inst := range p.in.Instances {
+		if err := ctx.Err(); err != nil {
+			return pkgerrors.Wrap(err, "pipeline start aborted")
+		}
+		next, cp, err := p.resume(inst)
+		if err != nil {
+			return err
+		}
+		if resumed == nil {
+			resumed = cp
+		}
+		p.from[i] = next
+	}
+	// the buffer root carries on from the checkpoint if the
+	// instances do, a root recovered by the buffer prevails
+	if resumed != nil && p.in.Buffer.Root().Sign() == 0 {
+		if err := ctx.Err(); err != nil {
+			return pkgerrors.Wrap(err, "pipeline start aborted")
+		}
+		root, err := p.root(resumed)
+		if err != nil {
+			return err
+		}
+		if root != nil {
+			if err := p.in.Buffer.Rewind(root); err != nil {
+				return pkgerrors.Wrap(err, "failed to restore the checkpoint root")
+			}
+			log.Infow("pipeline: restored the checkpoint root", "root", root)
+		}
+	}
+	// the pipeline outlives the start context
+	var (
+		run, halt   = context.WithCancel(context.Background())
+		work, abort = context.WithCancel(context.Background())
+	)
+	p.work, p.halt, p.abort = work, halt, abort
+
+	// the sink returns once the buffer is closed
+	go p.in.Buffer.Sink(p.stream)
+	go func() {
+		defer close(p.done)
+		p.err = p.run(run, work)
+	}()
+	for i, inst := range p.in.Instances {
+		log.Infow("pipeline: started", "instance", inst.ID(), "chain", inst.Chain, "from", p.from[i])
+	}
+	return nil
+}
+
+// Stop finishes the current windows, drains the buffer
+// and persists the checkpoints, unless ctx is done before
+func (p *Pipeline) Stop(ctx context.Context) error {
+	if p.halt == nil {
+		return nil
+	}
+	p.halt()
+	select {
+	case <-p.done:
+		log.Infow("pipeline: stopped", "instances", len(p.in.Instances))
+		return p.err
+	case <-ctx.Done():
+		p.abort()
+		<-p.done
+		return pkgerrors.Wrap(ctx.Err(), "pipeline aborted")
+	}
+}
+
+// run watches the instances from their block and records until
+// ctx is done, the calls of the current windows are made with work
+func (p *Pipeline) run(ctx, work context.Context) error {
+	defer p.abort()
+	var (
+		watching   = make(chan struct{})
+		next       = make([]uint64, len(p.in.Instances))
+		wg         sync.WaitGroup
+		validating sync.WaitGroup
+	)
+	for i, inst := range p.in.Instances {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			next[i] = p.watch(ctx, work, i)
+		}(i)
+		if v := p.validators[i]; v != nil {
+			validating.Add(1)
+			go func(inst Instance, v Validator) {
+				defer validating.Done()
+				p.validate(ctx, inst, v)
+			}(inst, v)
+		}
+	}
+	go func() {
+		wg.Wait()
+		close(watching)
+	}()
+	defer validating.Wait()
+
+	for stopped := false; !stopped; {
+		select {
+		case ss := <-p.stream:
+			p.record(ss)
+		case drained := <-p.settling:
+			if err := internal.Drain(work, p.in.Buffer, p.stream, p.record); err == nil {
+				close(drained)
+			}
+		case <-watching:
+			stopped = true
+		}
+	}
+	// flush the absorbed states
+	// before persisting the checkpoints
+	if err := internal.Drain(work, p.in.Buffer, p.stream, p.record); err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for i, inst := range p.in.Instances {
+		path := p.checkpoint(inst)
+		if path == "" {
+			continue
+		}
+		if err := internal.SaveCheckpoint(path, &internal.Checkpoint{Next: next[i], Time: now}); err != nil {
+			return err
+		}
+		log.Infow("pipeline: saved checkpoint", "instance", inst.ID(), "next", next[i])
+	}
+	if path := p.in.Config.RootCheckpoint; path != "" {
+		root := p.in.Buffer.Root().String()
+		if err := internal.SaveCheckpoint(path, &internal.Checkpoint{Root: root, Time: now}); err != nil {
+			return err
+		}
+		log.Infow("pipeline: saved the root checkpoint", "root", root)
+	}
+	return nil
+}
+
+// sleep waits for the duration
+// returns false if the context is done
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// watch watches the instance from its first block and absorbs
+// the observed states until either context is done, the states
+// of reorged blocks are rewound first.
+// Returns the first block of the next window
+func (p *Pipeline) watch(ctx, work context.Context, i int) uint64 {
+	var (
+		inst     = p.in.Instances[i]
+		id       = inst.ID()
+		window   = [2]uint64{p.from[i], p.from[i]}
+		absorbed = &absorbed{limit: DefaultReorgDepth}
+	)
+	for ctx.Err() == nil && work.Err() == nil {
+		if block, ok := p.rewindOf(i); ok {
+			absorbed.rewind(block)
+			if block < window[0] {
+				window[0] = block
+			}
+		}
+		event, rewatch, err := absorbed.reorged(work, inst.Backend)
+		if err != nil {
+			log.Errorw("pipeline/watch: failed to check the absorbed blocks", "instance", id, "err", err)
+			sleep(ctx, inst.WaitTime)
+			continue
+		}
+		if event != nil {
+			// the states of the reorged blocks are rewound
+			// and watched again, else they are not checked again
+			if !p.rewind(work, i, event, rewatch) {
+				absorbed.rewind(event.BlockNumber)
+			}
+			continue
+		}
+
+		latest, err := inst.Backend.BlockNumber(work)
+		if err != nil {
+			log.Errorw("pipeline/watch: failed to get the latest block", "instance", id, "err", err)
+			p.in.Alerts.Raise(alert.New(alert.Warning, "observer", "adapter failure", err.Error(),
+				"instance", id))
+			sleep(ctx, inst.WaitTime)
+			continue
+		}
+		if latest < window[0] {
+			sleep(ctx, inst.WaitTime)
+			continue
+		}
+		if window[1] = window[0] + inst.WindowSize - 1; window[1] > latest {
+			window[1] = latest
+		}
+
+		states, err := inst.Watcher.WatchContext(work, inst.Observable, window)
+		if err != nil {
+			// the watcher raised the alert
+			// retry the same window
+			log.Errorw("pipeline/watch: failed to watch the window", "instance", id, "window", window, "err", err)
+			sleep(ctx, inst.WaitTime)
+			continue
+		}
+		log.Infow("pipeline/watch: watched window", "instance", id, "window", window, "states", len(states))
+
+		if len(states) > 0 {
+			// release the resolved quarantined states
+			// once the absorbed states are recorded
+			if p.in.Detector.Resolved() {
+				p.release(work, id)
+			}
+			p.absorb(id, window, states)
+			absorbed.push(states)
+		}
+		window[0] = window[1] + 1
+	}
+	return window[0]
+}
+
+// absorb absorbs the states of the window of the instance
+// and checks the buffer root against the returned root
+func (p *Pipeline) absorb(id string, window [2]uint64, states []watcher.State) {
+	defer p.absorbing.Unlock()
+	p.absorbing.Lock()
+
+	root, err := p.in.Detector.Absorb(states)
+	if err != nil {
+		// the detector raised the alert, the states
+		// before the failure are committed, skip over the window
+		log.Errorw("pipeline/watch: failed to absorb the window", "instance", id, "window", window, "err", err)
+		return
+	}
+	// every observed state has been stashed
+	// the buffer root must be the absorbed root
+	if root.Cmp(p.in.Buffer.Root()) != 0 {
+		p.in.Alerts.Raise(alert.New(alert.Critical, "observer", "buffer root mismatch",
+			fmt.Sprintf("got: %d expected: %d", root, p.in.Buffer.Root()),
+			"instance", id))
+	}
+	p.report(id)
+}
+
+// release releases the resolved quarantined states once
+// every absorbed state is recorded, absorptions are held off
+func (p *Pipeline) release(work context.Context, id string) {
+	defer p.absorbing.Unlock()
+	p.absorbing.Lock()
+	if !p.settle(work) {
+		return
+	}
+	if _, err := p.in.Detector.Release(); err != nil {
+		p.in.Alerts.Raise(alert.New(alert.Critical, "detector", "failed to release quarantined states", err.Error(),
+			"instance", id))
+	}
+}
+
+// settle returns once every absorbed state is recorded,
+// false if work is done before
+func (p *Pipeline) settle(work context.Context) bool {
+	drained := make(chan struct{})
+	select {
+	case p.settling <- drained:
+	case <-work.Done():
+		return false
+	}
+	select {
+	case <-drained:
+		return true
+	case <-work.Done():
+		return false
+	}
+}
+
+// report logs the buffer metrics and
+// alerts once the buffer is at capacity
+func (p *Pipeline) report(id string) {
+	meter, ok := p.in.Buffer.(internal.Meter)
+	if !ok {
+		return
+	}
+	m := meter.Metrics()
+	log.Debugw("pipeline/watch: buffer", "depth", m.Depth, "capacity", m.Capacity, "spilled", m.Spilled,
+		"lag", m.Lag, "stashed", m.Stashed, "sinked", m.Sinked, "dropped", m.Dropped)
+	if m.Capacity > 0 && m.Depth >= m.Capacity {
+		p.in.Alerts.Raise(alert.New(alert.Warning, "buffer", "buffer is at capacity",
+			fmt.Sprintf("depth: %d lag: %s dropped: %d", m.Depth, m.Lag, m.Dropped),
+			"instance", id))
+	}
+}
+
+// track marks the state indices of the
+// stashed states and saves the tracker
+func (p *Pipeline) track(states []watcher.State) {
+	if p.in.Tracker == nil || p.in.Hooks.Indices == nil {
+		return
+	}
+	for _, state := range states {
+		if r, ok := p.in.Hooks.Indices(state); ok {
+			p.in.Tracker.Mark(state.Scope(), r)
+		}
+	}
+	if p.in.TrackerDir == "" {
+		return
+	}
+	if err := p.in.Tracker.Save(p.in.TrackerDir); err != nil {
+		log.Errorw("pipeline/track: failed to save the tracker", "err", err)
+		p.in.Alerts.Raise(alert.New(alert.Warning, "tracker", "failed to save the state index tracker", err.Error()))
+	}
+}
+
+// instanceOf returns the index of the
+// instance of the scope, -1 if none
+func (p *Pipeline) instanceOf(scope []byte) int {
+	for i, inst := range p.in.Instances {
+		if bytes.Equal(inst.Scope(), scope) {
+			return i
+		}
+	}
+	return -1
+}
+
+// refetch re-watches the block range of the instance of the scope
+func (p *Pipeline) refetch(scope []byte, blocks [2]uint64) ([]watcher.State, error) {
+	i := p.instanceOf(scope)
+	if i < 0 {
+		return nil, pkgerrors.Errorf("no instance of scope %x", scope)
+	}
+	inst := p.in.Instances[i]
+	return inst.Watcher.WatchContext(p.work, inst.Observable, blocks)
+}
+
+// record records the sinked state
+// and acknowledges it to the buffer
+func (p *Pipeline) record(ss []byte) {
+	p.handle(ss)
+	// failures have been alerted
+	// the state is not sinked again
+	if ack, ok := p.in.Buffer.(acker); ok {
+		if err := ack.Ack(); err != nil {
+			log.Errorw("pipeline/record: failed to acknowledge the state", "err", err)
+		}
+	}
+}
+
+func (p *Pipeline) handle(ss []byte) {
+	state := p.in.Des(ss)
+	if state == nil {
+		p.in.Alerts.Raise(alert.New(alert.Critical, "observer", "failed to deserialize state", "invalid state encoding"))
+		return
+	}
+	var id string
+	if i := p.instanceOf(state.Scope()); i >= 0 {
+		id = p.in.Instances[i].ID()
+	}
+	rec, err := p.in.Recorder.Record(state)
+	if err != nil {
+		// the recorder raised the alert
+		log.Errorw("pipeline/record: failed to record the state", "instance", id, "err", err)
+		return
+	}
+	if rec == nil {
+		return
+	}
+	if p.in.Store != nil {
+		if err := p.in.Store.Put(rec); err != nil {
+			p.in.Alerts.Raise(alert.New(alert.Critical, "store", "failed to persist record", err.Error(),
+				"instance", id,
+				"record", hex.EncodeToString(rec.Hash())))
+			return
+		}
+	}
+	p.accumulate(id, rec)
+	log.Infow("pipeline/record: recorded state", "instance", id, "record", hex.EncodeToString(rec.Hash()))
+	if p.in.Hooks.OnRecord != nil {
+		p.in.Hooks.OnRecord(state, rec)
+	}
+}
+
+// accumulate appends the record to the accumulator, the records
+// of states replayed after a restart are accumulated already
+func (p *Pipeline) accumulate(id string, rec recorder.Record) {
+	if p.in.Accumulator == nil {
+		return
+	}
+	if _, ok := p.in.Accumulator.Index(rec.Hash()); ok {
+		log.Debugw("pipeline/record: record already accumulated", "instance", id, "record", hex.EncodeToString(rec.Hash()))
+		return
+	}
+	if _, err := p.in.Accumulator.Append(rec.Hash()); err != nil {
+		p.in.Alerts.Raise(alert.New(alert.Critical, "accumulator", "failed to accumulate record", err.Error(),
+			"instance", id,
+			"record", hex.EncodeToString(rec.Hash())))
+	}
+}
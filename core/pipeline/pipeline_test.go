@@ -0,0 +1,586 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/accumulator"
+	"github.com/0xBow-io/asp-go-buildkit/core/alert"
+	"github.com/0xBow-io/asp-go-buildkit/core/detector"
+	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+	"github.com/0xBow-io/asp-go-buildkit/core/store"
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/0xBow-io/asp-go-buildkit/internal"
+	"github.com/0xBow-io/asp-go-buildkit/internal/erpc"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/test-go/testify/require"
+)
+
+var testScope = common.BigToHash(big.NewInt(1)).Bytes()
+
+type testState struct {
+	H []byte `cbor:"hash"`
+	E []byte `cbor:"e"`
+	// defaults to testScope
+	S []byte `cbor:"scope,omitempty"`
+}
+
+func (s *testState) Event() *watcher.Event { return new(watcher.Event).Deserialize(s.E) }
+func (s *testState) Inner() []byte         { return nil }
+func (s *testState) Hash() []byte          { return s.H }
+func (s *testState) Clone() watcher.State  { c := *s; return &c }
+func (s *testState) Serialize() []byte     { out, _ := cbor.Marshal(s); return out }
+func (s *testState) Scope() []byte {
+	if s.S != nil {
+		return s.S
+	}
+	return testScope
+}
+func (s *testState) Deserialize(b []byte) watcher.State {
+	x := &testState{}
+	if err := cbor.Unmarshal(b, x); err != nil {
+		return nil
+	}
+	return x
+}
+func (s *testState) Cmp(x watcher.State) int {
+	if !bytes.Equal(s.Hash(), x.Hash()) {
+		return 1
+	}
+	return 0
+}
+
+// testChain is a chain of the states of an observable,
+// a block is identified by its number and fork
+type testChain struct {
+	erpc.Backend
+	lock   sync.Mutex
+	latest uint64
+	forks  map[uint64]byte
+	// state hashes by block
+	states map[uint64]int64
+}
+
+func (c *testChain) header(block uint64) *types.Header {
+	return &types.Header{Number: new(big.Int).SetUint64(block), Extra: []byte{c.forks[block]}}
+}
+
+// reorg replaces the blocks from the block on
+func (c *testChain) reorg(block, latest uint64, states map[uint64]int64) {
+	defer c.lock.Unlock()
+	c.lock.Lock()
+	for b := range c.states {
+		if b >= block {
+			delete(c.states, b)
+		}
+	}
+	for b := block; b <= latest; b++ {
+		c.forks[b]++
+	}
+	for b, h := range states {
+		c.states[b] = h
+	}
+	c.latest = latest
+}
+
+func (c *testChain) BlockNumber(context.Context) (uint64, error) {
+	defer c.lock.Unlock()
+	c.lock.Lock()
+	return c.latest, nil
+}
+
+func (c *testChain) HeaderByNumber(_ context.Context, number *big.Int) (*types.Header, error) {
+	defer c.lock.Unlock()
+	c.lock.Lock()
+	return c.header(number.Uint64()), nil
+}
+
+// testObservable plays the states of the chain,
+// the scope defaults to testScope
+type testObservable struct {
+	chain *testChain
+	scope []byte
+}
+
+func (o testObservable) ChainID() int                       { return 1 }
+func (o testObservable) Address() common.Address            { return common.Address{} }
+func (o testObservable) Deserialize(b []byte) watcher.State { return new(testState).Deserialize(b) }
+
+func (o testObservable) ID() string {
+	if o.scope != nil {
+		return common.Bytes2Hex(o.scope)
+	}
+	return "test"
+}
+
+func (o testObservable) Scope() []byte {
+	if o.scope != nil {
+		return o.scope
+	}
+	return testScope
+}
+
+func (o testObservable) Play(_ erpc.Backend, opts *bind.FilterOpts) (<-chan []byte, error) {
+	c := o.chain
+	defer c.lock.Unlock()
+	c.lock.Lock()
+	sink := make(chan []byte, len(c.states))
+	for b := opts.Start; b <= *opts.End; b++ {
+		if h, ok := c.states[b]; ok {
+			sink <- (&testState{
+				H: common.BigToHash(big.NewInt(h)).Bytes(),
+				E: (&watcher.Event{BlockNumber: b, BlockHash: c.header(b).Hash().Bytes()}).Serialize(),
+				S: o.scope,
+			}).Serialize()
+		}
+	}
+	close(sink)
+	return sink, nil
+}
+
+func Test_Pipeline_Reorg(t *testing.T) {
+	var (
+		chain = &testChain{
+			latest: 20,
+			forks:  make(map[uint64]byte),
+			states: map[uint64]int64{5: 1, 10: 2, 15: 3},
+		}
+		obs  = testObservable{chain: chain}
+		des  = new(testState).Deserialize
+		buff = internal.NewTxBuffer(internal.NewBuffer(big.NewInt(0)), des).Serialized()
+		rec  = recorder.NewService()
+
+		lock     sync.Mutex
+		recorded []recorder.Record
+	)
+	p, err := New(Params{
+		Config: Config{WindowSize: 100, WaitTime: 5 * time.Millisecond},
+		Instances: []Instance{{
+			Observable: obs,
+			Backend:    chain,
+			Watcher:    watcher.NewService(chain),
+			From:       1,
+		}},
+		Detector: detector.NewService(buff),
+		Recorder: rec,
+		Buffer:   buff,
+		Des:      des,
+		Hooks: Hooks{OnRecord: func(_ watcher.State, r recorder.Record) {
+			defer lock.Unlock()
+			lock.Lock()
+			recorded = append(recorded, r)
+		}},
+	})
+	require.NoError(t, err)
+	records := func(n int) []recorder.Record {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			lock.Lock()
+			out := append([]recorder.Record(nil), recorded...)
+			lock.Unlock()
+			if len(out) >= n {
+				return out
+			}
+		}
+		t.Fatalf("%d states were not recorded", n)
+		return nil
+	}
+	hash := func(h int64) []byte { return common.BigToHash(big.NewInt(h)).Bytes() }
+
+	require.NoError(t, p.Start(context.Background()))
+	// the first state is known, the
+	// second is the pre-state of the next
+	out := records(1)
+	require.Equal(t, hash(2), out[0].PreState())
+	require.Equal(t, hash(3), out[0].PostState())
+
+	// the block of the 3rd state is reorged,
+	// the state is replaced by the 4th one
+	chain.reorg(12, 25, map[uint64]int64{13: 4})
+	out = records(2)
+	require.Equal(t, hash(2), out[1].PreState())
+	require.Equal(t, hash(4), out[1].PostState())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, p.Stop(ctx))
+	require.Len(t, records(2), 2)
+}
+
+func Test_Pipeline_Reorg_Chains(t *testing.T) {
+	var (
+		// the blocks of the chains are far apart
+		sepolia = &testChain{
+			latest: 12,
+			forks:  make(map[uint64]byte),
+			states: map[uint64]int64{5: 1, 10: 2},
+		}
+		gnosis = &testChain{
+			latest: 1020,
+			forks:  make(map[uint64]byte),
+			states: map[uint64]int64{1000: 11, 1010: 12, 1020: 13},
+		}
+		other = common.BigToHash(big.NewInt(2)).Bytes()
+		des   = new(testState).Deserialize
+		buff  = internal.NewTxBuffer(internal.NewBuffer(big.NewInt(0)), des).Serialized()
+		det   = detector.NewService(buff)
+
+		lock     sync.Mutex
+		recorded = make(map[string][]recorder.Record)
+	)
+	p, err := New(Params{
+		Config: Config{WindowSize: 100, WaitTime: 5 * time.Millisecond},
+		Instances: []Instance{{
+			Observable: testObservable{chain: sepolia},
+			Chain:      "sepolia",
+			Backend:    sepolia,
+			Watcher:    watcher.NewService(sepolia),
+			From:       1,
+		}, {
+			Observable: testObservable{chain: gnosis, scope: other},
+			Chain:      "gnosis",
+			Backend:    gnosis,
+			Watcher:    watcher.NewService(gnosis),
+			From:       1000,
+		}},
+		Detector: det,
+		Recorder: recorder.NewService(),
+		Buffer:   buff,
+		Des:      des,
+		Hooks: Hooks{OnRecord: func(_ watcher.State, r recorder.Record) {
+			defer lock.Unlock()
+			lock.Lock()
+			key := common.Bytes2Hex(r.Scope())
+			recorded[key] = append(recorded[key], r)
+		}},
+	})
+	require.NoError(t, err)
+	records := func(scope []byte, n int) []recorder.Record {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			lock.Lock()
+			out := append([]recorder.Record(nil), recorded[common.Bytes2Hex(scope)]...)
+			lock.Unlock()
+			if len(out) >= n {
+				return out
+			}
+		}
+		t.Fatalf("%d states were not recorded", n)
+		return nil
+	}
+	hash := func(h int64) []byte { return common.BigToHash(big.NewInt(h)).Bytes() }
+
+	require.NoError(t, p.Start(context.Background()))
+	records(other, 1)
+
+	// the sepolia chain grows once the gnosis states are stashed
+	sepolia.reorg(13, 20, map[uint64]int64{15: 3})
+	records(testScope, 1)
+
+	// the block of the 3rd sepolia state is reorged,
+	// the gnosis states at later blocks are left alone
+	sepolia.reorg(12, 25, map[uint64]int64{13: 4})
+	out := records(testScope, 2)
+	require.Equal(t, hash(2), out[1].PreState())
+	require.Equal(t, hash(4), out[1].PostState())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, p.Stop(ctx))
+	require.Len(t, records(other, 1), 1)
+	stats := det.Stats()
+	require.Equal(t, uint64(1), stats[common.Bytes2Hex(testScope)].RolledBack)
+	require.Zero(t, stats[common.Bytes2Hex(other)].RolledBack)
+}
+
+type testAlerts struct{ raised []*alert.Alert }
+
+func (a *testAlerts) Raise(x *alert.Alert) { a.raised = append(a.raised, x) }
+
+func Test_Pipeline_Accumulate(t *testing.T) {
+	s, err := store.NewBoltStore(filepath.Join(t.TempDir(), "records.db"), nil)
+	require.NoError(t, err)
+	defer s.Close()
+
+	var (
+		prev []byte
+		recs []recorder.Record
+	)
+	for h := int64(1); h <= 3; h++ {
+		state := &testState{
+			H: common.BigToHash(big.NewInt(h + 1)).Bytes(),
+			E: (&watcher.Event{BlockNumber: uint64(h), TxHash: common.BigToHash(big.NewInt(h)).Bytes()}).Serialize(),
+		}
+		rec := recorder.NewRecord(state, common.BigToHash(big.NewInt(h)).Bytes(), prev)
+		require.NoError(t, s.Put(rec))
+		recs, prev = append(recs, rec), rec.Hash()
+	}
+
+	var (
+		acc, _ = accumulator.New(accumulator.DefaultDepth)
+		alerts = &testAlerts{}
+		buff   = internal.NewBuffer(big.NewInt(0))
+		params = Params{
+			Config:      Config{WindowSize: 1},
+			Instances:   []Instance{{Observable: testObservable{}}},
+			Detector:    detector.NewService(buff),
+			Recorder:    recorder.NewService(),
+			Buffer:      buff,
+			Des:         new(testState).Deserialize,
+			Alerts:      alerts,
+			Store:       s,
+			Accumulator: acc,
+		}
+	)
+	p, err := New(params)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), acc.Size())
+	root := acc.Root()
+
+	// rebuilding the accumulator skips the leaves it holds
+	_, err = New(params)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), acc.Size())
+
+	// the records of replayed states are not accumulated twice
+	p.accumulate("test", recs[2])
+	require.Empty(t, alerts.raised)
+	require.Equal(t, 0, root.Cmp(acc.Root()))
+}
+
+func Test_Pipeline_Accumulate_Order(t *testing.T) {
+	s, err := store.NewBoltStore(filepath.Join(t.TempDir(), "records.db"), nil)
+	require.NoError(t, err)
+	defer s.Close()
+
+	var (
+		other  = common.BigToHash(big.NewInt(2)).Bytes()
+		acc, _ = accumulator.New(accumulator.DefaultDepth)
+		buff   = internal.NewBuffer(big.NewInt(0))
+		params = Params{
+			Config:      Config{WindowSize: 1},
+			Instances:   []Instance{{Observable: testObservable{}}},
+			Detector:    detector.NewService(buff),
+			Recorder:    recorder.NewService(),
+			Buffer:      buff,
+			Des:         new(testState).Deserialize,
+			Alerts:      &testAlerts{},
+			Store:       s,
+			Accumulator: acc,
+		}
+	)
+	p, err := New(params)
+	require.NoError(t, err)
+
+	// the states of the scopes are recorded interleaved,
+	// those of the other scope at earlier blocks
+	for h := int64(1); h <= 3; h++ {
+		for _, scope := range [][]byte{testScope, other} {
+			block := uint64(100 + h)
+			if bytes.Equal(scope, other) {
+				block = uint64(h)
+			}
+			p.handle((&testState{
+				H: common.BigToHash(big.NewInt(h + int64(block))).Bytes(),
+				E: (&watcher.Event{BlockNumber: block, TxHash: common.BigToHash(big.NewInt(int64(block))).Bytes()}).Serialize(),
+				S: scope,
+			}).Serialize())
+		}
+	}
+	require.Equal(t, uint64(4), acc.Size())
+
+	// the rebuilt accumulator has the same root
+	params.Accumulator, _ = accumulator.New(accumulator.DefaultDepth)
+	_, err = New(params)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), params.Accumulator.Size())
+	require.Equal(t, 0, acc.Root().Cmp(params.Accumulator.Root()))
+}
+
+func Test_Pipeline_Bootstrap(t *testing.T) {
+	s, err := store.NewBoltStore(filepath.Join(t.TempDir(), "records.db"), nil)
+	require.NoError(t, err)
+	defer s.Close()
+
+	hash := func(h int64) []byte { return common.BigToHash(big.NewInt(h)).Bytes() }
+	var prev []byte
+	for h := int64(1); h <= 3; h++ {
+		state := &testState{H: hash(h + 1), E: (&watcher.Event{BlockNumber: uint64(h), TxHash: hash(h)}).Serialize()}
+		rec := recorder.NewRecord(state, hash(h), prev)
+		require.NoError(t, s.Put(rec))
+		prev = rec.Hash()
+	}
+
+	var (
+		alerts  = &testAlerts{}
+		buff    = internal.NewBuffer(big.NewInt(0))
+		checked []uint64
+		onChain []uint64
+		known   = true
+		failing error
+	)
+	p, err := New(Params{
+		Config:    Config{WindowSize: 1},
+		Instances: []Instance{{Observable: testObservable{}}},
+		Detector:  detector.NewService(buff),
+		Recorder:  recorder.NewService(),
+		Buffer:    buff,
+		Des:       new(testState).Deserialize,
+		Alerts:    alerts,
+		Store:     s,
+		Hooks: Hooks{
+			Checkpoint: func(_ context.Context, _ Instance, block uint64) ([]byte, error) {
+				onChain = append(onChain, block)
+				return hash(100), nil
+			},
+			IsCheckpoint: func(_ context.Context, _ Instance, _ []byte, block uint64) (bool, error) {
+				checked = append(checked, block)
+				return known, failing
+			},
+		},
+	})
+	require.NoError(t, err)
+	p.work = context.Background()
+
+	// the last record before the first watched block is the pre-state
+	p.from[0] = 3
+	pre, err := p.bootstrap(testScope)
+	require.NoError(t, err)
+	require.Equal(t, hash(3), pre)
+	require.Equal(t, []uint64{2}, checked)
+	require.Empty(t, alerts.raised)
+
+	p.from[0] = 10
+	pre, err = p.bootstrap(testScope)
+	require.NoError(t, err)
+	require.Equal(t, hash(4), pre)
+
+	// a stored pre-state unknown on-chain is alerted
+	known = false
+	_, err = p.bootstrap(testScope)
+	require.NoError(t, err)
+	require.Len(t, alerts.raised, 1)
+	require.Equal(t, "stored pre-state is not an on-chain checkpoint", alerts.raised[0].Title)
+
+	// as is a failure to verify it
+	failing = errors.New("unavailable")
+	_, err = p.bootstrap(testScope)
+	require.NoError(t, err)
+	require.Len(t, alerts.raised, 2)
+	require.Equal(t, "failed to verify the stored pre-state", alerts.raised[1].Title)
+
+	// with no record before the first watched
+	// block the pre-state is the on-chain checkpoint
+	p.from[0] = 1
+	pre, err = p.bootstrap(testScope)
+	require.NoError(t, err)
+	require.Equal(t, hash(100), pre)
+	require.Equal(t, []uint64{0}, onChain)
+	require.Len(t, checked, 4)
+}
+
+func Test_Pipeline_Track(t *testing.T) {
+	var (
+		des     = new(testState).Deserialize
+		buff    = internal.NewTxBuffer(internal.NewBuffer(big.NewInt(0)), des).Serialized()
+		tracker = internal.NewTracker()
+		dir     = t.TempDir()
+	)
+	p, err := New(Params{
+		Config:    Config{WindowSize: 1},
+		Instances: []Instance{{Observable: testObservable{}}},
+		Detector:  detector.NewService(buff),
+		Recorder:  recorder.NewService(),
+		Buffer:    buff,
+		Des:       des,
+		Hooks: Hooks{Indices: func(state watcher.State) (internal.Range, bool) {
+			i := new(big.Int).SetBytes(state.Hash()).Uint64()
+			return internal.Range{From: i, To: i + 1}, true
+		}},
+		Tracker:    tracker,
+		TrackerDir: dir,
+	})
+	require.NoError(t, err)
+	go buff.Sink(make(chan []byte, 3))
+
+	// the indices of every stashed state are marked,
+	// whether observed, refetched or released
+	var states []watcher.State
+	for h := int64(1); h <= 3; h++ {
+		states = append(states, &testState{
+			H: common.BigToHash(big.NewInt(h)).Bytes(),
+			E: (&watcher.Event{BlockNumber: uint64(h), BlockHash: common.BigToHash(big.NewInt(h)).Bytes()}).Serialize(),
+		})
+	}
+	_, err = p.in.Detector.Absorb(states)
+	require.NoError(t, err)
+	// the first state of the scope is known, not stashed
+	require.Equal(t, []internal.Range{{From: 0, To: 2}}, tracker.Missing(testScope, internal.Range{From: 0, To: 4}))
+
+	saved, err := internal.LoadTracker(dir)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), saved.Count(testScope))
+}
+
+func Test_Pipeline_Resume(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		path = filepath.Join(dir, "test.json")
+		root = filepath.Join(dir, "root.json")
+		buff = internal.NewBuffer(big.NewInt(0))
+	)
+	p, err := New(Params{
+		Config: Config{
+			WindowSize:     10,
+			WaitTime:       time.Second,
+			Checkpoint:     func(string) string { return path },
+			RootCheckpoint: root,
+		},
+		Instances: []Instance{
+			{Observable: testObservable{}, Start: 5},
+			{Observable: testObservable{}, WindowSize: 2},
+		},
+		Detector: detector.NewService(buff),
+		Recorder: recorder.NewService(),
+		Buffer:   buff,
+		Des:      new(testState).Deserialize,
+	})
+	require.NoError(t, err)
+	// the instances default to the window and wait of the config
+	require.Equal(t, uint64(10), p.in.Instances[0].WindowSize)
+	require.Equal(t, uint64(2), p.in.Instances[1].WindowSize)
+	require.Equal(t, time.Second, p.in.Instances[1].WaitTime)
+
+	next, cp, err := p.resume(p.in.Instances[0])
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), next)
+	require.Nil(t, cp)
+
+	// the root of a checkpoint preceding the root checkpoint is restored
+	require.NoError(t, internal.SaveCheckpoint(path, &internal.Checkpoint{Next: 50, Root: "7"}))
+	next, cp, err = p.resume(p.in.Instances[0])
+	require.NoError(t, err)
+	require.Equal(t, uint64(50), next)
+	r, err := p.root(cp)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), r.Int64())
+
+	// the root checkpoint prevails
+	require.NoError(t, internal.SaveCheckpoint(root, &internal.Checkpoint{Root: "9"}))
+	r, err = p.root(cp)
+	require.NoError(t, err)
+	require.Equal(t, int64(9), r.Int64())
+
+	// the pipeline is not started once the start context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.True(t, errors.Is(p.Start(ctx), context.Canceled))
+	require.Nil(t, p.halt)
+}
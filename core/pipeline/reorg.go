@@ -0,0 +1,138 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/alert"
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/0xBow-io/asp-go-buildkit/internal/erpc"
+)
+
+// DefaultReorgDepth is the number of the last blocks
+// of absorbed states checked for reorgs per instance
+const DefaultReorgDepth = 64
+
+// absorbed are the events of the last blocks
+// of absorbed states of an instance, in order
+type absorbed struct {
+	events []*watcher.Event
+	limit  int
+}
+
+// push keeps the first event of every new block of the states
+func (a *absorbed) push(states []watcher.State) {
+	for _, state := range states {
+		ev := state.Event()
+		if ev == nil {
+			continue
+		}
+		if n := len(a.events); n > 0 && a.events[n-1].BlockNumber >= ev.BlockNumber {
+			continue
+		}
+		a.events = append(a.events, ev)
+	}
+	if over := len(a.events) - a.limit; over > 0 {
+		a.events = append(a.events[:0], a.events[over:]...)
+	}
+}
+
+// rewind drops the events at or after the block
+func (a *absorbed) rewind(block uint64) {
+	for i, ev := range a.events {
+		if ev.BlockNumber >= block {
+			a.events = a.events[:i]
+			return
+		}
+	}
+}
+
+// reorged returns the earliest of the events whose block is no
+// longer canonical, nil if there is none, and the block following
+// the last canonical event from which the blocks are watched again
+func (a *absorbed) reorged(ctx context.Context, backend erpc.Backend) (*watcher.Event, uint64, error) {
+	var first *watcher.Event
+	for i := len(a.events) - 1; i >= 0; i-- {
+		ev := a.events[i]
+		header, err := backend.HeaderByNumber(ctx, new(big.Int).SetUint64(ev.BlockNumber))
+		if err != nil {
+			return nil, 0, err
+		}
+		if bytes.Equal(header.Hash().Bytes(), ev.BlockHash) {
+			if first != nil {
+				return first, ev.BlockNumber + 1, nil
+			}
+			return nil, 0, nil
+		}
+		first = ev
+	}
+	if first == nil {
+		return nil, 0, nil
+	}
+	return first, first.BlockNumber, nil
+}
+
+// rewind rolls back the states of the instances of the chain absorbed
+// at or after the block of the reorged event once every absorbed state
+// is recorded, the instance watches again from the block.
+// The instances of the other rolled back states watch again from
+// the block of their first rolled back state.
+// Returns false if the states were not rewound
+func (p *Pipeline) rewind(work context.Context, i int, event *watcher.Event, from uint64) bool {
+	defer p.absorbing.Unlock()
+	p.absorbing.Lock()
+	id := p.in.Instances[i].ID()
+	if !p.settle(work) {
+		return false
+	}
+	rolled, err := p.in.Detector.RewindTo(event, p.scopesOf(p.in.Instances[i].Chain))
+	if err != nil {
+		log.Errorw("pipeline/watch: failed to rewind the reorged states", "instance", id, "block", event.BlockNumber, "err", err)
+		p.in.Alerts.Raise(alert.New(alert.Critical, "observer", "failed to rewind the reorged states", err.Error(),
+			"instance", id))
+		return false
+	}
+	log.Warnw("pipeline/watch: rewound the reorged states", "instance", id, "block", event.BlockNumber, "states", len(rolled))
+
+	defer p.rewinding.Unlock()
+	p.rewinding.Lock()
+	p.rewound(i, from)
+	for _, state := range rolled {
+		if ev := state.Event(); ev != nil {
+			if j := p.instanceOf(state.Scope()); j >= 0 {
+				p.rewound(j, ev.BlockNumber)
+			}
+		}
+	}
+	return true
+}
+
+// scopesOf returns the scopes of the instances of the chain
+func (p *Pipeline) scopesOf(chain string) [][]byte {
+	var scopes [][]byte
+	for _, inst := range p.in.Instances {
+		if inst.Chain == chain {
+			scopes = append(scopes, inst.Scope())
+		}
+	}
+	return scopes
+}
+
+// rewound sets the block the instance watches
+// again from, the earliest block prevails
+func (p *Pipeline) rewound(i int, block uint64) {
+	if r := p.rewinds[i]; r == 0 || block < r {
+		p.rewinds[i] = block
+	}
+}
+
+// rewindOf returns and clears the block
+// the instance watches again from, if any
+func (p *Pipeline) rewindOf(i int) (uint64, bool) {
+	defer p.rewinding.Unlock()
+	p.rewinding.Lock()
+	block := p.rewinds[i]
+	p.rewinds[i] = 0
+	return block, block > 0
+}
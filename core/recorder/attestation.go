@@ -0,0 +1,48 @@
+package recorder
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Scheme is the signature scheme of an attestation
+type Scheme string
+
+const (
+	// BabyJubJub EdDSA over the Poseidon hash
+	BabyJubJub Scheme = "babyjubjub"
+	// ECDSA over secp256k1
+	Secp256k1 Scheme = "secp256k1"
+)
+
+// Signer signs record hashes
+// with the operator key
+type Signer interface {
+	Scheme() Scheme
+	// PublicKey returns the compressed public key
+	PublicKey() []byte
+	Sign(hash []byte) ([]byte, error)
+}
+
+// Attestation is the signature of a record hash
+// by the operator which produced the record
+type Attestation struct {
+	Scheme    Scheme `cbor:"scheme"`
+	PublicKey []byte `cbor:"publicKey"`
+	Signature []byte `cbor:"signature"`
+}
+
+// Attest signs the hash with the signer
+func Attest(signer Signer, hash []byte) (*Attestation, error) {
+	if hash == nil {
+		return nil, errors.New("record has no hash")
+	}
+	sig, err := signer.Sign(hash)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to sign record")
+	}
+	return &Attestation{
+		Scheme:    signer.Scheme(),
+		PublicKey: signer.PublicKey(),
+		Signature: sig,
+	}, nil
+}
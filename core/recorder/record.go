@@ -2,9 +2,12 @@ package recorder
 
 import (
 	"bytes"
+	"math/big"
+	"time"
 
 	posiedon "github.com/iden3/go-iden3-crypto/poseidon"
 
+	"github.com/0xBow-io/asp-go-buildkit/core/schema"
 	"github.com/0xBow-io/asp-go-buildkit/core/watcher"
 	"github.com/fxamacker/cbor/v2"
 )
@@ -19,6 +22,23 @@ type Record interface {
 	// Hashes of the pre and post states
 	PreState() []byte
 	PostState() []byte
+	// Serialized state transition
+	// details of the post state
+	Transition() []byte
+	// Hash of the previous
+	// record of the scope
+	Previous() []byte
+	// Time the record was
+	// recorded at (unix seconds)
+	Time() int64
+	// Verify returns true if the
+	// hash commits to the record
+	Verify() bool
+	// Attestation of the record hash
+	// nil if the record is unsigned
+	Attestation() *Attestation
+	// Attest signs the record hash
+	Attest(Signer) error
 	Serialize() []byte
 	Deserialize([]byte) Record
 }
@@ -29,12 +49,57 @@ type _Record struct {
 	E     []byte `cbor:"event"`
 	PreS  []byte `cbor:"prestate"`
 	PostS []byte `cbor:"poststate"`
+	T     []byte `cbor:"transition"`
+	Prev  []byte `cbor:"prev"`
+	// the time differs between the observers of
+	// the transition and is excluded from the hash
+	Ts int64 `cbor:"time,omitempty"`
+	// the attestation signs the hash
+	// and is excluded from it
+	A *Attestation `cbor:"attestation,omitempty"`
+}
+
+// TransitionFields are the protocol agnostic
+// details of a state transition
+type TransitionFields struct {
+	Src          []byte
+	Sink         []byte
+	FeeCollector []byte
+	Fee          *big.Int
+}
+
+// TransitionDecoder decodes the serialized
+// state transition details of a protocol
+// returns nil if the transition is invalid
+type TransitionDecoder func(transition []byte) *TransitionFields
+
+// RecordSchema decodes the serialized records,
+// the chain of stored records is read through it
+var RecordSchema = schema.NewRegistry[Record](schema.RecordKind, 1).
+	Register(1, func(b []byte) (Record, error) {
+		r := &_Record{}
+		return r, cbor.Unmarshal(b, r)
+	}).
+	Migrate(schema.Legacy, schema.Identity)
+
+// NewRecord returns the record of the transition
+// from the pre state hash to the post state
+// chained to the previous record of the scope
+func NewRecord(post watcher.State, pre []byte, prev []byte) Record {
+	return new(_Record).Build(post, pre, prev)
+}
+
+// DeserializeRecord returns the deserialized record
+// returns nil if deserialization fails
+func DeserializeRecord(data []byte) Record {
+	return new(_Record).Deserialize(data)
 }
 
 // Build returns a new record of the new state
 // with reference to the previous state hash
+// and the hash of the previous record (nil for the first record of a scope)
 // returns nil if the hashes of the pre and post states are the same
-func (*_Record) Build(post watcher.State, pre watcher.State) Record {
+func (*_Record) Build(post watcher.State, pre []byte, prev []byte) Record {
 	event := post.Event().Serialize()
 	if event == nil {
 		return nil
@@ -44,11 +109,18 @@ func (*_Record) Build(post watcher.State, pre watcher.State) Record {
 		PreS:  make([]byte, 32),
 		PostS: make([]byte, 32),
 		E:     make([]byte, len(event)),
+		T:     make([]byte, len(post.Inner())),
+		Ts:    time.Now().Unix(),
 	}
 	copy(r.Sc, post.Scope())
-	copy(r.PreS, pre.Hash())
+	copy(r.PreS, pre)
 	copy(r.PostS, post.Hash())
 	copy(r.E, event)
+	copy(r.T, post.Inner())
+	if prev != nil {
+		r.Prev = make([]byte, len(prev))
+		copy(r.Prev, prev)
+	}
 
 	// verify the hashes of the pre and post states
 	// are different
@@ -63,34 +135,60 @@ func (*_Record) Build(post watcher.State, pre watcher.State) Record {
 // From iden3.
 func (r *_Record) Hash() []byte {
 	if r.H == nil {
-		s := r.Serialize()
-		if s == nil {
-			return nil
-		}
-		if hash, err := posiedon.HashBytes(s); err == nil {
-			r.H = hash.Bytes()
-		} else {
-			return nil
-		}
+		r.H = r.hash()
 	}
 	return r.H
 }
+
+// hash hashes the unversioned payload of the record
+// with the hash, time and attestation fields cleared
+// so that the hash is kept across schema versions
+func (r *_Record) hash() []byte {
+	c := *r
+	c.H, c.Ts, c.A = nil, 0, nil
+	s := c.payload()
+	if s == nil {
+		return nil
+	}
+	if hash, err := posiedon.HashBytes(s); err == nil {
+		return hash.Bytes()
+	}
+	return nil
+}
+
+func (r *_Record) Verify() bool {
+	return r.H != nil && bytes.Equal(r.H, r.hash())
+}
 func (r *_Record) Scope() []byte         { return r.Sc }
 func (r *_Record) Event() *watcher.Event { return new(watcher.Event).Deserialize(r.E) }
 func (r *_Record) PreState() []byte      { return r.PreS }
 func (r *_Record) PostState() []byte     { return r.PostS }
-func (r *_Record) Serialize() []byte {
+func (r *_Record) Transition() []byte    { return r.T }
+func (r *_Record) Previous() []byte      { return r.Prev }
+func (r *_Record) Time() int64           { return r.Ts }
+
+func (r *_Record) Attestation() *Attestation { return r.A }
+
+func (r *_Record) Attest(signer Signer) error {
+	a, err := Attest(signer, r.Hash())
+	if err != nil {
+		return err
+	}
+	r.A = a
+	return nil
+}
+func (r *_Record) payload() []byte {
 	if out, err := cbor.Marshal(r); err == nil {
 		return out
 	}
 	return nil
 }
 
-func (r *_Record) Deserialize(data []byte) Record {
-	_r := &_Record{}
+func (r *_Record) Serialize() []byte { return RecordSchema.Encode(r.payload()) }
 
-	if err := cbor.Unmarshal(data, _r); err == nil {
-		return _r
+func (r *_Record) Deserialize(data []byte) Record {
+	if rec, _, err := RecordSchema.Decode(data); err == nil {
+		return rec
 	}
 	return nil
 }
@@ -1,9 +1,12 @@
 package recorder
 
 import (
+	"bytes"
+	"encoding/hex"
 	"errors"
 	"sync"
 
+	"github.com/0xBow-io/asp-go-buildkit/core/alert"
 	"github.com/0xBow-io/asp-go-buildkit/core/watcher"
 	logging "github.com/ipfs/go-log/v2"
 )
@@ -12,29 +15,169 @@ var (
 	log = logging.Logger("recorder")
 )
 
+// DefaultChainLimit is the number of recent
+// chain links kept per scope to rewind the chain
+const DefaultChainLimit = 1024
+
+// HeadLookup returns the hash of the latest
+// persisted record of the scope, nil if there is none
+type HeadLookup func(scope []byte) ([]byte, error)
+
+// link is a record of the chain
+// by the hash of its post state
+type link struct {
+	post []byte
+	hash []byte
+}
+
+// Bootstrap returns the hash of the state preceding
+// the first observed state of the scope, nil if unknown
+type Bootstrap func(scope []byte) ([]byte, error)
+
 type Service struct {
-	wg       *sync.WaitGroup
-	preState watcher.State
+	wg *sync.WaitGroup
+	// hash of the latest state per scope
+	preStates map[string][]byte
+	bootstrap Bootstrap
+	alerts    alert.Raiser
+	// recent links of the record chain per scope
+	chains map[string][]link
+	lookup HeadLookup
+	signer Signer
 }
 
 func NewService() *Service {
 	return &Service{
-		preState: nil,
-		wg:       new(sync.WaitGroup),
+		preStates: make(map[string][]byte),
+		wg:        new(sync.WaitGroup),
+		alerts:    alert.Nop,
+		chains:    make(map[string][]link),
+	}
+}
+
+// SetAlerter sets the raiser of the recorder alerts
+func (s *Service) SetAlerter(r alert.Raiser) { s.alerts = r }
+
+// SetSigner sets the operator key
+// which attests every record
+func (s *Service) SetSigner(signer Signer) { s.signer = signer }
+
+// SetBootstrap sets the source of the pre-state
+// of scopes which have not been recorded since start.
+// Without it the first state of a scope is not recorded
+func (s *Service) SetBootstrap(b Bootstrap) { s.bootstrap = b }
+
+// preState returns the hash of the latest state of the scope
+func (s *Service) preState(scope []byte) ([]byte, error) {
+	if pre, ok := s.preStates[hex.EncodeToString(scope)]; ok {
+		return pre, nil
+	}
+	if s.bootstrap == nil {
+		return nil, nil
+	}
+	pre, err := s.bootstrap(scope)
+	if err != nil {
+		return nil, err
+	}
+	if pre != nil {
+		log.Infow("recorder/Record: bootstrapped pre-state",
+			"scope", hex.EncodeToString(scope),
+			"prestate", hex.EncodeToString(pre))
 	}
+	return pre, nil
+}
+
+// SetHeadLookup sets the lookup of the chain head
+// of scopes which have not been recorded since start
+func (s *Service) SetHeadLookup(lookup HeadLookup) { s.lookup = lookup }
+
+// head returns the hash of the latest record of the scope
+func (s *Service) head(scope []byte) ([]byte, error) {
+	if chain := s.chains[hex.EncodeToString(scope)]; len(chain) > 0 {
+		return chain[len(chain)-1].hash, nil
+	}
+	if s.lookup == nil {
+		return nil, nil
+	}
+	return s.lookup(scope)
+}
+
+func (s *Service) link(rec Record) {
+	key := hex.EncodeToString(rec.Scope())
+	chain := append(s.chains[key], link{post: rec.PostState(), hash: rec.Hash()})
+	if over := len(chain) - DefaultChainLimit; over > 0 {
+		chain = append(chain[:0], chain[over:]...)
+	}
+	s.chains[key] = chain
 }
 
 func (s *Service) Record(postState watcher.State) (Record, error) {
-	var rec Record = nil
-	if s.preState != nil {
-		if rec := new(_Record).Build(postState, s.preState); rec == nil {
-			return nil, errors.New("failed to build a new record")
-		} else {
-			s.preState = postState.Clone()
-			return rec, nil
-		}
+	var (
+		scope = postState.Scope()
+		key   = hex.EncodeToString(scope)
+	)
+	pre, err := s.preState(scope)
+	if err != nil {
+		s.alerts.Raise(alert.New(alert.Critical, "recorder", "failed to bootstrap the pre-state", err.Error(),
+			"scope", key))
+		return nil, err
+	}
+	if pre == nil {
+		s.preStates[key] = clone(postState.Hash())
+		return nil, nil
 	}
 
-	s.preState = postState.Clone()
+	prev, err := s.head(scope)
+	if err != nil {
+		s.alerts.Raise(alert.New(alert.Critical, "recorder", "failed to look up the chain head", err.Error(),
+			"scope", key))
+		return nil, err
+	}
+	rec := new(_Record).Build(postState, pre, prev)
+	if rec == nil {
+		s.alerts.Raise(alert.New(alert.Critical, "recorder", "failed to build a new record", "record is nil",
+			"scope", key,
+			"prestate", hex.EncodeToString(pre),
+			"poststate", hex.EncodeToString(postState.Hash())))
+		return nil, errors.New("failed to build a new record")
+	}
+	if s.signer != nil {
+		if err := rec.Attest(s.signer); err != nil {
+			s.alerts.Raise(alert.New(alert.Critical, "recorder", "failed to attest the record", err.Error(),
+				"scope", key))
+			return nil, err
+		}
+	}
+	s.link(rec)
+	s.preStates[key] = clone(postState.Hash())
 	return rec, nil
 }
+
+func clone(b []byte) []byte { return append([]byte(nil), b...) }
+
+// Rewind sets the pre-state of the scope back to the given state
+// after states of the scope have been rolled back by the detector.
+// The chain of the scope is cut after the record of the given state.
+// A nil state bootstraps the pre-state again
+func (s *Service) Rewind(scope []byte, preState watcher.State) {
+	key := hex.EncodeToString(scope)
+	if preState == nil {
+		delete(s.preStates, key)
+		delete(s.chains, key)
+		return
+	}
+	s.preStates[key] = clone(preState.Hash())
+
+	chain := s.chains[key]
+	for len(chain) > 0 && !bytes.Equal(chain[len(chain)-1].post, preState.Hash()) {
+		chain = chain[:len(chain)-1]
+	}
+	if len(chain) == 0 {
+		// the head is beyond the kept links
+		// and is looked up again
+		log.Warnw("recorder/Rewind: chain head not found", "scope", key)
+		delete(s.chains, key)
+		return
+	}
+	s.chains[key] = chain
+}
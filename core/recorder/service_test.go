@@ -0,0 +1,83 @@
+package recorder
+
+import (
+	"math/big"
+	"testing"
+
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/test-go/testify/require"
+)
+
+type testState struct{ sc, h []byte }
+
+func newTestState(scope, hash int64) *testState {
+	return &testState{
+		sc: common.BigToHash(big.NewInt(scope)).Bytes(),
+		h:  common.BigToHash(big.NewInt(hash)).Bytes(),
+	}
+}
+
+func (s *testState) Event() *watcher.Event              { return &watcher.Event{BlockNumber: 1} }
+func (s *testState) Scope() []byte                      { return s.sc }
+func (s *testState) Inner() []byte                      { return nil }
+func (s *testState) Hash() []byte                       { return s.h }
+func (s *testState) Clone() watcher.State               { c := *s; return &c }
+func (s *testState) Serialize() []byte                  { return nil }
+func (s *testState) Deserialize(b []byte) watcher.State { return nil }
+func (s *testState) Cmp(x watcher.State) int            { return 1 }
+
+func Test_Record_Bootstrap(t *testing.T) {
+	svc := NewService()
+	checkpoint := newTestState(1, 100).Hash()
+	svc.SetBootstrap(func(scope []byte) ([]byte, error) {
+		if new(big.Int).SetBytes(scope).Int64() == 1 {
+			return checkpoint, nil
+		}
+		return nil, nil
+	})
+
+	// the first state of a bootstrapped scope is recorded
+	rec, err := svc.Record(newTestState(1, 1))
+	require.NoError(t, err)
+	require.NotNil(t, rec)
+	require.Equal(t, checkpoint, rec.PreState())
+	require.Nil(t, rec.Previous())
+
+	next, err := svc.Record(newTestState(1, 2))
+	require.NoError(t, err)
+	require.Equal(t, rec.PostState(), next.PreState())
+	require.Equal(t, rec.Hash(), next.Previous())
+
+	// scopes are chained independently
+	rec, err = svc.Record(newTestState(2, 1))
+	require.NoError(t, err)
+	require.Nil(t, rec)
+	rec, err = svc.Record(newTestState(2, 2))
+	require.NoError(t, err)
+	require.Equal(t, newTestState(2, 1).Hash(), rec.PreState())
+	require.Nil(t, rec.Previous())
+
+	// rewinding re-links the chain to the restored state
+	svc.Rewind(newTestState(1, 0).Scope(), newTestState(1, 1))
+	again, err := svc.Record(newTestState(1, 3))
+	require.NoError(t, err)
+	require.Equal(t, newTestState(1, 1).Hash(), again.PreState())
+	require.Equal(t, next.Previous(), again.Previous())
+}
+
+func Test_Record_Hash(t *testing.T) {
+	var (
+		rec   = NewRecord(newTestState(1, 2), newTestState(1, 1).Hash(), nil)
+		other = NewRecord(newTestState(1, 2), newTestState(1, 1).Hash(), nil).(*_Record)
+	)
+	// records of the same transition made
+	// at different times share their hash
+	other.Ts++
+	require.Equal(t, rec.Hash(), other.Hash())
+	require.True(t, other.Verify())
+
+	got := DeserializeRecord(other.Serialize())
+	require.Equal(t, other.Time(), got.Time())
+	require.Equal(t, rec.Hash(), got.Hash())
+}
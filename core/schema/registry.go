@@ -0,0 +1,134 @@
+package schema
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Migration upgrades a payload from
+// its schema version to the next one
+type Migration func(payload []byte) ([]byte, error)
+
+// Identity is the migration of a version whose
+// payload is unchanged in the next version
+var Identity Migration = func(payload []byte) ([]byte, error) { return payload, nil }
+
+// Registry holds the decoders of every
+// schema version of a kind and the
+// migrations between consecutive versions.
+// The payloads serialized before the envelope
+// was introduced are those of the first version,
+// the registries migrate Legacy to it with Identity
+type Registry[T any] struct {
+	kind       Kind
+	current    Version
+	decoders   map[Version]func([]byte) (T, error)
+	migrations map[Version]Migration
+}
+
+// NewRegistry returns the registry of the kind
+// payloads are encoded at the current version
+func NewRegistry[T any](kind Kind, current Version) *Registry[T] {
+	return &Registry[T]{
+		kind:       kind,
+		current:    current,
+		decoders:   make(map[Version]func([]byte) (T, error)),
+		migrations: make(map[Version]Migration),
+	}
+}
+
+func (r *Registry[T]) Kind() Kind       { return r.kind }
+func (r *Registry[T]) Current() Version { return r.current }
+
+// Register sets the decoder of the version
+func (r *Registry[T]) Register(version Version, decode func([]byte) (T, error)) *Registry[T] {
+	if version > r.current {
+		panic(fmt.Sprintf("schema: %s decoder of version %d is ahead of the current version %d", r.kind, version, r.current))
+	}
+	r.decoders[version] = decode
+	return r
+}
+
+// Migrate sets the migration from the version to the next
+func (r *Registry[T]) Migrate(from Version, m Migration) *Registry[T] {
+	if from >= r.current {
+		panic(fmt.Sprintf("schema: %s migration from version %d is not behind the current version %d", r.kind, from, r.current))
+	}
+	r.migrations[from] = m
+	return r
+}
+
+// Encode wraps the payload at the current version
+func (r *Registry[T]) Encode(payload []byte) []byte {
+	return Wrap(r.kind, r.current, payload)
+}
+
+// Decode decodes the data with the decoder of its version.
+// A version without a decoder is migrated to
+// the next version that has one
+func (r *Registry[T]) Decode(data []byte) (v T, version Version, err error) {
+	env, err := r.unwrap(data)
+	if err != nil {
+		return v, Legacy, err
+	}
+	version, payload := env.Version, env.Payload
+	for {
+		if decode, ok := r.decoders[version]; ok {
+			v, err = decode(payload)
+			return v, env.Version, err
+		}
+		if payload, err = r.step(version, payload); err != nil {
+			return v, env.Version, err
+		}
+		version++
+	}
+}
+
+// Upgrade migrates the data to the current version,
+// returns false if the data is already current
+func (r *Registry[T]) Upgrade(data []byte) ([]byte, bool, error) {
+	env, err := r.unwrap(data)
+	if err != nil {
+		return nil, false, err
+	}
+	if env.Version == r.current {
+		return data, false, nil
+	}
+	payload := env.Payload
+	for version := env.Version; version < r.current; version++ {
+		if payload, err = r.step(version, payload); err != nil {
+			return nil, false, err
+		}
+	}
+	if out := r.Encode(payload); out != nil {
+		return out, true, nil
+	}
+	return nil, false, errors.Errorf("failed to encode %s payload", r.kind)
+}
+
+func (r *Registry[T]) unwrap(data []byte) (*Envelope, error) {
+	env := Unwrap(data)
+	if env.Version != Legacy && env.Kind != r.kind {
+		return nil, errors.Wrapf(ErrKindMismatch, "expected %s got %s", r.kind, env.Kind)
+	}
+	if env.Version > r.current {
+		return nil, errors.Wrapf(ErrUnsupportedVersion, "%s version %d", r.kind, env.Version)
+	}
+	return env, nil
+}
+
+func (r *Registry[T]) step(version Version, payload []byte) ([]byte, error) {
+	if version >= r.current {
+		return nil, errors.Wrapf(ErrUnsupportedVersion, "%s version %d", r.kind, version)
+	}
+	m, ok := r.migrations[version]
+	if !ok {
+		return nil, errors.Wrapf(ErrNoMigration, "%s version %d", r.kind, version)
+	}
+	out, err := m(payload)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to migrate %s from version %d", r.kind, version)
+	}
+	return out, nil
+}
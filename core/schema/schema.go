@@ -0,0 +1,68 @@
+/*
+Versions the serialized types of the observer.
+
+Every serialized type is wrapped in an envelope
+tagging the payload with its kind and schema version.
+Blobs written before the envelope was introduced
+are read as the Legacy version of the payload.
+*/
+
+package schema
+
+import (
+	"github.com/fxamacker/cbor/v2"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrKindMismatch       = errors.New("envelope is of a different kind")
+	ErrUnsupportedVersion = errors.New("unsupported schema version")
+	ErrNoMigration        = errors.New("no migration from schema version")
+)
+
+// Kind identifies the serialized type
+type Kind string
+
+const (
+	EventKind      Kind = "event"
+	StateKind      Kind = "state"
+	TransitionKind Kind = "transition"
+	RecordKind     Kind = "record"
+)
+
+// Version is the schema version of a payload
+type Version uint16
+
+// Legacy is the version of payloads
+// serialized without an envelope
+const Legacy Version = 0
+
+// Envelope tags a payload with its kind and schema version
+type Envelope struct {
+	_       struct{} `cbor:",toarray"`
+	Kind    Kind
+	Version Version
+	Payload []byte
+}
+
+// Wrap returns the serialized envelope of the payload
+// returns nil if serialization fails
+func Wrap(kind Kind, version Version, payload []byte) []byte {
+	if payload == nil {
+		return nil
+	}
+	if out, err := cbor.Marshal(&Envelope{Kind: kind, Version: version, Payload: payload}); err == nil {
+		return out
+	}
+	return nil
+}
+
+// Unwrap returns the envelope of the data,
+// data that is not an envelope is a Legacy payload
+func Unwrap(data []byte) *Envelope {
+	env := &Envelope{}
+	if err := cbor.Unmarshal(data, env); err != nil || env.Kind == "" || env.Version == Legacy {
+		return &Envelope{Version: Legacy, Payload: data}
+	}
+	return env
+}
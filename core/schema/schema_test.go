@@ -0,0 +1,67 @@
+package schema
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/test-go/testify/require"
+)
+
+type v1 struct {
+	Name string `cbor:"name"`
+}
+
+type v2 struct {
+	First string `cbor:"first"`
+	Last  string `cbor:"last"`
+}
+
+func Test_Registry(t *testing.T) {
+	decode := func(b []byte) (*v2, error) {
+		v := &v2{}
+		return v, cbor.Unmarshal(b, v)
+	}
+	r := NewRegistry[*v2](StateKind, 2).
+		Register(2, decode).
+		Migrate(Legacy, Identity).
+		Migrate(1, func(b []byte) ([]byte, error) {
+			old := &v1{}
+			if err := cbor.Unmarshal(b, old); err != nil {
+				return nil, err
+			}
+			return cbor.Marshal(&v2{First: old.Name})
+		})
+
+	legacy, _ := cbor.Marshal(&v1{Name: "alice"})
+
+	// legacy payloads are migrated through every version
+	v, version, err := r.Decode(legacy)
+	require.NoError(t, err)
+	require.Equal(t, Legacy, version)
+	require.Equal(t, "alice", v.First)
+
+	up, changed, err := r.Upgrade(legacy)
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.Equal(t, Version(2), Unwrap(up).Version)
+
+	v, version, err = r.Decode(up)
+	require.NoError(t, err)
+	require.Equal(t, Version(2), version)
+	require.Equal(t, "alice", v.First)
+
+	_, changed, err = r.Upgrade(up)
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	// newer and foreign payloads are rejected
+	_, _, err = r.Decode(Wrap(StateKind, 3, legacy))
+	require.True(t, errors.Is(err, ErrUnsupportedVersion))
+	_, _, err = r.Decode(Wrap(EventKind, 2, legacy))
+	require.True(t, errors.Is(err, ErrKindMismatch))
+
+	// a version without a decoder or migration
+	_, _, err = NewRegistry[*v2](StateKind, 2).Register(2, decode).Decode(legacy)
+	require.True(t, errors.Is(err, ErrNoMigration))
+}
@@ -0,0 +1,345 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	recordsBucket = []byte("records")
+	scopeIndex    = []byte("idx:scope")
+	blockIndex    = []byte("idx:block")
+	txIndex       = []byte("idx:tx")
+	srcIndex      = []byte("idx:src")
+	sinkIndex     = []byte("idx:sink")
+	// the put order of the records and its reverse
+	orderIndex  = []byte("idx:order")
+	orderBucket = []byte("order")
+	metaBucket  = []byte("meta")
+
+	buckets = [][]byte{recordsBucket, scopeIndex, blockIndex, txIndex, srcIndex, sinkIndex, orderIndex, orderBucket, metaBucket}
+)
+
+var _ RecordStore = (*BoltStore)(nil)
+
+// BoltStore is a RecordStore backed by a bbolt database.
+// Index buckets map a prefixed position key to the record hash:
+//
+//	prefix | block number (8 bytes) | log index (4 bytes) | record hash
+//
+// so that a prefix scan yields the records in event order.
+// The order index maps the put sequence of the records to their hash.
+// bbolt allows concurrent readers alongside a single writer
+type BoltStore struct {
+	db     *bolt.DB
+	decode recorder.TransitionDecoder
+}
+
+// NewBoltStore opens or creates the database at the path.
+// decode extracts the Src and Sink addresses of the records,
+// records are not indexed by address if it is nil
+func NewBoltStore(path string, decode recorder.TransitionDecoder) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open record store")
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		if err := initSchema(tx); err != nil {
+			return err
+		}
+		return initOrder(tx)
+	}); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "failed to create record store buckets")
+	}
+	return &BoltStore{db: db, decode: decode}, nil
+}
+
+// position returns the event order suffix of the index keys
+func position(rec recorder.Record) ([]byte, error) {
+	event := rec.Event()
+	if event == nil {
+		return nil, errors.Wrap(ErrInvalidRecord, "record has no event")
+	}
+	pos := make([]byte, 12, 12+len(rec.Hash()))
+	binary.BigEndian.PutUint64(pos[:8], event.BlockNumber)
+	binary.BigEndian.PutUint32(pos[8:], uint32(event.LogIndex))
+	return append(pos, rec.Hash()...), nil
+}
+
+func indexKey(prefix, pos []byte) []byte {
+	return append(append(make([]byte, 0, len(prefix)+len(pos)), prefix...), pos...)
+}
+
+func (s *BoltStore) Put(rec recorder.Record) error {
+	hash := rec.Hash()
+	if hash == nil {
+		return errors.Wrap(ErrInvalidRecord, "record has no hash")
+	}
+	pos, err := position(rec)
+	if err != nil {
+		return err
+	}
+	data := rec.Serialize()
+	if data == nil {
+		return errors.Wrap(ErrInvalidRecord, "failed to serialize record")
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		records := tx.Bucket(recordsBucket)
+		if records.Get(hash) != nil {
+			return nil
+		}
+		if err := records.Put(hash, data); err != nil {
+			return errors.Wrap(err, "failed to put record")
+		}
+		if err := putOrder(tx, hash); err != nil {
+			return errors.Wrap(err, "failed to index record by order")
+		}
+		for name, key := range s.indexKeys(rec, pos) {
+			if err := tx.Bucket([]byte(name)).Put(key, hash); err != nil {
+				return errors.Wrapf(err, "failed to index record by %s", name)
+			}
+		}
+		return nil
+	})
+}
+
+// indexKeys returns the key of the record in every index
+func (s *BoltStore) indexKeys(rec recorder.Record, pos []byte) map[string][]byte {
+	prefixes := map[string][]byte{
+		string(scopeIndex): rec.Scope(),
+		string(txIndex):    rec.Event().TxHash,
+	}
+	if s.decode != nil {
+		if fields := s.decode(rec.Transition()); fields != nil {
+			prefixes[string(srcIndex)] = fields.Src
+			prefixes[string(sinkIndex)] = fields.Sink
+		}
+	}
+	keys := map[string][]byte{string(blockIndex): pos}
+	for name, prefix := range prefixes {
+		if len(prefix) > 0 {
+			keys[name] = indexKey(prefix, pos)
+		}
+	}
+	return keys
+}
+
+func (s *BoltStore) Delete(hash []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		rec, err := get(tx, hash)
+		if err != nil {
+			return err
+		}
+		pos, err := position(rec)
+		if err != nil {
+			return err
+		}
+		for name, key := range s.indexKeys(rec, pos) {
+			if err := tx.Bucket([]byte(name)).Delete(key); err != nil {
+				return errors.Wrapf(err, "failed to unindex record by %s", name)
+			}
+		}
+		if seq := tx.Bucket(orderBucket).Get(hash); seq != nil {
+			if err := tx.Bucket(orderIndex).Delete(seq); err != nil {
+				return errors.Wrap(err, "failed to unindex record by order")
+			}
+			if err := tx.Bucket(orderBucket).Delete(hash); err != nil {
+				return errors.Wrap(err, "failed to unindex record by order")
+			}
+		}
+		return tx.Bucket(recordsBucket).Delete(hash)
+	})
+}
+
+func (s *BoltStore) Scopes() (scopes [][]byte, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(scopeIndex).Cursor()
+		for k, hash := c.First(); k != nil; {
+			scope := append([]byte(nil), k[:len(k)-len(hash)-12]...)
+			scopes = append(scopes, scope)
+			// skip over the remaining records of the scope
+			next := increment(scope)
+			if next == nil {
+				break
+			}
+			k, hash = c.Seek(next)
+		}
+		return nil
+	})
+	return scopes, err
+}
+
+func (s *BoltStore) Last(scope []byte) (rec recorder.Record, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		var (
+			c       = tx.Bucket(scopeIndex).Cursor()
+			k, hash []byte
+		)
+		if next := increment(scope); next != nil {
+			k, hash = c.Seek(next)
+		}
+		if k == nil {
+			k, hash = c.Last()
+		} else {
+			k, hash = c.Prev()
+		}
+		if k == nil || !bytes.HasPrefix(k, scope) {
+			return ErrRecordNotFound
+		}
+		rec, err = get(tx, hash)
+		return err
+	})
+	return rec, err
+}
+
+// increment returns the smallest key greater than every
+// key of the prefix, nil if there is no such key
+func increment(prefix []byte) []byte {
+	out := append([]byte(nil), prefix...)
+	for i := len(out) - 1; i >= 0; i-- {
+		if out[i]++; out[i] != 0 {
+			return out[:i+1]
+		}
+	}
+	return nil
+}
+
+func (s *BoltStore) Get(hash []byte) (rec recorder.Record, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		rec, err = get(tx, hash)
+		return err
+	})
+	return rec, err
+}
+
+func (s *BoltStore) Has(hash []byte) (ok bool, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		ok = tx.Bucket(recordsBucket).Get(hash) != nil
+		return nil
+	})
+	return ok, err
+}
+
+func get(tx *bolt.Tx, hash []byte) (recorder.Record, error) {
+	data := tx.Bucket(recordsBucket).Get(hash)
+	if data == nil {
+		return nil, ErrRecordNotFound
+	}
+	rec := recorder.DeserializeRecord(data)
+	if rec == nil {
+		return nil, errors.Wrap(ErrInvalidRecord, "failed to deserialize record")
+	}
+	return rec, nil
+}
+
+// scan iterates over the index keys within [from, to)
+// a nil to iterates until the end of the prefix
+func (s *BoltStore) scan(index, prefix, from, to []byte, fn Iterator) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(index).Cursor()
+		for k, hash := c.Seek(from); k != nil && bytes.HasPrefix(k, prefix); k, hash = c.Next() {
+			if to != nil && bytes.Compare(k, to) >= 0 {
+				break
+			}
+			rec, err := get(tx, hash)
+			if err != nil {
+				return errors.Wrapf(err, "dangling index entry %x", k)
+			}
+			if !fn(rec) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) byPrefix(index, prefix []byte, fn Iterator) error {
+	if len(prefix) == 0 {
+		return nil
+	}
+	return s.scan(index, prefix, prefix, nil, fn)
+}
+
+func (s *BoltStore) ByScope(scope []byte, fn Iterator) error {
+	return s.byPrefix(scopeIndex, scope, fn)
+}
+
+func (s *BoltStore) ByTxHash(tx []byte, fn Iterator) error {
+	return s.byPrefix(txIndex, tx, fn)
+}
+
+func (s *BoltStore) BySrc(addr []byte, fn Iterator) error {
+	return s.byPrefix(srcIndex, addr, fn)
+}
+
+func (s *BoltStore) BySink(addr []byte, fn Iterator) error {
+	return s.byPrefix(sinkIndex, addr, fn)
+}
+
+func (s *BoltStore) ByBlockRange(from, to uint64, fn Iterator) error {
+	if from > to {
+		return nil
+	}
+	start := binary.BigEndian.AppendUint64(nil, from)
+	var end []byte
+	if to < ^uint64(0) {
+		end = binary.BigEndian.AppendUint64(nil, to+1)
+	}
+	return s.scan(blockIndex, nil, start, end, fn)
+}
+
+func (s *BoltStore) ByOrder(fn Iterator) error {
+	return s.scan(orderIndex, nil, nil, nil, fn)
+}
+
+// putOrder appends the hash to the order index
+func putOrder(tx *bolt.Tx, hash []byte) error {
+	index := tx.Bucket(orderIndex)
+	seq, err := index.NextSequence()
+	if err != nil {
+		return err
+	}
+	key := binary.BigEndian.AppendUint64(nil, seq)
+	if err := index.Put(key, hash); err != nil {
+		return err
+	}
+	return tx.Bucket(orderBucket).Put(hash, key)
+}
+
+// initOrder indexes the records of a store created
+// before their order was persisted in event order,
+// the order their accumulator was rebuilt in
+func initOrder(tx *bolt.Tx) error {
+	if k, _ := tx.Bucket(orderBucket).Cursor().First(); k != nil {
+		return nil
+	}
+	var n int
+	c := tx.Bucket(blockIndex).Cursor()
+	for k, hash := c.First(); k != nil; k, hash = c.Next() {
+		if err := putOrder(tx, hash); err != nil {
+			return errors.Wrap(err, "failed to index the records by order")
+		}
+		n++
+	}
+	if n > 0 {
+		log.Infow("store: indexed the records by order", "records", n)
+	}
+	return nil
+}
+
+func (s *BoltStore) Close() error {
+	log.Infow("store/Close: closing record store", "path", s.db.Path())
+	return s.db.Close()
+}
@@ -0,0 +1,222 @@
+package store
+
+import (
+	"bytes"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+	"github.com/0xBow-io/asp-go-buildkit/core/schema"
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/test-go/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+type testState struct {
+	Sc []byte `cbor:"scope"`
+	H  []byte `cbor:"hash"`
+	E  []byte `cbor:"e"`
+	S  []byte `cbor:"s"`
+}
+
+// newTestState returns a state of the transition
+// from src to sink emitted in the block by the tx
+// of which the fee is the hash
+func newTestState(scope, hash int64, block uint64, tx int64, src, sink byte) *testState {
+	return &testState{
+		Sc: common.BigToHash(big.NewInt(scope)).Bytes(),
+		H:  common.BigToHash(big.NewInt(hash)).Bytes(),
+		E: (&watcher.Event{
+			BlockNumber: block,
+			TxHash:      common.BigToHash(big.NewInt(tx)).Bytes(),
+			LogIndex:    uint(hash),
+		}).Serialize(),
+		S: append(append(bytes.Repeat([]byte{src}, 20), bytes.Repeat([]byte{sink}, 20)...), byte(hash)),
+	}
+}
+
+func (s *testState) Event() *watcher.Event              { return new(watcher.Event).Deserialize(s.E) }
+func (s *testState) Scope() []byte                      { return s.Sc }
+func (s *testState) Inner() []byte                      { return s.S }
+func (s *testState) Hash() []byte                       { return s.H }
+func (s *testState) Clone() watcher.State               { c := *s; return &c }
+func (s *testState) Serialize() []byte                  { out, _ := cbor.Marshal(s); return out }
+func (s *testState) Deserialize(b []byte) watcher.State { return nil }
+func (s *testState) Cmp(x watcher.State) int            { return 1 }
+
+func testDecoder(b []byte) *recorder.TransitionFields {
+	if len(b) != 41 {
+		return nil
+	}
+	return &recorder.TransitionFields{Src: b[:20], Sink: b[20:40], Fee: big.NewInt(int64(b[40]))}
+}
+
+func collect(t *testing.T, iter func(Iterator) error) []recorder.Record {
+	var out []recorder.Record
+	require.NoError(t, iter(func(r recorder.Record) bool {
+		out = append(out, r)
+		return true
+	}))
+	return out
+}
+
+func Test_BoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.db")
+	s, err := NewBoltStore(path, testDecoder)
+	require.NoError(t, err)
+
+	var (
+		states = []*testState{
+			newTestState(1, 1, 10, 1, 0xa, 0xb),
+			newTestState(1, 2, 11, 2, 0xb, 0xc),
+			newTestState(2, 3, 11, 2, 0xa, 0xc),
+			newTestState(2, 4, 12, 3, 0xc, 0xa),
+			newTestState(1, 5, 14, 4, 0xa, 0xb),
+		}
+		records []recorder.Record
+		heads   = map[string][]byte{}
+	)
+	for i := 1; i < len(states); i++ {
+		rec := recorder.NewRecord(states[i], states[i-1].Hash(), heads[string(states[i].Scope())])
+		require.NotNil(t, rec)
+		heads[string(rec.Scope())] = rec.Hash()
+		require.NoError(t, s.Put(rec))
+		// putting the record again is a no-op
+		require.NoError(t, s.Put(rec))
+		records = append(records, rec)
+	}
+
+	rec, err := s.Get(records[0].Hash())
+	require.NoError(t, err)
+	require.Equal(t, records[0].Serialize(), rec.Serialize())
+
+	_, err = s.Get([]byte("unknown"))
+	require.Equal(t, ErrRecordNotFound, err)
+
+	require.Len(t, collect(t, func(fn Iterator) error { return s.ByScope(states[0].Scope(), fn) }), 2)
+	require.Len(t, collect(t, func(fn Iterator) error { return s.ByBlockRange(11, 12, fn) }), 3)
+	require.Len(t, collect(t, func(fn Iterator) error { return s.ByTxHash(states[1].Event().TxHash, fn) }), 2)
+	require.Len(t, collect(t, func(fn Iterator) error { return s.BySrc(bytes.Repeat([]byte{0xa}, 20), fn) }), 2)
+	require.Len(t, collect(t, func(fn Iterator) error { return s.BySink(bytes.Repeat([]byte{0xc}, 20), fn) }), 2)
+
+	// records are iterated in event order
+	ordered := collect(t, func(fn Iterator) error { return s.ByBlockRange(0, ^uint64(0), fn) })
+	require.Len(t, ordered, len(records))
+	for i, rec := range ordered {
+		require.Equal(t, records[i].Hash(), rec.Hash())
+	}
+
+	// the records survive a restart
+	require.NoError(t, s.Close())
+	s, err = NewBoltStore(path, testDecoder)
+	require.NoError(t, err)
+	defer s.Close()
+	ok, err := s.Has(records[3].Hash())
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	scopes, err := s.Scopes()
+	require.NoError(t, err)
+	require.Len(t, scopes, 2)
+	last, err := s.Last(states[0].Scope())
+	require.NoError(t, err)
+	require.Equal(t, records[3].Hash(), last.Hash())
+}
+
+func Test_BoltStore_ByOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.db")
+	s, err := NewBoltStore(path, testDecoder)
+	require.NoError(t, err)
+
+	// the records are put out of event order
+	var records []recorder.Record
+	for _, state := range []*testState{
+		newTestState(1, 1, 20, 1, 0xa, 0xb),
+		newTestState(2, 2, 10, 2, 0xa, 0xb),
+		newTestState(1, 3, 21, 3, 0xa, 0xb),
+		newTestState(2, 4, 11, 4, 0xa, 0xb),
+	} {
+		rec := recorder.NewRecord(state, common.BigToHash(big.NewInt(0)).Bytes(), nil)
+		require.NoError(t, s.Put(rec))
+		require.NoError(t, s.Put(rec))
+		records = append(records, rec)
+	}
+	hashes := func() (out [][]byte) {
+		for _, rec := range collect(t, s.ByOrder) {
+			out = append(out, rec.Hash())
+		}
+		return out
+	}
+	require.Equal(t, [][]byte{records[0].Hash(), records[1].Hash(), records[2].Hash(), records[3].Hash()}, hashes())
+
+	// a record put again after its deletion comes last
+	require.NoError(t, s.Delete(records[1].Hash()))
+	require.NoError(t, s.Put(records[1]))
+	require.Equal(t, [][]byte{records[0].Hash(), records[2].Hash(), records[3].Hash(), records[1].Hash()}, hashes())
+
+	// the records of a store without
+	// their order are ordered by event
+	require.NoError(t, s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{orderIndex, orderBucket} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	require.NoError(t, s.Close())
+	s, err = NewBoltStore(path, testDecoder)
+	require.NoError(t, err)
+	defer s.Close()
+	require.Equal(t, [][]byte{records[1].Hash(), records[3].Hash(), records[0].Hash(), records[2].Hash()}, hashes())
+}
+
+func Test_Verify(t *testing.T) {
+	s, err := NewBoltStore(filepath.Join(t.TempDir(), "records.db"), testDecoder)
+	require.NoError(t, err)
+	defer s.Close()
+
+	var (
+		scope   = newTestState(1, 0, 0, 0, 0, 0).Scope()
+		records []recorder.Record
+		prev    []byte
+	)
+	for i := int64(1); i < 6; i++ {
+		rec := recorder.NewRecord(newTestState(1, i+1, uint64(i), i, 0xa, 0xb), newTestState(1, i, 0, 0, 0, 0).Hash(), prev)
+		require.NoError(t, s.Put(rec))
+		records, prev = append(records, rec), rec.Hash()
+	}
+
+	brk, err := Verify(s, scope)
+	require.NoError(t, err)
+	require.Nil(t, brk)
+
+	// a tampered record no longer matches its hash
+	var fields map[string]interface{}
+	require.NoError(t, cbor.Unmarshal(schema.Unwrap(records[3].Serialize()).Payload, &fields))
+	fields["poststate"] = make([]byte, 32)
+	data, err := cbor.Marshal(fields)
+	require.NoError(t, err)
+	require.NoError(t, s.Delete(records[3].Hash()))
+	require.NoError(t, s.Put(recorder.DeserializeRecord(data)))
+
+	brk, err = Verify(s, scope)
+	require.NoError(t, err)
+	require.NotNil(t, brk)
+	require.Equal(t, 3, brk.Index)
+
+	// a removed record breaks the chain at its successor
+	require.NoError(t, s.Delete(records[1].Hash()))
+	brk, err = Verify(s, scope)
+	require.NoError(t, err)
+	require.NotNil(t, brk)
+	require.Equal(t, 1, brk.Index)
+	require.Equal(t, records[2].Hash(), brk.Record)
+
+	breaks, err := VerifyAll(s)
+	require.NoError(t, err)
+	require.Len(t, breaks, 1)
+}
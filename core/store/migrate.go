@@ -0,0 +1,129 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+	"github.com/0xBow-io/asp-go-buildkit/core/schema"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var schemaKey = []byte("schema:record")
+
+var (
+	ErrSchemaAhead = errors.New("record store is of a newer schema version")
+	ErrHashChanged = errors.New("migration changed the record hash")
+)
+
+// DefaultMigrationBatch is the number of
+// records rewritten per transaction
+const DefaultMigrationBatch = 1000
+
+// initSchema sets the schema version of a new store
+// to the current one, a store created before the
+// version was persisted is of the Legacy version
+func initSchema(tx *bolt.Tx) error {
+	meta := tx.Bucket(metaBucket)
+	if meta.Get(schemaKey) == nil {
+		version := recorder.RecordSchema.Current()
+		if k, _ := tx.Bucket(recordsBucket).Cursor().First(); k != nil {
+			version = schema.Legacy
+		}
+		return putSchema(tx, version)
+	}
+	if version := getSchema(tx); version > recorder.RecordSchema.Current() {
+		return errors.Wrapf(ErrSchemaAhead, "store version %d, current version %d", version, recorder.RecordSchema.Current())
+	}
+	return nil
+}
+
+func getSchema(tx *bolt.Tx) schema.Version {
+	v := tx.Bucket(metaBucket).Get(schemaKey)
+	if len(v) != 2 {
+		return schema.Legacy
+	}
+	return schema.Version(binary.BigEndian.Uint16(v))
+}
+
+func putSchema(tx *bolt.Tx, version schema.Version) error {
+	v := make([]byte, 2)
+	binary.BigEndian.PutUint16(v, uint16(version))
+	return tx.Bucket(metaBucket).Put(schemaKey, v)
+}
+
+// SchemaVersion returns the record schema version of the store
+func (s *BoltStore) SchemaVersion() (version schema.Version, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		version = getSchema(tx)
+		return nil
+	})
+	return version, err
+}
+
+// Migrate rewrites the stored records to the current version
+// of the record schema, batch records per transaction.
+// Records are content addressed so a migration may change
+// the encoding of a record but not what its hash commits to,
+// a record whose hash changes aborts the migration.
+// An interrupted migration is resumed by migrating again.
+// Returns the number of rewritten records
+func (s *BoltStore) Migrate(ctx context.Context, batch int) (int, error) {
+	if batch <= 0 {
+		batch = DefaultMigrationBatch
+	}
+	var (
+		migrated int
+		after    []byte
+		done     bool
+	)
+	for !done {
+		if err := ctx.Err(); err != nil {
+			return migrated, err
+		}
+		if err := s.db.Update(func(tx *bolt.Tx) error {
+			var (
+				records = tx.Bucket(recordsBucket)
+				c       = records.Cursor()
+				k, v    []byte
+				updates = make(map[string][]byte)
+			)
+			if after == nil {
+				k, v = c.First()
+			} else if k, v = c.Seek(after); k != nil && bytes.Equal(k, after) {
+				k, v = c.Next()
+			}
+			for n := 0; k != nil && n < batch; k, v = c.Next() {
+				after = append(after[:0], k...)
+				n++
+				out, changed, err := recorder.RecordSchema.Upgrade(v)
+				if err != nil {
+					return errors.Wrapf(err, "failed to migrate record %x", k)
+				}
+				if !changed {
+					continue
+				}
+				if rec := recorder.DeserializeRecord(out); rec == nil || !bytes.Equal(rec.Hash(), k) || !rec.Verify() {
+					return errors.Wrapf(ErrHashChanged, "record %x", k)
+				}
+				updates[string(k)] = out
+			}
+			for k, v := range updates {
+				if err := records.Put([]byte(k), v); err != nil {
+					return errors.Wrap(err, "failed to put migrated record")
+				}
+			}
+			migrated += len(updates)
+			if done = k == nil; done {
+				return putSchema(tx, recorder.RecordSchema.Current())
+			}
+			return nil
+		}); err != nil {
+			return migrated, err
+		}
+	}
+	log.Infow("store/Migrate: record store migrated", "records", migrated, "version", recorder.RecordSchema.Current())
+	return migrated, nil
+}
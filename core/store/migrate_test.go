@@ -0,0 +1,74 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+	"github.com/0xBow-io/asp-go-buildkit/core/schema"
+	"github.com/test-go/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func Test_Migrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.db")
+	s, err := NewBoltStore(path, testDecoder)
+	require.NoError(t, err)
+
+	var prev []byte
+	for i := int64(1); i < 8; i++ {
+		rec := recorder.NewRecord(newTestState(1, i+1, uint64(i), i, 0xa, 0xb), newTestState(1, i, 0, 0, 0, 0).Hash(), prev)
+		require.NoError(t, s.Put(rec))
+		prev = rec.Hash()
+	}
+
+	// rewrite the store as written before the envelope
+	require.NoError(t, s.db.Update(func(tx *bolt.Tx) error {
+		records := tx.Bucket(recordsBucket)
+		legacy := make(map[string][]byte)
+		records.ForEach(func(k, v []byte) error {
+			legacy[string(k)] = schema.Unwrap(v).Payload
+			return nil
+		})
+		for k, v := range legacy {
+			records.Put([]byte(k), v)
+		}
+		return tx.Bucket(metaBucket).Delete(schemaKey)
+	}))
+	require.NoError(t, s.Close())
+
+	s, err = NewBoltStore(path, testDecoder)
+	require.NoError(t, err)
+	defer s.Close()
+	version, err := s.SchemaVersion()
+	require.NoError(t, err)
+	require.Equal(t, schema.Legacy, version)
+
+	// legacy records are readable before the migration
+	breaks, err := VerifyAll(s)
+	require.NoError(t, err)
+	require.Empty(t, breaks)
+
+	n, err := s.Migrate(context.Background(), 3)
+	require.NoError(t, err)
+	require.Equal(t, 7, n)
+	version, err = s.SchemaVersion()
+	require.NoError(t, err)
+	require.Equal(t, recorder.RecordSchema.Current(), version)
+
+	require.NoError(t, s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(recordsBucket).ForEach(func(k, v []byte) error {
+			require.Equal(t, recorder.RecordSchema.Current(), schema.Unwrap(v).Version)
+			return nil
+		})
+	}))
+	breaks, err = VerifyAll(s)
+	require.NoError(t, err)
+	require.Empty(t, breaks)
+
+	// migrating a current store is a no-op
+	n, err = s.Migrate(context.Background(), 0)
+	require.NoError(t, err)
+	require.Zero(t, n)
+}
@@ -0,0 +1,233 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"math/big"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	DefaultPageLimit = 100
+	MaxPageLimit     = 1000
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Filter selects records, empty fields match every record.
+// Block and time ranges are inclusive
+type Filter struct {
+	Scope hexutil.Bytes `json:"scope,omitempty"`
+
+	FromBlock uint64 `json:"fromBlock,omitempty"`
+	// zero is unbounded
+	ToBlock uint64 `json:"toBlock,omitempty"`
+
+	// unix seconds, zero is unbounded
+	FromTime int64 `json:"fromTime,omitempty"`
+	ToTime   int64 `json:"toTime,omitempty"`
+
+	TxHash       hexutil.Bytes `json:"txHash,omitempty"`
+	Src          hexutil.Bytes `json:"src,omitempty"`
+	Sink         hexutil.Bytes `json:"sink,omitempty"`
+	FeeCollector hexutil.Bytes `json:"feeCollector,omitempty"`
+
+	MinFee *big.Int `json:"minFee,omitempty"`
+	MaxFee *big.Int `json:"maxFee,omitempty"`
+}
+
+type Order uint8
+
+const (
+	// oldest events first
+	Ascending Order = iota
+	// latest events first
+	Descending
+)
+
+// Query is a page request of the filtered records
+type Query struct {
+	Filter
+	Order Order `json:"order"`
+	// maximum number of records of the page
+	// defaults to DefaultPageLimit
+	Limit int `json:"limit,omitempty"`
+	// Cursor is the Next cursor of the previous page
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// Page is a page of the query results
+type Page struct {
+	Records []recorder.Record
+	// Next is the cursor of the next page
+	// empty if there are no more records
+	Next string
+}
+
+// Querier searches the stored records
+type Querier interface {
+	Query(q *Query) (*Page, error)
+}
+
+var _ Querier = (*BoltStore)(nil)
+
+// match returns true if the record passes
+// the filters which are not served by the index
+func (s *BoltStore) match(f *Filter, rec recorder.Record) bool {
+	if len(f.Scope) > 0 && !bytes.Equal(rec.Scope(), f.Scope) {
+		return false
+	}
+	if f.FromTime != 0 && rec.Time() < f.FromTime {
+		return false
+	}
+	if f.ToTime != 0 && rec.Time() > f.ToTime {
+		return false
+	}
+	if len(f.TxHash) > 0 {
+		if ev := rec.Event(); ev == nil || !bytes.Equal(ev.TxHash, f.TxHash) {
+			return false
+		}
+	}
+	if len(f.Src) == 0 && len(f.Sink) == 0 && len(f.FeeCollector) == 0 &&
+		f.MinFee == nil && f.MaxFee == nil {
+		return true
+	}
+
+	if s.decode == nil {
+		return false
+	}
+	fields := s.decode(rec.Transition())
+	switch {
+	case fields == nil:
+		return false
+	case len(f.Src) > 0 && !bytes.Equal(fields.Src, f.Src):
+		return false
+	case len(f.Sink) > 0 && !bytes.Equal(fields.Sink, f.Sink):
+		return false
+	case len(f.FeeCollector) > 0 && !bytes.Equal(fields.FeeCollector, f.FeeCollector):
+		return false
+	case f.MinFee != nil && (fields.Fee == nil || fields.Fee.Cmp(f.MinFee) < 0):
+		return false
+	case f.MaxFee != nil && (fields.Fee == nil || fields.Fee.Cmp(f.MaxFee) > 0):
+		return false
+	}
+	return true
+}
+
+// plan picks the most selective index of the filter
+func (f *Filter) plan() (index, prefix []byte) {
+	switch {
+	case len(f.TxHash) > 0:
+		return txIndex, f.TxHash
+	case len(f.Src) > 0:
+		return srcIndex, f.Src
+	case len(f.Sink) > 0:
+		return sinkIndex, f.Sink
+	case len(f.Scope) > 0:
+		return scopeIndex, f.Scope
+	}
+	return blockIndex, nil
+}
+
+// Query returns a page of the records matching the filter
+// in the order of their events
+func (s *BoltStore) Query(q *Query) (*Page, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultPageLimit
+	} else if limit > MaxPageLimit {
+		limit = MaxPageLimit
+	}
+	if q.ToBlock != 0 && q.FromBlock > q.ToBlock {
+		return &Page{}, nil
+	}
+
+	var cursor []byte
+	if q.Cursor != "" {
+		var err error
+		if cursor, err = hex.DecodeString(q.Cursor); err != nil || len(cursor) <= 12 {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	index, prefix := q.plan()
+	// index keys within [lower, upper) are of the block range
+	lower := indexKey(prefix, binary.BigEndian.AppendUint64(nil, q.FromBlock))
+	upper := increment(prefix)
+	if q.ToBlock != 0 && q.ToBlock < ^uint64(0) {
+		upper = indexKey(prefix, binary.BigEndian.AppendUint64(nil, q.ToBlock+1))
+	}
+
+	page := &Page{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(index).Cursor()
+
+		var k, hash []byte
+		if q.Order == Descending {
+			from := upper
+			if cursor != nil {
+				from = indexKey(prefix, cursor)
+			}
+			if from != nil {
+				k, hash = c.Seek(from)
+			}
+			if k == nil {
+				k, hash = c.Last()
+			} else {
+				k, hash = c.Prev()
+			}
+		} else {
+			from := lower
+			if cursor != nil {
+				from = indexKey(prefix, cursor)
+			}
+			if k, hash = c.Seek(from); cursor != nil && bytes.Equal(k, from) {
+				k, hash = c.Next()
+			}
+		}
+
+		for ; k != nil; k, hash = s.step(c, q.Order) {
+			if !bytes.HasPrefix(k, prefix) ||
+				bytes.Compare(k, lower) < 0 ||
+				(upper != nil && bytes.Compare(k, upper) >= 0) {
+				// out of range in the direction of iteration
+				break
+			}
+			rec, err := get(tx, hash)
+			if err != nil {
+				return errors.Wrapf(err, "dangling index entry %x", k)
+			}
+			if !s.match(&q.Filter, rec) {
+				continue
+			}
+			if len(page.Records) == limit {
+				// there are more records
+				last := page.Records[len(page.Records)-1]
+				pos, err := position(last)
+				if err != nil {
+					return err
+				}
+				page.Next = hex.EncodeToString(pos)
+				return nil
+			}
+			page.Records = append(page.Records, rec)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (s *BoltStore) step(c *bolt.Cursor, order Order) ([]byte, []byte) {
+	if order == Descending {
+		return c.Prev()
+	}
+	return c.Next()
+}
@@ -0,0 +1,89 @@
+package store
+
+import (
+	"bytes"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+	"github.com/test-go/testify/require"
+)
+
+func Test_Query(t *testing.T) {
+	s, err := NewBoltStore(filepath.Join(t.TempDir(), "records.db"), testDecoder)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// records of two alternating scopes
+	// from 0xa to 0xb if the hash is even
+	var records []recorder.Record
+	for i := int64(1); i <= 20; i++ {
+		src, sink := byte(0xa), byte(0xb)
+		if i%2 == 1 {
+			src, sink = sink, src
+		}
+		rec := recorder.NewRecord(newTestState(i%2, i+1, uint64(i), i, src, sink), newTestState(i%2, i, 0, 0, 0, 0).Hash(), nil)
+		require.NoError(t, s.Put(rec))
+		records = append(records, rec)
+	}
+
+	hashes := func(p *Page) (out [][]byte) {
+		for _, r := range p.Records {
+			out = append(out, r.Hash())
+		}
+		return out
+	}
+
+	// paginate over every record in both orders
+	for _, order := range []Order{Ascending, Descending} {
+		var (
+			q   = &Query{Order: order, Limit: 6}
+			got [][]byte
+		)
+		for {
+			page, err := s.Query(q)
+			require.NoError(t, err)
+			got = append(got, hashes(page)...)
+			if page.Next == "" {
+				break
+			}
+			q.Cursor = page.Next
+		}
+		require.Len(t, got, len(records))
+		for i, hash := range got {
+			j := i
+			if order == Descending {
+				j = len(records) - 1 - i
+			}
+			require.Equal(t, records[j].Hash(), hash)
+		}
+	}
+
+	// filters are combined with the block range
+	page, err := s.Query(&Query{Filter: Filter{
+		Src:       bytes.Repeat([]byte{0xa}, 20),
+		FromBlock: 5,
+		ToBlock:   14,
+		MinFee:    big.NewInt(8),
+		MaxFee:    big.NewInt(13),
+	}})
+	require.NoError(t, err)
+	// even blocks 8, 10, 12 with fees 9, 11, 13
+	require.Equal(t, [][]byte{records[7].Hash(), records[9].Hash(), records[11].Hash()}, hashes(page))
+
+	page, err = s.Query(&Query{Filter: Filter{Scope: records[0].Scope(), ToBlock: 6}, Order: Descending, Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{records[4].Hash(), records[2].Hash()}, hashes(page))
+	page, err = s.Query(&Query{Filter: Filter{Scope: records[0].Scope(), ToBlock: 6}, Order: Descending, Cursor: page.Next})
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{records[0].Hash()}, hashes(page))
+	require.Empty(t, page.Next)
+
+	page, err = s.Query(&Query{Filter: Filter{TxHash: records[3].Event().TxHash}})
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{records[3].Hash()}, hashes(page))
+
+	_, err = s.Query(&Query{Cursor: "zz"})
+	require.Equal(t, ErrInvalidCursor, err)
+}
@@ -0,0 +1,66 @@
+/*
+Persists the records of the recorder and indexes them for lookups.
+*/
+
+package store
+
+import (
+	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/pkg/errors"
+)
+
+var (
+	log = logging.Logger("store")
+)
+
+var (
+	ErrRecordNotFound = errors.New("record not found")
+	ErrInvalidRecord  = errors.New("invalid record")
+)
+
+// Iterator is called for every record in order
+// of their events, returning false stops the iteration
+type Iterator func(recorder.Record) bool
+
+// RecordStore persists records by their hash.
+// Iterations are ordered by the block number
+// and log index of the record events, but
+// for ByOrder
+type RecordStore interface {
+	// Put persists the record and its indexes
+	// putting an existing record is a no-op
+	Put(rec recorder.Record) error
+	// Get returns the record of the hash
+	Get(hash []byte) (recorder.Record, error)
+	// Has returns true if the record of the hash exists
+	Has(hash []byte) (bool, error)
+	// Delete removes the record and its indexes
+	Delete(hash []byte) error
+
+	// Scopes returns the scopes of the stored records
+	Scopes() ([][]byte, error)
+	// Last returns the latest record of the scope
+	Last(scope []byte) (recorder.Record, error)
+
+	// ByScope iterates over the records of the scope
+	ByScope(scope []byte, fn Iterator) error
+	// ByBlockRange iterates over the records
+	// of events emitted within [from, to]
+	ByBlockRange(from, to uint64, fn Iterator) error
+	// ByTxHash iterates over the records of the transaction
+	ByTxHash(tx []byte, fn Iterator) error
+	// BySrc iterates over the records
+	// of transitions from the address
+	BySrc(addr []byte, fn Iterator) error
+	// BySink iterates over the records
+	// of transitions to the address
+	BySink(addr []byte, fn Iterator) error
+	// ByOrder iterates over the records in the order
+	// they were first put, the records of any scope
+	// and chain are accumulated in this order
+	ByOrder(fn Iterator) error
+
+	Querier
+	Close() error
+}
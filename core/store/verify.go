@@ -0,0 +1,80 @@
+package store
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+	"github.com/pkg/errors"
+)
+
+// Break is the first link of a
+// record chain which does not hold
+type Break struct {
+	Scope []byte
+	// position of the record within the chain
+	Index  int
+	Record []byte
+	Reason string
+}
+
+func (b *Break) String() string {
+	return fmt.Sprintf("scope %x: record %d (%x) %s", b.Scope, b.Index, b.Record, b.Reason)
+}
+
+// Verify walks the record chain of the scope in event order
+// and returns the first break, nil if the chain holds.
+// Every record must commit to its content and to the hash
+// of the record preceding it. The first record may only
+// refer to a record which is no longer stored
+// if the chain was started from a pruned store
+func Verify(s RecordStore, scope []byte) (brk *Break, err error) {
+	var (
+		prev  recorder.Record
+		index int
+	)
+	err = s.ByScope(scope, func(rec recorder.Record) bool {
+		defer func() { prev, index = rec, index+1 }()
+		switch {
+		case !rec.Verify():
+			brk = &Break{Reason: "hash does not commit to the record"}
+		case prev == nil && rec.Previous() != nil:
+			if ok, err := s.Has(rec.Previous()); err == nil && ok {
+				brk = &Break{Reason: fmt.Sprintf("previous record %x is out of order", rec.Previous())}
+			}
+		case prev != nil && !bytes.Equal(rec.Previous(), prev.Hash()):
+			brk = &Break{Reason: fmt.Sprintf("previous record is %x, expected %x", rec.Previous(), prev.Hash())}
+		}
+		if brk != nil {
+			brk.Scope, brk.Index, brk.Record = scope, index, rec.Hash()
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to walk the chain of scope %s", hex.EncodeToString(scope))
+	}
+	return brk, nil
+}
+
+// VerifyAll verifies the record chain of every stored scope
+// and returns the first break of each broken chain
+func VerifyAll(s RecordStore) ([]*Break, error) {
+	scopes, err := s.Scopes()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list scopes")
+	}
+	var breaks []*Break
+	for _, scope := range scopes {
+		brk, err := Verify(s, scope)
+		if err != nil {
+			return nil, err
+		}
+		if brk != nil {
+			log.Warnw("store/Verify: record chain is broken", "break", brk.String())
+			breaks = append(breaks, brk)
+		}
+	}
+	return breaks, nil
+}
@@ -0,0 +1,225 @@
+package watcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrMalformedTopics = errors.New("malformed log topics")
+	ErrNoTopics        = errors.New("event has no topics")
+	ErrUnknownEvent    = errors.New("no registered abi matches the event signature")
+)
+
+// DecodedEvent is the human readable form of an Event
+// decoded against a registered contract ABI
+type DecodedEvent struct {
+	Name        string                 `json:"name"`
+	Signature   string                 `json:"signature"`
+	Contract    string                 `json:"contract"`
+	BlockNumber uint64                 `json:"blockNumber"`
+	TxHash      string                 `json:"txHash"`
+	LogIndex    uint                   `json:"logIndex"`
+	Args        map[string]interface{} `json:"args"`
+}
+
+// Map returns the decoded event as a generic map
+func (d *DecodedEvent) Map() map[string]interface{} {
+	return map[string]interface{}{
+		"name":        d.Name,
+		"signature":   d.Signature,
+		"contract":    d.Contract,
+		"blockNumber": d.BlockNumber,
+		"txHash":      d.TxHash,
+		"logIndex":    d.LogIndex,
+		"args":        d.Args,
+	}
+}
+
+// JSON returns the json encoding of the decoded event
+func (d *DecodedEvent) JSON() ([]byte, error) {
+	return json.Marshal(d)
+}
+
+// Topics splits the concatenated log topics
+// into individual 32 byte topics
+func (e *Event) Topics() ([]common.Hash, error) {
+	if len(e.LogTopics)%common.HashLength != 0 {
+		return nil, ErrMalformedTopics
+	}
+	topics := make([]common.Hash, len(e.LogTopics)/common.HashLength)
+	for i := range topics {
+		topics[i] = common.BytesToHash(e.LogTopics[i*common.HashLength : (i+1)*common.HashLength])
+	}
+	return topics, nil
+}
+
+// EventDecoder decodes events by matching
+// the first topic of the event against
+// the event ids of the registered ABIs
+type EventDecoder struct {
+	events map[common.Hash]abi.Event
+	lock   *sync.RWMutex
+}
+
+func NewEventDecoder() *EventDecoder {
+	return &EventDecoder{
+		events: make(map[common.Hash]abi.Event),
+		lock:   &sync.RWMutex{},
+	}
+}
+
+// Register registers all non-anonymous events of the contract ABI
+func (d *EventDecoder) Register(contract *abi.ABI) *EventDecoder {
+	defer d.lock.Unlock()
+	d.lock.Lock()
+	for _, ev := range contract.Events {
+		if ev.Anonymous {
+			continue
+		}
+		d.events[ev.ID] = ev
+	}
+	return d
+}
+
+// RegisterJSON parses the json ABI definition
+// and registers its events
+func (d *EventDecoder) RegisterJSON(def string) error {
+	parsed, err := abi.JSON(strings.NewReader(def))
+	if err != nil {
+		return errors.Wrap(err, "failed to parse abi")
+	}
+	d.Register(&parsed)
+	return nil
+}
+
+// Decode decodes the indexed and non-indexed arguments
+// of the event into named, typed values
+func (d *EventDecoder) Decode(e *Event) (*DecodedEvent, error) {
+	topics, err := e.Topics()
+	if err != nil {
+		return nil, err
+	}
+	if len(topics) == 0 {
+		return nil, ErrNoTopics
+	}
+
+	d.lock.RLock()
+	ev, ok := d.events[topics[0]]
+	d.lock.RUnlock()
+	if !ok {
+		return nil, errors.Wrap(ErrUnknownEvent, topics[0].Hex())
+	}
+
+	decoded := &DecodedEvent{
+		Name:        ev.Name,
+		Signature:   ev.Sig,
+		Contract:    common.BytesToAddress(e.LogAddress).Hex(),
+		BlockNumber: e.BlockNumber,
+		TxHash:      common.BytesToHash(e.TxHash).Hex(),
+		LogIndex:    e.LogIndex,
+		Args:        make(map[string]interface{}, len(ev.Inputs)),
+	}
+
+	// indexed arguments are stored in the topics
+	// following the event signature
+	indexed := 0
+	for i, arg := range ev.Inputs {
+		if !arg.Indexed {
+			continue
+		}
+		indexed++
+		if indexed >= len(topics) {
+			return nil, errors.Wrap(ErrMalformedTopics, "missing indexed argument "+argName(arg, i))
+		}
+		// dynamic types are only available as their keccak hash
+		if isHashedTopic(arg.Type) {
+			decoded.Args[argName(arg, i)] = topics[indexed].Hex()
+			continue
+		}
+		out := make(map[string]interface{}, 1)
+		if err := abi.ParseTopicsIntoMap(out, abi.Arguments{arg}, topics[indexed:indexed+1]); err != nil {
+			return nil, errors.Wrap(err, "failed to parse indexed argument "+argName(arg, i))
+		}
+		decoded.Args[argName(arg, i)] = render(arg.Type, out[arg.Name])
+	}
+
+	values, err := ev.Inputs.UnpackValues(e.LogData)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unpack event data")
+	}
+	i := 0
+	for j, arg := range ev.Inputs {
+		if arg.Indexed {
+			continue
+		}
+		decoded.Args[argName(arg, j)] = render(arg.Type, values[i])
+		i++
+	}
+
+	return decoded, nil
+}
+
+func argName(arg abi.Argument, pos int) string {
+	if arg.Name == "" {
+		return fmt.Sprintf("arg%d", pos)
+	}
+	return arg.Name
+}
+
+func isHashedTopic(t abi.Type) bool {
+	switch t.T {
+	case abi.StringTy, abi.BytesTy, abi.SliceTy, abi.ArrayTy, abi.TupleTy:
+		return true
+	}
+	return false
+}
+
+// render converts an unpacked abi value into
+// a value which reads well once encoded as json
+func render(t abi.Type, v interface{}) interface{} {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	switch t.T {
+	case abi.TupleTy:
+		out := make(map[string]interface{}, len(t.TupleElems))
+		for i, elem := range t.TupleElems {
+			out[t.TupleRawNames[i]] = render(*elem, rv.Field(i).Interface())
+		}
+		return out
+	case abi.SliceTy, abi.ArrayTy:
+		out := make([]interface{}, rv.Len())
+		for i := range out {
+			out[i] = render(*t.Elem, rv.Index(i).Interface())
+		}
+		return out
+	case abi.AddressTy:
+		if addr, ok := v.(common.Address); ok {
+			return addr.Hex()
+		}
+	case abi.IntTy, abi.UintTy:
+		if b, ok := v.(*big.Int); ok {
+			return b.String()
+		}
+		return fmt.Sprintf("%d", v)
+	case abi.FixedBytesTy:
+		out := make([]byte, rv.Len())
+		reflect.Copy(reflect.ValueOf(out), rv)
+		return hexutil.Encode(out)
+	case abi.BytesTy:
+		return hexutil.Encode(rv.Bytes())
+	case abi.HashTy:
+		if hash, ok := v.(common.Hash); ok {
+			return hash.Hex()
+		}
+	}
+	return v
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 
+	"github.com/0xBow-io/asp-go-buildkit/core/schema"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/fxamacker/cbor/v2"
@@ -74,16 +75,24 @@ func (e *Event) FromLog(log *types.Log) *Event {
 	return e
 }
 
+// EventSchema decodes the serialized events
+// carried by the states and the records
+var EventSchema = schema.NewRegistry[*Event](schema.EventKind, 1).
+	Register(1, func(b []byte) (*Event, error) {
+		e := &Event{}
+		return e, cbor.Unmarshal(b, e)
+	}).
+	Migrate(schema.Legacy, schema.Identity)
+
 func (e *Event) Serialize() []byte {
 	if out, err := cbor.Marshal(e); err == nil {
-		return out
+		return EventSchema.Encode(out)
 	}
 	return nil
 }
 
 func (*Event) Deserialize(data []byte) *Event {
-	e := &Event{}
-	if err := cbor.Unmarshal(data, e); err == nil {
+	if e, _, err := EventSchema.Decode(data); err == nil {
 		return e
 	}
 	return nil
@@ -96,23 +105,25 @@ func (*Event) Deserialize(data []byte) *Event {
 func (e *Event) Cmp(x *Event) int {
 	if common.BytesToHash(e.BlockHash).Cmp(common.BytesToHash(x.BlockHash)) == 0 {
 		if common.BytesToHash(e.TxHash).Cmp(common.BytesToHash(x.TxHash)) == 0 {
-			logIndexDiff := e.LogIndex - x.LogIndex
-			if logIndexDiff > 0 {
-				return 1
-			} else if logIndexDiff < 0 {
-				return -1
-			}
-			return 0
+			return cmpUint(uint64(e.LogIndex), uint64(x.LogIndex))
 		}
-		txIndexDiff := e.TxIndex - x.TxIndex
-		if txIndexDiff > 0 {
-			return 1
+		if e.TxIndex == x.TxIndex {
+			return -1
 		}
+		return cmpUint(uint64(e.TxIndex), uint64(x.TxIndex))
+	}
+	if e.BlockNumber == x.BlockNumber {
 		return -1
 	}
-	blockNumberDiff := e.BlockNumber - x.BlockNumber
-	if blockNumberDiff > 0 {
+	return cmpUint(e.BlockNumber, x.BlockNumber)
+}
+
+// cmpUint returns 1 if x > e, -1 if x < e and 0 otherwise
+func cmpUint(e, x uint64) int {
+	if x > e {
 		return 1
+	} else if x < e {
+		return -1
 	}
-	return -1
+	return 0
 }
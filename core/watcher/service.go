@@ -2,7 +2,9 @@ package watcher
 
 import (
 	"context"
+	"fmt"
 
+	"github.com/0xBow-io/asp-go-buildkit/core/alert"
 	"github.com/0xBow-io/asp-go-buildkit/internal/erpc"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
@@ -25,6 +27,7 @@ type Observable interface {
 
 type Service struct {
 	adapter erpc.Backend
+	alerts  alert.Raiser
 }
 
 func NewService(
@@ -32,10 +35,20 @@ func NewService(
 ) *Service {
 	return &Service{
 		adapter: adapter,
+		alerts:  alert.Nop,
 	}
 }
 
+// SetAlerter sets the raiser of the watcher alerts
+func (s *Service) SetAlerter(r alert.Raiser) { s.alerts = r }
+
 func (s *Service) Watch(obs Observable, blockRange [2]uint64) ([]State, error) {
+	return s.WatchContext(context.Background(), obs, blockRange)
+}
+
+// WatchContext watches the block range of the observable,
+// the observation fails once the context is done
+func (s *Service) WatchContext(ctx context.Context, obs Observable, blockRange [2]uint64) ([]State, error) {
 	if blockRange[0] > blockRange[1] || blockRange[0] == 0 || blockRange[1] == 0 {
 		return nil, errors.New("invalid block range")
 	}
@@ -45,24 +58,30 @@ func (s *Service) Watch(obs Observable, blockRange [2]uint64) ([]State, error) {
 	)
 
 	stream, err := obs.Play(s.adapter, &bind.FilterOpts{
-		Context: context.Background(),
+		Context: ctx,
 		Start:   blockRange[0],
 		End:     &blockRange[1],
 	})
 	if err != nil {
+		s.alerts.Raise(alert.New(alert.Critical, "watcher", "failed to observe instance", err.Error(),
+			"instance", obs.ID(),
+			"blocks", fmt.Sprintf("%d-%d", blockRange[0], blockRange[1])))
 		return nil, errors.Wrap(err, "failed to observe the given instance")
 	}
 
 	for {
-		s, ok := <-stream
+		ss, ok := <-stream
 		if !ok {
 			log.Debugw("watcher/Watch: stream closed", "instance", obs.ID())
 			break
 		}
-		if s == nil {
+		if ss == nil {
+			s.alerts.Raise(alert.New(alert.Critical, "watcher", "received a nil state", "observable stream failed",
+				"instance", obs.ID(),
+				"blocks", fmt.Sprintf("%d-%d", blockRange[0], blockRange[1])))
 			return nil, errors.New("received a nil state from the observable instance")
 		}
-		states = append(states, obs.Deserialize(s))
+		states = append(states, obs.Deserialize(ss))
 	}
 	return states, nil
 }
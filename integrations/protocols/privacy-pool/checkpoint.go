@@ -0,0 +1,54 @@
+package privacypool
+
+import (
+	"context"
+	"math/big"
+
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/0xBow-io/asp-go-buildkit/internal/erpc"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+)
+
+// callOpts pins the call at the block, zero is the latest block
+func callOpts(ctx context.Context, block uint64) *bind.CallOpts {
+	opts := &bind.CallOpts{Context: ctx}
+	if block > 0 {
+		opts.BlockNumber = new(big.Int).SetUint64(block)
+	}
+	return opts
+}
+
+// LastCheckpoint returns the state root of the last
+// checkpoint of the pool at the block
+// returns nil if the pool has no checkpoint yet
+func LastCheckpoint(ctx context.Context, obs watcher.Observable, adapter erpc.Backend, block uint64) ([]byte, error) {
+	caller, err := NewPrivacyPoolCaller(obs.Address(), adapter)
+	if err != nil {
+		return nil, errors.Wrap(err, ErrorInstanceNotFound.Error())
+	}
+	cp, err := caller.GetLastCheckpoint(callOpts(ctx, block))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get last checkpoint")
+	}
+	if cp.Root == nil || cp.Root.Sign() == 0 {
+		return nil, nil
+	}
+	return common.BigToHash(cp.Root).Bytes(), nil
+}
+
+// IsCheckpoint returns true if the state root
+// is a checkpoint of the pool at the block
+func IsCheckpoint(ctx context.Context, obs watcher.Observable, adapter erpc.Backend, root []byte, block uint64) (bool, error) {
+	caller, err := NewPrivacyPoolCaller(obs.Address(), adapter)
+	if err != nil {
+		return false, errors.Wrap(err, ErrorInstanceNotFound.Error())
+	}
+	cp, err := caller.FetchCheckpointAtRoot(callOpts(ctx, block), new(big.Int).SetBytes(root))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to fetch checkpoint at root")
+	}
+	return cp.Found, nil
+}
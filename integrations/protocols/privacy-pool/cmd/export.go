@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/export"
+	"github.com/0xBow-io/asp-go-buildkit/core/store"
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	privacypool "github.com/0xBow-io/asp-go-buildkit/integrations/protocols/privacy-pool"
+	erpc "github.com/0xBow-io/asp-go-buildkit/internal/erpc"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/spf13/cobra"
+)
+
+var exportFlags struct {
+	kind, format, out  string
+	scope              string
+	fromBlock, toBlock uint64
+	batch              int
+	observable, rpc    string
+	window             uint64
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "export records or states to jsonl, csv or parquet",
+	Long: `streams the stored records or the replayed states of the scope within the block range.
+an interrupted export is resumed from the checkpoint file next to the output`,
+	Run: func(cmd *cobra.Command, args []string) {
+		f := exportFlags
+		kind, err := export.ParseKind(f.kind)
+		if err != nil {
+			fmt.Printf("%+v \n", err)
+			os.Exit(1)
+		}
+		format, err := export.ParseFormat(f.format)
+		if err != nil {
+			fmt.Printf("%+v \n", err)
+			os.Exit(1)
+		}
+		if f.out == "" {
+			f.out = fmt.Sprintf("%s.%s", kind, format)
+		}
+		var scope []byte
+		if f.scope != "" {
+			if scope, err = hexutil.Decode(f.scope); err != nil {
+				fmt.Printf("invalid scope %+v \n", f.scope)
+				os.Exit(1)
+			}
+		}
+
+		decoder, err := privacypool.NewEventDecoder()
+		if err != nil {
+			fmt.Printf("failed to create the event decoder %+v \n", err)
+			os.Exit(1)
+		}
+		var (
+			src  export.Source
+			spec = export.Spec{
+				Kind:      kind,
+				Format:    format,
+				Scope:     strings.ToLower(f.scope),
+				FromBlock: f.fromBlock,
+				ToBlock:   f.toBlock,
+			}
+			decoders = export.Decoders{
+				Event:      decoder,
+				Transition: privacypool.TransitionDecoderFunc,
+			}
+		)
+		switch kind {
+		case export.Records:
+			if storePath == "" {
+				fmt.Println("--store is required")
+				os.Exit(1)
+			}
+			s, err := store.NewBoltStore(storePath, privacypool.TransitionDecoderFunc)
+			if err != nil {
+				fmt.Printf("failed to open record store %+v \n", err)
+				os.Exit(1)
+			}
+			defer s.Close()
+			src = &export.RecordSource{
+				Store: s,
+				Filter: store.Filter{
+					Scope:     scope,
+					FromBlock: f.fromBlock,
+					ToBlock:   f.toBlock,
+				},
+				Batch:    f.batch,
+				Decoders: decoders,
+			}
+		case export.States:
+			observable := findObservable(f.observable)
+			if observable == nil {
+				fmt.Printf("observable %+v not found \n", f.observable)
+				os.Exit(1)
+			}
+			if f.fromBlock == 0 || f.toBlock < f.fromBlock {
+				fmt.Println("a block range is required to replay states")
+				os.Exit(1)
+			}
+			adapter, err := erpc.NewERPC(f.rpc)
+			if err != nil {
+				fmt.Printf("failed to create adapter %+v \n", err)
+				os.Exit(1)
+			}
+			w := watcher.NewService(adapter)
+			src = &export.StateSource{
+				Watch: func(blocks [2]uint64) ([]watcher.State, error) {
+					return w.Watch(observable, blocks)
+				},
+				Scope:     scope,
+				FromBlock: f.fromBlock,
+				ToBlock:   f.toBlock,
+				Window:    f.window,
+				Decoders:  decoders,
+			}
+		}
+
+		cp, err := export.Export(context.Background(), src, spec, f.out)
+		if err != nil {
+			fmt.Printf("export interrupted, run again to resume %+v \n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Exported %d %s to %s\n", cp.Rows, kind, f.out)
+	},
+}
+
+func init() {
+	flags := exportCmd.Flags()
+	flags.StringVar(&exportFlags.kind, "kind", string(export.Records), "what to export (records|states)")
+	flags.StringVar(&exportFlags.format, "format", string(export.JSONL), "output format (jsonl|csv|parquet)")
+	flags.StringVar(&exportFlags.out, "out", "", "output file, defaults to <kind>.<format>")
+	flags.StringVar(&exportFlags.scope, "scope", "", "hex encoded scope, every scope if empty")
+	flags.Uint64Var(&exportFlags.fromBlock, "from-block", 0, "first block of the range")
+	flags.Uint64Var(&exportFlags.toBlock, "to-block", 0, "last block of the range, unbounded records if zero")
+	flags.IntVar(&exportFlags.batch, "batch", store.MaxPageLimit, "records per checkpoint")
+	flags.StringVar(&exportFlags.observable, "observable", "", "observable of the replayed states")
+	flags.StringVar(&exportFlags.rpc, "rpc", "", "rpc endpoint of the replayed states")
+	flags.Uint64Var(&exportFlags.window, "window", 10000, "blocks per replayed window")
+	rootCmd.AddCommand(exportCmd)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/0xBow-io/asp-go-buildkit/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var gapsCmd = &cobra.Command{
+	Use:   "gaps [scope] [from] [to]",
+	Short: "list the state indices that were never observed",
+	Long:  `reads the state index bitmaps of --tracker-dir and lists the missing index ranges of the scope (or of every scope) up to the highest observed index or within [from, to)`,
+	Args: cobra.MatchAll(cobra.MaximumNArgs(3), func(cmd *cobra.Command, args []string) error {
+		if len(args) == 2 {
+			return fmt.Errorf("the range [from, to) requires both from and to")
+		}
+		return nil
+	}),
+	Run: func(cmd *cobra.Command, args []string) {
+		if trackerDir == "" {
+			fmt.Println("--tracker-dir is required")
+			cmd.Usage()
+			os.Exit(1)
+		}
+		tracker, err := internal.LoadTracker(trackerDir)
+		if err != nil {
+			fmt.Printf("failed to load the state index tracker %+v \n", err)
+			os.Exit(1)
+		}
+
+		scopes := tracker.Scopes()
+		if len(args) > 0 {
+			scope, err := hex.DecodeString(args[0])
+			if err != nil {
+				fmt.Printf("invalid scope %+v \n", args[0])
+				os.Exit(1)
+			}
+			scopes = [][]byte{scope}
+		}
+		var bounds *internal.Range
+		if len(args) == 3 {
+			from, err := strconv.ParseUint(args[1], 10, 64)
+			if err != nil {
+				fmt.Printf("invalid from %+v \n", args[1])
+				os.Exit(1)
+			}
+			to, err := strconv.ParseUint(args[2], 10, 64)
+			if err != nil {
+				fmt.Printf("invalid to %+v \n", args[2])
+				os.Exit(1)
+			}
+			if to <= from {
+				fmt.Printf("empty range [%d, %d) \n", from, to)
+				os.Exit(1)
+			}
+			bounds = &internal.Range{From: from, To: to}
+		}
+
+		missed := false
+		for _, scope := range scopes {
+			r := bounds
+			if r == nil {
+				max, ok := tracker.Max(scope)
+				if !ok {
+					continue
+				}
+				r = &internal.Range{From: 0, To: max + 1}
+			}
+			for _, gap := range tracker.Missing(scope, *r) {
+				missed = true
+				fmt.Printf("Missing --> scope: %x indices: %s (%d) \n", scope, gap, gap.Len())
+			}
+		}
+		if !missed {
+			fmt.Println("no missing state indices")
+			return
+		}
+		os.Exit(2)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(gapsCmd)
+}
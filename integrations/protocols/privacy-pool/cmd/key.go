@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/attest"
+	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+
+	"github.com/spf13/cobra"
+)
+
+// passphraseEnv is the environment variable
+// of the keystore passphrase
+const passphraseEnv = "OPERATOR_KEY_PASSPHRASE"
+
+var (
+	keystorePath string
+	keyScheme    string
+)
+
+func passphrase() string {
+	p, ok := os.LookupEnv(passphraseEnv)
+	if !ok {
+		fmt.Printf("%s is not set \n", passphraseEnv)
+		os.Exit(1)
+	}
+	return p
+}
+
+// loadKey loads the operator key of the keystore
+func loadKey() attest.Key {
+	key, err := attest.Load(keystorePath, passphrase())
+	if err != nil {
+		fmt.Printf("failed to load operator key %+v \n", err)
+		os.Exit(1)
+	}
+	return key
+}
+
+func saveKey(key attest.Key) {
+	if err := attest.Save(keystorePath, key, passphrase()); err != nil {
+		fmt.Printf("failed to save operator key %+v \n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Operator Key --> scheme: %s, public key: %s, keystore: %s\n",
+		key.Scheme(), hex.EncodeToString(key.PublicKey()), keystorePath)
+}
+
+var keyCmd = &cobra.Command{
+	Use:   "key",
+	Short: "manage the operator key which attests the records",
+	Long:  fmt.Sprintf("the keystore passphrase is read from %s", passphraseEnv),
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if keystorePath == "" {
+			fmt.Println("--keystore is required")
+			cmd.Usage()
+			os.Exit(1)
+		}
+	},
+}
+
+var keyGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "generate a new operator key",
+	Run: func(cmd *cobra.Command, args []string) {
+		key, err := attest.Generate(recorder.Scheme(keyScheme))
+		if err != nil {
+			fmt.Printf("failed to generate operator key %+v \n", err)
+			os.Exit(1)
+		}
+		saveKey(key)
+	},
+}
+
+var keyImportCmd = &cobra.Command{
+	Use:   "import [private key]",
+	Short: "import a hex encoded operator key",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		raw, err := hex.DecodeString(strings.TrimPrefix(args[0], "0x"))
+		if err != nil {
+			fmt.Println("invalid private key")
+			os.Exit(1)
+		}
+		key, err := attest.Import(recorder.Scheme(keyScheme), raw)
+		if err != nil {
+			fmt.Printf("failed to import operator key %+v \n", err)
+			os.Exit(1)
+		}
+		saveKey(key)
+	},
+}
+
+var keyShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "show the public key of the operator key",
+	Run: func(cmd *cobra.Command, args []string) {
+		key := loadKey()
+		fmt.Printf("Operator Key --> scheme: %s, public key: %s\n",
+			key.Scheme(), hex.EncodeToString(key.PublicKey()))
+	},
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&keystorePath, "keystore", "",
+		"keystore file of the operator key, records are not attested if empty")
+	keyCmd.PersistentFlags().StringVar(&keyScheme, "scheme", string(recorder.BabyJubJub),
+		"signature scheme of the operator key (babyjubjub|secp256k1)")
+
+	keyCmd.AddCommand(keyGenerateCmd, keyImportCmd, keyShowCmd)
+	rootCmd.AddCommand(keyCmd)
+}
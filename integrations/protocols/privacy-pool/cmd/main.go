@@ -4,20 +4,47 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
+	"github.com/0xBow-io/asp-go-buildkit/core/accumulator"
+	"github.com/0xBow-io/asp-go-buildkit/core/alert"
+	"github.com/0xBow-io/asp-go-buildkit/core/detector"
+	"github.com/0xBow-io/asp-go-buildkit/core/store"
 	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
 	privacypool "github.com/0xBow-io/asp-go-buildkit/integrations/protocols/privacy-pool"
 	. "github.com/0xBow-io/asp-go-buildkit/integrations/protocols/privacy-pool/cmd/srv"
 
+	"github.com/0xBow-io/asp-go-buildkit/internal"
 	erpc "github.com/0xBow-io/asp-go-buildkit/internal/erpc"
 
+	logging "github.com/ipfs/go-log/v2"
 	"github.com/spf13/cobra"
 )
 
 var observables = privacypool.Observables()
 
+// signals returns the context cancelled on the first
+// stopping signal and the one cancelled on the second
+func signals() (stop context.Context, abort context.Context) {
+	var (
+		sig               = make(chan os.Signal, 2)
+		stopCtx, stopFn   = context.WithCancel(context.Background())
+		abortCtx, abortFn = context.WithCancel(context.Background())
+	)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sig
+		fmt.Println("Stopping after the current window, signal again to abort")
+		stopFn()
+		<-sig
+		abortFn()
+	}()
+	return stopCtx, abortCtx
+}
+
 func findObservable(id string) watcher.Observable {
 	for _, o := range observables {
 		if o.ID() == id {
@@ -44,6 +71,10 @@ var rootCmd = &cobra.Command{
 			observable watcher.Observable
 			adapter    erpc.Backend
 			from       int64
+			decoder    *watcher.EventDecoder
+			alertCfg   alert.Config
+			alerts     *alert.Manager
+			opts       Options
 		)
 		if len(args) < 4 {
 			cmd.Usage()
@@ -71,12 +102,141 @@ var rootCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		Observe(context.Background(), observable, adapter, uint64(from), 10000,
+		decoder, err = privacypool.NewEventDecoder()
+		if err != nil {
+			fmt.Printf("failed to create event decoder %+v \n", err)
+			os.Exit(1)
+		}
+
+		alertCfg, err = alert.NewConfig()
+		if err != nil {
+			fmt.Printf("failed to read alert config %+v \n", err)
+			os.Exit(1)
+		}
+		alerts, err = alert.NewFromConfig(alertCfg)
+		if err != nil {
+			fmt.Printf("failed to create alert manager %+v \n", err)
+			os.Exit(1)
+		}
+		defer alerts.Close()
+
+		opts = Options{
+			Decoder: decoder,
+			Alerts:  alerts,
+			Rules:   privacypool.Rules(),
+		}
+		if quarantineDir != "" {
+			if opts.Policy, err = detector.ParseQuarantinePolicy(quarantinePolicy); err != nil {
+				fmt.Printf("invalid quarantine policy %+v \n", err)
+				os.Exit(1)
+			}
+			if opts.Quarantine, err = detector.NewFileQuarantine(quarantineDir); err != nil {
+				fmt.Printf("failed to open quarantine %+v \n", err)
+				os.Exit(1)
+			}
+		}
+
+		if storePath != "" {
+			if opts.Store, err = store.NewBoltStore(storePath, privacypool.TransitionDecoderFunc); err != nil {
+				fmt.Printf("failed to open record store %+v \n", err)
+				os.Exit(1)
+			}
+			defer opts.Store.Close()
+		}
+		if keystorePath != "" {
+			opts.Signer = loadKey()
+		}
+		if walDir != "" {
+			policy, err := internal.ParseSyncPolicy(walSync)
+			if err != nil {
+				fmt.Printf("invalid wal sync policy %+v \n", err)
+				os.Exit(1)
+			}
+			opts.WAL = &internal.WALOptions{Dir: walDir, Sync: policy}
+		} else if bufferCapacity > 0 {
+			policy, err := internal.ParseOverflowPolicy(bufferOverflow)
+			if err != nil {
+				fmt.Printf("invalid buffer overflow policy %+v \n", err)
+				os.Exit(1)
+			}
+			opts.Bounded = &internal.BoundedOptions{Capacity: bufferCapacity, Policy: policy}
+			if policy == internal.Spill {
+				if spillDir == "" {
+					fmt.Println("the spill overflow policy requires --spill-dir")
+					os.Exit(1)
+				}
+				opts.Bounded.Spill = &internal.WALOptions{Dir: spillDir}
+			}
+		}
+		if trackerDir != "" {
+			if opts.Tracker, err = internal.LoadTracker(trackerDir); err != nil {
+				fmt.Printf("failed to load the state index tracker %+v \n", err)
+				os.Exit(1)
+			}
+			opts.TrackerDir = trackerDir
+		}
+		if opts.Accumulator, err = accumulator.New(treeDepth); err != nil {
+			fmt.Printf("failed to create accumulator %+v \n", err)
+			os.Exit(1)
+		}
+
+		opts.Checkpoint = checkpointPath
+		// the windows and records are logged by the pipeline
+		logging.SetLogLevel("pipeline", "info")
+		stop, abort := signals()
+		opts.Abort = abort
+
+		if err = Observe(stop, observable, adapter, uint64(from), 10000,
 			5*time.Second,
-			privacypool.StateDeserializerFunc)
+			privacypool.StateDeserializerFunc,
+			opts); err != nil {
+			fmt.Printf("observer failure %+v \n", err)
+			os.Exit(1)
+		}
 	},
 }
 
+var (
+	quarantineDir    string
+	quarantinePolicy string
+	storePath        string
+	treeDepth        int
+	walDir           string
+	walSync          string
+	bufferCapacity   int
+	bufferOverflow   string
+	spillDir         string
+	trackerDir       string
+	checkpointPath   string
+)
+
+func init() {
+	// subcommands are matched before the positional arguments
+	rootCmd.Args = cobra.ArbitraryArgs
+	rootCmd.PersistentFlags().StringVar(&quarantineDir, "quarantine-dir", "",
+		"directory of the quarantined state transitions, disabled if empty")
+	rootCmd.Flags().StringVar(&quarantinePolicy, "quarantine-policy", "continue",
+		"how to carry on after a quarantined state transition (continue|pause)")
+	rootCmd.PersistentFlags().StringVar(&storePath, "store", "",
+		"path of the record store database, required by the record commands, the observer records nothing if empty")
+	rootCmd.PersistentFlags().IntVar(&treeDepth, "tree-depth", accumulator.DefaultDepth,
+		"depth of the record accumulator")
+	rootCmd.Flags().StringVar(&walDir, "wal-dir", "",
+		"directory of the buffer write-ahead log, the buffer is in-memory if empty")
+	rootCmd.Flags().StringVar(&walSync, "wal-sync", string(internal.SyncInterval),
+		"when the buffer write-ahead log is fsynced (always|interval|never)")
+	rootCmd.Flags().IntVar(&bufferCapacity, "buffer-capacity", 0,
+		fmt.Sprintf("capacity of the in-memory buffer (e.g. %d), unbuffered if 0", internal.DefaultCapacity))
+	rootCmd.Flags().StringVar(&bufferOverflow, "buffer-overflow", string(internal.Block),
+		"what a full buffer does with new states (block|drop-oldest|spill)")
+	rootCmd.Flags().StringVar(&spillDir, "spill-dir", "",
+		"directory of the states spilled by a full buffer, required by the spill policy")
+	rootCmd.PersistentFlags().StringVar(&trackerDir, "tracker-dir", "",
+		"directory of the observed state index bitmaps, disabled if empty")
+	rootCmd.Flags().StringVar(&checkpointPath, "checkpoint", "",
+		"path of the observation checkpoint, resumed from unless [from] names another block, disabled if empty")
+}
+
 func main() {
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
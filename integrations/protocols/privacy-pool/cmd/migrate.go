@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+	"github.com/0xBow-io/asp-go-buildkit/core/store"
+	privacypool "github.com/0xBow-io/asp-go-buildkit/integrations/protocols/privacy-pool"
+
+	"github.com/spf13/cobra"
+)
+
+var migrateBatch int
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "migrate the record store to the current schema version",
+	Long:  `rewrites the stored records of an older schema version to the current one, an interrupted migration is resumed by running it again`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if storePath == "" {
+			fmt.Println("--store is required")
+			cmd.Usage()
+			os.Exit(1)
+		}
+		s, err := store.NewBoltStore(storePath, privacypool.TransitionDecoderFunc)
+		if err != nil {
+			fmt.Printf("failed to open record store %+v \n", err)
+			os.Exit(1)
+		}
+		defer s.Close()
+
+		version, err := s.SchemaVersion()
+		if err != nil {
+			fmt.Printf("failed to read schema version %+v \n", err)
+			os.Exit(1)
+		}
+		if version == recorder.RecordSchema.Current() {
+			fmt.Printf("record store is at schema version %d \n", version)
+			return
+		}
+		n, err := s.Migrate(context.Background(), migrateBatch)
+		if err != nil {
+			fmt.Printf("failed to migrate record store after %d records %+v \n", n, err)
+			os.Exit(1)
+		}
+		fmt.Printf("migrated %d records from schema version %d to %d \n", n, version, recorder.RecordSchema.Current())
+	},
+}
+
+func init() {
+	migrateCmd.Flags().IntVar(&migrateBatch, "batch", store.DefaultMigrationBatch, "number of records rewritten per transaction")
+	rootCmd.AddCommand(migrateCmd)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/accumulator"
+	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+	"github.com/0xBow-io/asp-go-buildkit/core/store"
+	privacypool "github.com/0xBow-io/asp-go-buildkit/integrations/protocols/privacy-pool"
+
+	"github.com/spf13/cobra"
+)
+
+var proveCmd = &cobra.Command{
+	Use:   "prove [record]",
+	Short: "prove the inclusion of a record",
+	Long:  `accumulates the stored records and prints the inclusion proof of the record hash`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		hash, err := hex.DecodeString(args[0])
+		if err != nil {
+			fmt.Printf("invalid record hash %+v \n", args[0])
+			os.Exit(1)
+		}
+		if storePath == "" {
+			fmt.Println("--store is required")
+			cmd.Usage()
+			os.Exit(1)
+		}
+		s, err := store.NewBoltStore(storePath, privacypool.TransitionDecoderFunc)
+		if err != nil {
+			fmt.Printf("failed to open record store %+v \n", err)
+			os.Exit(1)
+		}
+		defer s.Close()
+
+		acc, err := accumulator.New(treeDepth)
+		if err != nil {
+			fmt.Printf("failed to create accumulator %+v \n", err)
+			os.Exit(1)
+		}
+		// the records are accumulated in the order the observer stored them
+		if err = s.ByOrder(func(rec recorder.Record) bool {
+			_, err = acc.Append(rec.Hash())
+			return err == nil
+		}); err != nil {
+			fmt.Printf("failed to accumulate records %+v \n", err)
+			os.Exit(1)
+		}
+
+		proof, err := acc.Prove(hash)
+		if err != nil {
+			fmt.Printf("failed to prove record %+v \n", err)
+			os.Exit(1)
+		}
+		if !proof.Verify(hash) {
+			fmt.Println("proof does not verify !")
+			os.Exit(1)
+		}
+		out, _ := json.MarshalIndent(proof, "", "  ")
+		fmt.Println(string(out))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(proveCmd)
+}
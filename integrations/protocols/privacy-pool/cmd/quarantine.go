@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/detector"
+	privacypool "github.com/0xBow-io/asp-go-buildkit/integrations/protocols/privacy-pool"
+
+	"github.com/spf13/cobra"
+)
+
+var quarantineCmd = &cobra.Command{
+	Use:   "quarantine",
+	Short: "inspect and resolve quarantined state transitions",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if quarantineDir == "" {
+			fmt.Println("--quarantine-dir is required")
+			cmd.Usage()
+			os.Exit(1)
+		}
+	},
+}
+
+// openQuarantine returns a detector
+// operating on the quarantine directory
+func openQuarantine() *detector.Service {
+	q, err := detector.NewFileQuarantine(quarantineDir)
+	if err != nil {
+		fmt.Printf("failed to open quarantine %+v \n", err)
+		os.Exit(1)
+	}
+	d := detector.NewService(nil)
+	if err := d.SetQuarantine(q, detector.ContinuePolicy, privacypool.StateDeserializerFunc); err != nil {
+		fmt.Printf("failed to open quarantine %+v \n", err)
+		os.Exit(1)
+	}
+	return d
+}
+
+func parseID(arg string) uint64 {
+	id, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		fmt.Printf("invalid quarantine id %+v \n", arg)
+		os.Exit(1)
+	}
+	return id
+}
+
+var quarantineListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the quarantined state transitions",
+	Run: func(cmd *cobra.Command, args []string) {
+		entries, err := openQuarantine().Quarantined()
+		if err != nil {
+			fmt.Printf("failed to list quarantine %+v \n", err)
+			os.Exit(1)
+		}
+		for _, e := range entries {
+			fmt.Printf("%d\t%s\t%s\t%s\t%s\n",
+				e.ID,
+				e.Status,
+				time.Unix(e.Time, 0).UTC().Format(time.RFC3339),
+				hex.EncodeToString(e.Scope),
+				e.Verdict)
+		}
+	},
+}
+
+var quarantineInspectCmd = &cobra.Command{
+	Use:   "inspect [id]",
+	Short: "inspect a quarantined state transition",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		e, err := openQuarantine().Inspect(parseID(args[0]))
+		if err != nil {
+			fmt.Printf("failed to inspect quarantine entry %+v \n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("ID: %d\nStatus: %s\nTime: %s\nScope: %s\nVerdict: %s\n",
+			e.ID,
+			e.Status,
+			time.Unix(e.Time, 0).UTC().Format(time.RFC3339),
+			hex.EncodeToString(e.Scope),
+			e.Verdict)
+
+		state := privacypool.StateDeserializerFunc(e.State)
+		if state == nil {
+			fmt.Println("failed to deserialize state !")
+			return
+		}
+		fmt.Printf("State: %s\n", hex.EncodeToString(state.Hash()))
+		event := state.Event()
+		if event == nil {
+			return
+		}
+		fmt.Printf("Event: %+v\n", event.Format())
+
+		decoder, err := privacypool.NewEventDecoder()
+		if err != nil {
+			return
+		}
+		if decoded, err := decoder.Decode(event); err == nil {
+			if out, err := decoded.JSON(); err == nil {
+				fmt.Printf("Decoded Event: %s\n", out)
+			}
+		}
+	},
+}
+
+// resolveCmd returns a command which
+// resolves a pending quarantined state transition
+func resolveCmd(use, short string, resolve func(*detector.Service, uint64) error) *cobra.Command {
+	return &cobra.Command{
+		Use:   use + " [id]",
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			id := parseID(args[0])
+			if err := resolve(openQuarantine(), id); err != nil {
+				fmt.Printf("failed to %s quarantine entry %+v \n", use, err)
+				os.Exit(1)
+			}
+			fmt.Printf("quarantine entry %d %sd, it is released on the next absorption\n", id, use)
+		},
+	}
+}
+
+func init() {
+	quarantineCmd.AddCommand(
+		quarantineListCmd,
+		quarantineInspectCmd,
+		resolveCmd("approve", "approve a quarantined state transition", (*detector.Service).Approve),
+		resolveCmd("reject", "reject a quarantined state transition", (*detector.Service).Reject),
+	)
+	rootCmd.AddCommand(quarantineCmd)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/0xBow-io/asp-go-buildkit/builder/observer"
+	"github.com/0xBow-io/asp-go-buildkit/core/store"
+	privacypool "github.com/0xBow-io/asp-go-buildkit/integrations/protocols/privacy-pool"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/spf13/cobra"
+)
+
+var queryFlags struct {
+	scope, tx, src, sink, feeCollector string
+	minFee, maxFee                     string
+	fromBlock, toBlock                 uint64
+	fromTime, toTime                   int64
+	desc                               bool
+	limit                              int
+	cursor                             string
+}
+
+var queryCmd = &cobra.Command{
+	Use:   "query",
+	Short: "search the stored records",
+	Run: func(cmd *cobra.Command, args []string) {
+		if storePath == "" {
+			fmt.Println("--store is required")
+			cmd.Usage()
+			os.Exit(1)
+		}
+		q, err := parseQuery()
+		if err != nil {
+			fmt.Printf("invalid query %+v \n", err)
+			os.Exit(1)
+		}
+
+		s, err := store.NewBoltStore(storePath, privacypool.TransitionDecoderFunc)
+		if err != nil {
+			fmt.Printf("failed to open record store %+v \n", err)
+			os.Exit(1)
+		}
+		defer s.Close()
+
+		decoder, err := privacypool.NewEventDecoder()
+		if err != nil {
+			fmt.Printf("failed to create the event decoder %+v \n", err)
+			os.Exit(1)
+		}
+		page, err := observer.NewRecordsAPI(s, decoder, privacypool.TransitionDetailsFunc).Query(q)
+		if err != nil {
+			fmt.Printf("failed to query records %+v \n", err)
+			os.Exit(1)
+		}
+		out, _ := json.MarshalIndent(page, "", "  ")
+		fmt.Println(string(out))
+	},
+}
+
+func parseQuery() (*store.Query, error) {
+	f := queryFlags
+	q := &store.Query{
+		Filter: store.Filter{
+			FromBlock: f.fromBlock,
+			ToBlock:   f.toBlock,
+			FromTime:  f.fromTime,
+			ToTime:    f.toTime,
+		},
+		Limit:  f.limit,
+		Cursor: f.cursor,
+	}
+	if f.desc {
+		q.Order = store.Descending
+	}
+
+	var err error
+	if f.scope != "" {
+		if q.Scope, err = hexutil.Decode(f.scope); err != nil {
+			return nil, fmt.Errorf("scope: %w", err)
+		}
+	}
+	if f.tx != "" {
+		if q.TxHash, err = hexutil.Decode(f.tx); err != nil {
+			return nil, fmt.Errorf("tx: %w", err)
+		}
+	}
+	for _, addr := range []struct {
+		name string
+		in   string
+		out  *hexutil.Bytes
+	}{
+		{"src", f.src, &q.Src},
+		{"sink", f.sink, &q.Sink},
+		{"fee-collector", f.feeCollector, &q.FeeCollector},
+	} {
+		if addr.in == "" {
+			continue
+		}
+		if !common.IsHexAddress(addr.in) {
+			return nil, fmt.Errorf("%s: invalid address %s", addr.name, addr.in)
+		}
+		*addr.out = common.HexToAddress(addr.in).Bytes()
+	}
+	for _, fee := range []struct {
+		name string
+		in   string
+		out  **big.Int
+	}{
+		{"min-fee", f.minFee, &q.MinFee},
+		{"max-fee", f.maxFee, &q.MaxFee},
+	} {
+		if fee.in == "" {
+			continue
+		}
+		v, ok := new(big.Int).SetString(fee.in, 10)
+		if !ok {
+			return nil, fmt.Errorf("%s: invalid amount %s", fee.name, fee.in)
+		}
+		*fee.out = v
+	}
+	return q, nil
+}
+
+func init() {
+	flags := queryCmd.Flags()
+	flags.StringVar(&queryFlags.scope, "scope", "", "hex encoded scope")
+	flags.StringVar(&queryFlags.tx, "tx", "", "hex encoded transaction hash")
+	flags.StringVar(&queryFlags.src, "src", "", "source address of the transition")
+	flags.StringVar(&queryFlags.sink, "sink", "", "sink address of the transition")
+	flags.StringVar(&queryFlags.feeCollector, "fee-collector", "", "fee collector address of the transition")
+	flags.StringVar(&queryFlags.minFee, "min-fee", "", "minimum fee of the transition")
+	flags.StringVar(&queryFlags.maxFee, "max-fee", "", "maximum fee of the transition")
+	flags.Uint64Var(&queryFlags.fromBlock, "from-block", 0, "first block of the range")
+	flags.Uint64Var(&queryFlags.toBlock, "to-block", 0, "last block of the range, unbounded if zero")
+	flags.Int64Var(&queryFlags.fromTime, "from-time", 0, "first recording time of the range (unix seconds)")
+	flags.Int64Var(&queryFlags.toTime, "to-time", 0, "last recording time of the range (unix seconds)")
+	flags.BoolVar(&queryFlags.desc, "desc", false, "latest records first")
+	flags.IntVar(&queryFlags.limit, "limit", store.DefaultPageLimit, "maximum number of records")
+	flags.StringVar(&queryFlags.cursor, "cursor", "", "cursor of the next page")
+	rootCmd.AddCommand(queryCmd)
+}
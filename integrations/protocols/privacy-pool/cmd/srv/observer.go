@@ -2,145 +2,200 @@ package srv
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
 	"math/big"
-	"os"
-	"sync"
 	"time"
 
 	. "github.com/0xBow-io/asp-go-buildkit/internal"
 	erpc "github.com/0xBow-io/asp-go-buildkit/internal/erpc"
 
-	"encoding/hex"
-
+	"github.com/0xBow-io/asp-go-buildkit/core/accumulator"
+	"github.com/0xBow-io/asp-go-buildkit/core/alert"
 	"github.com/0xBow-io/asp-go-buildkit/core/detector"
+	"github.com/0xBow-io/asp-go-buildkit/core/pipeline"
 	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+	"github.com/0xBow-io/asp-go-buildkit/core/store"
 	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	privacypool "github.com/0xBow-io/asp-go-buildkit/integrations/protocols/privacy-pool"
 )
 
-type Watcher interface {
-	Watch(_ watcher.Observable) ([]watcher.State, error)
+// Options are the optional
+// components of the observer
+type Options struct {
+	// decodes the events of the recorded states
+	Decoder *watcher.EventDecoder
+	Alerts  alert.Raiser
+	// detector rules, defaults to detector.DefaultRules
+	Rules []detector.Rule
+	// quarantines the rejected state transitions
+	// instead of skipping over the window
+	Quarantine detector.Quarantine
+	Policy     detector.QuarantinePolicy
+	// persists the records
+	Store store.RecordStore
+	// accumulates the records for inclusion proofs
+	// it is rebuilt from the store at start
+	Accumulator *accumulator.Accumulator
+	// attests the records
+	Signer recorder.Signer
+	// persists the buffered states
+	// the buffer is in-memory if nil
+	WAL *WALOptions
+	// bounds the in-memory buffer
+	// the buffer is unbuffered if nil
+	Bounded *BoundedOptions
+	// records the observed state indices
+	// saved to TrackerDir after every window
+	Tracker    *Tracker
+	TrackerDir string
+	// persists the next window once stopped
+	// the observation resumes from it,
+	// the buffer root is persisted next to it
+	Checkpoint string
+	// aborts the observation once stopped,
+	// the buffer is drained until then
+	Abort context.Context
 }
 
-type Detector interface {
-	Absorb(in []watcher.State) (*big.Int, error)
+// newBuffer opens the buffer of the options, absorbed
+// batches are staged until the detector commits them
+func newBuffer(des watcher.StateDeserializer, opts Options) (Buffer, func() error, error) {
+	var (
+		buff   Buffer
+		closer = func() error { return nil }
+	)
+	if opts.WAL != nil {
+		durable, err := NewDurableBuffer(big.NewInt(0), *opts.WAL)
+		if err != nil {
+			return nil, nil, err
+		}
+		buff, closer = durable, durable.Close
+	} else if opts.Bounded != nil {
+		bounded, err := NewBoundedBuffer(big.NewInt(0), *opts.Bounded)
+		if err != nil {
+			return nil, nil, err
+		}
+		buff, closer = bounded, bounded.Close
+	} else {
+		buff = NewBuffer(big.NewInt(0))
+	}
+	return NewTxBuffer(buff, des).Serialized(), closer, nil
 }
 
-func InitBuff(sink chan []byte, initroot *big.Int) Buffer {
-	buff := NewBuffer(initroot)
-	// kick off the sink go-routine
-	go func() {
-		buff.Sink(sink)
-	}()
-
-	return buff
+// printer prints the recorded states and their decoded events
+func printer(decoder *watcher.EventDecoder) func(watcher.State, recorder.Record) {
+	return func(state watcher.State, rec recorder.Record) {
+		if event := state.Event(); event != nil {
+			fmt.Printf("New State --> hash: %+v, event: %+v \n",
+				hex.EncodeToString(state.Hash()),
+				event.Format())
+			if decoder != nil {
+				if decoded, err := decoder.Decode(event); err == nil {
+					if out, err := decoded.JSON(); err == nil {
+						fmt.Printf("Decoded Event --> %s \n", out)
+					}
+				}
+			}
+		}
+		fmt.Printf("Recorded State --> hash: %+v, postState: %+v preState: %+v\n",
+			hex.EncodeToString(rec.Hash()),
+			hex.EncodeToString(rec.PostState()),
+			hex.EncodeToString(rec.PreState()),
+		)
+	}
 }
 
+// Observe observes the instance from the block until ctx is
+// done, the buffer is then drained until opts.Abort is done
 func Observe(
 	ctx context.Context,
 	obs watcher.Observable,
 	adapter erpc.Backend,
 	startBlock uint64,
 	maxWindowSize uint64,
-	waitTimeMs time.Duration,
+	waitTime time.Duration,
 	des watcher.StateDeserializer,
+	opts Options,
 ) error {
+	if opts.Alerts == nil {
+		opts.Alerts = alert.Nop
+	}
+	buff, closeBuff, err := newBuffer(des, opts)
+	if err != nil {
+		return err
+	}
+	defer closeBuff()
+
 	var (
-		stream   = make(chan []byte)
-		wg       = new(sync.WaitGroup)
-		buff     = InitBuff(stream, big.NewInt(0))
-		detector = detector.NewService(buff)
+		alerts   = opts.Alerts
+		detector = detector.NewService(buff, opts.Rules...)
 		recorder = recorder.NewService()
 		watcher  = watcher.NewService(adapter)
-		window   = [2]uint64{startBlock, startBlock + maxWindowSize}
+		hooks    = privacypool.Protocol{}.Hooks()
 	)
+	detector.SetAlerter(alerts)
+	recorder.SetAlerter(alerts)
+	watcher.SetAlerter(alerts)
 
-	go func() {
-		for {
-
-			// get the latest block number
-			// from the adapter
-			latestBlock, err := adapter.BlockNumber(ctx)
-			if err != nil {
-				fmt.Printf("adapter failure: %s \n", err.Error())
-				continue
-			}
-			if latestBlock == window[0] {
-				time.Sleep(waitTimeMs)
-				continue
-			}
-			if latestBlock-window[0] < maxWindowSize {
-				window[1] = latestBlock
-			} else {
-				window[1] = window[0] + maxWindowSize
-			}
-
-			// watch the osbservable states
-			// and return the observations
-			observations, err := watcher.Watch(obs, window)
-			if err != nil {
-				fmt.Printf("watcher failure: %s \n", err.Error())
-				os.Exit(1)
-			}
-
-			fmt.Printf("Watched Window [%d %d] .. Received %d observations\n",
-				window[0], window[1], len(observations))
-
-			if len(observations) == 0 {
-				window[0] = window[1]
-				continue
-			}
-
-			// absorb the observations into the buffer
-			// detector will verify that the observations are of valid state transitions
-			if root, err := detector.Absorb(observations); err != nil {
-				fmt.Printf("detector failure: %s \n", err.Error())
-				os.Exit(1)
-			} else {
-				// gurantee that all the
-				// observed states has been stashed into the buffer
-				// the root calculated by the buffer should
-				// be the same as the root returned by the detector
-				if root.Cmp(buff.Root()) != 0 {
-					fmt.Printf("root mismatch, got: %d expected: %d \n", root, buff.Root())
-					os.Exit(1)
-				}
-				fmt.Printf("Buffer Root: %+v Cnt: %d\n", root, buff.Cnt())
-			}
-			window[0] = window[1]
-		}
-	}()
-
-	for ss := range stream {
-		// deserialize the state
-		if state := des(ss); state != nil {
-			event := state.Event()
-			if event == nil {
-				fmt.Println("failed to extract event !")
-				os.Exit(1)
-			}
-			fmt.Printf("New State --> hash: %+v, event: %+v \n",
-				hex.EncodeToString(state.Hash()),
-				event.Format())
-
-			rec, err := recorder.Record(state)
-			if err != nil {
-				fmt.Printf("recorder failure: %s \n", err.Error())
-				os.Exit(1)
-			}
-			if rec != nil {
-				fmt.Printf("Recorded State --> hash: %+v, postState: %+v preState: %+v\n",
-					hex.EncodeToString(rec.Hash()),
-					hex.EncodeToString(rec.PostState()),
-					hex.EncodeToString(rec.PreState()),
-				)
-			}
-		} else {
-			fmt.Println("failed to deserialize state !")
-			os.Exit(1)
+	if opts.Quarantine != nil {
+		if err := detector.SetQuarantine(opts.Quarantine, opts.Policy, des); err != nil {
+			return err
 		}
 	}
+	if opts.Signer != nil {
+		recorder.SetSigner(opts.Signer)
+	}
+	if opts.Accumulator != nil {
+		opts.Accumulator.OnAppend(func(root *big.Int, size uint64) {
+			fmt.Printf("Accumulator Root: %+v Size: %d\n", root, size)
+		})
+	}
+	hooks.OnRecord = printer(opts.Decoder)
 
-	wg.Wait()
-	return nil
+	var rootCheckpoint string
+	if opts.Checkpoint != "" {
+		rootCheckpoint = opts.Checkpoint + ".root"
+	}
+	p, err := pipeline.New(pipeline.Params{
+		Config: pipeline.Config{
+			WindowSize:     maxWindowSize,
+			WaitTime:       waitTime,
+			Checkpoint:     func(string) string { return opts.Checkpoint },
+			RootCheckpoint: rootCheckpoint,
+		},
+		Instances: []pipeline.Instance{{
+			Observable: obs,
+			Chain:      fmt.Sprintf("%d", obs.ChainID()),
+			Backend:    adapter,
+			Watcher:    watcher,
+			From:       startBlock,
+		}},
+		Detector:    detector,
+		Recorder:    recorder,
+		Buffer:      buff,
+		Des:         des,
+		Alerts:      alerts,
+		Hooks:       hooks,
+		Store:       opts.Store,
+		Accumulator: opts.Accumulator,
+		Tracker:     opts.Tracker,
+		TrackerDir:  opts.TrackerDir,
+	})
+	if err != nil {
+		return err
+	}
+	if err := p.Start(ctx); err != nil {
+		return err
+	}
+	<-ctx.Done()
+
+	// the current window is finished and
+	// the buffer drained unless aborted
+	fmt.Println("Stopping, draining the buffer ..")
+	abort := opts.Abort
+	if abort == nil {
+		abort = context.Background()
+	}
+	return p.Stop(abort)
 }
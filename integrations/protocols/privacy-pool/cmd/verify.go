@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/store"
+	privacypool "github.com/0xBow-io/asp-go-buildkit/integrations/protocols/privacy-pool"
+
+	"github.com/spf13/cobra"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify [scope]",
+	Short: "verify the record chains of the record store",
+	Long:  `walks the stored record chain of the scope (or of every scope) and reports the first break`,
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if storePath == "" {
+			fmt.Println("--store is required")
+			cmd.Usage()
+			os.Exit(1)
+		}
+		s, err := store.NewBoltStore(storePath, privacypool.TransitionDecoderFunc)
+		if err != nil {
+			fmt.Printf("failed to open record store %+v \n", err)
+			os.Exit(1)
+		}
+		defer s.Close()
+
+		var breaks []*store.Break
+		if len(args) == 1 {
+			scope, err := hex.DecodeString(args[0])
+			if err != nil {
+				fmt.Printf("invalid scope %+v \n", args[0])
+				os.Exit(1)
+			}
+			brk, err := store.Verify(s, scope)
+			if err != nil {
+				fmt.Printf("failed to verify record chain %+v \n", err)
+				os.Exit(1)
+			}
+			if brk != nil {
+				breaks = append(breaks, brk)
+			}
+		} else if breaks, err = store.VerifyAll(s); err != nil {
+			fmt.Printf("failed to verify record chains %+v \n", err)
+			os.Exit(1)
+		}
+
+		if len(breaks) == 0 {
+			fmt.Println("record chains are intact")
+			return
+		}
+		for _, brk := range breaks {
+			fmt.Printf("Broken chain --> %s \n", brk)
+		}
+		os.Exit(2)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+}
@@ -0,0 +1,16 @@
+package privacypool
+
+import (
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/pkg/errors"
+)
+
+// NewEventDecoder returns an event decoder
+// with the privacy pool contract ABI registered
+func NewEventDecoder() (*watcher.EventDecoder, error) {
+	parsed, err := PrivacyPoolMetaData.GetAbi()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load privacy pool abi")
+	}
+	return watcher.NewEventDecoder().Register(parsed), nil
+}
@@ -0,0 +1,66 @@
+package privacypool
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/test-go/testify/require"
+)
+
+func Test_EventDecoder(t *testing.T) {
+	decoder, err := NewEventDecoder()
+	require.NoError(t, err)
+
+	parsed, err := PrivacyPoolMetaData.GetAbi()
+	require.NoError(t, err)
+
+	var (
+		ev  = parsed.Events["Record"]
+		req = IPrivacyPoolRequest{
+			Src:          common.HexToAddress("0x01"),
+			Sink:         common.HexToAddress("0x02"),
+			FeeCollector: common.HexToAddress("0x03"),
+			Fee:          big.NewInt(1000),
+		}
+	)
+
+	data, err := ev.Inputs.NonIndexed().Pack(req, big.NewInt(1001), big.NewInt(1002))
+	require.NoError(t, err)
+
+	event := new(watcher.Event).FromLog(&types.Log{
+		BlockNumber: 7,
+		BlockHash:   common.HexToHash("0x08"),
+		TxHash:      common.HexToHash("0x09"),
+		Topics:      []common.Hash{ev.ID},
+		Data:        data,
+		Address:     common.HexToAddress("0x14"),
+	})
+
+	decoded, err := decoder.Decode(event)
+	require.NoError(t, err)
+	require.Equal(t, "Record", decoded.Name)
+	require.Equal(t, "1001", decoded.Args["stateRoot"])
+	require.Equal(t, "1002", decoded.Args["stateSize"])
+	require.Equal(t, map[string]interface{}{
+		"src":          common.HexToAddress("0x01").Hex(),
+		"sink":         common.HexToAddress("0x02").Hex(),
+		"feeCollector": common.HexToAddress("0x03").Hex(),
+		"fee":          "1000",
+	}, decoded.Args["_r"])
+
+	out, err := decoded.JSON()
+	require.NoError(t, err)
+	var m map[string]interface{}
+	require.NoError(t, json.Unmarshal(out, &m))
+	require.Equal(t, "Record", m["name"])
+
+	// unknown event signatures are rejected
+	event.LogTopics = common.HexToHash("0x12").Bytes()
+	_, err = decoder.Decode(event)
+	require.True(t, errors.Is(err, watcher.ErrUnknownEvent))
+}
@@ -0,0 +1,69 @@
+package privacypool
+
+import (
+	"context"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/detector"
+	"github.com/0xBow-io/asp-go-buildkit/core/pipeline"
+	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/0xBow-io/asp-go-buildkit/internal"
+	"github.com/pkg/errors"
+)
+
+// ProtocolID is the protocol id of the node config
+const ProtocolID = "privacy-pool"
+
+// ValidatorLimit is the number of stashed
+// states kept for cross-validation
+const ValidatorLimit = 1024
+
+// Protocol is the privacy pool protocol of the observer node
+type Protocol struct{}
+
+func (Protocol) ID() string { return ProtocolID }
+
+// Observable returns the observable of the instance id
+func (Protocol) Observable(instanceID string) (watcher.Observable, error) {
+	for _, obs := range Observables() {
+		if obs.ID() == instanceID {
+			return obs, nil
+		}
+	}
+	return nil, errors.Wrap(ErrorInstanceNotFound, instanceID)
+}
+
+// DefaultInstance returns the first pool instance of the chain
+func (Protocol) DefaultInstance(chainID uint64) string {
+	for _, obs := range Observables() {
+		if uint64(obs.ChainID()) == chainID {
+			return obs.ID()
+		}
+	}
+	return ""
+}
+
+func (Protocol) Deserializer() watcher.StateDeserializer       { return StateDeserializerFunc }
+func (Protocol) TransitionDecoder() recorder.TransitionDecoder { return TransitionDecoderFunc }
+func (Protocol) Rules() []detector.Rule                        { return Rules() }
+
+func (Protocol) EventDecoder() (*watcher.EventDecoder, error) { return NewEventDecoder() }
+func (Protocol) TransitionDetails() func([]byte) interface{}  { return TransitionDetailsFunc }
+
+// Hooks returns the pool specifics of the observation pipeline
+func (Protocol) Hooks() pipeline.Hooks {
+	return pipeline.Hooks{
+		Validator: func(inst pipeline.Instance) pipeline.Validator {
+			return NewValidator(inst.Observable, inst.Backend, ValidatorLimit)
+		},
+		Checkpoint: func(ctx context.Context, inst pipeline.Instance, block uint64) ([]byte, error) {
+			return LastCheckpoint(ctx, inst.Observable, inst.Backend, block)
+		},
+		IsCheckpoint: func(ctx context.Context, inst pipeline.Instance, state []byte, block uint64) (bool, error) {
+			return IsCheckpoint(ctx, inst.Observable, inst.Backend, state, block)
+		},
+		Indices: func(state watcher.State) (internal.Range, bool) {
+			return Indices(state, DefaultStateSizeStep)
+		},
+	}
+}
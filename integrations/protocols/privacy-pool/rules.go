@@ -0,0 +1,85 @@
+package privacypool
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/detector"
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/0xBow-io/asp-go-buildkit/internal"
+)
+
+// NumOutputs is the number of outputs of a
+// privacy pool proof (2-in-2-out transactions)
+const NumOutputs = 2
+
+/*
+DefaultStateSizeStep is the number of state leaves
+inserted by a single state transition: Process inserts
+the commitment of each output of the proof as a leaf,
+along with its cipher (see FetchCipherComponentsFromProof
+taking the output index, UnpackCipherAtIdx the leaf index).
+*/
+const DefaultStateSizeStep = NumOutputs
+
+// Rules returns the detector rules
+// for privacy pool state transitions
+func Rules() []detector.Rule {
+	return append(detector.DefaultRules(), SizeContinuityRule(DefaultStateSizeStep))
+}
+
+// Size returns the state size decoded
+// from the state transition event of the state
+// returns nil if the state can't be decoded
+func Size(s watcher.State) *big.Int {
+	if trans := new(StateTransitionEvent).Deserialize(s.Inner()); trans != nil {
+		return new(big.Int).SetBytes(trans.NewSize)
+	}
+	return nil
+}
+
+// Indices returns the range of the state indices
+// inserted by the state transition of the state
+// given the number of indices inserted per transition
+func Indices(s watcher.State, step uint64) (internal.Range, bool) {
+	size := Size(s)
+	if size == nil || !size.IsUint64() || size.Uint64() < step {
+		return internal.Range{}, false
+	}
+	return internal.Range{From: size.Uint64() - step, To: size.Uint64()}, true
+}
+
+// SizeContinuityRule verifies that the state size
+// grows by exactly step between consecutive states.
+// A larger growth is flagged as missed transitions,
+// a smaller growth is rejected.
+func SizeContinuityRule(step uint64) detector.Rule {
+	return detector.NewRule("size-continuity",
+		func(last watcher.State, next watcher.State) detector.Verdict {
+			lastSize, nextSize := Size(last), Size(next)
+			if lastSize == nil || nextSize == nil {
+				return detector.Rejected("failed to decode state size")
+			}
+
+			expected := new(big.Int).Add(lastSize, new(big.Int).SetUint64(step))
+			switch expected.Cmp(nextSize) {
+			case 0:
+				return detector.Accepted()
+			case 1:
+				return detector.Rejected(fmt.Sprintf("state size grew from %s to %s, expected %s",
+					lastSize, nextSize, expected))
+			}
+
+			gap := &detector.Gap{
+				Scope: next.Scope(),
+				// indices of the leaves between the last known
+				// state and the leaves inserted by the next state
+				From: lastSize.Uint64(),
+				To:   nextSize.Uint64() - step - 1,
+			}
+			if lastEvent, nextEvent := last.Event(), next.Event(); lastEvent != nil && nextEvent != nil {
+				gap.Blocks = [2]uint64{lastEvent.BlockNumber, nextEvent.BlockNumber}
+			}
+			return detector.Missed(gap)
+		})
+}
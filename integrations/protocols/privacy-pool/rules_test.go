@@ -0,0 +1,89 @@
+package privacypool
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/detector"
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/test-go/testify/require"
+)
+
+type testBuffer struct{ stashed [][]byte }
+
+func (b *testBuffer) Stash(in []byte) (*big.Int, error) {
+	b.stashed = append(b.stashed, in)
+	return big.NewInt(int64(len(b.stashed))), nil
+}
+
+func newTestState(scope []byte, size int64, block uint64) *State {
+	return new(State).DeriveFrom(scope, &PrivacyPoolRecord{
+		R: IPrivacyPoolRequest{
+			Src:          common.HexToAddress("0x01"),
+			Sink:         common.HexToAddress("0x02"),
+			FeeCollector: common.HexToAddress("0x03"),
+			Fee:          big.NewInt(0),
+		},
+		StateRoot: big.NewInt(1000 + size),
+		StateSize: big.NewInt(size),
+		Raw: types.Log{
+			BlockNumber: block,
+			BlockHash:   common.BigToHash(new(big.Int).SetUint64(block)),
+			TxHash:      common.BigToHash(big.NewInt(size)),
+		},
+	})
+}
+
+func Test_SizeContinuityRule(t *testing.T) {
+	var (
+		scope = SEPOLIA_ETH_POOL_1.Scope()
+		rule  = SizeContinuityRule(2)
+	)
+
+	require.Equal(t, detector.Accept, rule.Examine(newTestState(scope, 2, 10), newTestState(scope, 4, 11)).Decision)
+	require.Equal(t, detector.Reject, rule.Examine(newTestState(scope, 4, 10), newTestState(scope, 4, 11)).Decision)
+
+	verdict := rule.Examine(newTestState(scope, 2, 10), newTestState(scope, 8, 14))
+	require.Equal(t, detector.Flag, verdict.Decision)
+	require.NotNil(t, verdict.Gap)
+	require.Equal(t, uint64(2), verdict.Gap.From)
+	require.Equal(t, uint64(5), verdict.Gap.To)
+	require.Equal(t, [2]uint64{10, 14}, verdict.Gap.Blocks)
+}
+
+func Test_Detector_FillsGap(t *testing.T) {
+	var (
+		scope   = SEPOLIA_ETH_POOL_1.Scope()
+		buff    = &testBuffer{}
+		svc     = detector.NewService(buff, Rules()...)
+		flagged []detector.Verdict
+		tracked []watcher.State
+		chain   = []watcher.State{
+			newTestState(scope, 2, 10),
+			newTestState(scope, 4, 11),
+			newTestState(scope, 6, 12),
+			newTestState(scope, 8, 14),
+		}
+	)
+	svc.OnFlag(func(_ watcher.State, v detector.Verdict) { flagged = append(flagged, v) })
+	svc.OnStash(func(states []watcher.State) { tracked = append(tracked, states...) })
+	svc.SetRefetcher(func(_ []byte, blocks [2]uint64) ([]watcher.State, error) {
+		require.Equal(t, [2]uint64{11, 14}, blocks)
+		return chain[1:], nil
+	})
+
+	// the state at block 12 was missed
+	_, err := svc.Absorb([]watcher.State{chain[0], chain[1], chain[3]})
+	require.NoError(t, err)
+	require.Len(t, flagged, 1)
+	require.Equal(t, uint64(4), flagged[0].Gap.From)
+	require.Equal(t, uint64(5), flagged[0].Gap.To)
+	require.Len(t, buff.stashed, 3)
+	require.Equal(t, chain[2].Serialize(), buff.stashed[1])
+	require.Equal(t, chain[3].Serialize(), buff.stashed[2])
+	// the refetched state is handed over with the batch
+	require.Len(t, tracked, 3)
+	require.Equal(t, chain[2].Hash(), tracked[1].Hash())
+}
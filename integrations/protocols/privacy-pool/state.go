@@ -2,8 +2,12 @@ package privacypool
 
 import (
 	"bytes"
+	"encoding/json"
+	"math/big"
 	"reflect"
 
+	"github.com/0xBow-io/asp-go-buildkit/core/recorder"
+	"github.com/0xBow-io/asp-go-buildkit/core/schema"
 	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -40,19 +44,27 @@ func (s *State) DeriveFrom(scope []byte, r *PrivacyPoolRecord) *State {
 	return nil
 }
 
+// StateSchema decodes the serialized pool states
+// held by the buffers and the quarantine
+var StateSchema = schema.NewRegistry[*State](schema.StateKind, 1).
+	Register(1, func(b []byte) (*State, error) {
+		s := &State{}
+		return s, cbor.Unmarshal(b, s)
+	}).
+	Migrate(schema.Legacy, schema.Identity)
+
 // Serialize returns the serialized state
 // using the cbor marshaler
 // returns nil if serialization fails
 func (s *State) Serialize() []byte {
 	if out, err := cbor.Marshal(s); err == nil {
-		return out
+		return StateSchema.Encode(out)
 	}
 	return nil
 }
 
 var StateDeserializerFunc watcher.StateDeserializer = func(b []byte) watcher.State {
-	s := &State{}
-	if err := cbor.Unmarshal(b, s); err == nil {
+	if s, _, err := StateSchema.Decode(b); err == nil {
 		return s
 	}
 	return nil
@@ -62,13 +74,7 @@ var StateDeserializerFunc watcher.StateDeserializer = func(b []byte) watcher.Sta
 // from the byte slice
 // using the cbor unmarshaler
 // returns nil if deserialization fails
-func (*State) Deserialize(b []byte) watcher.State {
-	s := &State{}
-	if err := cbor.Unmarshal(b, s); err == nil {
-		return s
-	}
-	return nil
-}
+func (*State) Deserialize(b []byte) watcher.State { return StateDeserializerFunc(b) }
 
 // Scope returns the scope of the state
 func (s *State) Scope() []byte { return s.Sc }
@@ -125,18 +131,27 @@ type StateTransitionEvent struct {
 	TransitionInput
 }
 
+// TransitionSchema decodes the transition
+// inner to the states, which the stores
+// decode the indexed fields of the records from
+var TransitionSchema = schema.NewRegistry[*StateTransitionEvent](schema.TransitionKind, 1).
+	Register(1, func(b []byte) (*StateTransitionEvent, error) {
+		trans := &StateTransitionEvent{
+			TransitionInput: TransitionInput{},
+		}
+		return trans, cbor.Unmarshal(b, trans)
+	}).
+	Migrate(schema.Legacy, schema.Identity)
+
 func (trans *StateTransitionEvent) Serialize() []byte {
 	if out, err := cbor.Marshal(trans); err == nil {
-		return out
+		return TransitionSchema.Encode(out)
 	}
 	return nil
 }
 
 func (*StateTransitionEvent) Deserialize(data []byte) *StateTransitionEvent {
-	trans := &StateTransitionEvent{
-		TransitionInput: TransitionInput{},
-	}
-	if err := cbor.Unmarshal(data, trans); err == nil {
+	if trans, _, err := TransitionSchema.Decode(data); err == nil {
 		return trans
 	}
 	return nil
@@ -193,6 +208,43 @@ func (trans *StateTransitionEvent) FromRecord(r *PrivacyPoolRecord) (
 	return new(watcher.Event).FromLog(&r.Raw), trans
 }
 
+// TransitionDetailsFunc deserializes the
+// state transition event of a privacy pool record
+var TransitionDetailsFunc = func(b []byte) interface{} {
+	if trans := new(StateTransitionEvent).Deserialize(b); trans != nil {
+		return trans
+	}
+	return nil
+}
+
+// MarshalJSON renders the roots and addresses
+// as hex and the size and fee as decimals
+func (trans *StateTransitionEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{
+		"newRoot":      common.BytesToHash(trans.NewRoot).Hex(),
+		"newSize":      new(big.Int).SetBytes(trans.NewSize).String(),
+		"src":          common.BytesToAddress(trans.Src).Hex(),
+		"sink":         common.BytesToAddress(trans.Sink).Hex(),
+		"feeCollector": common.BytesToAddress(trans.FeeCollector).Hex(),
+		"fee":          new(big.Int).SetBytes(trans.Fee).String(),
+	})
+}
+
+// TransitionDecoderFunc decodes the state transition
+// details of a privacy pool record
+var TransitionDecoderFunc recorder.TransitionDecoder = func(b []byte) *recorder.TransitionFields {
+	trans := new(StateTransitionEvent).Deserialize(b)
+	if trans == nil {
+		return nil
+	}
+	return &recorder.TransitionFields{
+		Src:          trans.Src,
+		Sink:         trans.Sink,
+		FeeCollector: trans.FeeCollector,
+		Fee:          new(big.Int).SetBytes(trans.Fee),
+	}
+}
+
 // StateComparatorFunc is a function that compares two states
 // returns 1 if the states are inequal, 0 if they are equal
 // and -1 if they are not comparable
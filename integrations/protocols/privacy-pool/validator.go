@@ -0,0 +1,238 @@
+package privacypool
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/0xBow-io/asp-go-buildkit/core/pipeline"
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/0xBow-io/asp-go-buildkit/internal/erpc"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+)
+
+type DivergenceKind string
+
+const (
+	// the latest absorbed root is not the on-chain root
+	RootMismatch DivergenceKind = "root-mismatch"
+	// the latest absorbed size is not the on-chain size
+	SizeMismatch DivergenceKind = "size-mismatch"
+	// an absorbed root is unknown to the contract
+	UnknownRoot DivergenceKind = "unknown-root"
+	// an on-chain root was never absorbed
+	MissingRoot DivergenceKind = "missing-root"
+)
+
+// Divergence describes a mismatch between the
+// absorbed states and the on-chain state of the pool
+type Divergence struct {
+	Kind   DivergenceKind
+	Reason string
+}
+
+// Report is the outcome of cross-validating the
+// absorbed states against the contract at a pinned block
+type Report struct {
+	Block       uint64
+	StateRoot   *big.Int
+	StateSize   *big.Int
+	Checked     int
+	Divergences []Divergence
+}
+
+// Ok returns true if no divergences were found
+func (r *Report) Ok() bool { return len(r.Divergences) == 0 }
+
+func (r *Report) diverge(kind DivergenceKind, format string, args ...interface{}) {
+	r.Divergences = append(r.Divergences, Divergence{Kind: kind, Reason: fmt.Sprintf(format, args...)})
+}
+
+// Validator cross-validates the absorbed states
+// against the state roots recorded by the contract
+type Validator struct {
+	obs     watcher.Observable
+	adapter erpc.Backend
+	limit   int
+	states  []watcher.State
+	lock    *sync.RWMutex
+}
+
+// NewValidator returns a validator which keeps
+// track of the last limit absorbed states
+func NewValidator(obs watcher.Observable, adapter erpc.Backend, limit int) *Validator {
+	return &Validator{
+		obs:     obs,
+		adapter: adapter,
+		limit:   limit,
+		states:  make([]watcher.State, 0, limit),
+		lock:    &sync.RWMutex{},
+	}
+}
+
+// Track keeps track of the absorbed states
+// states are expected to be given in order
+func (v *Validator) Track(states ...watcher.State) {
+	defer v.lock.Unlock()
+	v.lock.Lock()
+	for _, s := range states {
+		v.states = append(v.states, s.Clone())
+	}
+	if over := len(v.states) - v.limit; over > 0 {
+		v.states = append(v.states[:0], v.states[over:]...)
+	}
+}
+
+// Rollback stops tracking the states
+// emitted at or after the block
+func (v *Validator) Rollback(block uint64) {
+	defer v.lock.Unlock()
+	v.lock.Lock()
+	for i, s := range v.states {
+		if ev := s.Event(); ev != nil && ev.BlockNumber >= block {
+			v.states = v.states[:i]
+			return
+		}
+	}
+}
+
+func (v *Validator) tracked() []watcher.State {
+	defer v.lock.RUnlock()
+	v.lock.RLock()
+	return append([]watcher.State(nil), v.states...)
+}
+
+// Validate compares the tracked states with the contract.
+// Calls are pinned at the block of the latest tracked state
+// at which the on-chain state must equal the latest tracked state.
+func (v *Validator) Validate(ctx context.Context) (*Report, error) {
+	states := v.tracked()
+	if len(states) == 0 {
+		return nil, errors.New("no states to validate")
+	}
+
+	latest := states[len(states)-1]
+	event := latest.Event()
+	if event == nil {
+		return nil, errors.New("latest state has no event")
+	}
+
+	caller, err := NewPrivacyPoolCaller(v.obs.Address(), v.adapter)
+	if err != nil {
+		return nil, errors.Wrap(err, ErrorInstanceNotFound.Error())
+	}
+
+	var (
+		opts = &bind.CallOpts{
+			Context:     ctx,
+			BlockNumber: new(big.Int).SetUint64(event.BlockNumber),
+		}
+		report = &Report{Block: event.BlockNumber, Checked: len(states)}
+	)
+
+	if report.StateRoot, err = caller.GetStateRoot(opts); err != nil {
+		return nil, errors.Wrap(err, "failed to get state root")
+	}
+	if report.StateSize, err = caller.GetStateSize(opts); err != nil {
+		return nil, errors.Wrap(err, "failed to get state size")
+	}
+
+	if !bytes.Equal(latest.Hash(), common.BigToHash(report.StateRoot).Bytes()) {
+		report.diverge(RootMismatch, "absorbed root %s, on-chain root %s",
+			common.BytesToHash(latest.Hash()).Hex(), common.BigToHash(report.StateRoot).Hex())
+	}
+	if size := Size(latest); size == nil || size.Cmp(report.StateSize) != 0 {
+		report.diverge(SizeMismatch, "absorbed size %v, on-chain size %s", size, report.StateSize)
+	}
+
+	roots := make([]*big.Int, len(states))
+	for i, s := range states {
+		roots[i] = new(big.Int).SetBytes(s.Hash())
+	}
+
+	seek, err := caller.SeekRootIdxs(opts, roots)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to seek root indices")
+	}
+
+	// consecutive states must have consecutive root indices
+	var first, last *big.Int
+	for i, ok := range seek.Ok {
+		if !ok {
+			report.diverge(UnknownRoot, "absorbed root %s is unknown to the contract",
+				common.BigToHash(roots[i]).Hex())
+			continue
+		}
+		idx := seek.Idx[i]
+		if last != nil && new(big.Int).Sub(idx, last).Cmp(big.NewInt(1)) != 0 {
+			report.diverge(MissingRoot, "root indices jump from %s to %s", last, idx)
+		}
+		if first == nil {
+			first = idx
+		}
+		last = idx
+	}
+	if first == nil {
+		return report, nil
+	}
+
+	onchain, err := caller.FetchRoots(opts, first, last)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch roots")
+	}
+	absorbed := make(map[string]struct{}, len(roots))
+	for _, root := range roots {
+		absorbed[root.String()] = struct{}{}
+	}
+	for _, root := range onchain {
+		if _, ok := absorbed[root.String()]; !ok {
+			report.diverge(MissingRoot, "on-chain root %s was never absorbed", common.BigToHash(root).Hex())
+		}
+	}
+	return report, nil
+}
+
+// Run validates the tracked states at every interval
+// until the context is cancelled
+func (v *Validator) Run(ctx context.Context, interval time.Duration, report func(*Report, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if len(v.tracked()) == 0 {
+				continue
+			}
+			report(v.Validate(ctx))
+		}
+	}
+}
+
+// Diverged validates the tracked states, if any,
+// and returns the divergences of the report
+func (v *Validator) Diverged(ctx context.Context) ([]pipeline.Divergence, error) {
+	if len(v.tracked()) == 0 {
+		return nil, nil
+	}
+	report, err := v.Validate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	divergences := make([]pipeline.Divergence, len(report.Divergences))
+	for i, d := range report.Divergences {
+		divergences[i] = pipeline.Divergence{Block: report.Block, Kind: string(d.Kind), Reason: d.Reason}
+	}
+	if report.Ok() {
+		log.Infow("validated states", "instance", v.obs.ID(), "checked", report.Checked, "block", report.Block,
+			"root", report.StateRoot, "size", report.StateSize)
+	}
+	return divergences, nil
+}
@@ -0,0 +1,148 @@
+package privacypool
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/0xBow-io/asp-go-buildkit/internal/erpc"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+	"github.com/test-go/testify/require"
+)
+
+// testPool is a backend answering the calls
+// of the pool contract by method name
+type testPool struct {
+	erpc.Backend
+	abi   *abi.ABI
+	calls map[string]func(args []interface{}) []interface{}
+}
+
+func newTestPool(t *testing.T) *testPool {
+	parsed, err := PrivacyPoolMetaData.GetAbi()
+	require.NoError(t, err)
+	return &testPool{abi: parsed, calls: make(map[string]func([]interface{}) []interface{})}
+}
+
+func (p *testPool) CallContract(_ context.Context, call ethereum.CallMsg, _ *big.Int) ([]byte, error) {
+	method, err := p.abi.MethodById(call.Data[:4])
+	if err != nil {
+		return nil, err
+	}
+	args, err := method.Inputs.Unpack(call.Data[4:])
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := p.calls[method.Name]
+	if !ok {
+		return nil, errors.Errorf("unexpected call to %s", method.Name)
+	}
+	return method.Outputs.Pack(fn(args)...)
+}
+
+func (p *testPool) CodeAt(context.Context, common.Address, *big.Int) ([]byte, error) {
+	return []byte{1}, nil
+}
+
+// onchain answers the pool calls from the on-chain
+// roots, the latest root being the state root
+func (p *testPool) onchain(roots []*big.Int, size int64) {
+	p.calls["GetStateRoot"] = func([]interface{}) []interface{} {
+		return []interface{}{roots[len(roots)-1]}
+	}
+	p.calls["GetStateSize"] = func([]interface{}) []interface{} {
+		return []interface{}{big.NewInt(size)}
+	}
+	p.calls["SeekRootIdxs"] = func(args []interface{}) []interface{} {
+		seek := args[0].([]*big.Int)
+		var (
+			ok  = make([]bool, len(seek))
+			idx = make([]*big.Int, len(seek))
+		)
+		for i, root := range seek {
+			idx[i] = big.NewInt(0)
+			for j, r := range roots {
+				if r.Cmp(root) == 0 {
+					ok[i], idx[i] = true, big.NewInt(int64(j))
+				}
+			}
+		}
+		return []interface{}{ok, idx}
+	}
+	p.calls["FetchRoots"] = func(args []interface{}) []interface{} {
+		from, to := args[0].(*big.Int).Int64(), args[1].(*big.Int).Int64()
+		return []interface{}{roots[from : to+1]}
+	}
+}
+
+func Test_Validator(t *testing.T) {
+	var (
+		scope  = SEPOLIA_ETH_POOL_1.Scope()
+		states = []watcher.State{
+			newTestState(scope, 2, 10),
+			newTestState(scope, 4, 11),
+			newTestState(scope, 6, 12),
+		}
+		roots = []*big.Int{big.NewInt(1002), big.NewInt(1004), big.NewInt(1006)}
+	)
+
+	t.Run("match", func(t *testing.T) {
+		pool := newTestPool(t)
+		pool.onchain(roots, 6)
+		v := NewValidator(SEPOLIA_ETH_POOL_1, pool, 16)
+		v.Track(states...)
+
+		report, err := v.Validate(context.Background())
+		require.NoError(t, err)
+		require.True(t, report.Ok())
+		require.Equal(t, uint64(12), report.Block)
+		require.Equal(t, 3, report.Checked)
+	})
+
+	t.Run("divergence", func(t *testing.T) {
+		// the state of size 6 was never absorbed
+		pool := newTestPool(t)
+		pool.onchain([]*big.Int{big.NewInt(1002), big.NewInt(1004), big.NewInt(1006), big.NewInt(1008)}, 8)
+		v := NewValidator(SEPOLIA_ETH_POOL_1, pool, 16)
+		v.Track(states[0], states[1], newTestState(scope, 8, 13))
+
+		report, err := v.Validate(context.Background())
+		require.NoError(t, err)
+		require.False(t, report.Ok())
+		kinds := make(map[DivergenceKind]int)
+		for _, d := range report.Divergences {
+			kinds[d.Kind]++
+		}
+		require.Equal(t, map[DivergenceKind]int{MissingRoot: 2}, kinds)
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		// the contract moved past the latest absorbed state
+		pool := newTestPool(t)
+		pool.onchain(append(roots, big.NewInt(1008)), 8)
+		v := NewValidator(SEPOLIA_ETH_POOL_1, pool, 16)
+		v.Track(states...)
+
+		report, err := v.Validate(context.Background())
+		require.NoError(t, err)
+		kinds := make(map[DivergenceKind]int)
+		for _, d := range report.Divergences {
+			kinds[d.Kind]++
+		}
+		require.Equal(t, map[DivergenceKind]int{RootMismatch: 1, SizeMismatch: 1}, kinds)
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		v := NewValidator(SEPOLIA_ETH_POOL_1, newTestPool(t), 2)
+		v.Track(states...)
+		require.Len(t, v.tracked(), 2)
+		v.Rollback(12)
+		require.Len(t, v.tracked(), 1)
+		require.Equal(t, states[1].Hash(), v.tracked()[0].Hash())
+	})
+}
@@ -1 +1,270 @@
 package internal
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/RoaringBitmap/roaring/roaring64"
+	"github.com/pkg/errors"
+)
+
+const bitmapExt = ".bitmap"
+
+// Range is the half-open range of indices [From, To)
+type Range struct {
+	From uint64 `json:"from"`
+	To   uint64 `json:"to"`
+}
+
+func (r Range) String() string { return fmt.Sprintf("[%d, %d)", r.From, r.To) }
+
+// Len returns the number of indices of the range
+func (r Range) Len() uint64 { return r.To - r.From }
+
+/*
+Tracker records the observed state indices per scope
+in compressed (roaring) bitmaps, to find the indices
+which were never observed.
+*/
+type Tracker struct {
+	bitmaps map[string]*roaring64.Bitmap
+	// scopes changed since the last save
+	dirty map[string]bool
+	lock  *sync.RWMutex
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		bitmaps: make(map[string]*roaring64.Bitmap),
+		dirty:   make(map[string]bool),
+		lock:    &sync.RWMutex{},
+	}
+}
+
+func (t *Tracker) bitmap(scope []byte) *roaring64.Bitmap {
+	key := hex.EncodeToString(scope)
+	b, ok := t.bitmaps[key]
+	if !ok {
+		b = roaring64.New()
+		t.bitmaps[key] = b
+	}
+	return b
+}
+
+// Mark records the indices of the range as observed
+func (t *Tracker) Mark(scope []byte, r Range) {
+	if r.To <= r.From {
+		return
+	}
+	defer t.lock.Unlock()
+	t.lock.Lock()
+	t.bitmap(scope).AddRange(r.From, r.To)
+	t.dirty[hex.EncodeToString(scope)] = true
+}
+
+// Has returns true if the index was observed
+func (t *Tracker) Has(scope []byte, index uint64) bool {
+	defer t.lock.RUnlock()
+	t.lock.RLock()
+	b, ok := t.bitmaps[hex.EncodeToString(scope)]
+	return ok && b.Contains(index)
+}
+
+// Count returns the number of observed indices of the scope
+func (t *Tracker) Count(scope []byte) uint64 {
+	defer t.lock.RUnlock()
+	t.lock.RLock()
+	if b, ok := t.bitmaps[hex.EncodeToString(scope)]; ok {
+		return b.GetCardinality()
+	}
+	return 0
+}
+
+// Max returns the highest observed index
+// of the scope, false if there is none
+func (t *Tracker) Max(scope []byte) (uint64, bool) {
+	defer t.lock.RUnlock()
+	t.lock.RLock()
+	if b, ok := t.bitmaps[hex.EncodeToString(scope)]; ok && !b.IsEmpty() {
+		return b.Maximum(), true
+	}
+	return 0, false
+}
+
+// Scopes returns the tracked scopes in order
+func (t *Tracker) Scopes() [][]byte {
+	defer t.lock.RUnlock()
+	t.lock.RLock()
+	scopes := make([][]byte, 0, len(t.bitmaps))
+	for key := range t.bitmaps {
+		scope, _ := hex.DecodeString(key)
+		scopes = append(scopes, scope)
+	}
+	sort.Slice(scopes, func(i, j int) bool { return bytes.Compare(scopes[i], scopes[j]) < 0 })
+	return scopes
+}
+
+// Missing returns the ranges of the indices
+// within the range that were not observed.
+// The observed runs are skipped by rank,
+// one lookup per run rather than per index
+func (t *Tracker) Missing(scope []byte, r Range) []Range {
+	if r.To <= r.From {
+		return nil
+	}
+	defer t.lock.RUnlock()
+	t.lock.RLock()
+	b, ok := t.bitmaps[hex.EncodeToString(scope)]
+	if !ok || b.IsEmpty() {
+		return []Range{r}
+	}
+
+	var ranges []Range
+	for at := r.From; at < r.To; {
+		// the first observed index at or after at
+		before := uint64(0)
+		if at > 0 {
+			before = b.Rank(at - 1)
+		}
+		next, err := b.Select(before)
+		if err != nil || next >= r.To {
+			ranges = append(ranges, Range{From: at, To: r.To})
+			break
+		}
+		if next > at {
+			ranges = append(ranges, Range{From: at, To: next})
+		}
+		at = runEnd(b, next, r.To, before)
+	}
+	return ranges
+}
+
+// runEnd returns the first index after the observed start
+// that was not observed, at most to.
+// before is the number of indices observed before start
+func runEnd(b *roaring64.Bitmap, start, to, before uint64) uint64 {
+	lo, hi := start+1, to
+	for lo < hi {
+		// every index of [start, mid] is observed
+		if mid := lo + (hi-lo)/2; b.Rank(mid)-before == mid-start+1 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// combine returns the tracker of the bitmaps
+// of both trackers combined per scope
+func (t *Tracker) combine(o *Tracker, op func(x, y *roaring64.Bitmap) *roaring64.Bitmap, both bool) *Tracker {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+
+	out := NewTracker()
+	for key, b := range t.bitmaps {
+		if ob, ok := o.bitmaps[key]; ok {
+			out.bitmaps[key] = op(b, ob)
+		} else if !both {
+			out.bitmaps[key] = b.Clone()
+		}
+	}
+	if !both {
+		for key, ob := range o.bitmaps {
+			if _, ok := t.bitmaps[key]; !ok {
+				out.bitmaps[key] = ob.Clone()
+			}
+		}
+	}
+	for key := range out.bitmaps {
+		out.dirty[key] = true
+	}
+	return out
+}
+
+// Union returns the indices observed by either tracker
+func (t *Tracker) Union(o *Tracker) *Tracker {
+	return t.combine(o, roaring64.Or, false)
+}
+
+// Intersect returns the indices observed by both trackers
+func (t *Tracker) Intersect(o *Tracker) *Tracker {
+	return t.combine(o, roaring64.And, true)
+}
+
+// Save writes the bitmap of every changed
+// scope to its own file in the directory
+func (t *Tracker) Save(dir string) error {
+	defer t.lock.Unlock()
+	t.lock.Lock()
+	if len(t.dirty) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return errors.Wrap(err, "failed to create tracker dir")
+	}
+	for key := range t.dirty {
+		b := t.bitmaps[key]
+		b.RunOptimize()
+		if err := saveBitmap(filepath.Join(dir, key+bitmapExt), b); err != nil {
+			return errors.Wrapf(err, "failed to save the bitmap of scope %s", key)
+		}
+		delete(t.dirty, key)
+	}
+	return nil
+}
+
+func saveBitmap(path string, b *roaring64.Bitmap) error {
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := b.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// LoadTracker reads the bitmaps saved in the directory
+// an empty tracker is returned if there are none
+func LoadTracker(dir string) (*Tracker, error) {
+	t := NewTracker()
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+bitmapExt))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		key := strings.TrimSuffix(filepath.Base(path), bitmapExt)
+		if _, err := hex.DecodeString(key); err != nil {
+			continue
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open bitmap")
+		}
+		b := roaring64.New()
+		_, err = b.ReadFrom(f)
+		f.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read the bitmap of scope %s", key)
+		}
+		t.bitmaps[key] = b
+	}
+	return t, nil
+}
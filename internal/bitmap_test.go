@@ -0,0 +1,46 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/test-go/testify/require"
+)
+
+func Test_Tracker(t *testing.T) {
+	var (
+		scope = []byte{0x01}
+		other = []byte{0x02}
+		a     = NewTracker()
+		b     = NewTracker()
+	)
+	a.Mark(scope, Range{0, 10})
+	a.Mark(scope, Range{20, 25})
+	a.Mark(scope, Range{40, 41})
+	a.Mark(other, Range{0, 5})
+
+	require.Equal(t, []Range{{10, 20}, {25, 40}, {41, 50}}, a.Missing(scope, Range{5, 50}))
+	require.Empty(t, a.Missing(scope, Range{0, 10}))
+	require.Equal(t, []Range{{0, 3}}, a.Missing([]byte{0x03}, Range{0, 3}))
+
+	// the missing ranges are found per observed run
+	huge := NewTracker()
+	huge.Mark(scope, Range{0, 1 << 24})
+	huge.Mark(scope, Range{1<<24 + 5, 1 << 25})
+	require.Equal(t, []Range{{1 << 24, 1<<24 + 5}, {1 << 25, 1<<25 + 1}}, huge.Missing(scope, Range{1, 1<<25 + 1}))
+
+	b.Mark(scope, Range{5, 30})
+	require.Equal(t, []Range{{30, 40}}, a.Union(b).Missing(scope, Range{0, 41}))
+	both := a.Intersect(b)
+	require.Equal(t, uint64(10), both.Count(scope))
+	require.Zero(t, both.Count(other))
+
+	// bitmaps are persisted per scope
+	dir := t.TempDir()
+	require.NoError(t, a.Save(dir))
+	loaded, err := LoadTracker(dir)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{scope, other}, loaded.Scopes())
+	require.Equal(t, a.Missing(scope, Range{0, 100}), loaded.Missing(scope, Range{0, 100}))
+	require.True(t, loaded.Has(other, 4))
+	require.False(t, loaded.Has(other, 5))
+}
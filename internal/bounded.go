@@ -0,0 +1,424 @@
+package internal
+
+import (
+	"encoding/binary"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// OverflowPolicy is how a full bounded buffer stashes
+type OverflowPolicy string
+
+const (
+	// Block waits for the sink to make room
+	Block OverflowPolicy = "block"
+	// DropOldest drops the oldest waiting state
+	DropOldest OverflowPolicy = "drop-oldest"
+	// Spill appends the state to a log on disk
+	// until the sink catches up
+	Spill OverflowPolicy = "spill"
+)
+
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	switch p := OverflowPolicy(strings.ToLower(name)); p {
+	case Block, DropOldest, Spill:
+		return p, nil
+	}
+	return "", errors.Errorf("unknown overflow policy %s", name)
+}
+
+const (
+	DefaultCapacity = 1024
+	// window over which the throughput is measured
+	rateWindow = 10 * time.Second
+	// the spill log is read back in batches
+	spillBatch     = 256
+	spillConsumer  = "sink"
+	spillTimestamp = 8
+)
+
+type BoundedOptions struct {
+	Capacity int
+	Policy   OverflowPolicy
+	// log of the spilled states,
+	// required by the Spill policy
+	Spill *WALOptions
+}
+
+// BufferMetrics is a snapshot of the buffer state
+type BufferMetrics struct {
+	Capacity int `json:"capacity"`
+	// states waiting to be sinked
+	Depth int `json:"depth"`
+	// of which are spilled to disk
+	Spilled int `json:"spilled"`
+	// age of the oldest waiting state
+	Lag time.Duration `json:"lag"`
+	// totals since the buffer was opened
+	Stashed uint64 `json:"stashed"`
+	Sinked  uint64 `json:"sinked"`
+	Dropped uint64 `json:"dropped"`
+	// states per second over the last window
+	StashRate float64 `json:"stashRate"`
+	SinkRate  float64 `json:"sinkRate"`
+	// time the stash waited for room
+	Blocked time.Duration `json:"blocked"`
+}
+
+// Meter is implemented by buffers exporting metrics
+type Meter interface {
+	Metrics() BufferMetrics
+}
+
+// rate measures the throughput of a counter
+type rate struct {
+	start time.Time
+	base  uint64
+	last  float64
+}
+
+func (r *rate) observe(n uint64, now time.Time) float64 {
+	elapsed := now.Sub(r.start)
+	if r.start.IsZero() {
+		r.start, r.base = now, n
+		return 0
+	}
+	current := float64(n-r.base) / elapsed.Seconds()
+	if elapsed >= rateWindow {
+		r.start, r.base, r.last = now, n, current
+	}
+	if r.last == 0 {
+		return current
+	}
+	return r.last
+}
+
+type bounded struct {
+	data []byte
+	at   time.Time
+	// offset of the spilled state + 1
+	// 0 if it was never spilled
+	spill uint64
+}
+
+var (
+	_ Buffer        = (*BoundedBuffer)(nil)
+	_ Meter         = (*BoundedBuffer)(nil)
+	_ Pender        = (*BoundedBuffer)(nil)
+	_ HashedStasher = (*BoundedBuffer)(nil)
+)
+
+/*
+BoundedBuffer is an in-memory buffer of a fixed capacity.
+Stash returns as soon as the state is queued, the overflow
+policy decides what happens once the capacity is reached.
+*/
+type BoundedBuffer struct {
+	opts  BoundedOptions
+	queue []bounded
+	// spilled states not yet read back
+	spill     *WAL
+	spilled   int
+	spillRead uint64
+	// spill offsets of the sinked states
+	// not yet acknowledged, 0 if not spilled
+	inflight []uint64
+
+	root    *big.Int
+	stashed uint64
+	sinked  uint64
+	dropped uint64
+	blocked time.Duration
+	stashR  rate
+	sinkR   rate
+
+	closed bool
+	cond   *sync.Cond
+	lock   *sync.Mutex
+}
+
+// NewBoundedBuffer returns the bounded buffer
+// spilled states of a previous run are recovered
+func NewBoundedBuffer(initRoot *big.Int, opts BoundedOptions) (*BoundedBuffer, error) {
+	if opts.Capacity <= 0 {
+		opts.Capacity = DefaultCapacity
+	}
+	if opts.Policy == "" {
+		opts.Policy = Block
+	}
+	lock := &sync.Mutex{}
+	b := &BoundedBuffer{
+		opts: opts,
+		root: big.NewInt(0).Set(initRoot),
+		cond: sync.NewCond(lock),
+		lock: lock,
+	}
+	if opts.Policy != Spill {
+		return b, nil
+	}
+	if opts.Spill == nil {
+		return nil, errors.New("spill policy requires a spill log")
+	}
+	wal, frames, err := OpenWAL(*opts.Spill)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open the spill log")
+	}
+	b.spill, b.spillRead = wal, wal.Offset(spillConsumer)
+	for _, fr := range frames {
+		if fr.Kind == EntryFrame && fr.Offset >= b.spillRead {
+			b.spilled++
+		}
+	}
+	if b.spilled > 0 {
+		log.Infow("internal/BoundedBuffer: recovered spilled states", "spilled", b.spilled)
+	}
+	return b, nil
+}
+
+func (b *BoundedBuffer) Root() *big.Int {
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	return b.root
+}
+
+// Stash queues the serialized state
+// and folds it into the rolling root
+func (b *BoundedBuffer) Stash(ss []byte) (*big.Int, error) { return b.StashHashed(ss, nil) }
+
+// StashHashed stashes the serialized state
+// folding its precomputed hash, if not nil
+func (b *BoundedBuffer) StashHashed(ss []byte, hash *big.Int) (*big.Int, error) {
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	if b.closed {
+		return nil, errors.New("buffer is closed")
+	}
+	newRoot, err := foldHashed(b.root, ss, hash)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to stash incoming")
+	}
+	now := time.Now()
+
+	switch full := len(b.queue) >= b.opts.Capacity; {
+	case b.opts.Policy == Spill && (full || b.spilled > 0):
+		// states are spilled until the
+		// spill is drained to keep their order
+		data := make([]byte, spillTimestamp+len(ss))
+		binary.BigEndian.PutUint64(data, uint64(now.UnixNano()))
+		copy(data[spillTimestamp:], ss)
+		if _, err := b.spill.Append(EntryFrame, big.NewInt(0), data); err != nil {
+			return nil, errors.Wrap(err, "failed to spill incoming")
+		}
+		b.spilled++
+	case full && b.opts.Policy == DropOldest:
+		b.queue[0] = bounded{}
+		b.queue = b.queue[1:]
+		b.dropped++
+		log.Warnw("internal/BoundedBuffer: dropped the oldest state", "dropped", b.dropped)
+		b.queue = append(b.queue, bounded{data: ss, at: now})
+	case full:
+		for !b.closed && len(b.queue) >= b.opts.Capacity {
+			b.cond.Wait()
+		}
+		if b.closed {
+			return nil, errors.New("buffer is closed")
+		}
+		b.blocked += time.Since(now)
+		b.queue = append(b.queue, bounded{data: ss, at: now})
+	default:
+		b.queue = append(b.queue, bounded{data: ss, at: now})
+	}
+
+	b.root = newRoot
+	b.stashed++
+	b.stashR.observe(b.stashed, now)
+	b.cond.Broadcast()
+	return new(big.Int).Set(newRoot), nil
+}
+
+// refill reads spilled states back into the queue,
+// at least one state is queued unless none is spilled
+func (b *BoundedBuffer) refill() error {
+	room := b.opts.Capacity - len(b.queue)
+	if b.spilled == 0 || room <= 0 {
+		return nil
+	}
+	if room > spillBatch {
+		room = spillBatch
+	}
+	// a batch may only hold the root
+	// frames opening the segments
+	for queued := len(b.queue); len(b.queue) == queued && b.spilled > 0; {
+		frames, err := b.spill.Read(b.spillRead, room)
+		if err != nil {
+			return errors.Wrap(err, "failed to read the spill log")
+		}
+		if len(frames) == 0 {
+			return errors.Errorf("%d spilled states are missing from the spill log", b.spilled)
+		}
+		for _, fr := range frames {
+			b.spillRead = fr.Offset + 1
+			if fr.Kind != EntryFrame || len(fr.Data) < spillTimestamp {
+				continue
+			}
+			b.queue = append(b.queue, bounded{
+				data:  fr.Data[spillTimestamp:],
+				at:    time.Unix(0, int64(binary.BigEndian.Uint64(fr.Data))),
+				spill: fr.Offset + 1,
+			})
+			b.spilled--
+		}
+	}
+	return nil
+}
+
+// Sink sends the queued states in order
+// until the buffer is closed
+func (b *BoundedBuffer) Sink(sink chan<- []byte) {
+	for {
+		b.lock.Lock()
+		for !b.closed && len(b.queue) == 0 && b.spilled == 0 {
+			b.cond.Wait()
+		}
+		if b.closed {
+			b.lock.Unlock()
+			return
+		}
+		if len(b.queue) == 0 {
+			if err := b.refill(); err != nil {
+				log.Errorw("internal/BoundedBuffer: failed to refill from the spill log", "err", err)
+				b.lock.Unlock()
+				time.Sleep(time.Second)
+				continue
+			}
+		}
+		next := b.queue[0]
+		b.queue[0] = bounded{}
+		b.queue = b.queue[1:]
+		// the spill offset is committed
+		// once the state is acknowledged
+		b.inflight = append(b.inflight, next.spill)
+		b.cond.Broadcast()
+		b.lock.Unlock()
+
+		sink <- next.data
+
+		b.lock.Lock()
+		b.sinked++
+		b.sinkR.observe(b.sinked, time.Now())
+		b.lock.Unlock()
+	}
+}
+
+// Ack acknowledges the oldest sinked state,
+// a spilled state is not recovered after a restart
+func (b *BoundedBuffer) Ack() error {
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	if len(b.inflight) == 0 {
+		return ErrNothingDelivered
+	}
+	if offset := b.inflight[0]; offset > 0 {
+		if err := b.spill.Commit(spillConsumer, offset); err != nil {
+			return errors.Wrap(err, "failed to commit the spill offset")
+		}
+	}
+	b.inflight = b.inflight[1:]
+	return nil
+}
+
+// Size returns the number of
+// states waiting to be sinked
+func (b *BoundedBuffer) Size() int {
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	return len(b.queue) + b.spilled
+}
+
+// Pending returns the number of waiting
+// and sinked states not yet acknowledged
+func (b *BoundedBuffer) Pending() int {
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	return len(b.queue) + b.spilled + len(b.inflight)
+}
+
+func (b *BoundedBuffer) Cnt() uint64 {
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	return b.stashed
+}
+
+// Rewind sets the rolling root back to a previous root
+// states which have already been sinked are not recalled
+func (b *BoundedBuffer) Rewind(root *big.Int) error {
+	if root == nil {
+		return errors.New("cannot rewind to a nil root")
+	}
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	b.root = big.NewInt(0).Set(root)
+	return nil
+}
+
+// Purge drops every waiting state
+func (b *BoundedBuffer) Purge() bool {
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	if len(b.queue) == 0 && b.spilled == 0 {
+		return false
+	}
+	if b.spill != nil {
+		next := b.spill.Next()
+		if err := b.spill.Commit(spillConsumer, next); err != nil {
+			log.Errorw("internal/BoundedBuffer: failed to purge the spill log", "err", err)
+			return false
+		}
+		b.spillRead, b.spilled = next, 0
+		// the purge committed past the sinked states
+		for i := range b.inflight {
+			b.inflight[i] = 0
+		}
+	}
+	b.dropped += uint64(len(b.queue))
+	b.queue = nil
+	b.cond.Broadcast()
+	return true
+}
+
+func (b *BoundedBuffer) Metrics() BufferMetrics {
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	now := time.Now()
+	m := BufferMetrics{
+		Capacity:  b.opts.Capacity,
+		Depth:     len(b.queue) + b.spilled,
+		Spilled:   b.spilled,
+		Stashed:   b.stashed,
+		Sinked:    b.sinked,
+		Dropped:   b.dropped,
+		StashRate: b.stashR.observe(b.stashed, now),
+		SinkRate:  b.sinkR.observe(b.sinked, now),
+		Blocked:   b.blocked,
+	}
+	if len(b.queue) > 0 {
+		m.Lag = now.Sub(b.queue[0].at)
+	}
+	return m
+}
+
+// Close stops the sink and unblocks the stashes
+func (b *BoundedBuffer) Close() error {
+	b.lock.Lock()
+	b.closed = true
+	b.cond.Broadcast()
+	b.lock.Unlock()
+	if b.spill != nil {
+		return b.spill.Close()
+	}
+	return nil
+}
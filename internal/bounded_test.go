@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/require"
+)
+
+func Test_BoundedBuffer_Overflow(t *testing.T) {
+	t.Run("block", func(t *testing.T) {
+		b, err := NewBoundedBuffer(big.NewInt(0), BoundedOptions{Capacity: 2, Policy: Block})
+		require.NoError(t, err)
+		defer b.Close()
+
+		for i := 0; i < 2; i++ {
+			_, err := b.Stash([]byte{byte(i)})
+			require.NoError(t, err)
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			b.Stash([]byte{2})
+		}()
+		select {
+		case <-done:
+			t.Fatal("stash did not block on a full buffer")
+		case <-time.After(50 * time.Millisecond):
+		}
+		require.Equal(t, 2, b.Metrics().Depth)
+
+		sink := make(chan []byte)
+		go b.Sink(sink)
+		for i := 0; i < 3; i++ {
+			require.Equal(t, []byte{byte(i)}, <-sink)
+		}
+		<-done
+		require.True(t, b.Metrics().Blocked > 0)
+	})
+
+	t.Run("drop-oldest", func(t *testing.T) {
+		b, err := NewBoundedBuffer(big.NewInt(0), BoundedOptions{Capacity: 2, Policy: DropOldest})
+		require.NoError(t, err)
+		defer b.Close()
+
+		for i := 0; i < 5; i++ {
+			_, err := b.Stash([]byte{byte(i)})
+			require.NoError(t, err)
+		}
+		m := b.Metrics()
+		require.Equal(t, 2, m.Depth)
+		require.Equal(t, uint64(3), m.Dropped)
+		require.Equal(t, uint64(5), m.Stashed)
+		require.True(t, m.Lag > 0)
+
+		sink := make(chan []byte)
+		go b.Sink(sink)
+		require.Equal(t, []byte{3}, <-sink)
+		require.Equal(t, []byte{4}, <-sink)
+	})
+
+	t.Run("spill", func(t *testing.T) {
+		opts := BoundedOptions{
+			Capacity: 2,
+			Policy:   Spill,
+			Spill:    &WALOptions{Dir: t.TempDir(), SegmentSize: 128, Sync: SyncNever},
+		}
+		b, err := NewBoundedBuffer(big.NewInt(0), opts)
+		require.NoError(t, err)
+
+		var root *big.Int
+		for i := 0; i < 20; i++ {
+			root, err = b.Stash([]byte{byte(i)})
+			require.NoError(t, err)
+		}
+		require.Equal(t, 18, b.Metrics().Spilled)
+		require.Equal(t, 20, b.Size())
+
+		// the first 10 states are acknowledged,
+		// the next ones are sinked but not
+		sink := make(chan []byte)
+		go b.Sink(sink)
+		for i := 0; i < 10; i++ {
+			require.Equal(t, []byte{byte(i)}, <-sink)
+			require.NoError(t, b.Ack())
+		}
+		require.Equal(t, []byte{10}, <-sink)
+		require.NoError(t, b.Close())
+
+		// unacknowledged spilled states survive a restart
+		b, err = NewBoundedBuffer(root, opts)
+		require.NoError(t, err)
+		defer b.Close()
+		sink = make(chan []byte)
+		go b.Sink(sink)
+		for i := 10; i < 20; i++ {
+			require.Equal(t, []byte{byte(i)}, <-sink)
+		}
+		require.Equal(t, 0, b.Size())
+	})
+
+	t.Run("spill-root-frames", func(t *testing.T) {
+		// the segments open with a root frame,
+		// read back one frame at a time
+		opts := BoundedOptions{
+			Capacity: 1,
+			Policy:   Spill,
+			Spill:    &WALOptions{Dir: t.TempDir(), SegmentSize: 64, Sync: SyncNever},
+		}
+		b, err := NewBoundedBuffer(big.NewInt(0), opts)
+		require.NoError(t, err)
+		defer b.Close()
+
+		for i := 0; i < 6; i++ {
+			_, err = b.Stash([]byte{byte(i)})
+			require.NoError(t, err)
+		}
+		sink := make(chan []byte)
+		go b.Sink(sink)
+		for i := 0; i < 6; i++ {
+			select {
+			case got := <-sink:
+				require.Equal(t, []byte{byte(i)}, got)
+			case <-time.After(500 * time.Millisecond):
+				t.Fatalf("state %d was not sinked", i)
+			}
+			require.NoError(t, b.Ack())
+		}
+	})
+}
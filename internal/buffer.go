@@ -3,8 +3,8 @@ package internal
 import (
 	"math/big"
 	"sync"
+	"sync/atomic"
 
-	posiedon "github.com/iden3/go-iden3-crypto/poseidon"
 	"github.com/pkg/errors"
 )
 
@@ -21,12 +21,21 @@ type Buffer interface {
 	Root() *big.Int
 	Cnt() uint64
 	Purge() bool
+	Rewind(root *big.Int) error
+}
+
+// Pender is implemented by buffers which count
+// the states not yet acknowledged, sinked included
+type Pender interface {
+	Pending() int
 }
 
 type _Buffer struct {
 	stash   chan []byte
 	root    *big.Int
 	counter uint64
+	// stashed states not yet acknowledged
+	pending atomic.Int64
 	lock    *sync.RWMutex
 }
 
@@ -43,21 +52,41 @@ func (s *_Buffer) Root() *big.Int {
 	return s.root
 }
 
-func (s *_Buffer) Stash(ss []byte) (*big.Int, error) {
-
-	var (
-		hash    *big.Int
-		newRoot *big.Int
-		err     error
-	)
-	if hash, err = posiedon.HashBytes(ss); err == nil {
-		if newRoot, err = posiedon.HashWithState([]*big.Int{hash}, s.root); err == nil {
-			s.stash <- ss
-			s.counter++
-			return s.root.Set(newRoot), nil
-		}
+// Fold folds the poseidon hash of the
+// serialized state into the rolling root
+func Fold(root *big.Int, ss []byte) (*big.Int, error) {
+	hash, err := HashBytes(ss)
+	if err != nil {
+		return nil, err
+	}
+	return FoldHash(root, hash)
+}
+
+func (s *_Buffer) Stash(ss []byte) (*big.Int, error) { return s.StashHashed(ss, nil) }
+
+// StashHashed stashes the serialized state
+// folding its precomputed hash, if not nil
+func (s *_Buffer) StashHashed(ss []byte, hash *big.Int) (*big.Int, error) {
+	newRoot, err := foldHashed(s.root, ss, hash)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to stash incoming")
+	}
+	s.pending.Add(1)
+	s.stash <- ss
+	s.counter++
+	return s.root.Set(newRoot), nil
+}
+
+// Rewind sets the rolling root back to a previous root
+// states which have already been sinked are not recalled
+func (s *_Buffer) Rewind(root *big.Int) error {
+	if root == nil {
+		return errors.New("cannot rewind to a nil root")
 	}
-	return nil, errors.Wrap(err, "failed to stash incoming")
+	defer s.lock.Unlock()
+	s.lock.Lock()
+	s.root = big.NewInt(0).Set(root)
+	return nil
 }
 
 func (s *_Buffer) Sink(sink chan<- []byte) {
@@ -74,6 +103,25 @@ func (s *_Buffer) Cnt() uint64 {
 	return s.counter
 }
 
+// Ack acknowledges the oldest sinked state
+func (s *_Buffer) Ack() error {
+	for {
+		n := s.pending.Load()
+		if n == 0 {
+			return ErrNothingDelivered
+		}
+		if s.pending.CompareAndSwap(n, n-1) {
+			return nil
+		}
+	}
+}
+
+// Pending returns the number of
+// states not yet acknowledged
+func (s *_Buffer) Pending() int {
+	return int(s.pending.Load())
+}
+
 // Purge removes all the stashed & to-be sinked
 // states from the buffer
 // By simply dumping the items into the ether
@@ -86,6 +134,7 @@ func (s *_Buffer) Purge() bool {
 	for {
 		select {
 		case <-s.stash:
+			s.pending.Add(-1)
 			continue
 		default:
 			s.counter = 0
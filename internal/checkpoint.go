@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// Checkpoint is the progress of an observation
+// persisted once its states have been recorded
+type Checkpoint struct {
+	// first block of the next window
+	Next uint64 `json:"next,omitempty"`
+	// buffer root after the last window
+	Root string `json:"root,omitempty"`
+	Time int64  `json:"time"`
+}
+
+// SaveCheckpoint writes the checkpoint to the path
+// the previous checkpoint is replaced atomically
+func SaveCheckpoint(path string, cp *Checkpoint) error {
+	out, err := json.Marshal(cp)
+	if err != nil {
+		return errors.Wrap(err, "failed to serialize checkpoint")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return errors.Wrap(err, "failed to create checkpoint dir")
+	}
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create checkpoint")
+	}
+	if _, err := f.Write(out); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to write checkpoint")
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to sync checkpoint")
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// LoadCheckpoint reads the checkpoint of the path
+// nil is returned if there is none
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to read checkpoint")
+	}
+	cp := &Checkpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, errors.Wrap(err, "failed to deserialize checkpoint")
+	}
+	return cp, nil
+}
@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"context"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/require"
+)
+
+func Test_Checkpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "observer", "checkpoint.json")
+
+	cp, err := LoadCheckpoint(path)
+	require.NoError(t, err)
+	require.Nil(t, cp)
+
+	require.NoError(t, SaveCheckpoint(path, &Checkpoint{Next: 10, Root: "1"}))
+	require.NoError(t, SaveCheckpoint(path, &Checkpoint{Next: 20, Root: "2"}))
+	cp, err = LoadCheckpoint(path)
+	require.NoError(t, err)
+	require.Equal(t, uint64(20), cp.Next)
+	require.Equal(t, "2", cp.Root)
+}
+
+func Test_Drain(t *testing.T) {
+	var (
+		stream  = make(chan []byte)
+		handled [][]byte
+	)
+	buff, err := NewBoundedBuffer(big.NewInt(0), BoundedOptions{Capacity: 8})
+	require.NoError(t, err)
+	defer buff.Close()
+	for i := byte(1); i <= 3; i++ {
+		_, err := buff.Stash([]byte{i})
+		require.NoError(t, err)
+	}
+	go buff.Sink(stream)
+	require.NoError(t, Drain(context.Background(), buff, stream, func(ss []byte) {
+		handled = append(handled, ss)
+		require.NoError(t, buff.Ack())
+	}))
+	require.Equal(t, [][]byte{{1}, {2}, {3}}, handled)
+	require.Equal(t, 0, buff.Pending())
+
+	// a state sinked but not yet handled is waited for
+	unbuffered := NewBuffer(big.NewInt(0)).(*_Buffer)
+	go unbuffered.Sink(stream)
+	go unbuffered.Stash([]byte{4})
+	for unbuffered.Pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	handled = nil
+	require.NoError(t, Drain(context.Background(), unbuffered, stream, func(ss []byte) {
+		handled = append(handled, ss)
+		require.NoError(t, unbuffered.Ack())
+	}))
+	require.Equal(t, [][]byte{{4}}, handled)
+
+	// the drain stops once aborted
+	durable, err := NewDurableBuffer(big.NewInt(0), WALOptions{Dir: t.TempDir()})
+	require.NoError(t, err)
+	defer durable.Close()
+	_, err = durable.Stash([]byte{1})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Error(t, Drain(ctx, durable, make(chan []byte), func([]byte) {}))
+}
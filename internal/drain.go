@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// interval between checks of the drained buffer
+const drainPoll = 100 * time.Millisecond
+
+/*
+Drain handles the states sinked by the buffer into the stream
+until the buffer is empty and no state is in flight.
+The stashing must have stopped beforehand. If the buffer is
+a Pender, handle must acknowledge the states and the drain
+waits until none is pending, else a state taken out of the
+buffer but not yet sent can be missed.
+Returns an error if the context is done before.
+*/
+func Drain(ctx context.Context, buff Buffer, stream <-chan []byte, handle func([]byte)) error {
+	pending := buff.Size
+	if p, ok := buff.(Pender); ok {
+		pending = p.Pending
+	}
+	for {
+		select {
+		case ss := <-stream:
+			handle(ss)
+			continue
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "drain aborted")
+		case <-time.After(drainPoll):
+		}
+		if pending() > 0 {
+			continue
+		}
+		// a state taken out of the buffer
+		// is waiting on the stream
+		select {
+		case ss := <-stream:
+			handle(ss)
+		default:
+			return nil
+		}
+	}
+}
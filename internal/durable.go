@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"math/big"
+	"sync"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/pkg/errors"
+)
+
+var (
+	log = logging.Logger("buffer")
+)
+
+var ErrNothingDelivered = errors.New("no delivered entry to acknowledge")
+
+// sinkConsumer is the consumer of the sinked entries
+const sinkConsumer = "sink"
+
+var (
+	_ Buffer        = (*DurableBuffer)(nil)
+	_ HashedStasher = (*DurableBuffer)(nil)
+	_ Pender        = (*DurableBuffer)(nil)
+)
+
+/*
+DurableBuffer is a Buffer backed by a WAL.
+Stashed states are appended to the log before
+they are sinked and stay pending until the
+consumer acknowledges them, pending states and
+the rolling root are recovered after a restart.
+*/
+type DurableBuffer struct {
+	wal *WAL
+	// entries not yet acknowledged in order,
+	// the first delivered of them were sinked
+	pending   []Frame
+	delivered int
+	root      *big.Int
+	counter   uint64
+	closed    bool
+	done      chan struct{}
+	cond      *sync.Cond
+	lock      *sync.Mutex
+}
+
+// NewDurableBuffer opens the buffer of the log.
+// The root starts from the init root
+// if the log has no recorded root
+func NewDurableBuffer(initRoot *big.Int, opts WALOptions) (*DurableBuffer, error) {
+	wal, frames, err := OpenWAL(opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open the buffer wal")
+	}
+	lock := &sync.Mutex{}
+	b := &DurableBuffer{
+		wal:  wal,
+		root: big.NewInt(0).Set(initRoot),
+		// entries of the removed segments
+		counter: wal.Compacted(EntryFrame),
+		done:    make(chan struct{}),
+		cond:    sync.NewCond(lock),
+		lock:    lock,
+	}
+	offset := wal.Offset(sinkConsumer)
+	for _, fr := range frames {
+		b.root = fr.Root
+		if fr.Kind != EntryFrame {
+			continue
+		}
+		b.counter++
+		if fr.Offset >= offset {
+			b.pending = append(b.pending, fr)
+		}
+	}
+	if len(frames) > 0 {
+		log.Infow("internal/DurableBuffer: recovered buffer",
+			"pending", len(b.pending), "root", b.root.String(), "next", wal.Next())
+	}
+	return b, nil
+}
+
+func (b *DurableBuffer) Root() *big.Int {
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	return b.root
+}
+
+// Stash appends the serialized state to the log
+// and folds it into the rolling root
+func (b *DurableBuffer) Stash(ss []byte) (*big.Int, error) { return b.StashHashed(ss, nil) }
+
+// StashHashed stashes the serialized state
+// folding its precomputed hash, if not nil
+func (b *DurableBuffer) StashHashed(ss []byte, hash *big.Int) (*big.Int, error) {
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	newRoot, err := foldHashed(b.root, ss, hash)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to stash incoming")
+	}
+	offset, err := b.wal.Append(EntryFrame, newRoot, ss)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to append incoming")
+	}
+	b.pending = append(b.pending, Frame{Offset: offset, Kind: EntryFrame, Root: newRoot, Data: ss})
+	b.root = newRoot
+	b.counter++
+	b.cond.Broadcast()
+	return new(big.Int).Set(newRoot), nil
+}
+
+// Rewind sets the rolling root back to a previous root
+// states which have already been sinked are not recalled
+func (b *DurableBuffer) Rewind(root *big.Int) error {
+	if root == nil {
+		return errors.New("cannot rewind to a nil root")
+	}
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	if _, err := b.wal.Append(RootFrame, root, nil); err != nil {
+		return errors.Wrap(err, "failed to append rewound root")
+	}
+	b.root = big.NewInt(0).Set(root)
+	return nil
+}
+
+// Sink sends the pending states in order
+// until the buffer is closed
+func (b *DurableBuffer) Sink(sink chan<- []byte) {
+	for {
+		b.lock.Lock()
+		for !b.closed && b.delivered == len(b.pending) {
+			b.cond.Wait()
+		}
+		if b.closed {
+			b.lock.Unlock()
+			return
+		}
+		data := b.pending[b.delivered].Data
+		b.delivered++
+		b.lock.Unlock()
+		select {
+		case sink <- data:
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// Ack acknowledges the oldest sinked state, it is not
+// recovered after a restart once the sink offset is
+// saved along the sync policy of the log
+func (b *DurableBuffer) Ack() error {
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	if b.delivered == 0 {
+		return ErrNothingDelivered
+	}
+	if err := b.wal.Commit(sinkConsumer, b.pending[0].Offset+1); err != nil {
+		return errors.Wrap(err, "failed to commit the sink offset")
+	}
+	b.pending[0] = Frame{}
+	b.pending = b.pending[1:]
+	b.delivered--
+	return nil
+}
+
+// Size returns the number of
+// states waiting to be sinked
+func (b *DurableBuffer) Size() int {
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	return len(b.pending) - b.delivered
+}
+
+// Pending returns the number of
+// states not yet acknowledged
+func (b *DurableBuffer) Pending() int {
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	return len(b.pending)
+}
+
+// Cnt returns the number of states
+// stashed into the log (recovered included)
+func (b *DurableBuffer) Cnt() uint64 {
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	return b.counter
+}
+
+// Purge drops every pending state
+// by moving the sink offset to the end of the log
+func (b *DurableBuffer) Purge() bool {
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	if len(b.pending) == 0 {
+		return false
+	}
+	if err := b.wal.Commit(sinkConsumer, b.wal.Next()); err != nil {
+		log.Errorw("internal/DurableBuffer: failed to purge", "err", err)
+		return false
+	}
+	b.pending, b.delivered = nil, 0
+	return true
+}
+
+// Close stops the sink and closes the log,
+// a state the sink failed to send stays pending
+func (b *DurableBuffer) Close() error {
+	b.lock.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.done)
+	}
+	b.cond.Broadcast()
+	b.lock.Unlock()
+	return b.wal.Close()
+}
@@ -3,6 +3,7 @@ package erpc
 import (
 	"context"
 	"errors"
+	"fmt"
 	"math/big"
 	"strings"
 
@@ -62,6 +63,9 @@ func callFunc[T any](
 	erpc *ERPC,
 ) (value T, err error) {
 	result, err := rpcCall()
+	if err != nil {
+		return value, fmt.Errorf("%s: %w", rpcMethodName, err)
+	}
 	return result, nil
 }
 
@@ -432,3 +436,8 @@ func (erpc *ERPC) TransactionReceipt(ctx context.Context, txHash common.Hash) (*
 	}
 	return receipt, nil
 }
+
+// Close closes the underlying client
+func (erpc *ERPC) Close() {
+	erpc.client.Close()
+}
@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"math/big"
+	"runtime"
+	"sync"
+
+	posiedon "github.com/iden3/go-iden3-crypto/poseidon"
+	"github.com/pkg/errors"
+)
+
+var _ HashedStasher = (*_Buffer)(nil)
+
+// HashedStasher is implemented by buffers
+// which stash states with a precomputed hash,
+// as returned by HashBytes
+type HashedStasher interface {
+	StashHashed(ss []byte, hash *big.Int) (*big.Int, error)
+}
+
+// HashBytes returns the poseidon hash of the serialized state
+func HashBytes(ss []byte) (*big.Int, error) { return posiedon.HashBytes(ss) }
+
+// FoldHash folds the hash of a
+// serialized state into the rolling root
+func FoldHash(root *big.Int, hash *big.Int) (*big.Int, error) {
+	return posiedon.HashWithState([]*big.Int{hash}, root)
+}
+
+// foldHashed folds the serialized state into the root
+// hashing it only if the hash is nil
+func foldHashed(root *big.Int, ss []byte, hash *big.Int) (*big.Int, error) {
+	if hash == nil {
+		return Fold(root, ss)
+	}
+	return FoldHash(root, hash)
+}
+
+/*
+Hasher hashes batches of serialized states on a pool
+of workers. Hashing the states is where the cost of
+folding lies, so the hashes are computed concurrently
+and only folded into the root in order.
+*/
+type Hasher struct {
+	workers int
+}
+
+// NewHasher returns a hasher of the given number of
+// workers, one per cpu if workers is not positive
+func NewHasher(workers int) *Hasher {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return &Hasher{workers: workers}
+}
+
+// Hash returns the hashes of the serialized
+// states in the order of the batch
+func (h *Hasher) Hash(batch [][]byte) ([]*big.Int, error) {
+	var (
+		hashes  = make([]*big.Int, len(batch))
+		errs    = make([]error, len(batch))
+		jobs    = make(chan int)
+		wg      = new(sync.WaitGroup)
+		workers = h.workers
+	)
+	if workers > len(batch) {
+		workers = len(batch)
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				hashes[i], errs[i] = HashBytes(batch[i])
+			}
+		}()
+	}
+	for i := range batch {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to hash state %d of the batch", i)
+		}
+	}
+	return hashes, nil
+}
+
+// FoldAll folds the batch into the root in order
+// and returns the root after every state
+func (h *Hasher) FoldAll(root *big.Int, batch [][]byte) ([]*big.Int, error) {
+	hashes, err := h.Hash(batch)
+	if err != nil {
+		return nil, err
+	}
+	roots := make([]*big.Int, len(batch))
+	for i, hash := range hashes {
+		if root, err = FoldHash(root, hash); err != nil {
+			return nil, errors.Wrapf(err, "failed to fold state %d of the batch", i)
+		}
+		roots[i] = root
+	}
+	return roots, nil
+}
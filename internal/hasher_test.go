@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"crypto/rand"
+	"math/big"
+	"testing"
+
+	"github.com/test-go/testify/require"
+)
+
+// batch of n random states of the size of a serialized state
+func testBatch(t testing.TB, n int) [][]byte {
+	batch := make([][]byte, n)
+	for i := range batch {
+		batch[i] = make([]byte, 320)
+		_, err := rand.Read(batch[i])
+		require.NoError(t, err)
+	}
+	return batch
+}
+
+func Test_Hasher_FoldAll(t *testing.T) {
+	var (
+		batch = testBatch(t, 64)
+		root  = big.NewInt(0)
+		h     = NewHasher(4)
+	)
+	roots, err := h.FoldAll(root, batch)
+	require.NoError(t, err)
+	require.Len(t, roots, len(batch))
+
+	// the roots match the sequential fold
+	for i, ss := range batch {
+		root, err = Fold(root, ss)
+		require.NoError(t, err)
+		require.Equal(t, 0, root.Cmp(roots[i]))
+	}
+
+	// stashing the hashed states yields the same root
+	b, err := NewBoundedBuffer(big.NewInt(0), BoundedOptions{Capacity: len(batch)})
+	require.NoError(t, err)
+	hashes, err := h.Hash(batch)
+	require.NoError(t, err)
+	for i, ss := range batch {
+		_, err := b.StashHashed(ss, hashes[i])
+		require.NoError(t, err)
+	}
+	require.Equal(t, 0, root.Cmp(b.Root()))
+
+	roots, err = h.FoldAll(root, nil)
+	require.NoError(t, err)
+	require.Empty(t, roots)
+}
+
+func BenchmarkFold_Sequential(b *testing.B) {
+	batch := testBatch(b, 1000)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		root := big.NewInt(0)
+		for _, ss := range batch {
+			var err error
+			if root, err = Fold(root, ss); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkFold_Parallel(b *testing.B) {
+	var (
+		batch = testBatch(b, 1000)
+		h     = NewHasher(0)
+	)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := h.FoldAll(big.NewInt(0), batch); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package internal
+
+import (
+	"math/big"
+	"sync"
+
+	"github.com/0xBow-io/asp-go-buildkit/core"
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/pkg/errors"
+)
+
+var ErrNothingStaged = errors.New("no staged state")
+
+var (
+	_ core.StateBuffer = (*TxBuffer)(nil)
+	_ Buffer           = (*SerializedTx)(nil)
+	_ Meter            = (*SerializedTx)(nil)
+	_ HashedStasher    = (*SerializedTx)(nil)
+	_ Pender           = (*SerializedTx)(nil)
+)
+
+// staged is a stashed state which is
+// not yet visible to the sink
+type staged struct {
+	state watcher.State
+	data  []byte
+	hash  *big.Int
+	// root before the state was folded in
+	prevRoot *big.Int
+}
+
+/*
+TxBuffer stages the stashed states on top of a Buffer.
+Staged states are folded into the staged root and are
+only stashed into the underlying buffer, and thus made
+visible to the sink, on Commit. Discard drops the staged
+batch and restores the root of the last commit.
+*/
+type TxBuffer struct {
+	inner  Buffer
+	des    watcher.StateDeserializer
+	staged []staged
+	// staged states being stashed
+	// into the inner buffer
+	committing []staged
+	root       *big.Int
+	lock       *sync.Mutex
+	// serializes the commits with the
+	// changes of the inner buffer
+	commit *sync.Mutex
+}
+
+// NewTxBuffer returns the transactional buffer
+// over the inner buffer, staged states are
+// deserialized with des when peeked or popped
+func NewTxBuffer(inner Buffer, des watcher.StateDeserializer) *TxBuffer {
+	return &TxBuffer{
+		inner:  inner,
+		des:    des,
+		root:   new(big.Int).Set(inner.Root()),
+		lock:   &sync.Mutex{},
+		commit: &sync.Mutex{},
+	}
+}
+
+func (b *TxBuffer) stash(state watcher.State, data []byte, hash *big.Int) (*big.Int, error) {
+	if data == nil {
+		return nil, errors.New("failed to serialize the state")
+	}
+	if hash == nil {
+		var err error
+		if hash, err = HashBytes(data); err != nil {
+			return nil, errors.Wrap(err, "failed to hash incoming")
+		}
+	}
+	newRoot, err := FoldHash(b.root, hash)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to stage incoming")
+	}
+	b.staged = append(b.staged, staged{state: state, data: data, hash: hash, prevRoot: b.root})
+	b.root = newRoot
+	return new(big.Int).Set(newRoot), nil
+}
+
+// Stash stages the state
+func (b *TxBuffer) Stash(state watcher.State) error {
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	_, err := b.stash(state, state.Serialize(), nil)
+	return err
+}
+
+// Commit stashes the staged states into the inner
+// buffer in order. On failure the states which
+// were not stashed yet stay staged.
+// The buffer is not locked while stashing, states
+// staged meanwhile are left for the next commit
+func (b *TxBuffer) Commit() error {
+	defer b.commit.Unlock()
+	b.commit.Lock()
+
+	b.lock.Lock()
+	batch := b.staged
+	b.staged, b.committing = nil, batch
+	b.lock.Unlock()
+
+	hashed, _ := b.inner.(HashedStasher)
+	for i, st := range batch {
+		var err error
+		// the staged hash is not computed again
+		if hashed != nil {
+			_, err = hashed.StashHashed(st.data, st.hash)
+		} else {
+			_, err = b.inner.Stash(st.data)
+		}
+		b.lock.Lock()
+		if err != nil {
+			b.staged = append(append([]staged{}, batch[i:]...), b.staged...)
+			b.committing = nil
+			b.lock.Unlock()
+			return errors.Wrap(err, "failed to commit staged state")
+		}
+		b.committing = batch[i+1:]
+		b.lock.Unlock()
+	}
+
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	expected := b.root
+	if len(b.staged) > 0 {
+		expected = b.staged[0].prevRoot
+	}
+	if expected.Cmp(b.inner.Root()) != 0 {
+		return errors.Errorf("committed root mismatch, got: %d expected: %d", b.inner.Root(), expected)
+	}
+	return nil
+}
+
+// Discard drops the staged states and restores the
+// root of the last commit, returns false if nothing was staged
+func (b *TxBuffer) Discard() bool {
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	if len(b.staged) == 0 {
+		return false
+	}
+	b.root = b.staged[0].prevRoot
+	b.staged = nil
+	return true
+}
+
+func (b *TxBuffer) last() (watcher.State, error) {
+	if len(b.staged) == 0 {
+		return nil, ErrNothingStaged
+	}
+	st := &b.staged[len(b.staged)-1]
+	if st.state == nil {
+		if st.state = b.des(st.data); st.state == nil {
+			return nil, errors.New("failed to deserialize the staged state")
+		}
+	}
+	return st.state, nil
+}
+
+// PeekLast returns the last staged state
+func (b *TxBuffer) PeekLast() (watcher.State, error) {
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	return b.last()
+}
+
+// Pop unstages the last staged state
+// and restores the root prior to it
+func (b *TxBuffer) Pop() (watcher.State, error) {
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	state, err := b.last()
+	if err != nil {
+		return nil, err
+	}
+	b.root = b.staged[len(b.staged)-1].prevRoot
+	b.staged = b.staged[:len(b.staged)-1]
+	return state, nil
+}
+
+// Size returns the number of staged
+// and not yet sinked states
+func (b *TxBuffer) Size() int {
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	return len(b.staged) + len(b.committing) + b.inner.Size()
+}
+
+// Staged returns the number of staged states,
+// those being committed included
+func (b *TxBuffer) Staged() int {
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	return len(b.staged) + len(b.committing)
+}
+
+// Root returns the root of the staged states
+func (b *TxBuffer) Root() *big.Int {
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	return new(big.Int).Set(b.root)
+}
+
+// Rewind sets the root back to a previous root,
+// only possible when nothing is staged
+func (b *TxBuffer) Rewind(root *big.Int) error {
+	defer b.commit.Unlock()
+	b.commit.Lock()
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	if len(b.staged) > 0 {
+		return errors.New("cannot rewind with staged states")
+	}
+	if err := b.inner.Rewind(root); err != nil {
+		return err
+	}
+	b.root = new(big.Int).Set(root)
+	return nil
+}
+
+// Purge drops the staged states and the
+// states of the inner buffer, the root
+// of the last commit is kept
+func (b *TxBuffer) Purge() error {
+	_, err := b.purge()
+	return err
+}
+
+// purge purges the buffer and
+// returns whether any state was dropped
+func (b *TxBuffer) purge() (bool, error) {
+	defer b.commit.Unlock()
+	b.commit.Lock()
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	purged := len(b.staged) > 0
+	if purged {
+		b.root = b.staged[0].prevRoot
+		b.staged = nil
+	}
+	if b.inner.Purge() {
+		purged = true
+	}
+	if root := b.inner.Root(); root == nil || root.Cmp(b.root) != 0 {
+		return purged, b.inner.Rewind(b.root)
+	}
+	return purged, nil
+}
+
+// snapshot is the serialized image of the buffer
+type snapshot struct {
+	// root of the last commit
+	Root   []byte   `cbor:"root"`
+	Staged [][]byte `cbor:"staged"`
+}
+
+// Snapshot returns the image of the committed
+// root and the staged states
+func (b *TxBuffer) Snapshot() ([]byte, error) {
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	var (
+		pending = append(append([]staged{}, b.committing...), b.staged...)
+		snap    = snapshot{Root: b.root.Bytes()}
+	)
+	if len(pending) > 0 {
+		snap.Root = pending[0].prevRoot.Bytes()
+	}
+	for _, st := range pending {
+		snap.Staged = append(snap.Staged, st.data)
+	}
+	out, err := cbor.Marshal(&snap)
+	return out, errors.Wrap(err, "failed to serialize snapshot")
+}
+
+// Restore reloads the snapshot, the staged states
+// are replaced and the root is rewound to the snapshot root
+func (b *TxBuffer) Restore(data []byte) error {
+	snap := snapshot{}
+	if err := cbor.Unmarshal(data, &snap); err != nil {
+		return errors.Wrap(err, "failed to deserialize snapshot")
+	}
+	defer b.commit.Unlock()
+	b.commit.Lock()
+	defer b.lock.Unlock()
+	b.lock.Lock()
+	root := new(big.Int).SetBytes(snap.Root)
+	if inner := b.inner.Root(); inner == nil || root.Cmp(inner) != 0 {
+		if err := b.inner.Rewind(root); err != nil {
+			return errors.Wrap(err, "failed to rewind to the snapshot root")
+		}
+	}
+	b.root, b.staged = root, nil
+	for _, data := range snap.Staged {
+		if _, err := b.stash(nil, data, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Serialized returns the view of the
+// buffer stashing serialized states
+func (b *TxBuffer) Serialized() *SerializedTx { return &SerializedTx{b} }
+
+// SerializedTx is the view of a TxBuffer
+// over serialized states as stashed by the detector
+type SerializedTx struct{ b *TxBuffer }
+
+// Stash stages the serialized state
+// and returns the staged root
+func (s *SerializedTx) Stash(ss []byte) (*big.Int, error) {
+	defer s.b.lock.Unlock()
+	s.b.lock.Lock()
+	return s.b.stash(nil, ss, nil)
+}
+
+// StashHashed stages the serialized state
+// with its precomputed hash, if not nil
+func (s *SerializedTx) StashHashed(ss []byte, hash *big.Int) (*big.Int, error) {
+	defer s.b.lock.Unlock()
+	s.b.lock.Lock()
+	return s.b.stash(nil, ss, hash)
+}
+
+func (s *SerializedTx) Commit() error              { return s.b.Commit() }
+func (s *SerializedTx) Discard() bool              { return s.b.Discard() }
+func (s *SerializedTx) Root() *big.Int             { return s.b.Root() }
+func (s *SerializedTx) Rewind(root *big.Int) error { return s.b.Rewind(root) }
+func (s *SerializedTx) Size() int                  { return s.b.Size() }
+func (s *SerializedTx) Cnt() uint64                { return s.b.inner.Cnt() }
+func (s *SerializedTx) Sink(sink chan<- []byte)    { s.b.inner.Sink(sink) }
+
+// Purge purges the buffer and returns
+// whether any state was dropped
+func (s *SerializedTx) Purge() bool {
+	purged, err := s.b.purge()
+	if err != nil {
+		log.Errorw("internal/SerializedTx: failed to restore the root after purge", "err", err)
+	}
+	return purged
+}
+
+// Metrics returns the metrics of the inner buffer
+func (s *SerializedTx) Metrics() BufferMetrics {
+	if m, ok := s.b.inner.(Meter); ok {
+		return m.Metrics()
+	}
+	return BufferMetrics{Depth: s.b.inner.Size(), Stashed: s.b.inner.Cnt()}
+}
+
+// Pending returns the number of staged states and
+// states of the inner buffer not yet acknowledged,
+// or not yet sinked if it does not count them
+func (s *SerializedTx) Pending() int {
+	inner := s.b.inner.Size
+	if p, ok := s.b.inner.(Pender); ok {
+		inner = p.Pending
+	}
+	return s.b.Staged() + inner()
+}
+
+// Ack acknowledges the oldest sinked state
+// if the inner buffer keeps sinked states
+func (s *SerializedTx) Ack() error {
+	if ack, ok := s.b.inner.(interface{ Ack() error }); ok {
+		return ack.Ack()
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	watcher "github.com/0xBow-io/asp-go-buildkit/core/watcher"
+	"github.com/test-go/testify/require"
+)
+
+// testState is a state serialized as its hash
+type testState struct{ h []byte }
+
+func (s *testState) Event() *watcher.Event              { return nil }
+func (s *testState) Scope() []byte                      { return nil }
+func (s *testState) Inner() []byte                      { return nil }
+func (s *testState) Hash() []byte                       { return s.h }
+func (s *testState) Clone() watcher.State               { return &testState{s.h} }
+func (s *testState) Serialize() []byte                  { return s.h }
+func (s *testState) Deserialize(b []byte) watcher.State { return &testState{b} }
+func (s *testState) Cmp(x watcher.State) int            { return 1 }
+
+func Test_TxBuffer(t *testing.T) {
+	var (
+		inner = NewBuffer(big.NewInt(0))
+		sink  = make(chan []byte, 16)
+		b     = NewTxBuffer(inner, (&testState{}).Deserialize)
+	)
+	go inner.Sink(sink)
+
+	require.NoError(t, b.Stash(&testState{[]byte{1}}))
+	require.NoError(t, b.Stash(&testState{[]byte{2}}))
+	staged := b.Root()
+	require.NoError(t, b.Commit())
+	require.Equal(t, 0, staged.Cmp(inner.Root()))
+	require.Equal(t, []byte{1}, <-sink)
+	require.Equal(t, []byte{2}, <-sink)
+
+	// discarded states never reach the sink
+	committed := b.Root()
+	require.NoError(t, b.Stash(&testState{[]byte{3}}))
+	require.NoError(t, b.Stash(&testState{[]byte{4}}))
+	last, err := b.PeekLast()
+	require.NoError(t, err)
+	require.Equal(t, []byte{4}, last.Hash())
+	require.True(t, b.Discard())
+	require.Equal(t, 0, committed.Cmp(b.Root()))
+	require.False(t, b.Discard())
+	_, err = b.Pop()
+	require.True(t, errors.Is(err, ErrNothingStaged))
+
+	// a snapshot reloads the staged states
+	require.NoError(t, b.Stash(&testState{[]byte{5}}))
+	require.NoError(t, b.Stash(&testState{[]byte{6}}))
+	root := b.Root()
+	snap, err := b.Snapshot()
+	require.NoError(t, err)
+
+	popped, err := b.Pop()
+	require.NoError(t, err)
+	require.Equal(t, []byte{6}, popped.Hash())
+	require.NoError(t, b.Purge())
+	require.Equal(t, 0, committed.Cmp(b.Root()))
+
+	require.NoError(t, b.Restore(snap))
+	require.Equal(t, 2, b.Staged())
+	require.Equal(t, 0, root.Cmp(b.Root()))
+	require.NoError(t, b.Commit())
+	require.Equal(t, []byte{5}, <-sink)
+	require.Equal(t, []byte{6}, <-sink)
+}
+
+func Test_TxBuffer_Commit(t *testing.T) {
+	var (
+		inner = NewBuffer(big.NewInt(0))
+		sink  = make(chan []byte)
+		b     = NewTxBuffer(inner, (&testState{}).Deserialize)
+		done  = make(chan error)
+	)
+	require.NoError(t, b.Stash(&testState{[]byte{1}}))
+	go func() { done <- b.Commit() }()
+	for committing := 0; committing == 0; time.Sleep(time.Millisecond) {
+		b.lock.Lock()
+		committing = len(b.committing)
+		b.lock.Unlock()
+	}
+
+	// the buffer is usable while the commit
+	// waits on the inner buffer to be sinked
+	require.NoError(t, b.Stash(&testState{[]byte{2}}))
+	require.Equal(t, 2, b.Staged())
+	go inner.Sink(sink)
+	require.Equal(t, []byte{1}, <-sink)
+	require.NoError(t, <-done)
+	require.Equal(t, 1, b.Staged())
+
+	require.NoError(t, b.Commit())
+	require.Equal(t, []byte{2}, <-sink)
+	require.Equal(t, 0, b.Root().Cmp(inner.Root()))
+}
+
+func Test_SerializedTx_Purge(t *testing.T) {
+	b := NewTxBuffer(NewBuffer(big.NewInt(0)), (&testState{}).Deserialize).Serialized()
+	require.False(t, b.Purge())
+
+	_, err := b.Stash([]byte{1})
+	require.NoError(t, err)
+	require.True(t, b.Purge())
+	require.Equal(t, 0, b.Root().Sign())
+}
@@ -0,0 +1,642 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+/*
+	WAL is a segmented append-only log of frames:
+
+		length (4 bytes) | crc32c (4 bytes) | kind (1 byte) | root (32 bytes) | data
+
+	Every frame carries the rolling root after it was
+	appended, so that the root survives the removal
+	of consumed segments. Segments are named after
+	the offset of their first frame.
+*/
+
+var (
+	ErrWALClosed    = errors.New("wal is closed")
+	ErrInvalidFrame = errors.New("invalid wal frame")
+)
+
+// SyncPolicy is when appended frames are fsynced
+type SyncPolicy string
+
+const (
+	// SyncAlways fsyncs every append
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval fsyncs on a fixed interval
+	SyncInterval SyncPolicy = "interval"
+	// SyncNever leaves flushing to the os
+	SyncNever SyncPolicy = "never"
+)
+
+func ParseSyncPolicy(name string) (SyncPolicy, error) {
+	switch p := SyncPolicy(strings.ToLower(name)); p {
+	case SyncAlways, SyncInterval, SyncNever:
+		return p, nil
+	}
+	return "", errors.Errorf("unknown wal sync policy %s", name)
+}
+
+const (
+	DefaultSegmentSize  int64 = 64 << 20
+	DefaultSyncInterval       = time.Second
+
+	segmentExt     = ".wal"
+	offsetsFile    = "offsets"
+	compactionFile = "compacted"
+	frameHeader    = 8
+	rootSize       = 32
+	// bound of the frame length
+	maxFrameSize = 1 << 28
+)
+
+// frame kinds
+const (
+	// EntryFrame is a stashed entry
+	EntryFrame byte = iota + 1
+	// RootFrame is a root set without an entry
+	RootFrame
+)
+
+type WALOptions struct {
+	Dir          string
+	SegmentSize  int64
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+}
+
+// Frame is a recovered frame of the log
+type Frame struct {
+	Offset uint64
+	Kind   byte
+	Root   *big.Int
+	Data   []byte
+}
+
+type segment struct {
+	first uint64
+	path  string
+}
+
+// position is the byte position
+// of the frame at the offset
+type position struct {
+	first  uint64
+	pos    int64
+	offset uint64
+}
+
+// compaction is the record of the removed segments,
+// segments before the offset are removed
+type compaction struct {
+	Offset uint64 `json:"offset"`
+	// number of removed frames per kind
+	Frames map[byte]uint64 `json:"frames"`
+}
+
+type WAL struct {
+	opts      WALOptions
+	segments  []segment
+	f         *os.File
+	w         *bufio.Writer
+	size      int64
+	next      uint64
+	root      *big.Int
+	offsets   map[string]uint64
+	compacted compaction
+	dirty     bool
+	// set once offsets were committed
+	// since they were last saved
+	committed bool
+	// position past the frames last read
+	read   position
+	closed bool
+	stop   chan struct{}
+	lock   *sync.Mutex
+}
+
+func segmentPath(dir string, first uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", first, segmentExt))
+}
+
+// OpenWAL opens or creates the log in the directory.
+// A torn frame at the tail of the last
+// segment is truncated away
+func OpenWAL(opts WALOptions) (*WAL, []Frame, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+	if opts.Sync == "" {
+		opts.Sync = SyncInterval
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = DefaultSyncInterval
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create wal dir")
+	}
+	w := &WAL{
+		opts:    opts,
+		offsets: make(map[string]uint64),
+		stop:    make(chan struct{}),
+		lock:    &sync.Mutex{},
+	}
+	if err := w.loadOffsets(); err != nil {
+		return nil, nil, err
+	}
+	if err := w.loadCompaction(); err != nil {
+		return nil, nil, err
+	}
+	frames, err := w.recover()
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.Sync != SyncAlways {
+		go w.syncLoop()
+	}
+	return w, frames, nil
+}
+
+// recover reads the frames of every segment
+// and opens the last segment for appending
+func (w *WAL) recover() ([]Frame, error) {
+	paths, err := filepath.Glob(filepath.Join(w.opts.Dir, "*"+segmentExt))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		var first uint64
+		if _, err := fmt.Sscanf(filepath.Base(path), "%020d"+segmentExt, &first); err != nil {
+			continue
+		}
+		// the removal of the compacted
+		// segment was interrupted
+		if first < w.compacted.Offset {
+			if err := os.Remove(path); err != nil {
+				return nil, errors.Wrap(err, "failed to remove wal segment")
+			}
+			continue
+		}
+		w.segments = append(w.segments, segment{first: first, path: path})
+	}
+	sort.Slice(w.segments, func(i, j int) bool { return w.segments[i].first < w.segments[j].first })
+
+	var frames []Frame
+	for i, seg := range w.segments {
+		last := i == len(w.segments)-1
+		if i > 0 && seg.first != w.next {
+			return nil, errors.Errorf("wal segment %s does not follow offset %d", seg.path, w.next)
+		}
+		w.next = seg.first
+		valid, err := w.readSegment(seg, func(fr Frame) {
+			frames = append(frames, fr)
+			w.root = fr.Root
+			w.next++
+		})
+		if err != nil && !(last && errors.Is(err, ErrInvalidFrame)) {
+			return nil, err
+		}
+		if last {
+			if err != nil {
+				log.Warnw("internal/WAL: truncating torn frame", "segment", seg.path, "at", valid)
+			}
+			if w.f, err = os.OpenFile(seg.path, os.O_RDWR, 0o644); err != nil {
+				return nil, errors.Wrap(err, "failed to open wal segment")
+			}
+			if err := w.f.Truncate(valid); err != nil {
+				return nil, errors.Wrap(err, "failed to truncate wal segment")
+			}
+			if _, err := w.f.Seek(valid, io.SeekStart); err != nil {
+				return nil, err
+			}
+			w.w, w.size = bufio.NewWriter(w.f), valid
+		}
+	}
+	if w.f == nil {
+		if err := w.roll(); err != nil {
+			return nil, err
+		}
+	}
+	return frames, nil
+}
+
+// readSegment calls fn for every valid frame of the
+// segment and returns the length of its valid prefix
+func (w *WAL) readSegment(seg segment, fn func(Frame)) (int64, error) {
+	at, err := w.readFrom(position{first: seg.first, offset: seg.first}, seg, func(fr Frame) bool {
+		fn(fr)
+		return true
+	})
+	return at.pos, err
+}
+
+// readFrom calls fn for every valid frame of the segment from
+// the position on until fn returns false, and returns the
+// position past the last valid frame read
+func (w *WAL) readFrom(at position, seg segment, fn func(Frame) bool) (position, error) {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return at, errors.Wrap(err, "failed to open wal segment")
+	}
+	defer f.Close()
+	if _, err := f.Seek(at.pos, io.SeekStart); err != nil {
+		return at, errors.Wrap(err, "failed to seek wal segment")
+	}
+
+	var (
+		r      = bufio.NewReader(f)
+		header = make([]byte, frameHeader)
+	)
+	for {
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			return at, nil
+		} else if err != nil {
+			return at, errors.Wrap(ErrInvalidFrame, "short header")
+		}
+		n := binary.BigEndian.Uint32(header[:4])
+		if n < 1+rootSize || n > maxFrameSize {
+			return at, errors.Wrapf(ErrInvalidFrame, "length %d", n)
+		}
+		body := make([]byte, n)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return at, errors.Wrap(ErrInvalidFrame, "short body")
+		}
+		if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(header[4:]) {
+			return at, errors.Wrap(ErrInvalidFrame, "checksum mismatch")
+		}
+		more := fn(Frame{
+			Offset: at.offset,
+			Kind:   body[0],
+			Root:   new(big.Int).SetBytes(body[1 : 1+rootSize]),
+			Data:   body[1+rootSize:],
+		})
+		at.offset++
+		at.pos += int64(frameHeader) + int64(n)
+		if !more {
+			return at, nil
+		}
+	}
+}
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// roll starts a new segment at the next offset.
+// The segment opens with the current root so
+// that it survives the removal of older segments
+func (w *WAL) roll() error {
+	if w.f != nil {
+		if err := w.flush(true); err != nil {
+			return err
+		}
+		if err := w.f.Close(); err != nil {
+			return err
+		}
+	}
+	seg := segment{first: w.next, path: segmentPath(w.opts.Dir, w.next)}
+	f, err := os.OpenFile(seg.path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return errors.Wrap(err, "failed to create wal segment")
+	}
+	w.segments = append(w.segments, seg)
+	w.f, w.w, w.size = f, bufio.NewWriter(f), 0
+	if w.root != nil {
+		if _, err := w.append(RootFrame, w.root, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Append appends the frame and returns its offset
+func (w *WAL) Append(kind byte, root *big.Int, data []byte) (uint64, error) {
+	defer w.lock.Unlock()
+	w.lock.Lock()
+	if w.closed {
+		return 0, ErrWALClosed
+	}
+	if w.size >= w.opts.SegmentSize {
+		if err := w.roll(); err != nil {
+			return 0, errors.Wrap(err, "failed to roll wal segment")
+		}
+	}
+	offset, err := w.append(kind, root, data)
+	if err != nil {
+		return 0, err
+	}
+	if w.opts.Sync == SyncAlways {
+		return offset, w.flush(true)
+	}
+	// frames are readable by the os
+	// once flushed out of the writer
+	return offset, w.flush(false)
+}
+
+func (w *WAL) append(kind byte, root *big.Int, data []byte) (uint64, error) {
+	if root == nil || root.Sign() < 0 || root.BitLen() > rootSize*8 {
+		return 0, errors.Wrap(ErrInvalidFrame, "root is not a 32 bytes unsigned integer")
+	}
+	if 1+rootSize+len(data) > maxFrameSize {
+		return 0, errors.Wrap(ErrInvalidFrame, "frame is too large")
+	}
+	body := make([]byte, 1+rootSize+len(data))
+	body[0] = kind
+	root.FillBytes(body[1 : 1+rootSize])
+	copy(body[1+rootSize:], data)
+
+	header := make([]byte, frameHeader)
+	binary.BigEndian.PutUint32(header[:4], uint32(len(body)))
+	binary.BigEndian.PutUint32(header[4:], crc32.Checksum(body, crcTable))
+	if _, err := w.w.Write(header); err != nil {
+		return 0, errors.Wrap(err, "failed to write wal frame")
+	}
+	if _, err := w.w.Write(body); err != nil {
+		return 0, errors.Wrap(err, "failed to write wal frame")
+	}
+	w.size += int64(len(header) + len(body))
+	w.root = new(big.Int).Set(root)
+	w.dirty = true
+	w.next++
+	return w.next - 1, nil
+}
+
+func (w *WAL) flush(sync bool) error {
+	if err := w.w.Flush(); err != nil {
+		return errors.Wrap(err, "failed to flush wal")
+	}
+	if sync && w.dirty {
+		if err := w.f.Sync(); err != nil {
+			return errors.Wrap(err, "failed to sync wal")
+		}
+		w.dirty = false
+	}
+	return nil
+}
+
+// syncLoop saves the committed offsets on the interval,
+// the appended frames are fsynced unless the policy is never
+func (w *WAL) syncLoop() {
+	ticker := time.NewTicker(w.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if err := w.persist(w.opts.Sync != SyncNever); err != nil && !errors.Is(err, ErrWALClosed) {
+				log.Errorw("internal/WAL: failed to sync", "err", err)
+			}
+		}
+	}
+}
+
+// Sync fsyncs the appended frames
+// and saves the committed offsets
+func (w *WAL) Sync() error { return w.persist(true) }
+
+func (w *WAL) persist(sync bool) error {
+	defer w.lock.Unlock()
+	w.lock.Lock()
+	if w.closed {
+		return ErrWALClosed
+	}
+	if err := w.flush(sync); err != nil {
+		return err
+	}
+	return w.saveCommitted()
+}
+
+// Read returns up to max frames from the offset on.
+// A read from the offset past the last read frames
+// carries on from their position in the segment
+func (w *WAL) Read(from uint64, max int) ([]Frame, error) {
+	defer w.lock.Unlock()
+	w.lock.Lock()
+	if w.closed {
+		return nil, ErrWALClosed
+	}
+	if max <= 0 {
+		return nil, nil
+	}
+	if err := w.flush(false); err != nil {
+		return nil, err
+	}
+	var frames []Frame
+	for i, seg := range w.segments {
+		if i+1 < len(w.segments) && w.segments[i+1].first <= from {
+			continue
+		}
+		at := position{first: seg.first, offset: seg.first}
+		if r := w.read; r.first == seg.first && r.offset <= from {
+			at = r
+		}
+		at, err := w.readFrom(at, seg, func(fr Frame) bool {
+			if fr.Offset >= from {
+				frames = append(frames, fr)
+			}
+			return len(frames) < max
+		})
+		if err != nil {
+			return nil, err
+		}
+		w.read = at
+		if len(frames) >= max {
+			break
+		}
+	}
+	return frames, nil
+}
+
+// Next returns the offset of the next frame
+func (w *WAL) Next() uint64 {
+	defer w.lock.Unlock()
+	w.lock.Lock()
+	return w.next
+}
+
+// Offset returns the offset of the
+// next frame to consume by the consumer
+func (w *WAL) Offset(consumer string) uint64 {
+	defer w.lock.Unlock()
+	w.lock.Lock()
+	return w.offsets[consumer]
+}
+
+// Commit sets the offset of the consumer and removes the segments
+// consumed by every consumer. The offsets are saved as the frames
+// are synced, on every commit only if the policy is always
+func (w *WAL) Commit(consumer string, offset uint64) error {
+	defer w.lock.Unlock()
+	w.lock.Lock()
+	if w.closed {
+		return ErrWALClosed
+	}
+	if offset > w.next {
+		return errors.Errorf("offset %d is beyond the wal end %d", offset, w.next)
+	}
+	w.offsets[consumer] = offset
+	w.committed = true
+	if w.opts.Sync == SyncAlways {
+		if err := w.saveCommitted(); err != nil {
+			return err
+		}
+	}
+	return w.compact()
+}
+
+// compact removes the segments which every
+// consumer is past, the last segment is kept.
+// The removed frames are counted before the removal
+func (w *WAL) compact() error {
+	min := w.next
+	for _, offset := range w.offsets {
+		if offset < min {
+			min = offset
+		}
+	}
+	n := 0
+	for n < len(w.segments)-1 && w.segments[n+1].first <= min {
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+
+	// the offsets past the segments
+	// are saved before their removal
+	if err := w.saveCommitted(); err != nil {
+		return err
+	}
+	next := compaction{Offset: w.segments[n].first, Frames: make(map[byte]uint64)}
+	for kind, cnt := range w.compacted.Frames {
+		next.Frames[kind] = cnt
+	}
+	for _, seg := range w.segments[:n] {
+		if _, err := w.readSegment(seg, func(fr Frame) { next.Frames[fr.Kind]++ }); err != nil {
+			return errors.Wrap(err, "failed to count wal segment")
+		}
+	}
+	if err := w.save(compactionFile, next); err != nil {
+		return errors.Wrap(err, "failed to write wal compaction")
+	}
+	w.compacted = next
+
+	for _, seg := range w.segments[:n] {
+		if err := os.Remove(seg.path); err != nil {
+			return errors.Wrap(err, "failed to remove wal segment")
+		}
+	}
+	w.segments = w.segments[n:]
+	return nil
+}
+
+// Compacted returns the number of frames of
+// the kind removed with the consumed segments
+func (w *WAL) Compacted(kind byte) uint64 {
+	defer w.lock.Unlock()
+	w.lock.Lock()
+	return w.compacted.Frames[kind]
+}
+
+func (w *WAL) loadCompaction() error {
+	data, err := os.ReadFile(filepath.Join(w.opts.Dir, compactionFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "failed to read wal compaction")
+	}
+	if err := json.Unmarshal(data, &w.compacted); err != nil {
+		return errors.Wrap(err, "failed to decode wal compaction")
+	}
+	return nil
+}
+
+func (w *WAL) loadOffsets() error {
+	data, err := os.ReadFile(filepath.Join(w.opts.Dir, offsetsFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "failed to read wal offsets")
+	}
+	if err := json.Unmarshal(data, &w.offsets); err != nil {
+		return errors.Wrap(err, "failed to decode wal offsets")
+	}
+	return nil
+}
+
+// saveCommitted saves the offsets if
+// any was committed since last saved
+func (w *WAL) saveCommitted() error {
+	if !w.committed {
+		return nil
+	}
+	if err := w.save(offsetsFile, w.offsets); err != nil {
+		return errors.Wrap(err, "failed to write wal offsets")
+	}
+	w.committed = false
+	return nil
+}
+
+// save replaces the json encoded
+// file of the log directory
+func (w *WAL) save(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(w.opts.Dir, name)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if w.opts.Sync != SyncNever {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Close syncs and closes the log
+func (w *WAL) Close() error {
+	defer w.lock.Unlock()
+	w.lock.Lock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	close(w.stop)
+	if err := w.flush(true); err != nil {
+		w.f.Close()
+		return err
+	}
+	if err := w.saveCommitted(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/require"
+)
+
+func Test_DurableBuffer_Recover(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		opts = WALOptions{Dir: dir, SegmentSize: 256, Sync: SyncAlways}
+		sink = make(chan []byte)
+	)
+	b, err := NewDurableBuffer(big.NewInt(0), opts)
+	require.NoError(t, err)
+	sinking := make(chan struct{})
+	go func() {
+		defer close(sinking)
+		b.Sink(sink)
+	}()
+
+	var root *big.Int
+	for i := 0; i < 10; i++ {
+		root, err = b.Stash([]byte{byte(i), 0xff})
+		require.NoError(t, err)
+	}
+	// the first 4 states are consumed
+	for i := 0; i < 4; i++ {
+		require.Equal(t, []byte{byte(i), 0xff}, <-sink)
+		require.NoError(t, b.Ack())
+	}
+	// the 5th is sinked but not acknowledged,
+	// the sink is stopped while sending the 6th
+	<-sink
+	require.NoError(t, b.Close())
+	<-sinking
+
+	// consumed segments are removed
+	segments, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	require.NoError(t, err)
+	require.True(t, len(segments) < 10)
+
+	// a torn frame at the tail is dropped
+	f, err := os.OpenFile(segments[len(segments)-1], os.O_WRONLY|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 40, 1, 2})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	b, err = NewDurableBuffer(big.NewInt(0), opts)
+	require.NoError(t, err)
+	defer b.Close()
+	require.Equal(t, 0, root.Cmp(b.Root()))
+	require.Equal(t, 6, b.Size())
+	// states of the removed segments are counted
+	require.Equal(t, uint64(10), b.Cnt())
+
+	sink = make(chan []byte)
+	go b.Sink(sink)
+	for i := 4; i < 10; i++ {
+		require.Equal(t, []byte{byte(i), 0xff}, <-sink)
+		require.NoError(t, b.Ack())
+	}
+
+	// the recovered root keeps rolling
+	next, err := Fold(root, []byte{0xaa})
+	require.NoError(t, err)
+	got, err := b.Stash([]byte{0xaa})
+	require.NoError(t, err)
+	require.Equal(t, 0, next.Cmp(got))
+
+	require.NoError(t, b.Rewind(root))
+	require.Equal(t, 0, root.Cmp(b.Root()))
+}
+
+func Test_DurableBuffer_Ack(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		opts = WALOptions{Dir: dir, Sync: SyncInterval, SyncInterval: time.Hour}
+		sink = make(chan []byte, 10)
+	)
+	b, err := NewDurableBuffer(big.NewInt(0), opts)
+	require.NoError(t, err)
+	go b.Sink(sink)
+	for i := 0; i < 10; i++ {
+		_, err = b.Stash([]byte{byte(i)})
+		require.NoError(t, err)
+	}
+	for i := 0; i < 6; i++ {
+		<-sink
+		require.NoError(t, b.Ack())
+	}
+	// the offsets are saved on sync, not on every ack
+	_, err = os.Stat(filepath.Join(dir, offsetsFile))
+	require.True(t, os.IsNotExist(err))
+	require.NoError(t, b.wal.Sync())
+	_, err = os.Stat(filepath.Join(dir, offsetsFile))
+	require.NoError(t, err)
+
+	<-sink
+	require.NoError(t, b.Ack())
+	// the offsets are saved on close
+	require.NoError(t, b.Close())
+	b, err = NewDurableBuffer(big.NewInt(0), opts)
+	require.NoError(t, err)
+	defer b.Close()
+	require.Equal(t, 3, b.Pending())
+}
+
+func Test_WAL_Read(t *testing.T) {
+	w, _, err := OpenWAL(WALOptions{Dir: t.TempDir(), SegmentSize: 512, Sync: SyncNever})
+	require.NoError(t, err)
+	defer w.Close()
+	var offsets []uint64
+	for i := 0; i < 50; i++ {
+		offset, err := w.Append(EntryFrame, big.NewInt(int64(i)), []byte{byte(i)})
+		require.NoError(t, err)
+		offsets = append(offsets, offset)
+	}
+	require.True(t, len(w.segments) > 1)
+
+	// the batches carry on from the position of the last read
+	var (
+		read []byte
+		from uint64
+	)
+	for {
+		frames, err := w.Read(from, 7)
+		require.NoError(t, err)
+		if len(frames) == 0 {
+			break
+		}
+		for _, fr := range frames {
+			if fr.Kind == EntryFrame {
+				read = append(read, fr.Data...)
+			}
+			from = fr.Offset + 1
+		}
+		require.Equal(t, from, w.read.offset)
+	}
+	require.Len(t, read, 50)
+	for i, b := range read {
+		require.Equal(t, byte(i), b)
+	}
+
+	// a read behind the position starts over
+	frames, err := w.Read(offsets[3], 2)
+	require.NoError(t, err)
+	require.Equal(t, []byte{3}, frames[0].Data)
+	require.Equal(t, offsets[4], frames[1].Offset)
+}